@@ -0,0 +1,199 @@
+// Copyright 2021 Cloud Privacy Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lpg
+
+// stepFrom returns the path element that follows the edge starting
+// from node
+func stepFrom(node *Node, edge *Edge) PathElement {
+	return PathElement{Edge: edge, Reverse: edge.to == node && edge.from != node}
+}
+
+// ShortestPath returns a path with the minimum number of edges from
+// 'from' to 'to' following edges in dir, or nil if there is no such
+// path. If edgeFilter is not nil, only the edges it accepts are
+// followed. If maxDepth is not -1, paths longer than maxDepth are not
+// considered. The path from a node to itself is the node.
+func ShortestPath(from, to *Node, dir EdgeDir, edgeFilter func(*Edge) bool, maxDepth int) *Path {
+	paths := shortestPathsBetween(from, to, dir, edgeFilter, maxDepth, false)
+	if len(paths) == 0 {
+		return nil
+	}
+	return paths[0]
+}
+
+// AllShortestPaths returns all the paths with the minimum number of
+// edges from 'from' to 'to'. The arguments are the same as
+// ShortestPath.
+func AllShortestPaths(from, to *Node, dir EdgeDir, edgeFilter func(*Edge) bool, maxDepth int) []*Path {
+	return shortestPathsBetween(from, to, dir, edgeFilter, maxDepth, true)
+}
+
+// bfsFrontier is one side of a bidirectional breadth-first search
+type bfsFrontier struct {
+	// dir is the direction edges are followed from the frontier
+	dir EdgeDir
+	// depth is the distance of the nodes in the frontier
+	depth int
+	nodes []*Node
+	dist  map[*Node]int
+	// links are the edges connecting a node to the nodes one step
+	// closer to the start of this side, as path elements in the
+	// direction of the path from 'from' to 'to'
+	links map[*Node][]PathElement
+}
+
+func newBFSFrontier(start *Node, dir EdgeDir) *bfsFrontier {
+	return &bfsFrontier{
+		dir:   dir,
+		nodes: []*Node{start},
+		dist:  map[*Node]int{start: 0},
+		links: make(map[*Node][]PathElement),
+	}
+}
+
+// expand visits the nodes one step away from the frontier, and
+// returns the newly visited nodes. If forward is false, the search
+// goes from 'to' backwards.
+func (f *bfsFrontier) expand(edgeFilter func(*Edge) bool, forward bool) []*Node {
+	next := make([]*Node, 0)
+	f.depth++
+	for _, node := range f.nodes {
+		for edges := node.GetEdges(f.dir); edges.Next(); {
+			edge := edges.Edge()
+			if edgeFilter != nil && !edgeFilter(edge) {
+				continue
+			}
+			pe := stepFrom(node, edge)
+			other := pe.GetTargetNode()
+			if other == node {
+				continue
+			}
+			if !forward {
+				pe = stepFrom(other, edge)
+			}
+			d, seen := f.dist[other]
+			if !seen {
+				f.dist[other] = f.depth
+				next = append(next, other)
+			} else if d != f.depth {
+				continue
+			}
+			f.links[other] = append(f.links[other], pe)
+		}
+	}
+	f.nodes = next
+	return next
+}
+
+// paths returns the paths from the start of this side to the node,
+// with up to limit paths if limit is not -1. The elements of the
+// backward side are returned from the node to the start.
+func (f *bfsFrontier) paths(node *Node, forward bool, limit int) [][]PathElement {
+	ret := make([][]PathElement, 0)
+	var recurse func(*Node, []PathElement) bool
+	recurse = func(node *Node, suffix []PathElement) bool {
+		links := f.links[node]
+		if len(links) == 0 {
+			path := make([]PathElement, len(suffix))
+			copy(path, suffix)
+			if forward {
+				for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+					path[i], path[j] = path[j], path[i]
+				}
+			}
+			ret = append(ret, path)
+			return limit == -1 || len(ret) < limit
+		}
+		for _, pe := range links {
+			prev := pe.GetSourceNode()
+			if !forward {
+				prev = pe.GetTargetNode()
+			}
+			if !recurse(prev, append(suffix, pe)) {
+				return false
+			}
+		}
+		return true
+	}
+	recurse(node, nil)
+	return ret
+}
+
+// shortestPathsBetween runs a bidirectional breadth-first search,
+// expanding the smaller frontier one level at a time until the two
+// sides meet. Every shortest path crosses exactly one of the nodes
+// where the sides meet in the last expanded level, so the paths are
+// the combinations of the paths to and from those nodes.
+func shortestPathsBetween(from, to *Node, dir EdgeDir, edgeFilter func(*Edge) bool, maxDepth int, all bool) []*Path {
+	if from == to {
+		return []*Path{PathFromNode(from)}
+	}
+	forward := newBFSFrontier(from, dir)
+	// The backward side follows edges in the opposite direction
+	backward := newBFSFrontier(to, -dir)
+	for maxDepth == -1 || forward.depth+backward.depth < maxDepth {
+		if len(forward.nodes) == 0 || len(backward.nodes) == 0 {
+			return nil
+		}
+		var meet []*Node
+		var other *bfsFrontier
+		if len(forward.nodes) <= len(backward.nodes) {
+			other = backward
+			meet = forward.expand(edgeFilter, true)
+		} else {
+			other = forward
+			meet = backward.expand(edgeFilter, false)
+		}
+		// Keep the new nodes that are closest to the other side
+		closest := -1
+		nodes := make([]*Node, 0)
+		for _, node := range meet {
+			d, ok := other.dist[node]
+			if !ok {
+				continue
+			}
+			if closest == -1 || d < closest {
+				closest = d
+				nodes = nodes[:0]
+			}
+			if d == closest {
+				nodes = append(nodes, node)
+			}
+		}
+		if len(nodes) == 0 {
+			continue
+		}
+		limit := -1
+		if !all {
+			limit = 1
+		}
+		ret := make([]*Path, 0)
+		for _, node := range nodes {
+			for _, head := range forward.paths(node, true, limit) {
+				for _, tail := range backward.paths(node, false, limit) {
+					elements := make([]PathElement, 0, len(head)+len(tail))
+					elements = append(elements, head...)
+					elements = append(elements, tail...)
+					ret = append(ret, &Path{path: elements})
+					if !all {
+						return ret
+					}
+				}
+			}
+		}
+		return ret
+	}
+	return nil
+}
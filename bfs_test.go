@@ -0,0 +1,223 @@
+// Copyright 2021 Cloud Privacy Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lpg
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// getBFSTestGraph returns the graph
+//
+//	a -> b -> d -> e
+//	a -> c -> d
+//	a -> x -> y -> d
+//
+// where a -> b is labeled "skip", and all other edges "link"
+func getBFSTestGraph() (*Graph, map[string]*Node) {
+	g := NewGraph()
+	nodes := make(map[string]*Node)
+	for _, name := range []string{"a", "b", "c", "d", "e", "x", "y"} {
+		nodes[name] = g.NewNode(nil, map[string]interface{}{"name": name}, nil)
+	}
+	for _, e := range [][3]string{
+		{"a", "b", "skip"},
+		{"b", "d", "link"},
+		{"a", "c", "link"},
+		{"c", "d", "link"},
+		{"a", "x", "link"},
+		{"x", "y", "link"},
+		{"y", "d", "link"},
+		{"d", "e", "link"},
+	} {
+		g.NewEdge(nodes[e[0]], nodes[e[1]], e[2], nil, nil)
+	}
+	return g, nodes
+}
+
+// sortedPathNames returns the node names of the paths, sorted
+func sortedPathNames(paths []*Path) []string {
+	ret := make([]string, 0, len(paths))
+	for _, p := range paths {
+		ret = append(ret, strings.Join(pathNames(p), ""))
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// checkPath checks that the path elements are connected
+func checkPath(t *testing.T, p *Path) {
+	for i := 1; i < p.NumEdges(); i++ {
+		assert.Equal(t, p.path[i-1].GetTargetNode(), p.path[i].GetSourceNode())
+	}
+}
+
+func TestShortestPath(t *testing.T) {
+	_, nodes := getBFSTestGraph()
+	path := ShortestPath(nodes["a"], nodes["e"], OutgoingEdge, nil, -1)
+	if assert.NotNil(t, path) {
+		assert.Equal(t, 3, path.NumEdges())
+		assert.Equal(t, nodes["a"], path.First())
+		assert.Equal(t, nodes["e"], path.Last())
+		checkPath(t, path)
+	}
+	assert.Nil(t, ShortestPath(nodes["e"], nodes["a"], OutgoingEdge, nil, -1))
+	assert.Nil(t, ShortestPath(nodes["a"], nodes["e"], OutgoingEdge, nil, 2))
+	assert.NotNil(t, ShortestPath(nodes["a"], nodes["e"], OutgoingEdge, nil, 3))
+	assert.Equal(t, []string{"a"}, pathNames(ShortestPath(nodes["a"], nodes["a"], OutgoingEdge, nil, 0)))
+
+	// Follow edges backwards
+	path = ShortestPath(nodes["e"], nodes["x"], IncomingEdge, nil, -1)
+	assert.Equal(t, []string{"e", "d", "y", "x"}, pathNames(path))
+	for _, pe := range path.path {
+		assert.True(t, pe.Reverse)
+	}
+	checkPath(t, path)
+
+	path = ShortestPath(nodes["a"], nodes["e"], OutgoingEdge, GetEdgeFilterFunc(NewStringSet("link"), nil), -1)
+	assert.Equal(t, []string{"a", "c", "d", "e"}, pathNames(path))
+}
+
+func TestAllShortestPaths(t *testing.T) {
+	g, nodes := getBFSTestGraph()
+	assert.Equal(t, []string{"abde", "acde"}, sortedPathNames(AllShortestPaths(nodes["a"], nodes["e"], OutgoingEdge, nil, -1)))
+	assert.Equal(t, []string{"acde"}, sortedPathNames(AllShortestPaths(nodes["a"], nodes["e"], OutgoingEdge, GetEdgeFilterFunc(NewStringSet("link"), nil), -1)))
+	assert.Equal(t, []string{"edba", "edca"}, sortedPathNames(AllShortestPaths(nodes["e"], nodes["a"], IncomingEdge, nil, -1)))
+	assert.Equal(t, []string{"bac", "bdc"}, sortedPathNames(AllShortestPaths(nodes["b"], nodes["c"], AnyEdge, nil, -1)))
+	for _, p := range AllShortestPaths(nodes["b"], nodes["c"], AnyEdge, nil, -1) {
+		checkPath(t, p)
+	}
+	assert.Empty(t, AllShortestPaths(nodes["b"], nodes["c"], OutgoingEdge, nil, -1))
+
+	// Parallel edges give distinct paths
+	g.NewEdge(nodes["d"], nodes["e"], "link", nil, nil)
+	assert.Equal(t, 4, len(AllShortestPaths(nodes["a"], nodes["e"], OutgoingEdge, nil, -1)))
+	assert.Equal(t, 2, len(AllShortestPaths(nodes["x"], nodes["e"], OutgoingEdge, nil, 3)))
+}
+
+func TestAllShortestPathsRandom(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	g := NewGraph()
+	nodes := make([]*Node, 0)
+	for i := 0; i < 40; i++ {
+		nodes = append(nodes, g.NewNode(nil, nil, nil))
+	}
+	for i := 0; i < 100; i++ {
+		g.NewEdge(nodes[rnd.Intn(len(nodes))], nodes[rnd.Intn(len(nodes))], "e", nil, nil)
+	}
+	for _, dir := range []EdgeDir{OutgoingEdge, AnyEdge} {
+		from := nodes[0]
+		// Count the shortest paths with a one-sided search
+		dist := map[*Node]int{from: 0}
+		count := map[*Node]int{from: 1}
+		queue := []*Node{from}
+		for len(queue) > 0 {
+			node := queue[0]
+			queue = queue[1:]
+			for edges := node.GetEdges(dir); edges.Next(); {
+				next := stepFrom(node, edges.Edge()).GetTargetNode()
+				if next == node {
+					continue
+				}
+				if _, ok := dist[next]; !ok {
+					dist[next] = dist[node] + 1
+					queue = append(queue, next)
+				}
+				if dist[next] == dist[node]+1 {
+					count[next] += count[node]
+				}
+			}
+		}
+		for _, to := range nodes[1:] {
+			paths := AllShortestPaths(from, to, dir, nil, -1)
+			assert.Equal(t, count[to], len(paths))
+			for _, p := range paths {
+				assert.Equal(t, dist[to], p.NumEdges())
+				assert.Equal(t, from, p.First())
+				assert.Equal(t, to, p.Last())
+				checkPath(t, p)
+			}
+		}
+	}
+}
+
+func TestShortestPathPattern(t *testing.T) {
+	g, nodes := getBFSTestGraph()
+	symbols := map[string]*PatternSymbol{
+		"src": {Nodes: NewNodeSet()},
+		"dst": {Nodes: NewNodeSet()},
+	}
+	symbols["src"].AddNode(nodes["a"])
+	symbols["dst"].AddNode(nodes["e"])
+	symbols["dst"].AddNode(nodes["y"])
+	pattern := Pattern{
+		{Name: "src"},
+		{Min: 1, Max: -1, PathMode: AllShortestPathsMode},
+		{Name: "dst"},
+	}
+	acc, err := pattern.FindPaths(g, symbols)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"abde", "acde", "axy"}, sortedPathNames(acc.Paths))
+
+	pattern[1].PathMode = ShortestPathMode
+	acc, err = pattern.FindPaths(g, symbols)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(acc.Paths))
+
+	pattern[1].Labels = NewStringSet("link")
+	pattern[1].PathMode = AllShortestPathsMode
+	acc, err = pattern.FindPaths(g, symbols)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"acde", "axy"}, sortedPathNames(acc.Paths))
+
+	pattern[1].Max = 2
+	acc, err = pattern.FindPaths(g, symbols)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"axy"}, sortedPathNames(acc.Paths))
+
+	// Max=0 is unbounded
+	pattern[1].Max = 0
+	acc, err = pattern.FindPaths(g, symbols)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"acde", "axy"}, sortedPathNames(acc.Paths))
+	pattern[1].Max = 2
+
+	pattern[2].Name = "unbound"
+	_, err = pattern.FindPaths(g, symbols)
+	assert.Equal(t, ErrUnboundPathEnd("unbound"), err)
+
+	// A single edge shortest path item is the cheapest element, but
+	// the plan must not start from it
+	g.NewEdge(nodes["a"], nodes["b"], "skip", nil, nil)
+	for _, node := range nodes {
+		symbols["src"].AddNode(node)
+	}
+	symbols["dst"].AddNode(nodes["b"])
+	pattern = Pattern{
+		{Name: "src"},
+		{Labels: NewStringSet("skip"), Min: 1, Max: 1, PathMode: ShortestPathMode},
+		{Name: "dst"},
+	}
+	plan, err := pattern.GetPlan(g, symbols)
+	assert.Nil(t, err)
+	_, ok := plan.steps[0].(*iterateNodes)
+	assert.True(t, ok)
+	acc, err = pattern.FindPaths(g, symbols)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"ab"}, sortedPathNames(acc.Paths))
+}
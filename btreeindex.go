@@ -81,3 +81,95 @@ func (s *setTree[V, I]) valueItr() Iterator {
 		},
 	}
 }
+
+func (s *setTree[V, I]) indexType() IndexType { return BtreeIndex }
+
+func (s *setTree[V, I]) forEach(f func(V, I) bool) {
+	if s.tree == nil {
+		return
+	}
+	s.tree.Scan(func(value V, fs *fastSet) bool {
+		for itr := fs.iterator(); itr.Next(); {
+			if !f(value, itr.Value().(I)) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// valueRange selects the index values between lo and hi. A nil bound
+// is unbounded. If while is not nil, the scan stops at the first
+// value for which while returns false.
+type valueRange[V ordered] struct {
+	lo, hi                   *V
+	loInclusive, hiInclusive bool
+	while                    func(V) bool
+}
+
+// contains returns true if the value is in the range
+func (r valueRange[V]) contains(value V) bool {
+	if r.while != nil && !r.while(value) {
+		return false
+	}
+	if r.lo != nil && (value < *r.lo || (!r.loInclusive && value == *r.lo)) {
+		return false
+	}
+	if r.hi != nil && (value > *r.hi || (!r.hiInclusive && value == *r.hi)) {
+		return false
+	}
+	return true
+}
+
+// scan returns an iterator over the items whose values are in the
+// range, in value order
+func (s *setTree[V, I]) scan(r valueRange[V], descending bool) (Iterator, error) {
+	if s.tree == nil {
+		return emptyIterator{}, nil
+	}
+	sets := make([]*fastSet, 0)
+	size := 0
+	visit := func(key V, value *fastSet) bool {
+		if r.while != nil && !r.while(key) {
+			return false
+		}
+		if descending {
+			if r.lo != nil && (key < *r.lo || (!r.loInclusive && key == *r.lo)) {
+				return false
+			}
+			if r.hi != nil && !r.hiInclusive && key == *r.hi {
+				return true
+			}
+		} else {
+			if r.hi != nil && (key > *r.hi || (!r.hiInclusive && key == *r.hi)) {
+				return false
+			}
+			if r.lo != nil && !r.loInclusive && key == *r.lo {
+				return true
+			}
+		}
+		sets = append(sets, value)
+		size += value.size()
+		return true
+	}
+	switch {
+	case !descending && r.lo != nil:
+		s.tree.Ascend(*r.lo, visit)
+	case !descending:
+		s.tree.Scan(visit)
+	case r.hi != nil:
+		s.tree.Descend(*r.hi, visit)
+	default:
+		s.tree.Reverse(visit)
+	}
+	return withSize(&funcIterator{
+		iteratorFunc: func() Iterator {
+			if len(sets) == 0 {
+				return nil
+			}
+			ret := sets[0].iterator()
+			sets = sets[1:]
+			return ret
+		},
+	}, size), nil
+}
@@ -18,7 +18,9 @@ type withProperties interface {
 	ForEachProperty(func(string, interface{}) bool) bool
 }
 
-// CopyGraph copies source graph into target, using clonePropertyFunc to clone properties
+// CopyGraph copies source graph into target, using clonePropertyFunc
+// to clone properties. Panics if a copied node or edge violates the
+// index constraints of the target graph.
 func CopyGraph(source, target *Graph, clonePropertyFunc func(string, interface{}) interface{}) map[*Node]*Node {
 	return CopyGraphf(source, func(node *Node, nodeMap map[*Node]*Node) *Node {
 		return target.cloneNode(source, node, clonePropertyFunc)
@@ -0,0 +1,161 @@
+// Copyright 2021 Cloud Privacy Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lpg
+
+// ComponentOptions are the options of the connected component
+// functions
+type ComponentOptions struct {
+	// EdgeFilter, if not nil, selects the edges that connect
+	// nodes. Other edges are ignored.
+	EdgeFilter func(*Edge) bool
+	// If IDProperty is not empty, the index of the component of each
+	// node is written to this node property. If a value violates the
+	// index constraints, no value is written and the error is
+	// returned.
+	IDProperty string
+}
+
+// WeaklyConnectedComponents returns the sets of nodes connected to
+// each other ignoring the edge directions. Every node of the graph
+// is in exactly one component.
+func WeaklyConnectedComponents(graph *Graph, options ComponentOptions) ([]*NodeSet, error) {
+	ret := make([]*NodeSet, 0)
+	seen := make(map[*Node]struct{})
+	for nodes := graph.GetNodes(); nodes.Next(); {
+		start := nodes.Node()
+		if _, ok := seen[start]; ok {
+			continue
+		}
+		component := NewNodeSet()
+		component.Add(start)
+		seen[start] = struct{}{}
+		queue := []*Node{start}
+		for len(queue) > 0 {
+			node := queue[0]
+			queue = queue[1:]
+			for edges := node.GetEdges(AnyEdge); edges.Next(); {
+				edge := edges.Edge()
+				if options.EdgeFilter != nil && !options.EdgeFilter(edge) {
+					continue
+				}
+				next := stepFrom(node, edge).GetTargetNode()
+				if _, ok := seen[next]; ok {
+					continue
+				}
+				seen[next] = struct{}{}
+				component.Add(next)
+				queue = append(queue, next)
+			}
+		}
+		ret = append(ret, component)
+	}
+	if err := options.writeIDs(graph, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// StronglyConnectedComponents returns the sets of nodes where every
+// node is reachable from every other node following the edge
+// directions, using Tarjan's algorithm. Every node of the graph is
+// in exactly one component. A component is returned before the
+// components that have edges to it.
+func StronglyConnectedComponents(graph *Graph, options ComponentOptions) ([]*NodeSet, error) {
+	type frame struct {
+		node  *Node
+		edges EdgeIterator
+	}
+	ret := make([]*NodeSet, 0)
+	index := make(map[*Node]int)
+	lowLink := make(map[*Node]int)
+	onStack := make(map[*Node]struct{})
+	stack := make([]*Node, 0)
+	frames := make([]frame, 0)
+	visit := func(node *Node) {
+		index[node] = len(index)
+		lowLink[node] = index[node]
+		stack = append(stack, node)
+		onStack[node] = struct{}{}
+		frames = append(frames, frame{node: node, edges: node.GetEdges(OutgoingEdge)})
+	}
+	for nodes := graph.GetNodes(); nodes.Next(); {
+		if _, ok := index[nodes.Node()]; ok {
+			continue
+		}
+		// Depth-first search without recursion, so deep graphs do
+		// not exhaust the stack
+		visit(nodes.Node())
+		for len(frames) > 0 {
+			top := frames[len(frames)-1]
+			if top.edges.Next() {
+				edge := top.edges.Edge()
+				if options.EdgeFilter != nil && !options.EdgeFilter(edge) {
+					continue
+				}
+				next := edge.GetTo()
+				if _, ok := index[next]; !ok {
+					visit(next)
+				} else if _, ok := onStack[next]; ok && index[next] < lowLink[top.node] {
+					lowLink[top.node] = index[next]
+				}
+				continue
+			}
+			frames = frames[:len(frames)-1]
+			node := top.node
+			if lowLink[node] == index[node] {
+				// node is the root of a component
+				component := NewNodeSet()
+				for {
+					n := stack[len(stack)-1]
+					stack = stack[:len(stack)-1]
+					delete(onStack, n)
+					component.Add(n)
+					if n == node {
+						break
+					}
+				}
+				ret = append(ret, component)
+			}
+			if len(frames) > 0 {
+				if parent := frames[len(frames)-1].node; lowLink[node] < lowLink[parent] {
+					lowLink[parent] = lowLink[node]
+				}
+			}
+		}
+	}
+	if err := options.writeIDs(graph, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// writeIDs sets the IDProperty of the nodes to the component
+// index. If a value cannot be set, the values already set are rolled
+// back.
+func (options ComponentOptions) writeIDs(graph *Graph, components []*NodeSet) error {
+	if len(options.IDProperty) == 0 {
+		return nil
+	}
+	tx := graph.Begin()
+	for i, component := range components {
+		for nodes := component.Iterator(); nodes.Next(); {
+			if err := nodes.Node().TrySetProperty(options.IDProperty, i); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
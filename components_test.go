@@ -0,0 +1,177 @@
+// Copyright 2021 Cloud Privacy Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lpg
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// getComponentTestGraph returns the graph
+//
+//	a -> b -> c -> a
+//	c -> d -> e -> d
+//	f
+//
+// where c -> d is labeled "weak", and all other edges "strong"
+func getComponentTestGraph() (*Graph, map[string]*Node) {
+	g := NewGraph()
+	nodes := make(map[string]*Node)
+	for _, name := range []string{"a", "b", "c", "d", "e", "f"} {
+		nodes[name] = g.NewNode(nil, map[string]interface{}{"name": name}, nil)
+	}
+	for _, e := range [][2]string{{"a", "b"}, {"b", "c"}, {"c", "a"}, {"c", "d"}, {"d", "e"}, {"e", "d"}} {
+		label := "strong"
+		if e[0] == "c" && e[1] == "d" {
+			label = "weak"
+		}
+		g.NewEdge(nodes[e[0]], nodes[e[1]], label, nil, nil)
+	}
+	return g, nodes
+}
+
+// componentNames returns the sorted node names of each component
+func componentNames(components []*NodeSet) []string {
+	ret := make([]string, 0, len(components))
+	for _, component := range components {
+		names := make([]string, 0)
+		for _, node := range component.Slice() {
+			name, _ := node.GetProperty("name")
+			names = append(names, name.(string))
+		}
+		sort.Strings(names)
+		ret = append(ret, strings.Join(names, ""))
+	}
+	return ret
+}
+
+func TestWeaklyConnectedComponents(t *testing.T) {
+	g, nodes := getComponentTestGraph()
+	components, err := WeaklyConnectedComponents(g, ComponentOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"abcde", "f"}, componentNames(components))
+
+	components, err = WeaklyConnectedComponents(g, ComponentOptions{
+		EdgeFilter: GetEdgeFilterFunc(NewStringSet("strong"), nil),
+		IDProperty: "component",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"abc", "de", "f"}, componentNames(components))
+	for name, id := range map[string]int{"a": 0, "b": 0, "c": 0, "d": 1, "e": 1, "f": 2} {
+		v, _ := nodes[name].GetProperty("component")
+		assert.Equal(t, id, v)
+	}
+}
+
+func TestComponentIDConstraint(t *testing.T) {
+	g, nodes := getComponentTestGraph()
+	assert.Nil(t, g.AddUniqueConstraint("u", "component"))
+	nodes["a"].SetLabels(NewStringSet("u"))
+	nodes["b"].SetLabels(NewStringSet("u"))
+	// a and b are in the same component, so the second write fails
+	var uerr ErrUniqueConstraint
+	_, err := WeaklyConnectedComponents(g, ComponentOptions{IDProperty: "component"})
+	assert.ErrorAs(t, err, &uerr)
+	_, err = StronglyConnectedComponents(g, ComponentOptions{IDProperty: "component"})
+	assert.ErrorAs(t, err, &uerr)
+	for _, node := range nodes {
+		_, ok := node.GetProperty("component")
+		assert.False(t, ok)
+	}
+	assert.Nil(t, g.Verify())
+}
+
+func TestStronglyConnectedComponents(t *testing.T) {
+	g, nodes := getComponentTestGraph()
+	components, err := StronglyConnectedComponents(g, ComponentOptions{IDProperty: "scc"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"de", "abc", "f"}, componentNames(components))
+	v, _ := nodes["e"].GetProperty("scc")
+	assert.Equal(t, 0, v)
+
+	components, err = StronglyConnectedComponents(g, ComponentOptions{
+		EdgeFilter: func(edge *Edge) bool { return edge.GetFrom() != nodes["c"] },
+	})
+	assert.Nil(t, err)
+	names := componentNames(components)
+	sort.Strings(names)
+	assert.Equal(t, []string{"a", "b", "c", "de", "f"}, names)
+
+	// A long cycle does not need deep recursion
+	g = NewGraph()
+	first := g.NewNode(nil, nil, nil)
+	prev := first
+	for i := 0; i < 100000; i++ {
+		node := g.NewNode(nil, nil, nil)
+		g.NewEdge(prev, node, "next", nil, nil)
+		prev = node
+	}
+	g.NewEdge(prev, first, "next", nil, nil)
+	components, err = StronglyConnectedComponents(g, ComponentOptions{})
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(components)) {
+		assert.Equal(t, g.NumNodes(), components[0].Len())
+	}
+}
+
+func TestStronglyConnectedComponentsRandom(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	g := NewGraph()
+	nodes := make([]*Node, 0)
+	for i := 0; i < 30; i++ {
+		nodes = append(nodes, g.NewNode(nil, nil, nil))
+	}
+	for i := 0; i < 45; i++ {
+		g.NewEdge(nodes[rnd.Intn(len(nodes))], nodes[rnd.Intn(len(nodes))], "e", nil, nil)
+	}
+	reachable := func(from *Node) map[*Node]struct{} {
+		walker := Walker{Dir: OutgoingEdge}
+		ret := make(map[*Node]struct{})
+		for _, node := range NodeSlice(walker.Walk(from).Nodes()) {
+			ret[node] = struct{}{}
+		}
+		return ret
+	}
+	reach := make(map[*Node]map[*Node]struct{})
+	for _, node := range nodes {
+		reach[node] = reachable(node)
+	}
+	components, err := StronglyConnectedComponents(g, ComponentOptions{IDProperty: "c"})
+	assert.Nil(t, err)
+	total := 0
+	for i, component := range components {
+		total += component.Len()
+		// Edges leaving the component lead to earlier components
+		for _, node := range component.Slice() {
+			for edges := node.GetEdges(OutgoingEdge); edges.Next(); {
+				c, _ := edges.Edge().GetTo().GetProperty("c")
+				assert.True(t, c.(int) <= i)
+			}
+		}
+	}
+	assert.Equal(t, len(nodes), total)
+	for _, u := range nodes {
+		for _, v := range nodes {
+			_, uv := reach[u][v]
+			_, vu := reach[v][u]
+			cu, _ := u.GetProperty("c")
+			cv, _ := v.GetProperty("c")
+			assert.Equal(t, uv && vu, cu == cv)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright 2021 Cloud Privacy Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lpg
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// compositeIndex indexes the items by the values of an ordered list
+// of properties. Items that do not have all the properties are not
+// indexed. The index key is the concatenation of the string
+// representations of the values, each escaped and terminated so that
+// the keys starting with the same values are adjacent in a B-tree.
+type compositeIndex[I Item] struct {
+	index[string, I]
+	keys []string
+}
+
+func newCompositeIndex[I Item](keys []string, it IndexType) *compositeIndex[I] {
+	ret := &compositeIndex[I]{keys: append([]string{}, keys...)}
+	if it == BtreeIndex {
+		ret.index = &setTree[string, I]{}
+	} else {
+		ret.index = &hashIndex[string, I]{}
+	}
+	return ret
+}
+
+// appendCompositeValue appends the encoded value to the key
+func appendCompositeValue(buf *strings.Builder, value interface{}) {
+	appendKeyPart(buf, fmt.Sprintf("%v", value))
+}
+
+// appendKeyPart appends s to the key. 0x00 is escaped as 0x00 0xff,
+// and s is terminated with 0x00 0x01
+func appendKeyPart(buf *strings.Builder, s string) {
+	for i := 0; i < len(s); i++ {
+		buf.WriteByte(s[i])
+		if s[i] == 0 {
+			buf.WriteByte(0xff)
+		}
+	}
+	buf.WriteByte(0)
+	buf.WriteByte(1)
+}
+
+// has returns true if the property is one of the index keys
+func (c *compositeIndex[I]) has(property string) bool {
+	for _, k := range c.keys {
+		if k == property {
+			return true
+		}
+	}
+	return false
+}
+
+// itemKey returns the index key for the properties, and false if a
+// key is missing
+func (c *compositeIndex[I]) itemKey(props properties) (string, bool) {
+	var buf strings.Builder
+	for _, k := range c.keys {
+		v, ok := props[k]
+		if !ok {
+			return "", false
+		}
+		appendCompositeValue(&buf, v)
+	}
+	return buf.String(), true
+}
+
+// lookup returns the items whose properties match the bound values,
+// and the number of index keys used. A hash index can only be used if
+// all the keys are bound. A B-tree index can be used if a leftmost
+// prefix of the keys is bound. Returns nil,0 if the index cannot be
+// used.
+func (c *compositeIndex[I]) lookup(values map[string]interface{}) (Iterator, int) {
+	var buf strings.Builder
+	n := 0
+	for _, k := range c.keys {
+		v, ok := values[k]
+		if !ok || v == nil {
+			break
+		}
+		appendCompositeValue(&buf, v)
+		n++
+	}
+	if n == 0 {
+		return nil, 0
+	}
+	if n == len(c.keys) {
+		return c.find(buf.String()), n
+	}
+	itr, err := c.scan(prefixRange(buf.String()), false)
+	if err != nil {
+		return nil, 0
+	}
+	return itr, n
+}
+
+// compositeCandidates returns an iterator from the composite index
+// that selects the smallest number of items for the bound values, and
+// the keys of the index used in the lookup. Returns nil if no
+// composite index can be used.
+func compositeCandidates[I Item](indexes []*compositeIndex[I], values map[string]interface{}) (Iterator, []string) {
+	var ret Iterator
+	var keys []string
+	for _, ix := range indexes {
+		itr, n := ix.lookup(values)
+		if itr == nil {
+			continue
+		}
+		if ret == nil || itr.MaxSize() < ret.MaxSize() {
+			ret = itr
+			keys = ix.keys[:n]
+		}
+	}
+	return ret, keys
+}
+
+func addToComposites[I Item](indexes []*compositeIndex[I], props properties, id int, item I, property string) {
+	for _, ix := range indexes {
+		if len(property) > 0 && !ix.has(property) {
+			continue
+		}
+		if key, ok := ix.itemKey(props); ok {
+			ix.add(key, id, item)
+		}
+	}
+}
+
+func removeFromComposites[I Item](indexes []*compositeIndex[I], props properties, id int, property string) {
+	for _, ix := range indexes {
+		if len(property) > 0 && !ix.has(property) {
+			continue
+		}
+		if key, ok := ix.itemKey(props); ok {
+			ix.remove(key, id)
+		}
+	}
+}
+
+func findComposite[I Item](indexes []*compositeIndex[I], keys []string) *compositeIndex[I] {
+	for _, ix := range indexes {
+		if len(ix.keys) != len(keys) {
+			continue
+		}
+		found := true
+		for i := range keys {
+			if ix.keys[i] != keys[i] {
+				found = false
+				break
+			}
+		}
+		if found {
+			return ix
+		}
+	}
+	return nil
+}
+
+func checkCompositeKeys(keys []string) error {
+	if len(keys) < 2 {
+		return errors.New("composite index needs at least two keys")
+	}
+	seen := make(map[string]struct{}, len(keys))
+	for _, k := range keys {
+		if _, ok := seen[k]; ok {
+			return fmt.Errorf("duplicate key in composite index: %s", k)
+		}
+		seen[k] = struct{}{}
+	}
+	return nil
+}
+
+// CompositeNodeIndex sets up an index for the given node properties.
+// If an index for the same keys exists, this is a no-op.
+func (g *graphIndex) CompositeNodeIndex(keys []string, graph *Graph, it IndexType) error {
+	if err := checkCompositeKeys(keys); err != nil {
+		return err
+	}
+	if findComposite(g.nodeComposites, keys) != nil {
+		return nil
+	}
+	ix := newCompositeIndex[*Node](keys, it)
+	for nodes := graph.GetNodes(); nodes.Next(); {
+		node := nodes.Node()
+		if key, ok := ix.itemKey(node.properties); ok {
+			ix.add(key, node.id, node)
+		}
+	}
+	g.nodeComposites = append(g.nodeComposites, ix)
+	return nil
+}
+
+// CompositeEdgeIndex sets up an index for the given edge properties.
+// If an index for the same keys exists, this is a no-op.
+func (g *graphIndex) CompositeEdgeIndex(keys []string, graph *Graph, it IndexType) error {
+	if err := checkCompositeKeys(keys); err != nil {
+		return err
+	}
+	if findComposite(g.edgeComposites, keys) != nil {
+		return nil
+	}
+	ix := newCompositeIndex[*Edge](keys, it)
+	for edges := graph.GetEdges(); edges.Next(); {
+		edge := edges.Edge()
+		if key, ok := ix.itemKey(edge.properties); ok {
+			ix.add(key, edge.id, edge)
+		}
+	}
+	g.edgeComposites = append(g.edgeComposites, ix)
+	return nil
+}
@@ -0,0 +1,998 @@
+// Copyright 2021 Cloud Privacy Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lpg
+
+import (
+	"errors"
+	"maps"
+	"runtime"
+	"slices"
+	"sort"
+	"sync"
+	"weak"
+)
+
+// ErrSnapshotReadOnly is the panic value when a graph snapshot is
+// modified
+var ErrSnapshotReadOnly = errors.New("graph snapshot is read-only")
+
+// Snapshots share the nodes and edges of the live graph. Every state
+// of a node or edge is stamped with the graph version it was written
+// at, and a snapshot taken at version v sees the states written
+// before v. When a node or edge that a snapshot may see is modified,
+// its state is first saved in the history of the live graph.
+
+// nodeImage is a saved state of a node. The labels, contexts, and
+// properties are not modified once saved.
+type nodeImage struct {
+	epoch      uint64
+	exists     bool
+	labels     *StringSet
+	contexts   *StringSet
+	properties properties
+	externalID string
+}
+
+type nodeHistory struct {
+	node *Node
+	// images are in epoch order
+	images []nodeImage
+}
+
+// edgeImage is a saved state of an edge
+type edgeImage struct {
+	epoch      uint64
+	exists     bool
+	label      string
+	contexts   *StringSet
+	properties properties
+	externalID string
+}
+
+type edgeHistory struct {
+	edge   *Edge
+	images []edgeImage
+}
+
+// cowState keeps the saved states of the live graph while there are
+// snapshots
+type cowState struct {
+	// versions is the number of snapshots of each version
+	versions map[uint64]int
+	nodes    map[int]*nodeHistory
+	edges    map[int]*edgeHistory
+	// edgesByNode are the edges with history by node ID
+	edgesByNode map[int][]*Edge
+	// nodeExternalIDs and edgeExternalIDs are the histories with a
+	// saved state that has the external ID
+	nodeExternalIDs map[string][]*nodeHistory
+	edgeExternalIDs map[string][]*edgeHistory
+}
+
+// at returns the state of the node seen at version, or nil if the
+// node does not exist at version. The current state of the node must
+// be newer than version.
+func (h *nodeHistory) at(version uint64) *nodeImage {
+	for i := len(h.images) - 1; i >= 0; i-- {
+		if h.images[i].epoch < version {
+			if h.images[i].exists {
+				return &h.images[i]
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+func (h *edgeHistory) at(version uint64) *edgeImage {
+	for i := len(h.images) - 1; i >= 0; i-- {
+		if h.images[i].epoch < version {
+			if h.images[i].exists {
+				return &h.images[i]
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+func cloneStringSet(s *StringSet) *StringSet {
+	if s == nil {
+		return nil
+	}
+	return s.Clone()
+}
+
+// checkWritable panics if the graph is a snapshot
+func (g *Graph) checkWritable() {
+	if g.view != nil {
+		panic(ErrSnapshotReadOnly)
+	}
+}
+
+// preserveNode saves the state of the node before it is modified if
+// a snapshot may see it. exists is false if the node is not in the
+// graph.
+func (g *Graph) preserveNode(node *Node, exists bool) {
+	if g.cow == nil {
+		g.checkWritable()
+		return
+	}
+	if node.epoch >= g.version {
+		return
+	}
+	h := g.cow.nodes[node.id]
+	if h == nil {
+		h = &nodeHistory{node: node}
+		g.cow.nodes[node.id] = h
+	}
+	img := nodeImage{epoch: node.epoch, exists: exists}
+	if exists {
+		// Snapshots may share the current labels, contexts, and
+		// properties, so the node continues with copies
+		img.labels, img.contexts, img.properties = node.labels, node.contexts, node.properties
+		img.externalID = g.index.nodeExternalIDs.of(node.id)
+		if img.externalID != "" && !slices.Contains(g.cow.nodeExternalIDs[img.externalID], h) {
+			g.cow.nodeExternalIDs[img.externalID] = append(g.cow.nodeExternalIDs[img.externalID], h)
+		}
+		node.labels = cloneStringSet(node.labels)
+		node.contexts = cloneStringSet(node.contexts)
+		node.properties = maps.Clone(node.properties)
+	}
+	h.images = append(h.images, img)
+	node.epoch = g.version
+}
+
+// preserveEdge saves the state of the edge before it is modified if
+// a snapshot may see it. exists is false if the edge is not in the
+// graph.
+func (g *Graph) preserveEdge(edge *Edge, exists bool) {
+	if g.cow == nil {
+		g.checkWritable()
+		return
+	}
+	if edge.epoch >= g.version {
+		return
+	}
+	h := g.cow.edges[edge.id]
+	if h == nil {
+		h = &edgeHistory{edge: edge}
+		g.cow.edges[edge.id] = h
+		g.cow.edgesByNode[edge.from.id] = append(g.cow.edgesByNode[edge.from.id], edge)
+		if edge.to != edge.from {
+			g.cow.edgesByNode[edge.to.id] = append(g.cow.edgesByNode[edge.to.id], edge)
+		}
+	}
+	img := edgeImage{epoch: edge.epoch, exists: exists}
+	if exists {
+		img.label, img.contexts, img.properties = edge.label, edge.contexts, edge.properties
+		img.externalID = g.index.edgeExternalIDs.of(edge.id)
+		if img.externalID != "" && !slices.Contains(g.cow.edgeExternalIDs[img.externalID], h) {
+			g.cow.edgeExternalIDs[img.externalID] = append(g.cow.edgeExternalIDs[img.externalID], h)
+		}
+		edge.contexts = cloneStringSet(edge.contexts)
+		edge.properties = maps.Clone(edge.properties)
+	}
+	h.images = append(h.images, img)
+	edge.epoch = g.version
+}
+
+// Snapshot returns a read-only graph with the nodes and edges of this
+// graph as they are now. It works as SyncGraph.Snapshot, but reads of
+// the snapshot do not lock anything, so this graph and the snapshot
+// must be used from one goroutine, or the caller must serialize the
+// access to them.
+//
+// For a graph wrapped in a SyncGraph, Snapshot can be called in
+// Update to get a snapshot that includes the uncommitted changes of
+// the transaction. That snapshot must only be read in View or Update,
+// and released in Update.
+func (g *Graph) Snapshot() *Graph {
+	return g.snapshot(nil)
+}
+
+// snapshot returns a snapshot whose reads hold the read lock of
+// lock. The write lock must be held while taking the snapshot. lock
+// can only be nil if the graph and the snapshot are used by a single
+// goroutine.
+func (g *Graph) snapshot(lock *sync.RWMutex) *Graph {
+	g.checkWritable()
+	if g.cow == nil {
+		g.cow = &cowState{
+			versions:        make(map[uint64]int),
+			nodes:           make(map[int]*nodeHistory),
+			edges:           make(map[int]*edgeHistory),
+			edgesByNode:     make(map[int][]*Edge),
+			nodeExternalIDs: make(map[string][]*nodeHistory),
+			edgeExternalIDs: make(map[string][]*edgeHistory),
+		}
+	}
+	g.version++
+	g.cow.versions[g.version]++
+	s := &Graph{
+		index:   newGraphIndex(),
+		version: g.version,
+	}
+	s.view = &graphView{
+		graph:    s,
+		live:     g,
+		lock:     lock,
+		version:  g.version,
+		idLimit:  g.idBase,
+		numNodes: g.NumNodes(),
+		numEdges: g.NumEdges(),
+		nodes:    make(map[int]weak.Pointer[Node]),
+		edges:    make(map[int]weak.Pointer[Edge]),
+	}
+	return s
+}
+
+// Release releases the snapshot, so the live graph no longer keeps
+// the states only this snapshot sees. The snapshot, and the nodes and
+// edges obtained from it, must not be used after Release. Release
+// does nothing if the graph is not a snapshot.
+func (g *Graph) Release() {
+	v := g.view
+	if v == nil {
+		return
+	}
+	if v.lock != nil {
+		v.lock.Lock()
+		defer v.lock.Unlock()
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.released {
+		return
+	}
+	v.released = true
+	v.nodes, v.edges = nil, nil
+	live := v.live
+	live.cow.release(v.version)
+	if len(live.cow.versions) == 0 {
+		live.cow = nil
+	}
+}
+
+// release removes a snapshot of the version, and drops the states no
+// remaining snapshot sees
+func (c *cowState) release(version uint64) {
+	if c.versions[version]--; c.versions[version] > 0 {
+		return
+	}
+	delete(c.versions, version)
+	if len(c.versions) == 0 {
+		return
+	}
+	// A state written at epoch and replaced at next is seen by the
+	// versions in (epoch, next]
+	seen := func(epoch, next uint64) bool {
+		for w := range c.versions {
+			if epoch < w && w <= next {
+				return true
+			}
+		}
+		return false
+	}
+	for id, h := range c.nodes {
+		images := h.images[:0]
+		for i, img := range h.images {
+			next := h.node.epoch
+			if i+1 < len(h.images) {
+				next = h.images[i+1].epoch
+			}
+			if seen(img.epoch, next) {
+				images = append(images, img)
+			}
+		}
+		clear(h.images[len(images):])
+		h.images = images
+		if len(images) == 0 {
+			delete(c.nodes, id)
+		}
+	}
+	dropped := make(map[*Edge]struct{})
+	for id, h := range c.edges {
+		images := h.images[:0]
+		for i, img := range h.images {
+			next := h.edge.epoch
+			if i+1 < len(h.images) {
+				next = h.images[i+1].epoch
+			}
+			if seen(img.epoch, next) {
+				images = append(images, img)
+			}
+		}
+		clear(h.images[len(images):])
+		h.images = images
+		if len(images) == 0 {
+			delete(c.edges, id)
+			dropped[h.edge] = struct{}{}
+		}
+	}
+	for edge := range dropped {
+		for _, id := range []int{edge.from.id, edge.to.id} {
+			edges := slices.DeleteFunc(c.edgesByNode[id], func(e *Edge) bool {
+				_, ok := dropped[e]
+				return ok
+			})
+			if len(edges) == 0 {
+				delete(c.edgesByNode, id)
+			} else {
+				c.edgesByNode[id] = edges
+			}
+		}
+	}
+	clear(c.nodeExternalIDs)
+	for _, h := range c.nodes {
+		for _, img := range h.images {
+			if img.externalID != "" && !slices.Contains(c.nodeExternalIDs[img.externalID], h) {
+				c.nodeExternalIDs[img.externalID] = append(c.nodeExternalIDs[img.externalID], h)
+			}
+		}
+	}
+	clear(c.edgeExternalIDs)
+	for _, h := range c.edges {
+		for _, img := range h.images {
+			if img.externalID != "" && !slices.Contains(c.edgeExternalIDs[img.externalID], h) {
+				c.edgeExternalIDs[img.externalID] = append(c.edgeExternalIDs[img.externalID], h)
+			}
+		}
+	}
+}
+
+// graphView implements the read operations of a snapshot. The nodes
+// and edges of the snapshot are created when they are first read, and
+// share the labels, contexts, and properties with the live graph or
+// its history.
+type graphView struct {
+	// graph is the snapshot
+	graph *Graph
+	live  *Graph
+	// lock is the lock of the SyncGraph of the live graph
+	lock    *sync.RWMutex
+	version uint64
+	// idLimit is the ID counter of the live graph when the snapshot
+	// was taken. The IDs of the snapshot nodes and edges are below
+	// it.
+	idLimit  int
+	numNodes int
+	numEdges int
+
+	mu       sync.Mutex
+	released bool
+	// nodes and edges are the snapshot nodes and edges in use by
+	// ID. They are weak, so a node or edge that is no longer
+	// referenced is collected, and its entry is dropped.
+	nodes map[int]weak.Pointer[Node]
+	edges map[int]weak.Pointer[Edge]
+}
+
+// viewEntry is the cache entry of the snapshot node or edge with the
+// ID
+type viewEntry[T any] struct {
+	id  int
+	ptr weak.Pointer[T]
+}
+
+// dropNode removes the cache entry of a collected node, unless it
+// is replaced
+func (v *graphView) dropNode(e viewEntry[Node]) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.nodes[e.id] == e.ptr {
+		delete(v.nodes, e.id)
+	}
+}
+
+// dropEdge removes the cache entry of a collected edge, unless it
+// is replaced
+func (v *graphView) dropEdge(e viewEntry[Edge]) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.edges[e.id] == e.ptr {
+		delete(v.edges, e.id)
+	}
+}
+
+// begin locks the live graph for reading. Handlers are not called
+// between begin and end.
+func (v *graphView) begin() {
+	if v.lock != nil {
+		v.lock.RLock()
+	}
+	v.mu.Lock()
+	if v.released {
+		v.end()
+		panic("graph snapshot is released")
+	}
+}
+
+func (v *graphView) end() {
+	v.mu.Unlock()
+	if v.lock != nil {
+		v.lock.RUnlock()
+	}
+}
+
+// node returns the snapshot node for the node ID, or nil if the
+// snapshot does not have the node
+func (v *graphView) node(id int) *Node {
+	if node := v.nodes[id].Value(); node != nil {
+		return node
+	}
+	var ret *Node
+	if node := v.live.GetNode(id); node != nil && node.epoch < v.version {
+		ret = &Node{
+			graph:      v.graph,
+			id:         id,
+			labels:     node.labels,
+			contexts:   node.contexts,
+			properties: node.properties,
+		}
+	} else if h := v.live.cow.nodes[id]; h != nil && h.node.epoch >= v.version {
+		if img := h.at(v.version); img != nil {
+			ret = &Node{
+				graph:      v.graph,
+				id:         id,
+				labels:     img.labels,
+				contexts:   img.contexts,
+				properties: img.properties,
+			}
+		}
+	}
+	if ret != nil {
+		ptr := weak.Make(ret)
+		v.nodes[id] = ptr
+		runtime.AddCleanup(ret, v.dropNode, viewEntry[Node]{id: id, ptr: ptr})
+	}
+	return ret
+}
+
+// edge returns the snapshot edge for the edge ID, or nil if the
+// snapshot does not have the edge
+func (v *graphView) edge(id int) *Edge {
+	if edge := v.edges[id].Value(); edge != nil {
+		return edge
+	}
+	var ret *Edge
+	if edge := v.live.GetEdge(id); edge != nil && edge.epoch < v.version {
+		ret = &Edge{
+			from:       v.node(edge.from.id),
+			to:         v.node(edge.to.id),
+			id:         id,
+			label:      edge.label,
+			contexts:   edge.contexts,
+			properties: edge.properties,
+		}
+	} else if h := v.live.cow.edges[id]; h != nil && h.edge.epoch >= v.version {
+		if img := h.at(v.version); img != nil {
+			ret = &Edge{
+				from:       v.node(h.edge.from.id),
+				to:         v.node(h.edge.to.id),
+				id:         id,
+				label:      img.label,
+				contexts:   img.contexts,
+				properties: img.properties,
+			}
+		}
+	}
+	if ret != nil {
+		ptr := weak.Make(ret)
+		v.edges[id] = ptr
+		runtime.AddCleanup(ret, v.dropEdge, viewEntry[Edge]{id: id, ptr: ptr})
+	}
+	return ret
+}
+
+// viewIDs are the IDs of the result of a snapshot query. live are
+// the IDs of the live items that are not modified since the snapshot,
+// and history are the IDs of the items with history that may be in
+// the result.
+type viewIDs struct {
+	live    []int
+	history []int
+}
+
+func (ids *viewIDs) size() int { return len(ids.live) + len(ids.history) }
+
+// nextViewItem returns the snapshot item for the next ID using get.
+// The items with history are returned if match selects them. match
+// can be nil to select all.
+func nextViewItem[T *Node | *Edge](ids *viewIDs, get func(int) T, match func(T) bool) (T, bool) {
+	if len(ids.live) > 0 {
+		id := ids.live[0]
+		ids.live = ids.live[1:]
+		return get(id), true
+	}
+	for len(ids.history) > 0 {
+		id := ids.history[0]
+		ids.history = ids.history[1:]
+		if item := get(id); item != nil && (match == nil || match(item)) {
+			return item, true
+		}
+	}
+	return nil, false
+}
+
+// viewIterator returns the items of a snapshot one at a time. The
+// live graph is locked only while next runs, so a snapshot iterator
+// can be used while the live graph is written.
+type viewIterator struct {
+	view    *graphView
+	next    func() (interface{}, bool)
+	maxSize int
+	current interface{}
+}
+
+func (i *viewIterator) Next() bool {
+	i.view.begin()
+	defer i.view.end()
+	item, ok := i.next()
+	i.current = item
+	return ok
+}
+
+func (i *viewIterator) Value() interface{} { return i.current }
+
+func (i *viewIterator) MaxSize() int { return i.maxSize }
+
+// collectNodes returns the IDs of the live nodes of itr that are not
+// modified since the snapshot, and the IDs of the nodes with history.
+// itr must return all the live nodes that are in the result. itr can
+// be nil.
+func (v *graphView) collectNodes(itr Iterator) *viewIDs {
+	ids := &viewIDs{}
+	if itr != nil {
+		for itr.Next() {
+			if node := itr.Value().(*Node); node.epoch < v.version {
+				ids.live = append(ids.live, node.id)
+			}
+		}
+	}
+	for id, h := range v.live.cow.nodes {
+		if h.node.epoch >= v.version {
+			ids.history = append(ids.history, id)
+		}
+	}
+	sort.Ints(ids.history)
+	return ids
+}
+
+// collectEdges is the edge version of collectNodes
+func (v *graphView) collectEdges(itr Iterator) *viewIDs {
+	ids := &viewIDs{}
+	if itr != nil {
+		for itr.Next() {
+			if edge := itr.Value().(*Edge); edge.epoch < v.version {
+				ids.live = append(ids.live, edge.id)
+			}
+		}
+	}
+	for id, h := range v.live.cow.edges {
+		if h.edge.epoch >= v.version {
+			ids.history = append(ids.history, id)
+		}
+	}
+	sort.Ints(ids.history)
+	return ids
+}
+
+// nodeIterator returns the snapshot nodes of ids selected by match
+// as they are iterated
+func (v *graphView) nodeIterator(ids *viewIDs, match func(*Node) bool) NodeIterator {
+	return nodeIterator{&viewIterator{
+		view:    v,
+		maxSize: ids.size(),
+		next: func() (interface{}, bool) {
+			node, ok := nextViewItem(ids, v.node, match)
+			return node, ok
+		},
+	}}
+}
+
+// edgeIterator is the edge version of nodeIterator
+func (v *graphView) edgeIterator(ids *viewIDs, match func(*Edge) bool) EdgeIterator {
+	return edgeIterator{&viewIterator{
+		view:    v,
+		maxSize: ids.size(),
+		next: func() (interface{}, bool) {
+			edge, ok := nextViewItem(ids, v.edge, match)
+			return edge, ok
+		},
+	}}
+}
+
+// nodeSlice returns the snapshot nodes of ids selected by match
+func (v *graphView) nodeSlice(ids *viewIDs, match func(*Node) bool) []*Node {
+	ret := make([]*Node, 0, ids.size())
+	for {
+		node, ok := nextViewItem(ids, v.node, match)
+		if !ok {
+			return ret
+		}
+		ret = append(ret, node)
+	}
+}
+
+// edgeSlice is the edge version of nodeSlice
+func (v *graphView) edgeSlice(ids *viewIDs, match func(*Edge) bool) []*Edge {
+	ret := make([]*Edge, 0, ids.size())
+	for {
+		edge, ok := nextViewItem(ids, v.edge, match)
+		if !ok {
+			return ret
+		}
+		ret = append(ret, edge)
+	}
+}
+
+// allNodes returns the nodes of the snapshot in ID order
+func (v *graphView) allNodes() NodeIterator {
+	v.begin()
+	defer v.end()
+	id := 0
+	return nodeIterator{&viewIterator{
+		view:    v,
+		maxSize: v.numNodes,
+		next: func() (interface{}, bool) {
+			for ; id < v.idLimit; id++ {
+				if node := v.node(id); node != nil {
+					id++
+					return node, true
+				}
+			}
+			return nil, false
+		},
+	}}
+}
+
+// allEdges returns the edges of the snapshot in ID order
+func (v *graphView) allEdges() EdgeIterator {
+	v.begin()
+	defer v.end()
+	id := 0
+	return edgeIterator{&viewIterator{
+		view:    v,
+		maxSize: v.numEdges,
+		next: func() (interface{}, bool) {
+			for ; id < v.idLimit; id++ {
+				if edge := v.edge(id); edge != nil {
+					id++
+					return edge, true
+				}
+			}
+			return nil, false
+		},
+	}}
+}
+
+// nodeQuery returns the snapshot nodes of the live query selected by
+// match
+func (v *graphView) nodeQuery(live func(*Graph) Iterator, match func(*Node) bool) NodeIterator {
+	v.begin()
+	defer v.end()
+	return v.nodeIterator(v.collectNodes(live(v.live)), match)
+}
+
+// edgeQuery returns the snapshot edges of the live query selected by
+// match
+func (v *graphView) edgeQuery(live func(*Graph) Iterator, match func(*Edge) bool) EdgeIterator {
+	v.begin()
+	defer v.end()
+	return v.edgeIterator(v.collectEdges(live(v.live)), match)
+}
+
+// nodeEdges returns the snapshot edges of the node. With AnyEdge,
+// the incoming edges are followed by the outgoing edges as in the
+// live graph. If labels is empty, returns all edges.
+func (v *graphView) nodeEdges(node *Node, dir EdgeDir, labels *StringSet) []*Edge {
+	ret := make([]*Edge, 0)
+	add := func(dir EdgeDir) {
+		if live := v.live.GetNode(node.id); live != nil {
+			for itr := live.GetEdgesWithAnyLabel(dir, labels); itr.Next(); {
+				if edge := itr.Edge(); edge.epoch < v.version {
+					ret = append(ret, v.edge(edge.id))
+				}
+			}
+		}
+		for _, edge := range v.live.cow.edgesByNode[node.id] {
+			if edge.epoch < v.version {
+				continue
+			}
+			if (dir == IncomingEdge && edge.to.id != node.id) || (dir == OutgoingEdge && edge.from.id != node.id) {
+				continue
+			}
+			if e := v.edge(edge.id); e != nil && (labels.Len() == 0 || labels.Has(e.label)) {
+				ret = append(ret, e)
+			}
+		}
+	}
+	if dir == AnyEdge {
+		add(IncomingEdge)
+		add(OutgoingEdge)
+	} else {
+		add(dir)
+	}
+	return ret
+}
+
+func (v *graphView) edgesOf(node *Node, dir EdgeDir, labels *StringSet) EdgeIterator {
+	v.begin()
+	defer v.end()
+	return edgeIterator{newSliceIterator(v.nodeEdges(node, dir, labels))}
+}
+
+func (v *graphView) edgeCount(node *Node, dir EdgeDir) int {
+	v.begin()
+	defer v.end()
+	return len(v.nodeEdges(node, dir, nil))
+}
+
+func (v *graphView) getNode(id int) *Node {
+	v.begin()
+	defer v.end()
+	return v.node(id)
+}
+
+func (v *graphView) getEdge(id int) *Edge {
+	v.begin()
+	defer v.end()
+	return v.edge(id)
+}
+
+func (v *graphView) nodeByExternalID(id string) *Node {
+	v.begin()
+	defer v.end()
+	if node := v.live.GetNodeByExternalID(id); node != nil && node.epoch < v.version {
+		return v.node(node.id)
+	}
+	for _, h := range v.live.cow.nodeExternalIDs[id] {
+		if h.node.epoch < v.version {
+			continue
+		}
+		if img := h.at(v.version); img != nil && img.externalID == id {
+			return v.node(h.node.id)
+		}
+	}
+	return nil
+}
+
+func (v *graphView) edgeByExternalID(id string) *Edge {
+	v.begin()
+	defer v.end()
+	if edge := v.live.GetEdgeByExternalID(id); edge != nil && edge.epoch < v.version {
+		return v.edge(edge.id)
+	}
+	for _, h := range v.live.cow.edgeExternalIDs[id] {
+		if h.edge.epoch < v.version {
+			continue
+		}
+		if img := h.at(v.version); img != nil && img.externalID == id {
+			return v.edge(h.edge.id)
+		}
+	}
+	return nil
+}
+
+func (v *graphView) nodeExternalID(id int) string {
+	v.begin()
+	defer v.end()
+	if node := v.live.GetNode(id); node != nil && node.epoch < v.version {
+		return v.live.index.nodeExternalIDs.of(id)
+	}
+	if h := v.live.cow.nodes[id]; h != nil && h.node.epoch >= v.version {
+		if img := h.at(v.version); img != nil {
+			return img.externalID
+		}
+	}
+	return ""
+}
+
+func (v *graphView) edgeExternalID(id int) string {
+	v.begin()
+	defer v.end()
+	if edge := v.live.GetEdge(id); edge != nil && edge.epoch < v.version {
+		return v.live.index.edgeExternalIDs.of(id)
+	}
+	if h := v.live.cow.edges[id]; h != nil && h.edge.epoch >= v.version {
+		if img := h.at(v.version); img != nil {
+			return img.externalID
+		}
+	}
+	return ""
+}
+
+func (v *graphView) nodeByKeys(label string, keys []string, values []interface{}) (*Node, error) {
+	v.begin()
+	defer v.end()
+	node, err := v.live.GetNodeByKeys(label, keys, values...)
+	if err != nil {
+		return nil, err
+	}
+	if node != nil && node.epoch < v.version {
+		return v.node(node.id), nil
+	}
+	u := v.live.index.findUnique(label, keys)
+	key, ok := u.lookupKey(values)
+	if !ok {
+		return nil, nil
+	}
+	for _, node := range v.nodeSlice(v.collectNodes(nil), nil) {
+		if k, ok := u.key(node.labels, node.properties.getter()); ok && k == key {
+			return node, nil
+		}
+	}
+	return nil, nil
+}
+
+func (v *graphView) findNodes(allLabels *StringSet, properties map[string]interface{}) (NodeIterator, error) {
+	v.begin()
+	defer v.end()
+	itr, err := v.live.FindNodes(allLabels, properties)
+	if err != nil {
+		return nil, err
+	}
+	filter := GetNodeFilterFunc(allLabels, properties)
+	return v.nodeIterator(v.collectNodes(itr), filter), nil
+}
+
+func (v *graphView) findEdges(label string, properties map[string]interface{}) (EdgeIterator, error) {
+	v.begin()
+	defer v.end()
+	itr, err := v.live.FindEdges(label, properties)
+	if err != nil {
+		return nil, err
+	}
+	filter := GetEdgeFilterFunc(nil, properties)
+	if label != "" {
+		filter = GetEdgeFilterFunc(NewStringSet(label), properties)
+	}
+	return v.edgeIterator(v.collectEdges(itr), filter), nil
+}
+
+// scanNodes returns the nodes in the index range in property value
+// order
+func (v *graphView) scanNodes(key string, spec rangeSpec, descending bool) (NodeIterator, error) {
+	v.begin()
+	defer v.end()
+	itr, err := scanIndex(v.live.index.nodeProperties, key, spec, descending)
+	if err != nil {
+		return nil, err
+	}
+	index := v.live.index.nodeProperties[key]
+	r, _ := index.valueRange(key, spec)
+	nodes := v.nodeSlice(v.collectNodes(itr), func(node *Node) bool {
+		value, ok := node.GetProperty(key)
+		return ok && index.inRange(r, spec, value)
+	})
+	sortByPropertyValue(nodes, key, index.compareValues, descending)
+	return nodeIterator{newSliceIterator(nodes)}, nil
+}
+
+// scanEdges returns the edges in the index range in property value
+// order
+func (v *graphView) scanEdges(key string, spec rangeSpec, descending bool) (EdgeIterator, error) {
+	v.begin()
+	defer v.end()
+	itr, err := scanIndex(v.live.index.edgeProperties, key, spec, descending)
+	if err != nil {
+		return nil, err
+	}
+	index := v.live.index.edgeProperties[key]
+	r, _ := index.valueRange(key, spec)
+	edges := v.edgeSlice(v.collectEdges(itr), func(edge *Edge) bool {
+		value, ok := edge.GetProperty(key)
+		return ok && index.inRange(r, spec, value)
+	})
+	sortByPropertyValue(edges, key, index.compareValues, descending)
+	return edgeIterator{newSliceIterator(edges)}, nil
+}
+
+// sortByPropertyValue sorts the items by the value of the property
+// using compare
+func sortByPropertyValue[I WithProperties](items []I, key string, compare func(a, b interface{}) int, descending bool) {
+	slices.SortStableFunc(items, func(a, b I) int {
+		if descending {
+			a, b = b, a
+		}
+		x, _ := a.GetProperty(key)
+		y, _ := b.GetProperty(key)
+		return compare(x, y)
+	})
+}
+
+func (v *graphView) nodesWithAnyContext(contexts *StringSet) []*Node {
+	v.begin()
+	defer v.end()
+	ret := make([]*Node, 0)
+	seen := make(map[int]struct{})
+	contexts.Iter(func(context string) bool {
+		nodes := v.nodeSlice(v.collectNodes(v.live.index.nodesByContext.find(context)), func(node *Node) bool {
+			return node.contexts.Has(context)
+		})
+		for _, node := range nodes {
+			if _, ok := seen[node.id]; !ok {
+				seen[node.id] = struct{}{}
+				ret = append(ret, node)
+			}
+		}
+		return false
+	})
+	return ret
+}
+
+func (v *graphView) edgesWithAnyContext(nodeID int, contexts *StringSet, dir EdgeDir) []*Edge {
+	v.begin()
+	defer v.end()
+	node := v.node(nodeID)
+	if node == nil {
+		return nil
+	}
+	ret := make([]*Edge, 0)
+	seen := make(map[int]struct{})
+	for _, edge := range v.nodeEdges(node, dir, nil) {
+		if _, ok := seen[edge.id]; ok || !edge.contexts.HasAnySet(contexts) {
+			continue
+		}
+		seen[edge.id] = struct{}{}
+		ret = append(ret, edge)
+	}
+	return ret
+}
+
+func (v *graphView) edgesBetweenContexts(fromContext, toContext string) EdgeIterator {
+	v.begin()
+	defer v.end()
+	sources := v.nodeSlice(v.collectNodes(v.live.index.nodesByContext.find(fromContext)), func(node *Node) bool {
+		return node.contexts.Has(fromContext)
+	})
+	ret := make([]*Edge, 0)
+	for _, node := range sources {
+		for _, edge := range v.nodeEdges(node, OutgoingEdge, nil) {
+			if edge.to.contexts.Has(toContext) {
+				ret = append(ret, edge)
+			}
+		}
+	}
+	return edgeIterator{newSliceIterator(ret)}
+}
+
+// nodeCandidates returns the candidate nodes for the pattern item
+// using the indexes of the live graph. The result may include nodes
+// that do not match the item.
+func (v *graphView) nodeCandidates(item *PatternItem) (NodeIterator, int) {
+	v.begin()
+	defer v.end()
+	itr, _ := item.indexedNodes(v.live)
+	if itr == nil {
+		return nil, -1
+	}
+	ids := v.collectNodes(itr)
+	return v.nodeIterator(ids, nil), ids.size()
+}
+
+// edgeCandidates returns the candidate edges for the pattern item
+// using the indexes of the live graph
+func (v *graphView) edgeCandidates(item PatternItem) (EdgeIterator, int) {
+	v.begin()
+	defer v.end()
+	itr, _ := item.indexedEdges(v.live)
+	if itr == nil {
+		return nil, -1
+	}
+	ids := v.collectEdges(itr)
+	return v.edgeIterator(ids, nil), ids.size()
+}
@@ -0,0 +1,319 @@
+// Copyright 2021 Cloud Privacy Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lpg
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// dumpSnapshot is dumpGraph without the ID counter, and with the
+// edges of the nodes
+func dumpSnapshot(g *Graph) string {
+	lines := strings.Split(dumpGraph(g), "\n")[1:]
+	for nodes := g.GetNodes(); nodes.Next(); {
+		node := nodes.Node()
+		ids := make([]string, 0)
+		for edges := node.GetEdges(AnyEdge); edges.Next(); {
+			ids = append(ids, fmt.Sprint(edges.Edge().id))
+		}
+		sort.Strings(ids)
+		lines = append(lines, fmt.Sprintf("adj %d %d/%d %v", node.id, node.IncomingEdgeCount(), node.OutgoingEdgeCount(), ids))
+	}
+	sort.Strings(lines)
+	return fmt.Sprintf("%d %d\n", g.NumNodes(), g.NumEdges()) + strings.Join(lines, "\n")
+}
+
+func TestSnapshot(t *testing.T) {
+	g, nodes := getTxTestGraph(t)
+	expected := dumpSnapshot(g)
+	snap := g.Snapshot()
+	assert.Equal(t, expected, dumpSnapshot(snap))
+
+	nodes[0].SetLabels(NewStringSet("Robot"))
+	nodes[1].SetProperty("name", "x")
+	nodes[1].SetProperty("age", 100)
+	nodes[2].SetContexts(NewStringSet("c2"))
+	assert.Nil(t, nodes[0].SetExternalID("zero"))
+	edge := EdgeSlice(nodes[0].GetEdges(OutgoingEdge))[0]
+	edge.SetLabel("likes")
+	edge.SetProperty("weight", 10)
+	nodes[4].DetachAndRemove()
+	n := g.NewNode([]string{"Person"}, map[string]interface{}{"name": "p1", "age": 1}, nil)
+	g.NewEdge(n, nodes[3], "knows", nil, nil)
+	tx := g.Begin()
+	nodes[3].DetachAndRemove()
+	assert.Nil(t, tx.Rollback())
+	assert.Equal(t, expected, dumpSnapshot(snap))
+	assert.Nil(t, g.Verify())
+
+	// A second snapshot sees the changes
+	expected2 := dumpSnapshot(g)
+	snap2 := g.Snapshot()
+	nodes[1].RemoveProperty("name")
+	nodes[3].DetachAndRemove()
+	assert.Equal(t, expected, dumpSnapshot(snap))
+	assert.Equal(t, expected2, dumpSnapshot(snap2))
+
+	// Queries use the state of the snapshot
+	itr, err := snap.FindNodes(nil, map[string]interface{}{"name": "p1"})
+	assert.Nil(t, err)
+	found := NodeSlice(itr)
+	if assert.Equal(t, 1, len(found)) {
+		assert.Equal(t, 1, found[0].GetID())
+		assert.Equal(t, snap, found[0].GetGraph())
+	}
+	node, err := snap.GetNodeByKey("Person", "name", "p0")
+	assert.Nil(t, err)
+	assert.Equal(t, snap.GetNodeByExternalID("first"), node)
+	assert.Equal(t, "first", node.GetExternalID())
+	assert.Nil(t, snap.GetNodeByExternalID("zero"))
+	assert.Equal(t, 5, len(NodeSlice(snap.GetNodesWithAllLabels(NewStringSet("Person")))))
+	assert.Equal(t, 4, len(EdgeSlice(snap.GetEdgesWithAnyLabel(NewStringSet("knows")))))
+	assert.Equal(t, 5, len(NodeSlice(snap.GetNodesWithProperty("age"))))
+	ordered, err := snap.GetNodesOrderedByProperty("age", true)
+	assert.Nil(t, err)
+	ages := make([]interface{}, 0)
+	for _, node := range NodeSlice(ordered) {
+		age, _ := node.GetProperty("age")
+		ages = append(ages, age)
+	}
+	assert.Equal(t, []interface{}{4, 3, 2, 1, 0}, ages)
+	ordered, err = snap2.GetNodesWithPropertyRange("age", 1, 100, true)
+	assert.Nil(t, err)
+	ages = ages[:0]
+	for _, node := range NodeSlice(ordered) {
+		age, _ := node.GetProperty("age")
+		ages = append(ages, age)
+	}
+	assert.Equal(t, []interface{}{1, 2, 3, 100}, ages)
+
+	acc := DefaultMatchAccumulator{}
+	assert.Nil(t, Pattern{
+		{Labels: NewStringSet("Person"), Properties: map[string]interface{}{"name": "p0"}},
+		{Labels: NewStringSet("knows"), Min: 1, Max: -1},
+		{},
+	}.Run(snap, map[string]*PatternSymbol{}, &acc))
+	assert.Equal(t, 4, len(acc.Paths))
+
+	snap.Release()
+	assert.Equal(t, expected2, dumpSnapshot(snap2))
+	snap2.Release()
+	assert.Nil(t, g.cow)
+	assert.Nil(t, g.Verify())
+}
+
+func TestSnapshotRelease(t *testing.T) {
+	g := NewGraph()
+	node := g.NewNode(nil, map[string]interface{}{"v": 0}, nil)
+	snaps := make([]*Graph, 0)
+	for i := 1; i <= 3; i++ {
+		snaps = append(snaps, g.Snapshot())
+		node.SetProperty("v", i)
+	}
+	assert.Equal(t, 3, len(g.cow.nodes[node.id].images))
+	snaps[1].Release()
+	assert.Equal(t, 2, len(g.cow.nodes[node.id].images))
+	for i, snap := range []*Graph{snaps[0], snaps[2]} {
+		v, _ := snap.GetNode(node.id).GetProperty("v")
+		assert.Equal(t, i*2, v)
+	}
+	snaps[0].Release()
+	snaps[2].Release()
+	assert.Nil(t, g.cow)
+	assert.Panics(t, func() { snaps[0].GetNodes() })
+
+	// Nodes created after the last snapshot are not saved
+	snap := g.Snapshot()
+	created := g.NewNode(nil, nil, nil)
+	created.SetProperty("v", 1)
+	created.DetachAndRemove()
+	assert.Empty(t, g.cow.nodes)
+	assert.Equal(t, 1, len(NodeSlice(snap.GetNodes())))
+	snap.Release()
+}
+
+func TestSnapshotCache(t *testing.T) {
+	g, nodes := getTxTestGraph(t)
+	snap := g.Snapshot()
+	defer snap.Release()
+	node := snap.GetNode(nodes[0].id)
+	edge := EdgeSlice(node.GetEdges(AnyEdge))[0]
+	// The same node or edge is returned while it is in use
+	assert.True(t, node == snap.GetNode(nodes[0].id))
+	assert.True(t, edge == snap.GetEdge(edge.id))
+	assert.True(t, node == edge.from || node == edge.to)
+	assert.Equal(t, len(nodes), len(NodeSlice(snap.GetNodes())))
+	assert.Equal(t, g.NumEdges(), len(EdgeSlice(snap.GetEdges())))
+
+	// The nodes and edges that are not referenced are dropped
+	cached := func() int {
+		v := snap.view
+		v.mu.Lock()
+		defer v.mu.Unlock()
+		return len(v.nodes) + len(v.edges)
+	}
+	for i := 0; i < 100 && cached() > 3; i++ {
+		runtime.GC()
+		time.Sleep(time.Millisecond)
+	}
+	// edge holds both of its nodes
+	assert.Equal(t, 3, cached())
+	runtime.KeepAlive(node)
+	runtime.KeepAlive(edge)
+}
+
+func TestSnapshotReadOnly(t *testing.T) {
+	g := NewGraph()
+	n1 := g.NewNode([]string{"a"}, nil, nil)
+	g.NewEdge(n1, n1, "self", nil, nil)
+	snap := g.Snapshot()
+	defer snap.Release()
+	node := snap.GetNode(n1.id)
+	edge := EdgeSlice(node.GetEdges(OutgoingEdge))[0]
+	assert.Equal(t, 2, len(EdgeSlice(node.GetEdges(AnyEdge))))
+	for _, f := range []func(){
+		func() { snap.NewNode(nil, nil, nil) },
+		func() { snap.NewEdge(node, node, "x", nil, nil) },
+		func() { node.SetProperty("x", 1) },
+		func() { node.SetLabels(NewStringSet("b")) },
+		func() { node.Detach() },
+		func() { node.SetExternalID("x") },
+		func() { edge.SetLabel("x") },
+		func() { edge.Remove() },
+		func() { snap.Begin() },
+		func() { snap.AddNodePropertyIndex("x", HashIndex) },
+		func() { snap.Snapshot() },
+	} {
+		assert.PanicsWithValue(t, ErrSnapshotReadOnly, f)
+	}
+	assert.Equal(t, 1, snap.NumNodes())
+	assert.Equal(t, 1, snap.NumEdges())
+}
+
+// Run with -race
+func TestSyncGraphSnapshot(t *testing.T) {
+	s := NewSyncGraph(NewGraph())
+	assert.Nil(t, s.Update(func(g *Graph) error {
+		prev := g.NewNode([]string{"item"}, map[string]interface{}{"key": 0}, nil)
+		for i := 1; i < 10; i++ {
+			node := g.NewNode([]string{"item"}, map[string]interface{}{"key": i}, nil)
+			g.NewEdge(prev, node, "next", nil, nil)
+			prev = node
+		}
+		return nil
+	}))
+	snap := s.Snapshot()
+	expected := dumpSnapshot(snap)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			assert.Nil(t, s.Update(func(g *Graph) error {
+				for _, node := range NodeSlice(g.GetNodes()) {
+					node.SetProperty("key", i)
+				}
+				g.NewNode([]string{"item"}, nil, nil)
+				return nil
+			}))
+		}
+	}()
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				assert.Equal(t, expected, dumpSnapshot(snap))
+			}
+		}()
+	}
+	wg.Wait()
+	snap.Release()
+}
+
+func TestSnapshotInUpdate(t *testing.T) {
+	s := NewSyncGraph(NewGraph())
+	var snap *Graph
+	err := s.Update(func(g *Graph) error {
+		g.NewNode([]string{"item"}, nil, nil)
+		snap = g.Snapshot()
+		return fmt.Errorf("rollback")
+	})
+	assert.NotNil(t, err)
+	assert.Nil(t, s.View(func(g *Graph) error {
+		assert.Equal(t, 0, g.NumNodes())
+		// The snapshot has the uncommitted node
+		assert.Equal(t, 1, len(NodeSlice(snap.GetNodes())))
+		return nil
+	}))
+	assert.Nil(t, s.Update(func(g *Graph) error {
+		snap.Release()
+		assert.Nil(t, g.cow)
+		return nil
+	}))
+}
+
+func TestSnapshotIterateWhileUpdating(t *testing.T) {
+	s := NewSyncGraph(NewGraph())
+	assert.Nil(t, s.Update(func(g *Graph) error {
+		for i := 0; i < 10; i++ {
+			node := g.NewNode([]string{"item"}, map[string]interface{}{"key": i}, nil)
+			assert.Nil(t, node.SetExternalID(fmt.Sprint("n", i)))
+		}
+		return nil
+	}))
+	snap := s.Snapshot()
+	defer snap.Release()
+	nodes := snap.GetNodes()
+	found, err := snap.FindNodes(NewStringSet("item"), nil)
+	assert.Nil(t, err)
+	assert.True(t, nodes.Next())
+	assert.True(t, found.Next())
+	assert.Nil(t, s.Update(func(g *Graph) error {
+		for _, node := range NodeSlice(g.GetNodes()) {
+			if node.GetID()%2 == 0 {
+				node.DetachAndRemove()
+			} else {
+				node.SetProperty("key", -1)
+				assert.Nil(t, node.SetExternalID(fmt.Sprint("m", node.GetID())))
+			}
+		}
+		g.NewNode([]string{"item"}, nil, nil)
+		return nil
+	}))
+	key, _ := nodes.Node().GetProperty("key")
+	keys := []interface{}{key}
+	for nodes.Next() {
+		key, _ := nodes.Node().GetProperty("key")
+		keys = append(keys, key)
+	}
+	assert.Equal(t, []interface{}{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, keys)
+	assert.Equal(t, 10, len(NodeSlice(found))+1)
+	for i := 0; i < 10; i++ {
+		node := snap.GetNodeByExternalID(fmt.Sprint("n", i))
+		if assert.NotNil(t, node) {
+			assert.Equal(t, i, node.GetID())
+		}
+		assert.Nil(t, snap.GetNodeByExternalID(fmt.Sprint("m", i)))
+	}
+}
@@ -0,0 +1,299 @@
+// Copyright 2021 Cloud Privacy Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lpg
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultCypherContextProperty is the property used to export
+// contexts if CypherExporter.ContextProperty is empty
+const DefaultCypherContextProperty = "contexts"
+
+// CypherExporter writes nodes and edges as a Cypher script that can
+// be run in Neo4j or Memgraph.
+//
+// If IdentityProperty is empty, the script is a single statement that
+// creates all nodes using generated variable names, and then creates
+// all relationships using those variables. If IdentityProperty is
+// set, every node and relationship is written as a separate
+// statement, and relationships find their nodes using the identity
+// property.
+type CypherExporter struct {
+	// IdentityProperty is the node property that identifies a
+	// node. If set, all exported nodes must have this property.
+	IdentityProperty string
+
+	// ContextProperty is the property name contexts are written
+	// to. If empty, DefaultCypherContextProperty is used. Contexts are
+	// written as a list of strings, and only if they are not empty.
+	ContextProperty string
+
+	// If Merge is true, MERGE is used instead of CREATE, so the script
+	// can be run more than once.
+	Merge bool
+}
+
+func (c CypherExporter) contextProperty() string {
+	if len(c.ContextProperty) == 0 {
+		return DefaultCypherContextProperty
+	}
+	return c.ContextProperty
+}
+
+func (c CypherExporter) verb() string {
+	if c.Merge {
+		return "MERGE"
+	}
+	return "CREATE"
+}
+
+// Export writes all nodes and edges of the graph
+func (c CypherExporter) Export(g *Graph, out io.Writer) error {
+	return c.ExportNodesEdges(g.GetNodes(), g.GetEdges(), out)
+}
+
+// ExportPaths writes the nodes and edges of all the paths, such as
+// the paths of a DefaultMatchAccumulator. Every node and edge is
+// written once.
+func (c CypherExporter) ExportPaths(paths []*Path, out io.Writer) error {
+	nodes := NewNodeSet()
+	edges := NewEdgeSet()
+	for _, path := range paths {
+		for i := 0; i < path.NumNodes(); i++ {
+			nodes.Add(path.GetNode(i))
+		}
+		for i := 0; i < path.NumEdges(); i++ {
+			edges.Add(path.GetEdge(i))
+		}
+	}
+	return c.ExportNodesEdges(nodes.Iterator(), edges.Iterator(), out)
+}
+
+// ExportNodesEdges writes the nodes and edges. Edges whose from or to
+// node is not in nodes are skipped.
+func (c CypherExporter) ExportNodesEdges(nodes NodeIterator, edges EdgeIterator, out io.Writer) error {
+	if len(c.IdentityProperty) == 0 {
+		return c.exportWithVariables(nodes, edges, out)
+	}
+	return c.exportWithIdentity(nodes, edges, out)
+}
+
+func (c CypherExporter) exportWithVariables(nodes NodeIterator, edges EdgeIterator, out io.Writer) error {
+	nodeMap := make(map[*Node]string)
+	for nodes.Next() {
+		node := nodes.Node()
+		if _, seen := nodeMap[node]; seen {
+			continue
+		}
+		v := fmt.Sprintf("n%d", len(nodeMap))
+		nodeMap[node] = v
+		if _, err := fmt.Fprintf(out, "%s (%s%s %s)\n", c.verb(), v, cypherLabels(node.labels), c.nodeProperties(node)); err != nil {
+			return err
+		}
+	}
+	for edges.Next() {
+		edge := edges.Edge()
+		from, ok1 := nodeMap[edge.from]
+		to, ok2 := nodeMap[edge.to]
+		if !ok1 || !ok2 {
+			continue
+		}
+		rel, err := c.relationship(edge)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(out, "%s (%s)-%s->(%s)\n", c.verb(), from, rel, to); err != nil {
+			return err
+		}
+	}
+	if len(nodeMap) > 0 {
+		if _, err := io.WriteString(out, ";\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c CypherExporter) exportWithIdentity(nodes NodeIterator, edges EdgeIterator, out io.Writer) error {
+	nodeMap := make(map[*Node]string)
+	for nodes.Next() {
+		node := nodes.Node()
+		if _, seen := nodeMap[node]; seen {
+			continue
+		}
+		id, ok := node.GetProperty(c.IdentityProperty)
+		if !ok {
+			return fmt.Errorf("node %s does not have identity property %s", node, c.IdentityProperty)
+		}
+		key := fmt.Sprintf("%s {%s: %s}", cypherLabels(node.labels), cypherName(c.IdentityProperty), cypherValue(id))
+		nodeMap[node] = key
+		var err error
+		if c.Merge {
+			_, err = fmt.Fprintf(out, "MERGE (n%s) SET n += %s;\n", key, c.nodeProperties(node))
+		} else {
+			_, err = fmt.Fprintf(out, "CREATE (n%s %s);\n", cypherLabels(node.labels), c.nodeProperties(node))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for edges.Next() {
+		edge := edges.Edge()
+		from, ok1 := nodeMap[edge.from]
+		to, ok2 := nodeMap[edge.to]
+		if !ok1 || !ok2 {
+			continue
+		}
+		rel, err := c.relationship(edge)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(out, "MATCH (a%s), (b%s) %s (a)-%s->(b);\n", from, to, c.verb(), rel); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c CypherExporter) relationship(edge *Edge) (string, error) {
+	if len(edge.label) == 0 {
+		return "", errors.New("cannot export edge without label")
+	}
+	props := cypherMap(edge.properties, c.contextProperty(), edge.contexts)
+	if props == "{}" {
+		return fmt.Sprintf("[:%s]", cypherName(edge.label)), nil
+	}
+	return fmt.Sprintf("[:%s %s]", cypherName(edge.label), props), nil
+}
+
+func (c CypherExporter) nodeProperties(node *Node) string {
+	return cypherMap(node.properties, c.contextProperty(), node.contexts)
+}
+
+func cypherLabels(labels *StringSet) string {
+	sb := strings.Builder{}
+	for _, l := range labels.SortedSlice() {
+		sb.WriteString(":")
+		sb.WriteString(cypherName(l))
+	}
+	return sb.String()
+}
+
+// cypherMap returns the properties and contexts as a Cypher map literal
+func cypherMap(p properties, contextProperty string, contexts *StringSet) string {
+	keys := make([]string, 0, len(p)+1)
+	for k := range p {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	elements := make([]string, 0, len(keys)+1)
+	for _, k := range keys {
+		elements = append(elements, cypherName(k)+": "+cypherValue(p[k]))
+	}
+	if contexts.Len() > 0 {
+		elements = append(elements, cypherName(contextProperty)+": "+cypherValue(contexts.Slice()))
+	}
+	return "{" + strings.Join(elements, ", ") + "}"
+}
+
+// cypherName returns the name as is if it is a valid identifier, or
+// escaped with backticks otherwise
+func cypherName(name string) string {
+	valid := len(name) > 0
+	for i, c := range name {
+		if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9') {
+			continue
+		}
+		valid = false
+		break
+	}
+	if valid {
+		return name
+	}
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+func cypherString(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `'`, `\'`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+	return "'" + r.Replace(s) + "'"
+}
+
+// cypherValue returns the value as a Cypher literal
+func cypherValue(value interface{}) string {
+	if n, ok := value.(WithNativeValue); ok {
+		value = n.GetNativeValue()
+	}
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return cypherString(v)
+	case bool:
+		return strconv.FormatBool(v)
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return fmt.Sprint(v)
+	case float32:
+		return cypherFloat(float64(v))
+	case float64:
+		return cypherFloat(v)
+	case time.Time:
+		return "datetime(" + cypherString(v.Format(time.RFC3339Nano)) + ")"
+	case []string:
+		elements := make([]string, 0, len(v))
+		for _, x := range v {
+			elements = append(elements, cypherString(x))
+		}
+		return "[" + strings.Join(elements, ", ") + "]"
+	case []int:
+		elements := make([]string, 0, len(v))
+		for _, x := range v {
+			elements = append(elements, strconv.Itoa(x))
+		}
+		return "[" + strings.Join(elements, ", ") + "]"
+	case []interface{}:
+		elements := make([]string, 0, len(v))
+		for _, x := range v {
+			elements = append(elements, cypherValue(x))
+		}
+		return "[" + strings.Join(elements, ", ") + "]"
+	case map[string]interface{}:
+		return cypherMap(v, "", nil)
+	}
+	return cypherString(fmt.Sprint(value))
+}
+
+func cypherFloat(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "0.0/0.0"
+	case math.IsInf(f, 1):
+		return "1.0/0.0"
+	case math.IsInf(f, -1):
+		return "-1.0/0.0"
+	}
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eE") {
+		s += ".0"
+	}
+	return s
+}
@@ -0,0 +1,88 @@
+// Copyright 2021 Cloud Privacy Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lpg
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func getCypherTestGraph() (*Graph, []*Node) {
+	g := NewGraph()
+	n1 := g.NewNode([]string{"Person"}, map[string]interface{}{"id": 1, "name": "O'Brien"}, NewStringSet("c1"))
+	n2 := g.NewNode([]string{"Person", "Employee"}, map[string]interface{}{"id": 2, "score": 1.0}, nil)
+	n3 := g.NewNode([]string{"my label"}, map[string]interface{}{"id": 3, "tags": []string{"a", "b"}}, nil)
+	g.NewEdge(n1, n2, "KNOWS", map[string]interface{}{"since": 2020}, nil)
+	g.NewEdge(n2, n3, "OWNS", nil, NewStringSet("c2"))
+	return g, []*Node{n1, n2, n3}
+}
+
+func TestCypherExportVariables(t *testing.T) {
+	g, _ := getCypherTestGraph()
+	buf := bytes.Buffer{}
+	if err := (CypherExporter{}).Export(g, &buf); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, `CREATE (n0:Person {id: 1, name: 'O\'Brien', contexts: ['c1']})
+CREATE (n1:Employee:Person {id: 2, score: 1.0})
+CREATE (n2:`+"`my label`"+` {id: 3, tags: ['a', 'b']})
+CREATE (n0)-[:KNOWS {since: 2020}]->(n1)
+CREATE (n1)-[:OWNS {contexts: ['c2']}]->(n2)
+;
+`, buf.String())
+
+	buf.Reset()
+	if err := (CypherExporter{Merge: true, ContextProperty: "ctx"}).Export(g, &buf); err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(t, buf.String(), `MERGE (n0:Person {id: 1, name: 'O\'Brien', ctx: ['c1']})`)
+	assert.Contains(t, buf.String(), `MERGE (n0)-[:KNOWS {since: 2020}]->(n1)`)
+}
+
+func TestCypherExportIdentity(t *testing.T) {
+	g, _ := getCypherTestGraph()
+	buf := bytes.Buffer{}
+	if err := (CypherExporter{IdentityProperty: "id", Merge: true}).Export(g, &buf); err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(t, buf.String(), "MERGE (n:Person {id: 1}) SET n += {id: 1, name: 'O\\'Brien', contexts: ['c1']};\n")
+	assert.Contains(t, buf.String(), "MATCH (a:Person {id: 1}), (b:Employee:Person {id: 2}) MERGE (a)-[:KNOWS {since: 2020}]->(b);\n")
+
+	g.NewNode([]string{"Person"}, nil, nil)
+	assert.Error(t, (CypherExporter{IdentityProperty: "id"}).Export(g, &buf))
+}
+
+func TestCypherExportPaths(t *testing.T) {
+	g, _ := getCypherTestGraph()
+	pat := Pattern{
+		{Labels: NewStringSet("Employee")},
+		{Min: 1, Max: 1},
+		{},
+	}
+	acc, err := pat.FindPaths(g, map[string]*PatternSymbol{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := bytes.Buffer{}
+	if err := (CypherExporter{}).ExportPaths(acc.Paths, &buf); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, `CREATE (n0:Employee:Person {id: 2, score: 1.0})
+CREATE (n1:`+"`my label`"+` {id: 3, tags: ['a', 'b']})
+CREATE (n0)-[:OWNS {contexts: ['c2']}]->(n1)
+;
+`, buf.String())
+}
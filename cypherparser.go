@@ -0,0 +1,1124 @@
+// Copyright 2021 Cloud Privacy Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lpg
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrQuerySyntax is returned by ParseQuery for malformed queries. Pos
+// is the byte offset of the error in the query, Line and Column are
+// 1-based.
+type ErrQuerySyntax struct {
+	Pos    int
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e ErrQuerySyntax) Error() string {
+	return fmt.Sprintf("syntax error at line %d, column %d: %s", e.Line, e.Column, e.Msg)
+}
+
+// Query is a compiled read-only query of the form
+//
+//	MATCH (a:Label {k:'v'})-[:REL*1..3]->(b) RETURN a, b
+//
+// The MATCH clause is compiled into a Pattern. Named variables are
+// bound to PatternItem.Name.
+type Query struct {
+	Pattern Pattern
+	// Return contains the returned variable names
+	Return []string
+}
+
+// ParseQuery parses a query in the supported openCypher subset:
+//
+//   - A single MATCH clause containing one path pattern
+//   - Nodes with an optional variable, labels, and a property map
+//   - Relationships in any direction with an optional variable,
+//     alternative labels (:A|B), a variable length (*, *n, *n..m,
+//     *..m, *n..), and a property map
+//   - An optional WHERE clause with comparisons (=, <>, <, <=, >, >=),
+//     IN, STARTS WITH, =~, IS NULL, IS NOT NULL on the properties of
+//     a variable, combined with AND, OR, NOT, and parentheses
+//   - A RETURN clause listing variables, or *
+//
+// Property maps may contain strings, integers, floats, booleans, null,
+// and lists of those. WHERE conditions on a single variable are
+// compiled into the Where predicates of the pattern items of that
+// variable. Conditions that relate several variables, such as
+// a.owner = b.id or a <> b, are compiled into pattern constraints.
+func ParseQuery(query string) (*Query, error) {
+	p := &queryParser{input: query}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	return p.parseQuery()
+}
+
+// GetPlan returns the match execution plan of the query pattern
+func (q *Query) GetPlan(graph *Graph, symbols map[string]*PatternSymbol) (MatchPlan, error) {
+	return q.Pattern.GetPlan(graph, symbols)
+}
+
+// Run runs the query and returns a row for each match. Each row maps
+// the returned variable names to their values, a *Node for node
+// variables and a *Path for relationship variables.
+func (q *Query) Run(graph *Graph, symbols map[string]*PatternSymbol) ([]map[string]interface{}, error) {
+	acc := DefaultMatchAccumulator{}
+	if err := q.Pattern.Run(graph, symbols, &acc); err != nil {
+		return nil, err
+	}
+	rows := make([]map[string]interface{}, 0, len(acc.Symbols))
+	for _, symbols := range acc.Symbols {
+		row := make(map[string]interface{}, len(q.Return))
+		for _, name := range q.Return {
+			row[name] = symbols[name]
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+type queryTokenKind int
+
+const (
+	qtEOF queryTokenKind = iota
+	qtIdent
+	qtQuotedIdent
+	qtString
+	qtInt
+	qtFloat
+	qtPunct
+)
+
+type queryToken struct {
+	kind queryTokenKind
+	text string
+	pos  int
+}
+
+// queryParser is a recursive descent parser that reads one token
+// ahead
+type queryParser struct {
+	input string
+	pos   int
+	tok   queryToken
+}
+
+func (p *queryParser) errorAt(pos int, format string, args ...interface{}) error {
+	line, col := 1, 1
+	for i, c := range p.input {
+		if i >= pos {
+			break
+		}
+		if c == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+	}
+	return ErrQuerySyntax{Pos: pos, Line: line, Column: col, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *queryParser) unexpected(expected string) error {
+	if p.tok.kind == qtEOF {
+		return p.errorAt(p.tok.pos, "expected %s, got end of input", expected)
+	}
+	return p.errorAt(p.tok.pos, "expected %s, got '%s'", expected, p.tok.text)
+}
+
+// next reads the next token into p.tok
+func (p *queryParser) next() error {
+	for p.pos < len(p.input) {
+		r, sz := utf8.DecodeRuneInString(p.input[p.pos:])
+		if unicode.IsSpace(r) {
+			p.pos += sz
+			continue
+		}
+		if strings.HasPrefix(p.input[p.pos:], "//") {
+			for p.pos < len(p.input) && p.input[p.pos] != '\n' {
+				p.pos++
+			}
+			continue
+		}
+		break
+	}
+	start := p.pos
+	if p.pos >= len(p.input) {
+		p.tok = queryToken{kind: qtEOF, pos: start}
+		return nil
+	}
+	r, sz := utf8.DecodeRuneInString(p.input[p.pos:])
+	switch {
+	case r == '_' || unicode.IsLetter(r):
+		p.pos += sz
+		for p.pos < len(p.input) {
+			r, sz := utf8.DecodeRuneInString(p.input[p.pos:])
+			if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+				break
+			}
+			p.pos += sz
+		}
+		p.tok = queryToken{kind: qtIdent, text: p.input[start:p.pos], pos: start}
+		return nil
+
+	case r >= '0' && r <= '9':
+		return p.readNumber(start)
+
+	case r == '`':
+		sb := strings.Builder{}
+		p.pos++
+		for {
+			if p.pos >= len(p.input) {
+				return p.errorAt(start, "unterminated quoted name")
+			}
+			c := p.input[p.pos]
+			p.pos++
+			if c == '`' {
+				if p.pos < len(p.input) && p.input[p.pos] == '`' {
+					sb.WriteByte('`')
+					p.pos++
+					continue
+				}
+				break
+			}
+			sb.WriteByte(c)
+		}
+		p.tok = queryToken{kind: qtQuotedIdent, text: sb.String(), pos: start}
+		return nil
+
+	case r == '\'' || r == '"':
+		return p.readString(start, byte(r))
+	}
+	for _, punct := range []string{"..", "<>", "<=", ">=", "=~"} {
+		if strings.HasPrefix(p.input[p.pos:], punct) {
+			p.pos += len(punct)
+			p.tok = queryToken{kind: qtPunct, text: punct, pos: start}
+			return nil
+		}
+	}
+	if strings.ContainsRune("()[]{}:,-<>*.|=+/%", r) {
+		p.pos += sz
+		p.tok = queryToken{kind: qtPunct, text: string(r), pos: start}
+		return nil
+	}
+	return p.errorAt(start, "unexpected character '%c'", r)
+}
+
+func (p *queryParser) readNumber(start int) error {
+	for p.pos < len(p.input) && p.input[p.pos] >= '0' && p.input[p.pos] <= '9' {
+		p.pos++
+	}
+	kind := qtInt
+	// A '.' followed by a digit is a fraction. Otherwise it may be a
+	// range operator
+	if p.pos+1 < len(p.input) && p.input[p.pos] == '.' && p.input[p.pos+1] >= '0' && p.input[p.pos+1] <= '9' {
+		kind = qtFloat
+		p.pos++
+		for p.pos < len(p.input) && p.input[p.pos] >= '0' && p.input[p.pos] <= '9' {
+			p.pos++
+		}
+	}
+	if p.pos < len(p.input) && (p.input[p.pos] == 'e' || p.input[p.pos] == 'E') {
+		kind = qtFloat
+		p.pos++
+		if p.pos < len(p.input) && (p.input[p.pos] == '+' || p.input[p.pos] == '-') {
+			p.pos++
+		}
+		digits := p.pos
+		for p.pos < len(p.input) && p.input[p.pos] >= '0' && p.input[p.pos] <= '9' {
+			p.pos++
+		}
+		if digits == p.pos {
+			return p.errorAt(start, "malformed number")
+		}
+	}
+	p.tok = queryToken{kind: kind, text: p.input[start:p.pos], pos: start}
+	return nil
+}
+
+func (p *queryParser) readString(start int, quote byte) error {
+	sb := strings.Builder{}
+	p.pos++
+	for {
+		if p.pos >= len(p.input) {
+			return p.errorAt(start, "unterminated string")
+		}
+		c := p.input[p.pos]
+		p.pos++
+		if c == quote {
+			break
+		}
+		if c != '\\' {
+			sb.WriteByte(c)
+			continue
+		}
+		if p.pos >= len(p.input) {
+			return p.errorAt(start, "unterminated string")
+		}
+		c = p.input[p.pos]
+		p.pos++
+		switch c {
+		case 'n':
+			sb.WriteByte('\n')
+		case 'r':
+			sb.WriteByte('\r')
+		case 't':
+			sb.WriteByte('\t')
+		case '\\', '\'', '"':
+			sb.WriteByte(c)
+		default:
+			return p.errorAt(p.pos-2, "invalid escape sequence '\\%c'", c)
+		}
+	}
+	p.tok = queryToken{kind: qtString, text: sb.String(), pos: start}
+	return nil
+}
+
+// isPunct returns true if the current token is the given punctuation
+func (p *queryParser) isPunct(punct string) bool {
+	return p.tok.kind == qtPunct && p.tok.text == punct
+}
+
+// isKeyword returns true if the current token is the given keyword
+func (p *queryParser) isKeyword(keyword string) bool {
+	return p.tok.kind == qtIdent && strings.EqualFold(p.tok.text, keyword)
+}
+
+func (p *queryParser) expectPunct(punct string) error {
+	if !p.isPunct(punct) {
+		return p.unexpected("'" + punct + "'")
+	}
+	return p.next()
+}
+
+func (p *queryParser) expectKeyword(keyword string) error {
+	if !p.isKeyword(keyword) {
+		return p.unexpected(keyword)
+	}
+	return p.next()
+}
+
+// parseName parses a variable, label, or property name
+func (p *queryParser) parseName(what string) (string, error) {
+	if p.tok.kind != qtIdent && p.tok.kind != qtQuotedIdent {
+		return "", p.unexpected(what)
+	}
+	name := p.tok.text
+	return name, p.next()
+}
+
+func (p *queryParser) parseQuery() (*Query, error) {
+	if err := p.expectKeyword("MATCH"); err != nil {
+		return nil, err
+	}
+	q := &Query{}
+	// Variable kinds and their positions
+	nodeVars := make(map[string]int)
+	edgeVars := make(map[string]int)
+	pattern, err := p.parsePattern(nodeVars, edgeVars)
+	if err != nil {
+		return nil, err
+	}
+	q.Pattern = pattern
+	if p.isKeyword("WHERE") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		conditions, err := p.parseOr(nodeVars, edgeVars)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range conditions {
+			if c.constraint != nil {
+				// Attach to the first item of the first variable
+				variable := c.constraint.Variables()[0]
+				for i := range q.Pattern {
+					if q.Pattern[i].Name == variable {
+						q.Pattern[i].Constraints = append(q.Pattern[i].Constraints, c.constraint)
+						break
+					}
+				}
+				continue
+			}
+			for i := range q.Pattern {
+				if q.Pattern[i].Name == c.variable {
+					q.Pattern[i].Where = andPredicates(q.Pattern[i].Where, c.predicate)
+				}
+			}
+		}
+	}
+	if err := p.expectKeyword("RETURN"); err != nil {
+		return nil, err
+	}
+	if p.isPunct("*") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		q.Return = pattern.GetSymbolNames().SortedSlice()
+	} else {
+		seen := make(map[string]struct{})
+		for {
+			pos := p.tok.pos
+			name, err := p.parseName("variable")
+			if err != nil {
+				return nil, err
+			}
+			_, node := nodeVars[name]
+			_, edge := edgeVars[name]
+			if !node && !edge {
+				return nil, p.errorAt(pos, "variable '%s' is not defined", name)
+			}
+			if _, dup := seen[name]; !dup {
+				seen[name] = struct{}{}
+				q.Return = append(q.Return, name)
+			}
+			if !p.isPunct(",") {
+				break
+			}
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if p.tok.kind != qtEOF {
+		return nil, p.unexpected("end of input")
+	}
+	return q, nil
+}
+
+func (p *queryParser) parsePattern(nodeVars, edgeVars map[string]int) (Pattern, error) {
+	pattern := Pattern{}
+	for {
+		node, err := p.parseNode(nodeVars, edgeVars)
+		if err != nil {
+			return nil, err
+		}
+		pattern = append(pattern, node)
+		if !p.isPunct("-") && !p.isPunct("<") {
+			return pattern, nil
+		}
+		edge, err := p.parseRelationship(nodeVars, edgeVars)
+		if err != nil {
+			return nil, err
+		}
+		pattern = append(pattern, edge)
+	}
+}
+
+// defineVariable records the variable, and makes sure it is not used
+// as both a node and an edge variable. An edge variable cannot be
+// used more than once
+func (p *queryParser) defineVariable(name string, pos int, isNode bool, nodeVars, edgeVars map[string]int) error {
+	if isNode {
+		if _, exists := edgeVars[name]; exists {
+			return p.errorAt(pos, "variable '%s' is already defined as a relationship", name)
+		}
+		if _, exists := nodeVars[name]; !exists {
+			nodeVars[name] = pos
+		}
+		return nil
+	}
+	if _, exists := nodeVars[name]; exists {
+		return p.errorAt(pos, "variable '%s' is already defined as a node", name)
+	}
+	if _, exists := edgeVars[name]; exists {
+		return p.errorAt(pos, "relationship variable '%s' cannot be used more than once", name)
+	}
+	edgeVars[name] = pos
+	return nil
+}
+
+func (p *queryParser) parseNode(nodeVars, edgeVars map[string]int) (PatternItem, error) {
+	item := PatternItem{}
+	if err := p.expectPunct("("); err != nil {
+		return item, err
+	}
+	if p.tok.kind == qtIdent || p.tok.kind == qtQuotedIdent {
+		pos := p.tok.pos
+		name, err := p.parseName("variable")
+		if err != nil {
+			return item, err
+		}
+		if err := p.defineVariable(name, pos, true, nodeVars, edgeVars); err != nil {
+			return item, err
+		}
+		item.Name = name
+	}
+	for p.isPunct(":") {
+		if err := p.next(); err != nil {
+			return item, err
+		}
+		label, err := p.parseName("label")
+		if err != nil {
+			return item, err
+		}
+		if item.Labels == nil {
+			item.Labels = NewStringSet()
+		}
+		item.Labels.Add(label)
+	}
+	if p.isPunct("{") {
+		props, err := p.parsePropertyMap()
+		if err != nil {
+			return item, err
+		}
+		item.Properties = props
+	}
+	if err := p.expectPunct(")"); err != nil {
+		return item, err
+	}
+	return item, nil
+}
+
+// parseRelationship parses one of -[...]->, <-[...]-, or -[...]-
+func (p *queryParser) parseRelationship(nodeVars, edgeVars map[string]int) (PatternItem, error) {
+	item := PatternItem{Min: 1, Max: 1}
+	start := p.tok.pos
+	if p.isPunct("<") {
+		item.ToLeft = true
+		if err := p.next(); err != nil {
+			return item, err
+		}
+	}
+	if err := p.expectPunct("-"); err != nil {
+		return item, err
+	}
+	if p.isPunct("[") {
+		if err := p.next(); err != nil {
+			return item, err
+		}
+		if err := p.parseRelationshipDetail(&item, nodeVars, edgeVars); err != nil {
+			return item, err
+		}
+		if err := p.expectPunct("]"); err != nil {
+			return item, err
+		}
+	}
+	if err := p.expectPunct("-"); err != nil {
+		return item, err
+	}
+	if p.isPunct(">") {
+		if item.ToLeft {
+			return item, p.errorAt(start, "relationship cannot point in both directions")
+		}
+		if err := p.next(); err != nil {
+			return item, err
+		}
+	} else if !item.ToLeft {
+		item.Undirected = true
+	}
+	return item, nil
+}
+
+func (p *queryParser) parseRelationshipDetail(item *PatternItem, nodeVars, edgeVars map[string]int) error {
+	if p.tok.kind == qtIdent || p.tok.kind == qtQuotedIdent {
+		pos := p.tok.pos
+		name, err := p.parseName("variable")
+		if err != nil {
+			return err
+		}
+		if err := p.defineVariable(name, pos, false, nodeVars, edgeVars); err != nil {
+			return err
+		}
+		item.Name = name
+	}
+	if p.isPunct(":") {
+		item.Labels = NewStringSet()
+		if err := p.next(); err != nil {
+			return err
+		}
+		for {
+			label, err := p.parseName("relationship type")
+			if err != nil {
+				return err
+			}
+			item.Labels.Add(label)
+			if !p.isPunct("|") {
+				break
+			}
+			if err := p.next(); err != nil {
+				return err
+			}
+			// Both :A|B and :A|:B are accepted
+			if p.isPunct(":") {
+				if err := p.next(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if p.isPunct("*") {
+		if err := p.parseRange(item); err != nil {
+			return err
+		}
+	}
+	if p.isPunct("{") {
+		props, err := p.parsePropertyMap()
+		if err != nil {
+			return err
+		}
+		item.Properties = props
+	}
+	return nil
+}
+
+// parseRange parses *, *n, *n..m, *..m, and *n..
+func (p *queryParser) parseRange(item *PatternItem) error {
+	start := p.tok.pos
+	if err := p.next(); err != nil {
+		return err
+	}
+	item.Min, item.Max = 1, -1
+	if p.tok.kind == qtInt {
+		n, err := p.parseInt()
+		if err != nil {
+			return err
+		}
+		item.Min, item.Max = n, n
+	}
+	if p.isPunct("..") {
+		if err := p.next(); err != nil {
+			return err
+		}
+		item.Max = -1
+		if p.tok.kind == qtInt {
+			n, err := p.parseInt()
+			if err != nil {
+				return err
+			}
+			item.Max = n
+		}
+	}
+	if item.Max != -1 && item.Max < item.Min {
+		return p.errorAt(start, "invalid range: %d..%d", item.Min, item.Max)
+	}
+	return nil
+}
+
+func (p *queryParser) parseInt() (int, error) {
+	n, err := strconv.Atoi(p.tok.text)
+	if err != nil {
+		return 0, p.errorAt(p.tok.pos, "invalid integer: %s", p.tok.text)
+	}
+	return n, p.next()
+}
+
+func (p *queryParser) parsePropertyMap() (map[string]interface{}, error) {
+	if err := p.expectPunct("{"); err != nil {
+		return nil, err
+	}
+	ret := make(map[string]interface{})
+	if p.isPunct("}") {
+		return ret, p.next()
+	}
+	for {
+		pos := p.tok.pos
+		key, err := p.parseName("property name")
+		if err != nil {
+			return nil, err
+		}
+		if _, exists := ret[key]; exists {
+			return nil, p.errorAt(pos, "duplicate property '%s'", key)
+		}
+		if err := p.expectPunct(":"); err != nil {
+			return nil, err
+		}
+		value, err := p.parseLiteral()
+		if err != nil {
+			return nil, err
+		}
+		ret[key] = value
+		if p.isPunct("}") {
+			return ret, p.next()
+		}
+		if err := p.expectPunct(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// parseLiteral parses a string, number, boolean, null, or a list of
+// literals
+func (p *queryParser) parseLiteral() (interface{}, error) {
+	tok := p.tok
+	switch tok.kind {
+	case qtString:
+		return tok.text, p.next()
+	case qtInt, qtFloat:
+		return p.parseNumber(false)
+	case qtIdent:
+		switch {
+		case strings.EqualFold(tok.text, "true"):
+			return true, p.next()
+		case strings.EqualFold(tok.text, "false"):
+			return false, p.next()
+		case strings.EqualFold(tok.text, "null"):
+			return nil, p.next()
+		}
+	case qtPunct:
+		switch tok.text {
+		case "-":
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			if p.tok.kind != qtInt && p.tok.kind != qtFloat {
+				return nil, p.unexpected("number")
+			}
+			return p.parseNumber(true)
+		case "[":
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			list := make([]interface{}, 0)
+			if p.isPunct("]") {
+				return list, p.next()
+			}
+			for {
+				v, err := p.parseLiteral()
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, v)
+				if p.isPunct("]") {
+					return list, p.next()
+				}
+				if err := p.expectPunct(","); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return nil, p.unexpected("literal value")
+}
+
+func (p *queryParser) parseNumber(negative bool) (interface{}, error) {
+	text := p.tok.text
+	if negative {
+		text = "-" + text
+	}
+	if p.tok.kind == qtInt {
+		n, err := strconv.Atoi(text)
+		if err != nil {
+			return nil, p.errorAt(p.tok.pos, "invalid integer: %s", text)
+		}
+		return n, p.next()
+	}
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return nil, p.errorAt(p.tok.pos, "invalid number: %s", text)
+	}
+	return f, p.next()
+}
+
+// queryCondition is a WHERE condition. A condition on the properties
+// of a single variable is a predicate. Other conditions are
+// constraints.
+type queryCondition struct {
+	variable   string
+	predicate  Predicate
+	constraint VariablePredicate
+	pos        int
+}
+
+// andPredicates returns the conjunction of the two predicates, either
+// of which can be nil
+func andPredicates(p1, p2 Predicate) Predicate {
+	if p1 == nil {
+		return p2
+	}
+	if p2 == nil {
+		return p1
+	}
+	if and, ok := p1.(AndPredicate); ok {
+		return append(append(AndPredicate{}, and...), p2)
+	}
+	return AndPredicate{p1, p2}
+}
+
+// itemConstraint evaluates a predicate on a variable as a constraint
+type itemConstraint struct {
+	variable  string
+	predicate Predicate
+}
+
+func (c itemConstraint) Variables() []string { return []string{c.variable} }
+
+func (c itemConstraint) EvalVariables(values map[string]interface{}) bool {
+	return c.evalVariablesTernary(values) == ternaryTrue
+}
+
+func (c itemConstraint) evalVariablesTernary(values map[string]interface{}) ternary {
+	item, ok := PropertyRef{Variable: c.variable}.getValue(values)
+	if !ok {
+		return ternaryUnknown
+	}
+	return evalPredicate(c.predicate, item.(PropertyGetter))
+}
+
+// combinedConstraint is the conjunction, or the disjunction if or is
+// true, of constraints
+type combinedConstraint struct {
+	vars        []string
+	constraints []VariablePredicate
+	or          bool
+}
+
+func (c combinedConstraint) Variables() []string { return c.vars }
+
+func (c combinedConstraint) EvalVariables(values map[string]interface{}) bool {
+	return c.evalVariablesTernary(values) == ternaryTrue
+}
+
+func (c combinedConstraint) evalVariablesTernary(values map[string]interface{}) ternary {
+	return foldTernary(c.or, len(c.constraints), func(i int) ternary {
+		return evalVariablePredicate(c.constraints[i], values)
+	})
+}
+
+// notConstraint negates a constraint
+type notConstraint struct {
+	constraint VariablePredicate
+}
+
+func (c notConstraint) Variables() []string { return c.constraint.Variables() }
+
+func (c notConstraint) EvalVariables(values map[string]interface{}) bool {
+	return c.evalVariablesTernary(values) == ternaryTrue
+}
+
+func (c notConstraint) evalVariablesTernary(values map[string]interface{}) ternary {
+	return evalVariablePredicate(c.constraint, values).not()
+}
+
+func (c queryCondition) asConstraint() VariablePredicate {
+	if c.constraint != nil {
+		return c.constraint
+	}
+	return itemConstraint{variable: c.variable, predicate: c.predicate}
+}
+
+// combineConditions combines the conditions into one using AND or
+// OR. If all conditions are predicates on the same variable, the
+// result is a predicate. Otherwise, it is a constraint.
+func combineConditions(conditions []queryCondition, or bool) queryCondition {
+	if len(conditions) == 1 {
+		return conditions[0]
+	}
+	ret := queryCondition{variable: conditions[0].variable, pos: conditions[0].pos}
+	single := true
+	for _, c := range conditions {
+		if c.constraint != nil || c.variable != ret.variable {
+			single = false
+			break
+		}
+	}
+	if single {
+		predicates := make([]Predicate, 0, len(conditions))
+		for _, c := range conditions {
+			predicates = append(predicates, c.predicate)
+		}
+		if or {
+			ret.predicate = OrPredicate(predicates)
+		} else {
+			ret.predicate = AndPredicate(predicates)
+		}
+		return ret
+	}
+	constraints := make([]VariablePredicate, 0, len(conditions))
+	variables := NewStringSet()
+	for _, c := range conditions {
+		x := c.asConstraint()
+		constraints = append(constraints, x)
+		variables.Add(x.Variables()...)
+	}
+	ret.variable = ""
+	ret.constraint = combinedConstraint{vars: variables.Slice(), constraints: constraints, or: or}
+	return ret
+}
+
+// parseOr parses a disjunction. If there is no OR, the conjuncts are
+// returned as separate conditions, so each can be attached to its own
+// variable
+func (p *queryParser) parseOr(nodeVars, edgeVars map[string]int) ([]queryCondition, error) {
+	first, err := p.parseAnd(nodeVars, edgeVars)
+	if err != nil {
+		return nil, err
+	}
+	if !p.isKeyword("OR") {
+		return first, nil
+	}
+	branches := []queryCondition{combineConditions(first, false)}
+	for p.isKeyword("OR") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		next, err := p.parseAnd(nodeVars, edgeVars)
+		if err != nil {
+			return nil, err
+		}
+		branches = append(branches, combineConditions(next, false))
+	}
+	return []queryCondition{combineConditions(branches, true)}, nil
+}
+
+func (p *queryParser) parseAnd(nodeVars, edgeVars map[string]int) ([]queryCondition, error) {
+	ret, err := p.parseNot(nodeVars, edgeVars)
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("AND") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		next, err := p.parseNot(nodeVars, edgeVars)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, next...)
+	}
+	return ret, nil
+}
+
+func (p *queryParser) parseNot(nodeVars, edgeVars map[string]int) ([]queryCondition, error) {
+	if p.isKeyword("NOT") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		conditions, err := p.parseNot(nodeVars, edgeVars)
+		if err != nil {
+			return nil, err
+		}
+		c := combineConditions(conditions, false)
+		if c.constraint == nil {
+			c.predicate = NotPredicate{Predicate: c.predicate}
+			return []queryCondition{c}, nil
+		}
+		c.constraint = notConstraint{constraint: c.constraint}
+		return []queryCondition{c}, nil
+	}
+	if p.isPunct("(") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		conditions, err := p.parseOr(nodeVars, edgeVars)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectPunct(")"); err != nil {
+			return nil, err
+		}
+		return conditions, nil
+	}
+	c, err := p.parseComparison(nodeVars, edgeVars)
+	if err != nil {
+		return nil, err
+	}
+	return []queryCondition{c}, nil
+}
+
+// parsePropertyRef parses variable.property, or variable
+func (p *queryParser) parsePropertyRef(nodeVars, edgeVars map[string]int) (PropertyRef, error) {
+	pos := p.tok.pos
+	variable, err := p.parseName("variable")
+	if err != nil {
+		return PropertyRef{}, err
+	}
+	_, node := nodeVars[variable]
+	_, edge := edgeVars[variable]
+	if !node && !edge {
+		return PropertyRef{}, p.errorAt(pos, "variable '%s' is not defined", variable)
+	}
+	if !p.isPunct(".") {
+		return PropertyRef{Variable: variable}, nil
+	}
+	if err := p.next(); err != nil {
+		return PropertyRef{}, err
+	}
+	key, err := p.parseName("property name")
+	if err != nil {
+		return PropertyRef{}, err
+	}
+	return PropertyRef{Variable: variable, Key: key}, nil
+}
+
+// isLiteralStart returns true if the current token starts a literal
+func (p *queryParser) isLiteralStart() bool {
+	return (p.tok.kind != qtIdent && p.tok.kind != qtQuotedIdent) || p.isKeyword("true") || p.isKeyword("false") || p.isKeyword("null")
+}
+
+var queryCompareOps = map[string]CompareOp{
+	"=":  OpEq,
+	"<>": OpNe,
+	"<":  OpLt,
+	"<=": OpLe,
+	">":  OpGt,
+	">=": OpGe,
+}
+
+// flip returns the operator with the operands swapped
+func (op CompareOp) flip() CompareOp {
+	switch op {
+	case OpLt:
+		return OpGt
+	case OpLe:
+		return OpGe
+	case OpGt:
+		return OpLt
+	case OpGe:
+		return OpLe
+	}
+	return op
+}
+
+// parseComparison parses a condition on a property of a variable, or
+// a comparison of two variables or their properties
+func (p *queryParser) parseComparison(nodeVars, edgeVars map[string]int) (queryCondition, error) {
+	ret := queryCondition{pos: p.tok.pos}
+	if p.isLiteralStart() {
+		// literal op variable.property
+		value, err := p.parseLiteral()
+		if err != nil {
+			return ret, err
+		}
+		op, ok := queryCompareOps[p.tok.text]
+		if p.tok.kind != qtPunct || !ok {
+			return ret, p.unexpected("comparison operator")
+		}
+		if err := p.next(); err != nil {
+			return ret, err
+		}
+		pos := p.tok.pos
+		ref, err := p.parsePropertyRef(nodeVars, edgeVars)
+		if err != nil {
+			return ret, err
+		}
+		if len(ref.Key) == 0 {
+			return ret, p.errorAt(pos, "expected property of '%s'", ref.Variable)
+		}
+		ret.variable = ref.Variable
+		ret.predicate = PropertyCompare{Key: ref.Key, Op: op.flip(), Value: value}
+		return ret, nil
+	}
+
+	ref, err := p.parsePropertyRef(nodeVars, edgeVars)
+	if err != nil {
+		return ret, err
+	}
+	ret.variable = ref.Variable
+	if op, ok := queryCompareOps[p.tok.text]; ok && p.tok.kind == qtPunct {
+		if err := p.next(); err != nil {
+			return ret, err
+		}
+		if !p.isLiteralStart() {
+			pos := p.tok.pos
+			right, err := p.parsePropertyRef(nodeVars, edgeVars)
+			if err != nil {
+				return ret, err
+			}
+			if (len(ref.Key) == 0 || len(right.Key) == 0) && op != OpEq && op != OpNe {
+				return ret, p.errorAt(pos, "variables can only be compared using = or <>")
+			}
+			ret.variable = ""
+			ret.constraint = VariableCompare{Left: ref, Op: op, Right: right}
+			return ret, nil
+		}
+		if len(ref.Key) == 0 {
+			return ret, p.errorAt(ret.pos, "expected property of '%s'", ref.Variable)
+		}
+		value, err := p.parseLiteral()
+		if err != nil {
+			return ret, err
+		}
+		ret.predicate = PropertyCompare{Key: ref.Key, Op: op, Value: value}
+		return ret, nil
+	}
+	if len(ref.Key) == 0 {
+		return ret, p.unexpected("comparison operator")
+	}
+	key := ref.Key
+	switch {
+	case p.isPunct("=~"):
+		if err := p.next(); err != nil {
+			return ret, err
+		}
+		if p.tok.kind != qtString {
+			return ret, p.unexpected("regular expression string")
+		}
+		re, err := regexp.Compile(p.tok.text)
+		if err != nil {
+			return ret, p.errorAt(p.tok.pos, "invalid regular expression: %v", err)
+		}
+		ret.predicate = PropertyMatches{Key: key, Regexp: re}
+		return ret, p.next()
+
+	case p.isKeyword("IN"):
+		if err := p.next(); err != nil {
+			return ret, err
+		}
+		if !p.isPunct("[") {
+			return ret, p.unexpected("list")
+		}
+		value, err := p.parseLiteral()
+		if err != nil {
+			return ret, err
+		}
+		ret.predicate = PropertyIn{Key: key, Values: value.([]interface{})}
+		return ret, nil
+
+	case p.isKeyword("STARTS"):
+		if err := p.next(); err != nil {
+			return ret, err
+		}
+		if err := p.expectKeyword("WITH"); err != nil {
+			return ret, err
+		}
+		if p.tok.kind != qtString {
+			return ret, p.unexpected("string")
+		}
+		ret.predicate = PropertyStartsWith{Key: key, Prefix: p.tok.text}
+		return ret, p.next()
+
+	case p.isKeyword("IS"):
+		if err := p.next(); err != nil {
+			return ret, err
+		}
+		not := false
+		if p.isKeyword("NOT") {
+			not = true
+			if err := p.next(); err != nil {
+				return ret, err
+			}
+		}
+		if err := p.expectKeyword("NULL"); err != nil {
+			return ret, err
+		}
+		if not {
+			ret.predicate = PropertyIsNotNull{Key: key}
+		} else {
+			ret.predicate = PropertyIsNull{Key: key}
+		}
+		return ret, nil
+	}
+	return ret, p.unexpected("comparison operator")
+}
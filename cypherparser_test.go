@@ -0,0 +1,261 @@
+// Copyright 2021 Cloud Privacy Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lpg
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	q, err := ParseQuery(`MATCH (a:Label:Other {k:'v', n: -3, f: 1.5, l: [1, "x"], b: true})-[:REL*1..3]->(b)<-[r:X|:Y]-(:Z)--(c) RETURN a, b, r`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 7, len(q.Pattern))
+	assert.Equal(t, []string{"a", "b", "r"}, q.Return)
+
+	a := q.Pattern[0]
+	assert.Equal(t, "a", a.Name)
+	assert.True(t, a.Labels.IsEqual(NewStringSet("Label", "Other")))
+	assert.Equal(t, map[string]interface{}{"k": "v", "n": -3, "f": 1.5, "l": []interface{}{1, "x"}, "b": true}, a.Properties)
+
+	rel := q.Pattern[1]
+	assert.Equal(t, "", rel.Name)
+	assert.True(t, rel.Labels.IsEqual(NewStringSet("REL")))
+	assert.Equal(t, 1, rel.Min)
+	assert.Equal(t, 3, rel.Max)
+	assert.False(t, rel.ToLeft)
+	assert.False(t, rel.Undirected)
+
+	r := q.Pattern[3]
+	assert.Equal(t, "r", r.Name)
+	assert.True(t, r.Labels.IsEqual(NewStringSet("X", "Y")))
+	assert.Equal(t, 1, r.Min)
+	assert.Equal(t, 1, r.Max)
+	assert.True(t, r.ToLeft)
+
+	assert.True(t, q.Pattern[4].Labels.IsEqual(NewStringSet("Z")))
+	assert.True(t, q.Pattern[5].Undirected)
+
+	for _, tc := range []struct {
+		rng      string
+		min, max int
+	}{
+		{"*", 1, -1},
+		{"*2", 2, 2},
+		{"*..4", 1, 4},
+		{"*2..", 2, -1},
+		{"*0..2", 0, 2},
+	} {
+		q, err := ParseQuery("match ()-[" + tc.rng + "]->() return *")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, tc.min, q.Pattern[1].Min, tc.rng)
+		assert.Equal(t, tc.max, q.Pattern[1].Max, tc.rng)
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	for _, tc := range []struct {
+		query  string
+		line   int
+		column int
+	}{
+		{"MATCH (a RETURN a", 1, 10},
+		{"MATCH (a)\n-[*3..1]->(b) RETURN a", 2, 3},
+		{"MATCH (a)<-[]->(b) RETURN a", 1, 10},
+		{"MATCH (a) RETURN b", 1, 18},
+		{"MATCH (a)-[a]->(b) RETURN a", 1, 12},
+		{"MATCH (a)-[r]->(b)-[r]->(c) RETURN a", 1, 21},
+		{"MATCH (a {k: 'v}) RETURN a", 1, 14},
+		{"MATCH (a) RETURN a a", 1, 20},
+		{"MATCH (a {k: 1, k: 2}) RETURN a", 1, 17},
+		{"RETURN a", 1, 1},
+		{"MATCH (a)", 1, 10},
+	} {
+		_, err := ParseQuery(tc.query)
+		var serr ErrQuerySyntax
+		if !errors.As(err, &serr) {
+			t.Errorf("%s: expecting syntax error, got %v", tc.query, err)
+			continue
+		}
+		assert.Equal(t, tc.line, serr.Line, tc.query)
+		assert.Equal(t, tc.column, serr.Column, tc.query)
+	}
+}
+
+func TestRunQuery(t *testing.T) {
+	graph, nodes := GetLineGraph(10, true)
+	nodes[2].SetProperty("key", "start")
+	nodes[5].SetProperty("key", "end")
+
+	q, err := ParseQuery("MATCH (a {key: 'start'})-[p:label*1..3]->(b) RETURN a, p, b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := q.Run(graph, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 3, len(rows))
+	for _, row := range rows {
+		assert.Equal(t, nodes[2], row["a"])
+		path := row["p"].(*Path)
+		assert.Equal(t, row["b"], path.Last())
+	}
+
+	q, err = ParseQuery("MATCH (a {key: 'end'})<-[*]-(b {key: 'start'}) RETURN b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err = q.Run(graph, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []map[string]interface{}{{"b": nodes[2]}}, rows)
+
+	// Repeated node variables constrain the match
+	graph, nodes = GetCircleGraph(3, false)
+	q, err = ParseQuery("MATCH (a)-->()-->()-->(a) RETURN a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err = q.Run(graph, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 3, len(rows))
+}
+
+func TestQueryWhere(t *testing.T) {
+	q, err := ParseQuery(`MATCH (a)-[r]->(b) WHERE a.x > 1 AND (b.s STARTS WITH 'x' OR b.s =~ 'y.*') AND NOT r.w IN [1, 2] AND 3 >= a.x AND b.z IS NOT NULL RETURN a`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, AndPredicate{
+		PropertyCompare{Key: "x", Op: OpGt, Value: 1},
+		PropertyCompare{Key: "x", Op: OpLe, Value: 3},
+	}, q.Pattern[0].Where)
+	assert.Equal(t, NotPredicate{Predicate: PropertyIn{Key: "w", Values: []interface{}{1, 2}}}, q.Pattern[1].Where)
+	and := q.Pattern[2].Where.(AndPredicate)
+	assert.Equal(t, 2, len(and))
+	assert.IsType(t, OrPredicate{}, and[0])
+	assert.Equal(t, PropertyIsNotNull{Key: "z"}, and[1])
+
+	for _, tc := range []struct {
+		query  string
+		column int
+	}{
+		{"MATCH (a)-->(b) WHERE a < b RETURN a", 27},
+		{"MATCH (a)-->(b) WHERE a = 1 RETURN a", 23},
+		{"MATCH (a) WHERE c.x = 1 RETURN a", 17},
+		{"MATCH (a) WHERE a.x ~ 1 RETURN a", 21},
+		{"MATCH (a) WHERE a.x =~ '(' RETURN a", 24},
+		{"MATCH (a) WHERE a.x IN 1 RETURN a", 24},
+		{"MATCH (a) WHERE a.x IS 1 RETURN a", 24},
+	} {
+		_, err := ParseQuery(tc.query)
+		var serr ErrQuerySyntax
+		if !errors.As(err, &serr) {
+			t.Errorf("%s: expecting syntax error, got %v", tc.query, err)
+			continue
+		}
+		assert.Equal(t, tc.column, serr.Column, tc.query)
+	}
+
+	graph, nodes := GetLineGraph(10, false)
+	for i, node := range nodes {
+		node.SetProperty("n", i)
+	}
+	q, err = ParseQuery("MATCH (a)-->(b) WHERE a.n >= 2 AND a.n < 5 AND b.n <> 4 RETURN a, b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := q.Run(graph, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, len(rows))
+}
+
+func TestQueryCrossVariable(t *testing.T) {
+	graph, nodes := GetCircleGraph(4, false)
+	for i, node := range nodes {
+		node.SetProperty("id", i)
+		node.SetProperty("owner", (i+2)%4)
+	}
+	for edges := graph.GetEdges(); edges.Next(); {
+		edge := edges.Edge()
+		ts, _ := edge.GetFrom().GetProperty("id")
+		edge.SetProperty("ts", ts)
+	}
+
+	q, err := ParseQuery("MATCH (a)-->()-->(b) WHERE a.owner = b.id RETURN a, b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(q.Pattern[0].Constraints))
+	rows, err := q.Run(graph, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 4, len(rows))
+
+	// Undirected pattern goes both ways, a <> b removes a-b-a. Each
+	// node reaches the opposite node through two paths
+	q, err = ParseQuery("MATCH (a)--()--(b) WHERE a <> b RETURN a, b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err = q.Run(graph, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		assert.NotEqual(t, row["a"], row["b"])
+	}
+	assert.Equal(t, 8, len(rows))
+
+	// Increasing timestamps. Only the path starting at node 0 wraps
+	// around without decreasing
+	q, err = ParseQuery("MATCH (a)-[e1]->()-[e2]->() WHERE e1.ts < e2.ts AND (a.id = 0 OR e2.ts = 3) RETURN a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err = q.Run(graph, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, len(rows))
+
+	// A comparison with a missing property is unknown, and so is its
+	// negation
+	nodes[0].RemoveProperty("owner")
+	q, err = ParseQuery("MATCH (a)-->()-->(b) WHERE NOT a.owner <> b.id RETURN a, b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err = q.Run(graph, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 3, len(rows))
+	for _, row := range rows {
+		assert.NotEqual(t, nodes[0], row["a"])
+	}
+}
@@ -0,0 +1,231 @@
+// Copyright 2021 Cloud Privacy Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lpg
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"math"
+)
+
+// ErrInvalidWeight is returned when the weight of an edge is negative
+// or not a number
+type ErrInvalidWeight struct {
+	Edge   *Edge
+	Weight float64
+}
+
+func (e ErrInvalidWeight) Error() string {
+	return fmt.Sprintf("Invalid edge weight: %v for %s", e.Weight, e.Edge)
+}
+
+// WeightedPathOptions are the options of the weighted shortest path
+// searches
+type WeightedPathOptions struct {
+	// Dir is the direction edges are followed. With AnyEdge, edges are
+	// followed in both directions.
+	Dir EdgeDir
+	// Weight returns the weight of an edge. Weights must be
+	// non-negative. If nil, all edges have weight 1. Use
+	// PropertyWeight to get the weight from an edge property.
+	Weight func(*Edge) float64
+	// EdgeFilter selects the edges to follow. If nil, all edges are
+	// followed. Use GetEdgeFilterFunc to select edges by label and
+	// properties.
+	EdgeFilter func(*Edge) bool
+}
+
+// PropertyWeight returns a weight function that returns the numeric
+// value of the edge property. Edges without the property have the
+// weight missing. Non-numeric values are invalid weights.
+func PropertyWeight(property string, missing float64) func(*Edge) float64 {
+	return func(edge *Edge) float64 {
+		value, ok := edge.GetProperty(property)
+		if !ok {
+			return missing
+		}
+		if n, ok := value.(WithNativeValue); ok {
+			value = n.GetNativeValue()
+		}
+		f, ok := floatValue(value)
+		if !ok {
+			return math.NaN()
+		}
+		return f
+	}
+}
+
+// ShortestPathTree contains the shortest paths from a source node to
+// the nodes reachable from it
+type ShortestPathTree struct {
+	source *Node
+	dist   map[*Node]float64
+	prev   map[*Node]PathElement
+	// nodes are in the order of distance
+	nodes []*Node
+}
+
+// Source returns the source node of the paths
+func (t *ShortestPathTree) Source() *Node { return t.source }
+
+// Nodes returns the reachable nodes in the order of distance from the
+// source, starting with the source
+func (t *ShortestPathTree) Nodes() []*Node { return t.nodes }
+
+// Distance returns the total weight of the shortest path to the
+// node. Returns false if the node is not reachable.
+func (t *ShortestPathTree) Distance(node *Node) (float64, bool) {
+	d, ok := t.dist[node]
+	return d, ok
+}
+
+// PathTo returns the shortest path to the node, or nil if the node is
+// not reachable. The path to the source is the source node.
+func (t *ShortestPathTree) PathTo(node *Node) *Path {
+	if _, ok := t.dist[node]; !ok {
+		return nil
+	}
+	elements := make([]PathElement, 0)
+	for node != t.source {
+		pe := t.prev[node]
+		elements = append(elements, pe)
+		node = pe.GetSourceNode()
+	}
+	if len(elements) == 0 {
+		return PathFromNode(t.source)
+	}
+	for i, j := 0, len(elements)-1; i < j; i, j = i+1, j-1 {
+		elements[i], elements[j] = elements[j], elements[i]
+	}
+	return &Path{path: elements}
+}
+
+// DijkstraPath returns a path with the minimum total weight from
+// 'from' to 'to' using Dijkstra's algorithm, and the weight of the
+// path. Returns nil if 'to' is not reachable. If the search stops
+// because the context is canceled, the error is ctx.Err().
+func DijkstraPath(ctx context.Context, from, to *Node, options WeightedPathOptions) (*Path, float64, error) {
+	return AStarPath(ctx, from, to, nil, options)
+}
+
+// DijkstraPaths returns the shortest paths from the source to all the
+// nodes reachable from it using Dijkstra's algorithm
+func DijkstraPaths(ctx context.Context, source *Node, options WeightedPathOptions) (*ShortestPathTree, error) {
+	return shortestPaths(ctx, source, nil, nil, options)
+}
+
+// AStarPath returns a path with the minimum total weight from 'from'
+// to 'to' using the A* algorithm, and the weight of the path. The
+// heuristic returns an estimate of the weight of the shortest path
+// from a node to 'to'. The estimate must not be larger than the
+// actual weight, otherwise the returned path may not be the
+// shortest. A nil heuristic is Dijkstra's algorithm. Returns nil if
+// 'to' is not reachable. If the search stops because the context is
+// canceled, the error is ctx.Err().
+func AStarPath(ctx context.Context, from, to *Node, heuristic func(*Node) float64, options WeightedPathOptions) (*Path, float64, error) {
+	tree, err := shortestPaths(ctx, from, to, heuristic, options)
+	if err != nil {
+		return nil, 0, err
+	}
+	path := tree.PathTo(to)
+	if path == nil {
+		return nil, 0, nil
+	}
+	return path, tree.dist[to], nil
+}
+
+// shortestPaths runs the search from source until target is reached,
+// or all reachable nodes are visited if target is nil
+func shortestPaths(ctx context.Context, source, target *Node, heuristic func(*Node) float64, options WeightedPathOptions) (*ShortestPathTree, error) {
+	tree := &ShortestPathTree{
+		source: source,
+		dist:   map[*Node]float64{source: 0},
+		prev:   make(map[*Node]PathElement),
+	}
+	estimate := func(node *Node) float64 {
+		if heuristic == nil {
+			return 0
+		}
+		return heuristic(node)
+	}
+	settled := make(map[*Node]struct{})
+	queue := &pathQueue{{node: source, priority: estimate(source)}}
+	for queue.Len() > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		item := heap.Pop(queue).(pathQueueItem)
+		if item.dist > tree.dist[item.node] {
+			// A shorter path to the node was found after this
+			// item was queued
+			continue
+		}
+		if _, ok := settled[item.node]; !ok {
+			settled[item.node] = struct{}{}
+			tree.nodes = append(tree.nodes, item.node)
+		}
+		if item.node == target {
+			break
+		}
+		for edges := item.node.GetEdges(options.Dir); edges.Next(); {
+			edge := edges.Edge()
+			if options.EdgeFilter != nil && !options.EdgeFilter(edge) {
+				continue
+			}
+			pe := stepFrom(item.node, edge)
+			next := pe.GetTargetNode()
+			if next == item.node {
+				continue
+			}
+			weight := 1.0
+			if options.Weight != nil {
+				weight = options.Weight(edge)
+				if weight < 0 || math.IsNaN(weight) {
+					return nil, ErrInvalidWeight{Edge: edge, Weight: weight}
+				}
+			}
+			dist := item.dist + weight
+			if d, ok := tree.dist[next]; ok && d <= dist {
+				continue
+			}
+			tree.dist[next] = dist
+			tree.prev[next] = pe
+			heap.Push(queue, pathQueueItem{node: next, dist: dist, priority: dist + estimate(next)})
+		}
+	}
+	return tree, nil
+}
+
+type pathQueueItem struct {
+	node *Node
+	dist float64
+	// priority is the distance plus the heuristic estimate
+	priority float64
+}
+
+// pathQueue is a min-heap of items by priority
+type pathQueue []pathQueueItem
+
+func (q pathQueue) Len() int            { return len(q) }
+func (q pathQueue) Less(i, j int) bool  { return q[i].priority < q[j].priority }
+func (q pathQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *pathQueue) Push(x interface{}) { *q = append(*q, x.(pathQueueItem)) }
+func (q *pathQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
@@ -0,0 +1,200 @@
+// Copyright 2021 Cloud Privacy Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lpg
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+)
+
+// getWeightedTestGraph returns nodes a, b, c, d, e with the edges
+//
+//	a -road:1-> b -road:2-> c -road:1-> d
+//	a -road:5-> c
+//	a -rail:2-> d
+//
+// e is not connected
+func getWeightedTestGraph() (*Graph, map[string]*Node) {
+	g := NewGraph()
+	nodes := make(map[string]*Node)
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		nodes[name] = g.NewNode(nil, map[string]interface{}{"name": name}, nil)
+	}
+	for _, e := range []struct {
+		from, to, label string
+		weight          interface{}
+	}{
+		{"a", "b", "road", 1},
+		{"b", "c", "road", 2.0},
+		{"a", "c", "road", 5},
+		{"c", "d", "road", int64(1)},
+		{"a", "d", "rail", 2},
+	} {
+		g.NewEdge(nodes[e.from], nodes[e.to], e.label, map[string]interface{}{"w": e.weight}, nil)
+	}
+	return g, nodes
+}
+
+// pathNames returns the names of the nodes of the path
+func pathNames(p *Path) []string {
+	names := make([]string, 0)
+	for i := 0; i < p.NumNodes(); i++ {
+		name, _ := p.GetNode(i).GetProperty("name")
+		names = append(names, name.(string))
+	}
+	return names
+}
+
+func TestDijkstraPath(t *testing.T) {
+	_, nodes := getWeightedTestGraph()
+	ctx := context.Background()
+	options := WeightedPathOptions{
+		Dir:    OutgoingEdge,
+		Weight: PropertyWeight("w", 1),
+	}
+	path, weight, err := DijkstraPath(ctx, nodes["a"], nodes["d"], options)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "d"}, pathNames(path))
+	assert.Equal(t, 2.0, weight)
+
+	options.EdgeFilter = GetEdgeFilterFunc(NewStringSet("road"), nil)
+	path, weight, err = DijkstraPath(ctx, nodes["a"], nodes["d"], options)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "b", "c", "d"}, pathNames(path))
+	assert.Equal(t, 4.0, weight)
+	for i := 0; i < path.NumEdges(); i++ {
+		assert.False(t, path.path[i].Reverse)
+	}
+
+	// Follow the edges backwards
+	options.Dir = IncomingEdge
+	path, weight, err = DijkstraPath(ctx, nodes["d"], nodes["a"], options)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"d", "c", "b", "a"}, pathNames(path))
+	assert.Equal(t, 4.0, weight)
+	for i := 0; i < path.NumEdges(); i++ {
+		assert.True(t, path.path[i].Reverse)
+	}
+
+	options.Dir = AnyEdge
+	path, weight, err = DijkstraPath(ctx, nodes["c"], nodes["a"], options)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"c", "b", "a"}, pathNames(path))
+	assert.Equal(t, 3.0, weight)
+	path, weight, err = DijkstraPath(ctx, nodes["b"], nodes["d"], options)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"b", "c", "d"}, pathNames(path))
+	assert.Equal(t, []bool{false, false}, []bool{path.path[0].Reverse, path.path[1].Reverse})
+	assert.Equal(t, 3.0, weight)
+
+	path, weight, err = DijkstraPath(ctx, nodes["a"], nodes["a"], options)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a"}, pathNames(path))
+	assert.Equal(t, 0.0, weight)
+
+	path, _, err = DijkstraPath(ctx, nodes["a"], nodes["e"], options)
+	assert.Nil(t, err)
+	assert.Nil(t, path)
+
+	// Without weights, the path with fewer edges is shorter
+	path, weight, err = DijkstraPath(ctx, nodes["a"], nodes["d"], WeightedPathOptions{Dir: OutgoingEdge})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "d"}, pathNames(path))
+	assert.Equal(t, 1.0, weight)
+}
+
+func TestDijkstraInvalidWeight(t *testing.T) {
+	g, nodes := getWeightedTestGraph()
+	ctx := context.Background()
+	e := g.NewEdge(nodes["d"], nodes["e"], "road", map[string]interface{}{"w": -1}, nil)
+	_, _, err := DijkstraPath(ctx, nodes["a"], nodes["e"], WeightedPathOptions{Dir: OutgoingEdge, Weight: PropertyWeight("w", 1)})
+	assert.Equal(t, ErrInvalidWeight{Edge: e, Weight: -1}, err)
+
+	e.SetProperty("w", "x")
+	_, _, err = DijkstraPath(ctx, nodes["a"], nodes["e"], WeightedPathOptions{Dir: OutgoingEdge, Weight: PropertyWeight("w", 1)})
+	if assert.IsType(t, ErrInvalidWeight{}, err) {
+		assert.True(t, math.IsNaN(err.(ErrInvalidWeight).Weight))
+	}
+
+	// Missing properties get the default weight
+	e.RemoveProperty("w")
+	_, weight, err := DijkstraPath(ctx, nodes["a"], nodes["e"], WeightedPathOptions{Dir: OutgoingEdge, Weight: PropertyWeight("w", 10)})
+	assert.Nil(t, err)
+	assert.Equal(t, 12.0, weight)
+}
+
+func TestDijkstraPaths(t *testing.T) {
+	_, nodes := getWeightedTestGraph()
+	tree, err := DijkstraPaths(context.Background(), nodes["a"], WeightedPathOptions{Dir: OutgoingEdge, Weight: PropertyWeight("w", 1)})
+	assert.Nil(t, err)
+	assert.Equal(t, nodes["a"], tree.Source())
+	assert.Equal(t, []*Node{nodes["a"], nodes["b"], nodes["d"], nodes["c"]}, tree.Nodes())
+	for name, expected := range map[string]float64{"a": 0, "b": 1, "c": 3, "d": 2} {
+		d, ok := tree.Distance(nodes[name])
+		assert.True(t, ok)
+		assert.Equal(t, expected, d)
+	}
+	assert.Equal(t, []string{"a", "b", "c"}, pathNames(tree.PathTo(nodes["c"])))
+	assert.Equal(t, []string{"a"}, pathNames(tree.PathTo(nodes["a"])))
+	_, ok := tree.Distance(nodes["e"])
+	assert.False(t, ok)
+	assert.Nil(t, tree.PathTo(nodes["e"]))
+}
+
+func TestAStarPath(t *testing.T) {
+	// A grid where moving right costs 1 and moving down costs 2
+	const size = 6
+	g := NewGraph()
+	grid := make([][]*Node, size)
+	for y := range grid {
+		grid[y] = make([]*Node, size)
+		for x := range grid[y] {
+			grid[y][x] = g.NewNode(nil, map[string]interface{}{"x": x, "y": y}, nil)
+			if x > 0 {
+				g.NewEdge(grid[y][x-1], grid[y][x], "right", map[string]interface{}{"w": 1}, nil)
+			}
+			if y > 0 {
+				g.NewEdge(grid[y-1][x], grid[y][x], "down", map[string]interface{}{"w": 2}, nil)
+			}
+		}
+	}
+	target := grid[size-1][size-1]
+	heuristic := func(node *Node) float64 {
+		x, _ := node.GetProperty("x")
+		y, _ := node.GetProperty("y")
+		return float64(size-1-x.(int)) + 2*float64(size-1-y.(int))
+	}
+	options := WeightedPathOptions{Dir: AnyEdge, Weight: PropertyWeight("w", 1)}
+	path, weight, err := AStarPath(context.Background(), grid[0][0], target, heuristic, options)
+	assert.Nil(t, err)
+	assert.Equal(t, 3.0*(size-1), weight)
+	assert.Equal(t, 2*(size-1), path.NumEdges())
+	assert.Equal(t, grid[0][0], path.First())
+	assert.Equal(t, target, path.Last())
+
+	dpath, dweight, err := DijkstraPath(context.Background(), grid[0][0], target, options)
+	assert.Nil(t, err)
+	assert.Equal(t, weight, dweight)
+	assert.Equal(t, path.NumEdges(), dpath.NumEdges())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err = AStarPath(ctx, grid[0][0], target, heuristic, options)
+	assert.Equal(t, context.Canceled, err)
+	_, err = DijkstraPaths(ctx, grid[0][0], options)
+	assert.Equal(t, context.Canceled, err)
+}
@@ -29,6 +29,8 @@ type Edge struct {
 	// 1: outgoing edges list
 	// 2: incoming edges list
 	listElements [3]edgeElement
+	// epoch is the graph version the edge state was written at
+	epoch uint64
 }
 
 // EdgeDir is used to show edge direction
@@ -65,9 +67,19 @@ func (edge *Edge) SetLabel(label string) {
 	}
 }
 
-// SetProperty sets an edge property
+// SetProperty sets an edge property. The index constraints of the
+// graph are not checked: a value that is not of the kind of a typed
+// property index is kept apart from the typed values, but is still
+// found through the index. Use TrySetProperty to check them.
 func (edge *Edge) SetProperty(key string, value interface{}) {
-	edge.from.graph.setEdgeProperty(edge, key, value)
+	edge.from.graph.setEdgeProperty(edge, key, value, false)
+}
+
+// TrySetProperty sets an edge property. Returns an error if the value
+// violates the index constraints of the graph. In that case the edge
+// is not changed.
+func (edge *Edge) TrySetProperty(key string, value interface{}) error {
+	return edge.from.graph.setEdgeProperty(edge, key, value, true)
 }
 
 // RemoveProperty removes an edge property
@@ -0,0 +1,91 @@
+// Copyright 2021 Cloud Privacy Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lpg
+
+import (
+	"github.com/kamstrup/intmap"
+)
+
+// edgeContextIndex indexes the edges of each node by edge context. The
+// node ID and the context are separate keys, so two node-context
+// pairs never share a set.
+type edgeContextIndex struct {
+	nodes *intmap.Map[int, map[string]*fastSet]
+}
+
+func newEdgeContextIndex() edgeContextIndex {
+	return edgeContextIndex{nodes: intmap.New[int, map[string]*fastSet](64)}
+}
+
+func (ix *edgeContextIndex) add(nodeID int, context string, edge *Edge) {
+	contexts, ok := ix.nodes.Get(nodeID)
+	if !ok {
+		contexts = make(map[string]*fastSet)
+		ix.nodes.Put(nodeID, contexts)
+	}
+	set := contexts[context]
+	if set == nil {
+		set = newFastSet()
+		contexts[context] = set
+	}
+	set.add(edge.id, edge)
+}
+
+func (ix *edgeContextIndex) remove(nodeID int, context string, edgeID int) {
+	contexts, ok := ix.nodes.Get(nodeID)
+	if !ok {
+		return
+	}
+	set := contexts[context]
+	if set == nil {
+		return
+	}
+	set.remove(edgeID)
+	if set.size() > 0 {
+		return
+	}
+	delete(contexts, context)
+	if len(contexts) == 0 {
+		ix.nodes.Del(nodeID)
+	}
+}
+
+// find returns the edges of the node with the context
+func (ix *edgeContextIndex) find(nodeID int, context string) Iterator {
+	contexts, ok := ix.nodes.Get(nodeID)
+	if !ok {
+		return emptyIterator{}
+	}
+	set := contexts[context]
+	if set == nil {
+		return emptyIterator{}
+	}
+	return withSize(set.iterator(), set.size())
+}
+
+// forEach calls f for all the node-context-edge triples of the index
+// until f returns false
+func (ix *edgeContextIndex) forEach(f func(nodeID int, context string, edge *Edge) bool) {
+	ix.nodes.ForEach(func(nodeID int, contexts map[string]*fastSet) bool {
+		for context, set := range contexts {
+			for itr := set.iterator(); itr.Next(); {
+				if !f(nodeID, context, itr.Value().(*Edge)) {
+					return false
+				}
+			}
+		}
+		return true
+	})
+}
@@ -0,0 +1,220 @@
+// Copyright 2021 Cloud Privacy Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lpg
+
+// Event is a graph change delivered to subscribers. It is one of
+// NodeCreated, NodeRemoved, EdgeCreated, EdgeRemoved, LabelsChanged,
+// ContextsChanged, EdgeLabelChanged, PropertySet, or PropertyRemoved.
+type Event interface {
+	isEvent()
+}
+
+// NodeCreated is emitted when a node is added to the graph
+type NodeCreated struct {
+	Node *Node
+}
+
+// NodeRemoved is emitted when a node is removed from the graph. The
+// edges of the node are removed before it.
+type NodeRemoved struct {
+	Node *Node
+}
+
+// EdgeCreated is emitted when an edge is added to the graph
+type EdgeCreated struct {
+	Edge *Edge
+}
+
+// EdgeRemoved is emitted when an edge is removed from the graph
+type EdgeRemoved struct {
+	Edge *Edge
+}
+
+// LabelsChanged is emitted when the labels of a node are set
+type LabelsChanged struct {
+	Node     *Node
+	Old, New *StringSet
+}
+
+// ContextsChanged is emitted when the contexts of a node or an edge
+// are set. Either Node or Edge is nil.
+type ContextsChanged struct {
+	Node     *Node
+	Edge     *Edge
+	Old, New *StringSet
+}
+
+// EdgeLabelChanged is emitted when the label of an edge is set
+type EdgeLabelChanged struct {
+	Edge     *Edge
+	Old, New string
+}
+
+// PropertySet is emitted when a property of a node or an edge is
+// set. Either Node or Edge is nil. If the property did not exist
+// before, Existed is false and Old is nil.
+type PropertySet struct {
+	Node     *Node
+	Edge     *Edge
+	Key      string
+	Old, New interface{}
+	Existed  bool
+}
+
+// PropertyRemoved is emitted when a property of a node or an edge is
+// removed. Either Node or Edge is nil.
+type PropertyRemoved struct {
+	Node *Node
+	Edge *Edge
+	Key  string
+	Old  interface{}
+}
+
+func (NodeCreated) isEvent()      {}
+func (NodeRemoved) isEvent()      {}
+func (EdgeCreated) isEvent()      {}
+func (EdgeRemoved) isEvent()      {}
+func (LabelsChanged) isEvent()    {}
+func (ContextsChanged) isEvent()  {}
+func (EdgeLabelChanged) isEvent() {}
+func (PropertySet) isEvent()      {}
+func (PropertyRemoved) isEvent()  {}
+
+// event returns the event for the change. Returns nil for changes
+// that are not reported to subscribers.
+func (c change) event() Event {
+	switch c.kind {
+	case nodeCreated:
+		return NodeCreated{Node: c.node}
+	case nodeRemoved:
+		return NodeRemoved{Node: c.node}
+	case edgeCreated:
+		return EdgeCreated{Edge: c.edge}
+	case edgeRemoved:
+		return EdgeRemoved{Edge: c.edge}
+	case nodeLabelsChanged:
+		return LabelsChanged{Node: c.node, Old: c.oldValue.(*StringSet), New: c.newValue.(*StringSet)}
+	case nodeContextsChanged, edgeContextsChanged:
+		return ContextsChanged{Node: c.node, Edge: c.edge, Old: c.oldValue.(*StringSet), New: c.newValue.(*StringSet)}
+	case edgeLabelChanged:
+		return EdgeLabelChanged{Edge: c.edge, Old: c.oldValue.(string), New: c.newValue.(string)}
+	case nodePropertySet, edgePropertySet:
+		return PropertySet{Node: c.node, Edge: c.edge, Key: c.key, Old: c.oldValue, New: c.newValue, Existed: c.existed}
+	case nodePropertyRemoved, edgePropertyRemoved:
+		return PropertyRemoved{Node: c.node, Edge: c.edge, Key: c.key, Old: c.oldValue}
+	}
+	return nil
+}
+
+// Subscription is a registered event listener
+type Subscription struct {
+	graph    *Graph
+	listener func(Event)
+	batch    func([]Event)
+	removed  bool
+}
+
+// Subscribe registers a listener that is called synchronously for
+// every change, after the change is applied. Changes made in a
+// transaction are delivered as they are made, and if the transaction
+// is rolled back, the listener receives the events of undoing them.
+// The listener must not modify the graph.
+func (g *Graph) Subscribe(listener func(Event)) *Subscription {
+	s := &Subscription{graph: g, listener: listener}
+	g.listeners = appendSubscription(g.listeners, s)
+	return s
+}
+
+// SubscribeBatch registers a listener that receives the events of
+// each transaction together when the outermost transaction
+// commits. Rolled back changes are not delivered. Changes made
+// outside a transaction are delivered as they are made, one event at
+// a time. The listener must not modify the graph.
+func (g *Graph) SubscribeBatch(listener func([]Event)) *Subscription {
+	s := &Subscription{graph: g, batch: listener}
+	g.batchListeners = appendSubscription(g.batchListeners, s)
+	return s
+}
+
+// Unsubscribe removes the listener. The listener is not called after
+// Unsubscribe returns.
+func (s *Subscription) Unsubscribe() {
+	g := s.graph
+	s.removed = true
+	if s.listener != nil {
+		g.listeners = removeSubscription(g.listeners, s)
+	} else {
+		g.batchListeners = removeSubscription(g.batchListeners, s)
+	}
+}
+
+// The subscription slices are copied on write, so listeners can
+// unsubscribe during delivery
+func appendSubscription(subs []*Subscription, s *Subscription) []*Subscription {
+	ret := make([]*Subscription, 0, len(subs)+1)
+	ret = append(ret, subs...)
+	return append(ret, s)
+}
+
+// removeSubscription returns nil if there are no subscriptions
+// left, so recording stays disabled without subscribers
+func removeSubscription(subs []*Subscription, s *Subscription) []*Subscription {
+	var ret []*Subscription
+	for _, x := range subs {
+		if x != s {
+			ret = append(ret, x)
+		}
+	}
+	return ret
+}
+
+// notify delivers the change to the synchronous listeners, and to
+// the batch listeners if there is no active transaction
+func (g *Graph) notify(c change) {
+	ev := c.event()
+	if ev == nil {
+		return
+	}
+	for _, s := range g.listeners {
+		if !s.removed {
+			s.listener(ev)
+		}
+	}
+	if g.tx == nil {
+		g.notifyBatch([]Event{ev})
+	}
+}
+
+func (g *Graph) notifyBatch(events []Event) {
+	if len(events) == 0 {
+		return
+	}
+	for _, s := range g.batchListeners {
+		if !s.removed {
+			s.batch(events)
+		}
+	}
+}
+
+// events returns the events of the changes
+func events(changes []change) []Event {
+	ret := make([]Event, 0, len(changes))
+	for _, c := range changes {
+		if ev := c.event(); ev != nil {
+			ret = append(ret, ev)
+		}
+	}
+	return ret
+}
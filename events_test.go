@@ -0,0 +1,100 @@
+// Copyright 2021 Cloud Privacy Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lpg
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSubscribe(t *testing.T) {
+	g := NewGraph()
+	received := make([]Event, 0)
+	sub := g.Subscribe(func(ev Event) {
+		// Events are delivered after the change
+		if e, ok := ev.(LabelsChanged); ok {
+			assert.True(t, e.Node.HasLabel("b"))
+		}
+		received = append(received, ev)
+	})
+	n1 := g.NewNode([]string{"a"}, nil, nil)
+	n2 := g.NewNode(nil, map[string]interface{}{"k": 1}, nil)
+	edge := g.NewEdge(n1, n2, "e", nil, nil)
+	n1.SetLabels(NewStringSet("b"))
+	n1.SetContexts(NewStringSet("c"))
+	n2.SetProperty("k", 2)
+	n2.RemoveProperty("k")
+	edge.SetLabel("f")
+	edge.SetProperty("w", 1)
+	n1.DetachAndRemove()
+	assert.Nil(t, n2.SetExternalID("x"))
+
+	assert.Equal(t, []Event{
+		NodeCreated{Node: n1},
+		NodeCreated{Node: n2},
+		EdgeCreated{Edge: edge},
+		LabelsChanged{Node: n1, Old: NewStringSet("a"), New: NewStringSet("b")},
+		ContextsChanged{Node: n1, Old: NewStringSet(), New: NewStringSet("c")},
+		PropertySet{Node: n2, Key: "k", Old: 1, New: 2, Existed: true},
+		PropertyRemoved{Node: n2, Key: "k", Old: 2},
+		EdgeLabelChanged{Edge: edge, Old: "e", New: "f"},
+		PropertySet{Edge: edge, Key: "w", New: 1},
+		EdgeRemoved{Edge: edge},
+		NodeRemoved{Node: n1},
+	}, received)
+
+	sub.Unsubscribe()
+	assert.False(t, g.recording())
+	g.NewNode(nil, nil, nil)
+	assert.Equal(t, 11, len(received))
+}
+
+func TestSubscribeTx(t *testing.T) {
+	g := NewGraph()
+	node := g.NewNode(nil, nil, nil)
+	sync := make([]Event, 0)
+	batches := make([][]Event, 0)
+	g.Subscribe(func(ev Event) { sync = append(sync, ev) })
+	g.SubscribeBatch(func(evs []Event) { batches = append(batches, evs) })
+
+	tx := g.Begin()
+	node.SetProperty("a", 1)
+	inner := g.Begin()
+	n := g.NewNode(nil, nil, nil)
+	assert.Nil(t, inner.Rollback())
+	node.SetProperty("a", 2)
+	assert.Empty(t, batches)
+	assert.Nil(t, tx.Commit())
+
+	// The synchronous listener sees the rolled back node being removed
+	assert.Equal(t, []Event{
+		PropertySet{Node: node, Key: "a", New: 1},
+		NodeCreated{Node: n},
+		NodeRemoved{Node: n},
+		PropertySet{Node: node, Key: "a", Old: 1, New: 2, Existed: true},
+	}, sync)
+	assert.Equal(t, [][]Event{{
+		PropertySet{Node: node, Key: "a", New: 1},
+		PropertySet{Node: node, Key: "a", Old: 1, New: 2, Existed: true},
+	}}, batches)
+
+	tx = g.Begin()
+	node.RemoveProperty("a")
+	assert.Nil(t, tx.Rollback())
+	assert.Equal(t, 1, len(batches))
+
+	node.RemoveProperty("a")
+	assert.Equal(t, [][]Event{{PropertyRemoved{Node: node, Key: "a", Old: 2}}}, batches[1:])
+}
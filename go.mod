@@ -1,10 +1,11 @@
 module github.com/Arnonrgo/lpg/v3
 
-go 1.23
+go 1.24
 
 require (
 	github.com/dolthub/swiss v0.2.1
 	github.com/emirpasic/gods v1.18.1
+	github.com/emirpasic/gods/v2 v2.0.0-alpha
 	github.com/kamstrup/intmap v0.4.0
 	github.com/stretchr/testify v1.9.0
 	github.com/tidwall/btree v1.7.0
@@ -13,7 +14,6 @@ require (
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/dolthub/maphash v0.1.0 // indirect
-	github.com/emirpasic/gods/v2 v2.0.0-alpha // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
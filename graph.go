@@ -16,9 +16,8 @@ package lpg
 
 import (
 	"errors"
-	"fmt"
 	"github.com/kamstrup/intmap"
-	"strconv"
+	"slices"
 )
 
 // A Graph is a labeled property graph containing nodes, and directed
@@ -41,6 +40,25 @@ type Graph struct {
 	allNodes nodeList
 	allEdges edgeMap
 	idBase   int
+	// tx is the innermost active transaction
+	tx *Tx
+	// txLog is the undo log of the active transactions
+	txLog []change
+	// undoing is set while a transaction is rolled back
+	undoing bool
+	// wal is the write-ahead log the changes are written to
+	wal *WAL
+	// listeners and batchListeners are the event subscriptions. They
+	// are nil if there are no subscriptions.
+	listeners      []*Subscription
+	batchListeners []*Subscription
+	// version is incremented when a snapshot is taken
+	version uint64
+	// cow keeps the states of the modified nodes and edges seen by
+	// the snapshots. It is nil if there are no snapshots.
+	cow *cowState
+	// view is set if the graph is a snapshot
+	view *graphView
 }
 
 // NewGraph constructs and returns a new graph. The new graph has no
@@ -52,21 +70,40 @@ func NewGraph() *Graph {
 	}
 }
 
-// NewNode creates a new node with the given labels and properties
+// NewNode creates a new node with the given labels and
+// properties. The index constraints of the graph are not checked:
+// property values that are not of the kind of a typed index are not
+// indexed, and a node violating a unique constraint replaces the
+// existing node for the key. Use TryNewNode to check the
+// constraints.
 func (g *Graph) NewNode(labels []string, props map[string]interface{}, contexts *StringSet) *Node {
-	var p properties
-	if len(props) > 0 {
-		p = make(properties, len(props))
-		for k, v := range props {
-			p[k] = v
-		}
+	return g.FastNewNode(NewStringSet(labels...), copyProperties(props), contexts)
+}
+
+// TryNewNode creates a new node with the given labels and
+// properties. Returns an error if the properties violate the index
+// constraints of the graph, e.g. if a property value is not of the
+// kind of the property index.
+func (g *Graph) TryNewNode(labels []string, props map[string]interface{}, contexts *StringSet) (*Node, error) {
+	return g.TryFastNewNode(NewStringSet(labels...), copyProperties(props), contexts)
+}
+
+// copyProperties returns a copy of props, or nil if props is empty
+func copyProperties(props map[string]interface{}) properties {
+	if len(props) == 0 {
+		return nil
 	}
-	return g.FastNewNode(NewStringSet(labels...), p, contexts)
+	p := make(properties, len(props))
+	for k, v := range props {
+		p[k] = v
+	}
+	return p
 }
 
 // FastNewNode creates a new node with the given labels and
 // properties. This version does not copy the labels and properties,
-// but uses the given label set and map directly
+// but uses the given label set and map directly. As with NewNode, the
+// index constraints of the graph are not checked.
 func (g *Graph) FastNewNode(labels *StringSet, props map[string]interface{}, contexts *StringSet) *Node {
 	node := &Node{
 		labels:     labels,
@@ -83,26 +120,42 @@ func (g *Graph) FastNewNode(labels *StringSet, props map[string]interface{}, con
 	return node
 }
 
+// TryFastNewNode is the version of FastNewNode that returns an error
+// if the properties violate the index constraints of the graph
+func (g *Graph) TryFastNewNode(labels *StringSet, props map[string]interface{}, contexts *StringSet) (*Node, error) {
+	if err := g.index.checkNewNode(labels, props); err != nil {
+		return nil, err
+	}
+	return g.FastNewNode(labels, props, contexts), nil
+}
+
 // NewEdge creates a new edge between the two nodes of the graph. Both
-// nodes must be nodes of this graph, otherwise this call panics
+// nodes must be nodes of this graph, otherwise this call panics. The
+// index constraints of the graph are not checked. Use TryNewEdge to
+// check them.
 func (g *Graph) NewEdge(from, to *Node, label string, props map[string]any, contexts *StringSet) *Edge {
-	var p properties
-	if len(props) > 0 {
-		p = make(properties, len(props))
-		for k, v := range props {
-			p[k] = v
-		}
+	if contexts != nil {
+		contexts = contexts.Clone()
 	}
+	return g.FastNewEdge(from, to, label, copyProperties(props), contexts)
+}
+
+// TryNewEdge creates a new edge between the two nodes of the
+// graph. Both nodes must be nodes of this graph, otherwise this call
+// panics. Returns an error if the properties violate the index
+// constraints of the graph.
+func (g *Graph) TryNewEdge(from, to *Node, label string, props map[string]any, contexts *StringSet) (*Edge, error) {
 	if contexts != nil {
 		contexts = contexts.Clone()
 	}
-	return g.FastNewEdge(from, to, label, p, contexts)
+	return g.TryFastNewEdge(from, to, label, copyProperties(props), contexts)
 }
 
 // FastNewEdge creates a new edge between the two nodes of the
 // graph. Both nodes must be nodes of this graph, otherwise this call
 // panics. This version uses the given properties map directly without
-// copying it.
+// copying it. As with NewEdge, the index constraints of the graph are
+// not checked.
 func (g *Graph) FastNewEdge(from, to *Node, label string, props map[string]any, contexts *StringSet) *Edge {
 	if from.graph != g {
 		panic("from node is not in graph")
@@ -119,19 +172,32 @@ func (g *Graph) FastNewEdge(from, to *Node, label string, props map[string]any,
 		properties: properties(props),
 	}
 	g.idBase++
-	g.allEdges.add(newEdge, 0)
-	g.connect(newEdge)
-	g.index.addEdgeToIndex(newEdge)
+	g.addEdge(newEdge)
 	return newEdge
 }
 
+// TryFastNewEdge is the version of FastNewEdge that returns an error
+// if the properties violate the index constraints of the graph
+func (g *Graph) TryFastNewEdge(from, to *Node, label string, props map[string]any, contexts *StringSet) (*Edge, error) {
+	if err := g.index.checkEdgeProperties(props); err != nil {
+		return nil, err
+	}
+	return g.FastNewEdge(from, to, label, props, contexts), nil
+}
+
 // NumNodes returns the number of nodes in the graph
 func (g *Graph) NumNodes() int {
+	if g.view != nil {
+		return g.view.numNodes
+	}
 	return g.allNodes.n
 }
 
 // NumEdges returns the number of edges in the graph
 func (g *Graph) NumEdges() int {
+	if g.view != nil {
+		return g.view.numEdges
+	}
 	return g.allEdges.size()
 }
 
@@ -140,6 +206,9 @@ func (g *Graph) NumEdges() int {
 // during iteration nodes are updated, new nodes are added, or
 // existing nodes are deleted.
 func (g *Graph) GetNodes() NodeIterator {
+	if g.view != nil {
+		return g.view.allNodes()
+	}
 	return &nodeListIterator{next: g.allNodes.head, n: g.allNodes.n}
 }
 
@@ -149,6 +218,11 @@ func (g *Graph) GetNodes() NodeIterator {
 // iterator is undefined if during iteration nodes are updated, new
 // nodes are added, or existing nodes are deleted.
 func (g *Graph) GetNodesWithAllLabels(labels *StringSet) NodeIterator {
+	if g.view != nil {
+		return g.view.nodeQuery(func(live *Graph) Iterator { return live.GetNodesWithAllLabels(labels) }, func(node *Node) bool {
+			return node.labels.HasAllSet(labels)
+		})
+	}
 	return g.index.nodesByLabel.IteratorAllLabels(labels)
 }
 
@@ -157,6 +231,9 @@ func (g *Graph) GetNodesWithAllLabels(labels *StringSet) NodeIterator {
 // during iteration edges are updated, new edges are added, or
 // existing edges are deleted.
 func (g *Graph) GetEdges() EdgeIterator {
+	if g.view != nil {
+		return g.view.allEdges()
+	}
 	return g.allEdges.iterator(0)
 }
 
@@ -166,17 +243,184 @@ func (g *Graph) GetEdges() EdgeIterator {
 // during iteration edges are updated, new edges are added, or
 // existing edges are deleted.
 func (g *Graph) GetEdgesWithAnyLabel(set *StringSet) EdgeIterator {
+	if g.view != nil {
+		return g.view.edgeQuery(func(live *Graph) Iterator { return live.GetEdgesWithAnyLabel(set) }, func(edge *Edge) bool {
+			return set.Has(edge.label)
+		})
+	}
 	return g.allEdges.iteratorAnyLabel(set, 0)
 }
 
 // AddEdgePropertyIndex adds an index for the given edge property
 func (g *Graph) AddEdgePropertyIndex(propertyName string, ix IndexType) {
+	g.checkWritable()
 	g.index.EdgePropertyIndex(propertyName, g, ix)
+	g.wal.declarePropertyIndex(walEdgeIndex, propertyName, ix, AnyValue)
 }
 
 // AddNodePropertyIndex adds an index for the given node property
 func (g *Graph) AddNodePropertyIndex(propertyName string, ix IndexType) {
+	g.checkWritable()
 	g.index.NodePropertyIndex(propertyName, g, ix)
+	g.wal.declarePropertyIndex(walNodeIndex, propertyName, ix, AnyValue)
+}
+
+// AddTypedEdgePropertyIndex adds an index for the given edge property
+// that only accepts values of the given kind. The index keeps the
+// values in their natural order, so range scans compare numbers
+// numerically. Once the index is added, the Try functions fail with
+// ErrValueKind when setting a property value of another kind, and
+// the other functions keep the value in a fallback set of the index,
+// so lookups and scans still find it. Returns
+// ErrValueKind if an existing edge has a value of another kind.
+func (g *Graph) AddTypedEdgePropertyIndex(propertyName string, ix IndexType, kind ValueKind) error {
+	g.checkWritable()
+	if err := g.index.TypedEdgePropertyIndex(propertyName, g, ix, kind); err != nil {
+		return err
+	}
+	g.wal.declarePropertyIndex(walEdgeIndex, propertyName, ix, kind)
+	return nil
+}
+
+// AddTypedNodePropertyIndex adds an index for the given node property
+// that only accepts values of the given kind. The index keeps the
+// values in their natural order, so range scans compare numbers
+// numerically. Once the index is added, the Try functions fail with
+// ErrValueKind when setting a property value of another kind, and
+// the other functions keep the value in a fallback set of the index,
+// so lookups and scans still find it. Returns
+// ErrValueKind if an existing node has a value of another kind.
+func (g *Graph) AddTypedNodePropertyIndex(propertyName string, ix IndexType, kind ValueKind) error {
+	g.checkWritable()
+	if err := g.index.TypedNodePropertyIndex(propertyName, g, ix, kind); err != nil {
+		return err
+	}
+	g.wal.declarePropertyIndex(walNodeIndex, propertyName, ix, kind)
+	return nil
+}
+
+// AddCompositeNodeIndex adds an index for the given ordered list of
+// node properties. Only the nodes that have all the properties are
+// indexed, and values are compared using their string
+// representation. FindNodes and pattern searches use the index when
+// all the keys are given, or for a B-tree index, when a leftmost
+// prefix of the keys is given.
+func (g *Graph) AddCompositeNodeIndex(keys []string, ix IndexType) error {
+	g.checkWritable()
+	if err := g.index.CompositeNodeIndex(keys, g, ix); err != nil {
+		return err
+	}
+	g.wal.declareComposite(walNodeComposite, keys, ix)
+	return nil
+}
+
+// AddCompositeEdgeIndex adds an index for the given ordered list of
+// edge properties. Only the edges that have all the properties are
+// indexed, and values are compared using their string
+// representation. FindEdges and pattern searches use the index when
+// all the keys are given, or for a B-tree index, when a leftmost
+// prefix of the keys is given.
+func (g *Graph) AddCompositeEdgeIndex(keys []string, ix IndexType) error {
+	g.checkWritable()
+	if err := g.index.CompositeEdgeIndex(keys, g, ix); err != nil {
+		return err
+	}
+	g.wal.declareComposite(walEdgeComposite, keys, ix)
+	return nil
+}
+
+// GetNodesWithPropertyRange returns the nodes whose property value
+// is between lo and hi using the B-tree index for the property. A nil
+// bound is unbounded, and inclusive applies to both bounds. Values
+// are compared in the order of the value kind of the index. For
+// untyped indexes, that is the string representation of the
+// values. The nodes are returned in property value order. Returns an
+// error if the property is not indexed, or if the index is a hash
+// index.
+func (g *Graph) GetNodesWithPropertyRange(key string, lo, hi interface{}, inclusive bool) (NodeIterator, error) {
+	if g.view != nil {
+		return g.view.scanNodes(key, rangeSpec{lo: lo, hi: hi, inclusive: inclusive}, false)
+	}
+	itr, err := scanIndex(g.index.nodeProperties, key, rangeSpec{lo: lo, hi: hi, inclusive: inclusive}, false)
+	if err != nil {
+		return nil, err
+	}
+	return nodeIterator{itr}, nil
+}
+
+// GetNodesWithPropertyPrefix returns the nodes whose property value
+// starts with prefix using the B-tree index for the property, in
+// property value order.
+func (g *Graph) GetNodesWithPropertyPrefix(key, prefix string) (NodeIterator, error) {
+	if g.view != nil {
+		return g.view.scanNodes(key, rangeSpec{prefix: &prefix}, false)
+	}
+	itr, err := scanIndex(g.index.nodeProperties, key, rangeSpec{prefix: &prefix}, false)
+	if err != nil {
+		return nil, err
+	}
+	return nodeIterator{itr}, nil
+}
+
+// GetNodesOrderedByProperty returns all the nodes that have the
+// property, ordered by property value, using the B-tree index for the
+// property.
+func (g *Graph) GetNodesOrderedByProperty(key string, descending bool) (NodeIterator, error) {
+	if g.view != nil {
+		return g.view.scanNodes(key, rangeSpec{}, descending)
+	}
+	itr, err := scanIndex(g.index.nodeProperties, key, rangeSpec{}, descending)
+	if err != nil {
+		return nil, err
+	}
+	return nodeIterator{itr}, nil
+}
+
+// GetEdgesWithPropertyRange returns the edges whose property value
+// is between lo and hi using the B-tree index for the property. A nil
+// bound is unbounded, and inclusive applies to both bounds. Values
+// are compared in the order of the value kind of the index. For
+// untyped indexes, that is the string representation of the
+// values. The edges are returned in property value order. Returns an
+// error if the property is not indexed, or if the index is a hash
+// index.
+func (g *Graph) GetEdgesWithPropertyRange(key string, lo, hi interface{}, inclusive bool) (EdgeIterator, error) {
+	if g.view != nil {
+		return g.view.scanEdges(key, rangeSpec{lo: lo, hi: hi, inclusive: inclusive}, false)
+	}
+	itr, err := scanIndex(g.index.edgeProperties, key, rangeSpec{lo: lo, hi: hi, inclusive: inclusive}, false)
+	if err != nil {
+		return nil, err
+	}
+	return edgeIterator{itr}, nil
+}
+
+// GetEdgesWithPropertyPrefix returns the edges whose property value
+// starts with prefix using the B-tree index for the property, in
+// property value order.
+func (g *Graph) GetEdgesWithPropertyPrefix(key, prefix string) (EdgeIterator, error) {
+	if g.view != nil {
+		return g.view.scanEdges(key, rangeSpec{prefix: &prefix}, false)
+	}
+	itr, err := scanIndex(g.index.edgeProperties, key, rangeSpec{prefix: &prefix}, false)
+	if err != nil {
+		return nil, err
+	}
+	return edgeIterator{itr}, nil
+}
+
+// GetEdgesOrderedByProperty returns all the edges that have the
+// property, ordered by property value, using the B-tree index for the
+// property.
+func (g *Graph) GetEdgesOrderedByProperty(key string, descending bool) (EdgeIterator, error) {
+	if g.view != nil {
+		return g.view.scanEdges(key, rangeSpec{}, descending)
+	}
+	itr, err := scanIndex(g.index.edgeProperties, key, rangeSpec{}, descending)
+	if err != nil {
+		return nil, err
+	}
+	return edgeIterator{itr}, nil
 }
 
 // GetNodesWithProperty returns an iterator for the nodes that has the
@@ -186,6 +430,12 @@ func (g *Graph) AddNodePropertyIndex(propertyName string, ix IndexType) {
 // the returned iterator is undefined if during iteration nodes are
 // updated, new nodes are added, or existing nodes are deleted.
 func (g *Graph) GetNodesWithProperty(property string) NodeIterator {
+	if g.view != nil {
+		return g.view.nodeQuery(func(live *Graph) Iterator { return live.GetNodesWithProperty(property) }, func(node *Node) bool {
+			_, ok := node.GetProperty(property)
+			return ok
+		})
+	}
 	itr := g.index.NodesWithProperty(property)
 	if itr != nil {
 		return itr
@@ -210,6 +460,12 @@ func (g *Graph) GetNodesWithProperty(property string) NodeIterator {
 // returned iterator is undefined if during iteration edges are
 // updated, new edges are added, or existing edges are deleted.
 func (g *Graph) GetEdgesWithProperty(property string) EdgeIterator {
+	if g.view != nil {
+		return g.view.edgeQuery(func(live *Graph) Iterator { return live.GetEdgesWithProperty(property) }, func(edge *Edge) bool {
+			_, ok := edge.GetProperty(property)
+			return ok
+		})
+	}
 	itr := g.index.EdgesWithProperty(property)
 	if itr != nil {
 		return itr
@@ -229,6 +485,12 @@ func (g *Graph) GetEdgesWithProperty(property string) EdgeIterator {
 }
 
 func (g *Graph) ProcessNodeWithAnyContext(contexts *StringSet, handler func(*Node)) {
+	if g.view != nil {
+		for _, node := range g.view.nodesWithAnyContext(contexts) {
+			handler(node)
+		}
+		return
+	}
 	seen := intmap.NewSet[int](10)
 	contexts.Iter(func(context string) bool {
 		itr := g.index.nodesByContext.find(context)
@@ -249,19 +511,23 @@ func (g *Graph) ProcessNodeWithAnyContext(contexts *StringSet, handler func(*Nod
 }
 
 func (g *Graph) ProcessEdgesWithAnyContext(nodeId int, contexts *StringSet, dir EdgeDir, handler func(*Edge)) {
+	if g.view != nil {
+		for _, edge := range g.view.edgesWithAnyContext(nodeId, contexts, dir) {
+			handler(edge)
+		}
+		return
+	}
 	seen := intmap.NewSet[int](10)
-	id := strconv.Itoa(nodeId)
 	contexts.Iter(func(context string) bool {
 		var itr Iterator
-		var key = id + context
 		switch dir {
 		case IncomingEdge:
-			itr = g.index.edgesToContext.find(key)
+			itr = g.index.edgesToContext.find(nodeId, context)
 		case OutgoingEdge:
-			itr = g.index.edgesFromContext.find(key)
+			itr = g.index.edgesFromContext.find(nodeId, context)
 		case AnyEdge:
-			it1 := g.index.edgesToContext.find(key)
-			it2 := g.index.edgesFromContext.find(key)
+			it1 := g.index.edgesToContext.find(nodeId, context)
+			it2 := g.index.edgesFromContext.find(nodeId, context)
 			itr = MultiIterator(it1, it2)
 		}
 		if itr == nil {
@@ -280,6 +546,49 @@ func (g *Graph) ProcessEdgesWithAnyContext(nodeId int, contexts *StringSet, dir
 
 }
 
+// GetEdgesWithContext returns the edges that have the given context
+func (g *Graph) GetEdgesWithContext(context string) EdgeIterator {
+	if g.view != nil {
+		return g.view.edgeQuery(func(live *Graph) Iterator { return live.index.edgesByContext.find(context) }, func(edge *Edge) bool {
+			return edge.contexts.Has(context)
+		})
+	}
+	return edgeIterator{g.index.edgesByContext.find(context)}
+}
+
+// GetEdgesBetweenContexts returns the edges from a node with
+// fromContext to a node with toContext. The adjacent edges of the nodes
+// on the side with fewer nodes are visited.
+func (g *Graph) GetEdgesBetweenContexts(fromContext, toContext string) EdgeIterator {
+	if g.view != nil {
+		return g.view.edgesBetweenContexts(fromContext, toContext)
+	}
+	sources := g.index.nodesByContext.find(fromContext)
+	targets := g.index.nodesByContext.find(toContext)
+	nodes, dir := sources, OutgoingEdge
+	if targets.MaxSize() < sources.MaxSize() {
+		nodes, dir = targets, IncomingEdge
+	}
+	return edgeIterator{&funcIterator{
+		iteratorFunc: func() Iterator {
+			if !nodes.Next() {
+				return nil
+			}
+			node := nodes.Value().(*Node)
+			return &filterIterator{
+				itr: node.GetEdges(dir),
+				filter: func(item interface{}) bool {
+					edge := item.(*Edge)
+					if dir == OutgoingEdge {
+						return edge.to.contexts.Has(toContext)
+					}
+					return edge.from.contexts.Has(fromContext)
+				},
+			}
+		},
+	}}
+}
+
 // FindNodes returns an iterator that will iterate through all the
 // nodes that have all of the given labels and properties. If
 // allLabels is nil or empty, it does not look at the labels. If
@@ -289,6 +598,9 @@ func (g *Graph) FindNodes(allLabels *StringSet, properties map[string]interface{
 		// Return all nodes
 		return g.GetNodes(), errors.New("no label or properties provided")
 	}
+	if g.view != nil {
+		return g.view.findNodes(allLabels, properties)
+	}
 
 	var nodesByLabelItr NodeIterator
 	nodesByLabelSize := -1
@@ -298,11 +610,16 @@ func (g *Graph) FindNodes(allLabels *StringSet, properties map[string]interface{
 	}
 	// Select the iterator with minimum max size
 
+	// A composite index may cover properties that are not indexed
+	// individually
+	compositeItr, compositeKeys := compositeCandidates(g.index.nodeComposites, properties)
 	propertyIterators := make(map[string]NodeIterator)
 	if len(properties) > 0 {
 		for k, v := range properties {
-			prop := fmt.Sprintf("%v", v)
-			itr, err := g.index.GetIteratorForNodeProperty(k, prop)
+			if g.index.isNodePropertyIndexed(k) == nil && slices.Contains(compositeKeys, k) {
+				continue
+			}
+			itr, err := g.index.GetIteratorForNodeProperty(k, v)
 			if err != nil {
 				return nil, err
 			}
@@ -324,6 +641,15 @@ func (g *Graph) FindNodes(allLabels *StringSet, properties map[string]interface{
 			minimumPropertyItrKey = k
 		}
 	}
+	var propertyItr Iterator
+	if minPropertySize != -1 {
+		propertyItr = propertyIterators[minimumPropertyItrKey]
+	}
+	if compositeItr != nil {
+		if sz := compositeItr.MaxSize(); sz != -1 && (minPropertySize == -1 || sz < minPropertySize) {
+			propertyItr, minPropertySize = compositeItr, sz
+		}
+	}
 
 	nodeFilterFunc := GetNodeFilterFunc(allLabels, properties)
 	// Iterate the minimum iterator, with a filter
@@ -343,7 +669,7 @@ func (g *Graph) FindNodes(allLabels *StringSet, properties map[string]interface{
 		// Iterate by property
 		return nodeIterator{
 			&filterIterator{
-				itr: propertyIterators[minimumPropertyItrKey],
+				itr: propertyItr,
 				filter: func(item interface{}) bool {
 					return nodeFilterFunc(item.(*Node))
 				},
@@ -365,6 +691,9 @@ func (g *Graph) FindEdges(label string, properties map[string]interface{}) (Edge
 		// Return all edges
 		return g.GetEdges(), errors.New("no label or properties provided")
 	}
+	if g.view != nil {
+		return g.view.findEdges(label, properties)
+	}
 
 	var edgesByLabelItr EdgeIterator
 	edgesByLabelSize := -1
@@ -376,11 +705,16 @@ func (g *Graph) FindEdges(label string, properties map[string]interface{}) (Edge
 	}
 	// Select the iterator with minimum max size
 
+	// A composite index may cover properties that are not indexed
+	// individually
+	compositeItr, compositeKeys := compositeCandidates(g.index.edgeComposites, properties)
 	propertyIterators := make(map[string]EdgeIterator)
 	if len(properties) > 0 {
 		for k, v := range properties {
-			prop := fmt.Sprintf("%v", v)
-			itr, err := g.index.GetIteratorForEdgeProperty(k, prop)
+			if g.index.isEdgePropertyIndexed(k) == nil && slices.Contains(compositeKeys, k) {
+				continue
+			}
+			itr, err := g.index.GetIteratorForEdgeProperty(k, v)
 			if err != nil {
 				return nil, err
 			}
@@ -403,6 +737,15 @@ func (g *Graph) FindEdges(label string, properties map[string]interface{}) (Edge
 			minimumPropertyItrKey = k
 		}
 	}
+	var propertyItr Iterator
+	if minPropertySize != -1 {
+		propertyItr = propertyIterators[minimumPropertyItrKey]
+	}
+	if compositeItr != nil {
+		if sz := compositeItr.MaxSize(); sz != -1 && (minPropertySize == -1 || sz < minPropertySize) {
+			propertyItr, minPropertySize = compositeItr, sz
+		}
+	}
 	edgeFilterFunc := GetEdgeFilterFunc(NewStringSet(label), properties)
 	if label == "" {
 		edgeFilterFunc = GetEdgeFilterFunc(nil, properties)
@@ -424,7 +767,7 @@ func (g *Graph) FindEdges(label string, properties map[string]interface{}) (Edge
 		// Iterate by property
 		return &edgeIterator{
 			&filterIterator{
-				itr: propertyIterators[minimumPropertyItrKey],
+				itr: propertyItr,
 				filter: func(item interface{}) bool {
 					return edgeFilterFunc(item.(*Edge))
 				},
@@ -437,8 +780,8 @@ func (g *Graph) FindEdges(label string, properties map[string]interface{}) (Edge
 
 // GetNodeFilterFunc returns a filter function that can be used to select
 // nodes that have all the specified labels, with correct property
-// values
-func GetNodeFilterFunc(labels *StringSet, properties map[string]interface{}) func(*Node) bool {
+// values, and that satisfy all the predicates
+func GetNodeFilterFunc(labels *StringSet, properties map[string]interface{}, predicates ...Predicate) func(*Node) bool {
 	return func(node *Node) (cmp bool) {
 		if labels != nil && labels.Len() > 0 {
 			if !node.labels.HasAllSet(labels) {
@@ -461,14 +804,19 @@ func GetNodeFilterFunc(labels *StringSet, properties map[string]interface{}) fun
 				return false
 			}
 		}
+		for _, predicate := range predicates {
+			if predicate != nil && !predicate.Eval(node) {
+				return false
+			}
+		}
 		return true
 	}
 }
 
 // GetEdgeFilterFunc returns a function that can be used to select edges
 // that have at least one of the specified labels, with correct
-// property values
-func GetEdgeFilterFunc(labels *StringSet, properties map[string]interface{}) func(*Edge) bool {
+// property values, and that satisfy all the predicates
+func GetEdgeFilterFunc(labels *StringSet, properties map[string]interface{}, predicates ...Predicate) func(*Edge) bool {
 	return func(edge *Edge) (cmp bool) {
 		if labels != nil && labels.Len() > 0 {
 			if !labels.Has(edge.label) {
@@ -491,40 +839,108 @@ func GetEdgeFilterFunc(labels *StringSet, properties map[string]interface{}) fun
 				return false
 			}
 		}
+		for _, predicate := range predicates {
+			if predicate != nil && !predicate.Eval(edge) {
+				return false
+			}
+		}
 		return true
 	}
 }
 
 func (g *Graph) setNodeContexts(node *Node, context *StringSet) {
+	g.preserveNode(node, true)
+	recording := g.recording()
+	var old *StringSet
+	if recording {
+		old = node.contexts.Clone()
+	}
 	node.contexts.Replace(context, func(s string) {
 		g.index.nodesByContext.remove(s, node.id)
 	}, func(s string) {
 		g.index.nodesByContext.add(s, node.id, node)
 	})
+	if recording {
+		g.record(change{kind: nodeContextsChanged, node: node, oldValue: old, newValue: context.Clone()})
+	}
 }
 
-func (g *Graph) setNodeLabels(node *Node, labels *StringSet) {
+// setNodeLabels sets the node labels. If check is true, returns
+// ErrUniqueConstraint if the node would violate a unique constraint,
+// and the node is not changed.
+func (g *Graph) setNodeLabels(node *Node, labels *StringSet, check bool) error {
+	if check {
+		if err := g.index.checkUnique(node, labels, node.properties.getter()); err != nil {
+			return err
+		}
+	}
+	g.preserveNode(node, true)
+	old := node.labels
+	g.index.removeUnique(node, "")
 	g.index.nodesByLabel.Replace(node, node.GetLabels(), labels)
 	node.labels = labels.Clone()
+	g.index.addUnique(node, "")
+	if g.recording() {
+		g.record(change{kind: nodeLabelsChanged, node: node, oldValue: old, newValue: labels.Clone()})
+	}
+	return nil
 }
 
-func (g *Graph) setNodeProperty(node *Node, key string, value interface{}) {
+// setNodeProperty sets a node property. If check is true, returns an
+// error if the value violates the index constraints, and the node is
+// not changed. Otherwise, a value that is not of the kind of the
+// property index goes to the fallback set of the index.
+func (g *Graph) setNodeProperty(node *Node, key string, value interface{}, check bool) error {
 	nix := g.index.isNodePropertyIndexed(key)
-	if node.properties == nil {
-		node.properties = make(properties)
-	} else {
-		oldValue, exists := node.properties[key]
-		if exists {
-			if nix != nil {
-				prop := fmt.Sprintf("%v", oldValue)
-				nix.remove(prop, node.id)
+	if check {
+		if nix != nil {
+			if _, err := nix.key(key, value); err != nil {
+				return err
 			}
 		}
+		err := g.index.checkUnique(node, node.labels, func(k string) (interface{}, bool) {
+			if k == key {
+				return value, true
+			}
+			v, ok := node.properties[k]
+			return v, ok
+		})
+		if err != nil {
+			return err
+		}
 	}
+	g.preserveNode(node, true)
+	if node.properties == nil {
+		node.properties = make(properties)
+	}
+	oldValue, exists := node.properties[key]
+	if exists && nix != nil {
+		nix.removeValue(oldValue, node.id)
+	}
+	removeFromComposites(g.index.nodeComposites, node.properties, node.id, key)
+	g.index.removeUnique(node, key)
 	node.properties[key] = value
 	if nix != nil {
-		prop := fmt.Sprintf("%v", value)
-		nix.add(prop, node.id, node)
+		nix.addValue(value, node.id, node)
+	}
+	addToComposites(g.index.nodeComposites, node.properties, node.id, node, key)
+	g.index.addUnique(node, key)
+	if g.recording() {
+		g.record(change{kind: nodePropertySet, node: node, key: key, oldValue: oldValue, newValue: value, existed: exists})
+	}
+	return nil
+}
+
+// removeAddedSince removes the nodes and edges whose IDs are not
+// less than idBase. Importers use it to undo a failed import without
+// keeping an undo log of every node and edge they add.
+func (g *Graph) removeAddedSince(idBase int) {
+	for id := idBase; id < g.idBase; id++ {
+		if edge := g.GetEdge(id); edge != nil {
+			edge.Remove()
+		} else if node := g.GetNode(id); node != nil {
+			node.DetachAndRemove()
+		}
 	}
 }
 
@@ -543,8 +959,24 @@ func (g *Graph) cloneNode(sourceGraph *Graph, sourceNode *Node, cloneProperty fu
 }
 
 func (g *Graph) addNode(node *Node) {
+	g.checkWritable()
+	node.epoch = g.version
 	g.allNodes.add(node)
 	g.index.addNodeToIndex(node)
+	if g.recording() {
+		g.record(change{kind: nodeCreated, node: node})
+	}
+}
+
+func (g *Graph) addEdge(edge *Edge) {
+	g.checkWritable()
+	edge.epoch = g.version
+	g.allEdges.add(edge, 0)
+	g.connect(edge)
+	g.index.addEdgeToIndex(edge)
+	if g.recording() {
+		g.record(change{kind: edgeCreated, edge: edge})
+	}
 }
 
 func (g *Graph) removeNodeProperty(node *Node, key string) {
@@ -555,31 +987,42 @@ func (g *Graph) removeNodeProperty(node *Node, key string) {
 	if !exists {
 		return
 	}
+	g.preserveNode(node, true)
 	nix := g.index.isNodePropertyIndexed(key)
 	if nix != nil {
-		prop := fmt.Sprintf("%v", value)
-		nix.remove(prop, node.id)
+		nix.removeValue(value, node.id)
 	}
+	removeFromComposites(g.index.nodeComposites, node.properties, node.id, key)
+	g.index.removeUnique(node, key)
 	delete(node.properties, key)
+	if g.recording() {
+		g.record(change{kind: nodePropertyRemoved, node: node, key: key, oldValue: value, existed: true})
+	}
 }
 
 func (g *Graph) detachRemoveNode(node *Node) {
+	g.preserveNode(node, true)
 	g.detachNode(node)
+	recording := g.recording()
+	var externalID string
+	if recording {
+		externalID = g.index.nodeExternalIDs.of(node.id)
+	}
 	g.allNodes.remove(node)
 	g.index.removeNodeFromIndex(node)
+	if recording {
+		g.record(change{kind: nodeRemoved, node: node, oldValue: externalID})
+	}
 }
 
 func (g *Graph) detachNode(node *Node) {
+	g.checkWritable()
 	for _, edge := range EdgeSlice(node.incoming.iterator(2)) {
-		g.disconnect(edge)
-		g.allEdges.remove(edge, 0)
-		g.index.removeEdgeFromIndex(edge)
+		g.removeEdge(edge)
 	}
 	node.incoming = edgeMap{}
 	for _, edge := range EdgeSlice(node.outgoing.iterator(1)) {
-		g.disconnect(edge)
-		g.allEdges.remove(edge, 0)
-		g.index.removeEdgeFromIndex(edge)
+		g.removeEdge(edge)
 	}
 	node.outgoing = edgeMap{}
 }
@@ -602,9 +1045,7 @@ func (g *Graph) cloneEdge(from, to *Node, sourceEdge *Edge, cloneProperty func(s
 		newEdge.properties = sourceEdge.properties.clone(to.graph, g, cloneProperty)
 	}
 	g.idBase++
-	g.allEdges.add(newEdge, 0)
-	g.connect(newEdge)
-	g.index.addEdgeToIndex(newEdge)
+	g.addEdge(newEdge)
 	return newEdge
 }
 
@@ -619,44 +1060,87 @@ func (g *Graph) disconnect(edge *Edge) {
 }
 
 func (g *Graph) setEdgeLabel(edge *Edge, label string) {
+	g.preserveEdge(edge, true)
+	old := edge.label
+	// Edge maps group edges by label, so the edge is removed using
+	// the old label and added back with the new one
 	g.disconnect(edge)
+	g.allEdges.remove(edge, 0)
+	g.index.edgesByLabel.remove(edge.label, edge.id)
 	edge.label = label
+	g.index.edgesByLabel.add(edge.label, edge.id, edge)
+	g.allEdges.add(edge, 0)
 	g.connect(edge)
+	if g.recording() {
+		g.record(change{kind: edgeLabelChanged, edge: edge, oldValue: old, newValue: label})
+	}
 }
 
 func (g *Graph) setEdgeContext(edge *Edge, context *StringSet) {
+	g.preserveEdge(edge, true)
+	recording := g.recording()
+	var old *StringSet
+	if recording {
+		old = edge.contexts.Clone()
+	}
 	edge.contexts.Replace(context, func(s string) {
-		g.index.edgesFromContext.remove(strconv.Itoa(edge.from.id)+s, edge.id)
-		g.index.edgesToContext.remove(strconv.Itoa(edge.to.id)+s, edge.id)
+		g.index.edgesByContext.remove(s, edge.id)
+		g.index.edgesFromContext.remove(edge.from.id, s, edge.id)
+		g.index.edgesToContext.remove(edge.to.id, s, edge.id)
 	}, func(s string) {
-		g.index.edgesFromContext.add(strconv.Itoa(edge.from.id)+s, edge.id, edge)
-		g.index.edgesToContext.add(strconv.Itoa(edge.to.id)+s, edge.id, edge)
+		g.index.edgesByContext.add(s, edge.id, edge)
+		g.index.edgesFromContext.add(edge.from.id, s, edge)
+		g.index.edgesToContext.add(edge.to.id, s, edge)
 	})
+	if recording {
+		g.record(change{kind: edgeContextsChanged, edge: edge, oldValue: old, newValue: context.Clone()})
+	}
 }
 
 func (g *Graph) removeEdge(edge *Edge) {
+	g.preserveEdge(edge, true)
+	recording := g.recording()
+	var externalID string
+	if recording {
+		externalID = g.index.edgeExternalIDs.of(edge.id)
+	}
 	g.disconnect(edge)
 	g.allEdges.remove(edge, 0)
+	g.index.removeEdgeFromIndex(edge)
+	if recording {
+		g.record(change{kind: edgeRemoved, edge: edge, oldValue: externalID})
+	}
 }
 
-func (g *Graph) setEdgeProperty(edge *Edge, key string, value interface{}) {
+// setEdgeProperty sets an edge property. If check is true, returns
+// an error if the value is not of the kind of the property index, and
+// the edge is not changed. Otherwise, such a value goes to the
+// fallback set of the index.
+func (g *Graph) setEdgeProperty(edge *Edge, key string, value interface{}, check bool) error {
 	nix := g.index.isEdgePropertyIndexed(key)
+	if check && nix != nil {
+		if _, err := nix.key(key, value); err != nil {
+			return err
+		}
+	}
+	g.preserveEdge(edge, true)
 	if edge.properties == nil {
 		edge.properties = make(properties)
-	} else {
-		oldValue, exists := edge.properties[key]
-		if exists {
-			if nix != nil {
-				prop := fmt.Sprintf("%v", oldValue)
-				nix.remove(prop, edge.id)
-			}
-		}
 	}
+	oldValue, exists := edge.properties[key]
+	if exists && nix != nil {
+		nix.removeValue(oldValue, edge.id)
+	}
+	removeFromComposites(g.index.edgeComposites, edge.properties, edge.id, key)
 	edge.properties[key] = value
 	if nix != nil {
-		prop := fmt.Sprintf("%v", value)
-		nix.add(prop, edge.id, edge)
+		nix.addValue(value, edge.id, edge)
 	}
+	addToComposites(g.index.edgeComposites, edge.properties, edge.id, edge, key)
+	if g.recording() {
+		g.record(change{kind: edgePropertySet, edge: edge, key: key, oldValue: oldValue, newValue: value, existed: exists})
+	}
+	return nil
 }
 
 func (g *Graph) removeEdgeProperty(edge *Edge, key string) {
@@ -667,12 +1151,16 @@ func (g *Graph) removeEdgeProperty(edge *Edge, key string) {
 	if !exists {
 		return
 	}
+	g.preserveEdge(edge, true)
 	nix := g.index.isEdgePropertyIndexed(key)
 	if nix != nil {
-		prop := fmt.Sprintf("%v", oldValue)
-		nix.remove(prop, edge.id)
+		nix.removeValue(oldValue, edge.id)
 	}
+	removeFromComposites(g.index.edgeComposites, edge.properties, edge.id, key)
 	delete(edge.properties, key)
+	if g.recording() {
+		g.record(change{kind: edgePropertyRemoved, edge: edge, key: key, oldValue: oldValue, existed: true})
+	}
 }
 
 type WithProperties interface {
@@ -71,6 +71,52 @@ func TestContexts(t *testing.T) {
 
 }
 
+func TestEdgeContextKeys(t *testing.T) {
+	g := NewGraph()
+	nodes := make([]*Node, 0)
+	for i := 0; i < 13; i++ {
+		nodes = append(nodes, g.NewNode(nil, nil, nil))
+	}
+	// Node 1 with context 2x and node 12 with context x used to share a key
+	e1 := g.NewEdge(nodes[1], nodes[0], "e", nil, NewStringSet("2x"))
+	e2 := g.NewEdge(nodes[12], nodes[0], "e", nil, NewStringSet("x"))
+	edges := make([]*Edge, 0)
+	g.ProcessEdgesWithAnyContext(nodes[1].GetID(), NewStringSet("2x"), OutgoingEdge, func(e *Edge) {
+		edges = append(edges, e)
+	})
+	assert.Equal(t, []*Edge{e1}, edges)
+	edges = edges[:0]
+	g.ProcessEdgesWithAnyContext(nodes[12].GetID(), NewStringSet("x"), OutgoingEdge, func(e *Edge) {
+		edges = append(edges, e)
+	})
+	assert.Equal(t, []*Edge{e2}, edges)
+
+	assert.Equal(t, []*Edge{e2}, EdgeSlice(g.GetEdgesWithContext("x")))
+	e1.SetContexts(NewStringSet("x"))
+	assert.ElementsMatch(t, []*Edge{e1, e2}, EdgeSlice(g.GetEdgesWithContext("x")))
+	assert.Empty(t, EdgeSlice(g.GetEdgesWithContext("2x")))
+	e2.Remove()
+	assert.Equal(t, []*Edge{e1}, EdgeSlice(g.GetEdgesWithContext("x")))
+	assert.Nil(t, g.Verify())
+}
+
+func TestEdgesBetweenContexts(t *testing.T) {
+	g := NewGraph()
+	a1 := g.NewNode(nil, nil, NewStringSet("a"))
+	a2 := g.NewNode(nil, nil, NewStringSet("a"))
+	b1 := g.NewNode(nil, nil, NewStringSet("b"))
+	ab := g.NewNode(nil, nil, NewStringSet("a", "b"))
+	e1 := g.NewEdge(a1, b1, "e", nil, nil)
+	e2 := g.NewEdge(a2, ab, "e", nil, nil)
+	e3 := g.NewEdge(b1, a1, "e", nil, nil)
+	g.NewEdge(a1, a2, "e", nil, nil)
+	// Visits the incoming edges of the target nodes
+	assert.ElementsMatch(t, []*Edge{e1, e2}, EdgeSlice(g.GetEdgesBetweenContexts("a", "b")))
+	// Visits the outgoing edges of the source nodes
+	assert.ElementsMatch(t, []*Edge{e3}, EdgeSlice(g.GetEdgesBetweenContexts("b", "a")))
+	assert.Empty(t, EdgeSlice(g.GetEdgesBetweenContexts("a", "c")))
+}
+
 func TestRetrieveEdgesWithContexts(t *testing.T) {
 	nodes := make([]*Node, 0)
 	g := NewGraph()
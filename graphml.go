@@ -0,0 +1,494 @@
+// Copyright 2021 Cloud Privacy Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lpg
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Reserved GraphML key IDs for labels and contexts
+const (
+	GraphMLNodeLabelsKey   = "node_labels"
+	GraphMLNodeContextsKey = "node_contexts"
+	GraphMLEdgeLabelKey    = "edge_label"
+	GraphMLEdgeContextsKey = "edge_contexts"
+)
+
+// GraphMLRenderer renders a graph in GraphML format. Node labels, edge
+// labels, and contexts are written as data elements with the reserved
+// keys. Labels and contexts are written as comma separated lists,
+// with commas and backslashes in the items escaped by a
+// backslash. Properties are written as data elements whose keys are
+// declared with the type inferred from the property values. Values
+// that are not primitive are written as JSON, under a separate key
+// marked with lpg.encoding="json".
+type GraphMLRenderer struct {
+	// NodeRenderer is called with the properties of a node before it
+	// is rendered. The properties can be modified. If the node is to
+	// be excluded, returns false.
+	NodeRenderer func(ID string, node *Node, properties map[string]interface{}) (bool, error)
+	// EdgeRenderer is called with the properties of an edge before it
+	// is rendered. The properties can be modified. The from and to
+	// nodes are rendered if this is called. If the edge is to be
+	// excluded, returns false.
+	EdgeRenderer func(fromID, toID string, edge *Edge, properties map[string]interface{}) (bool, error)
+}
+
+type graphmlDoc struct {
+	XMLName xml.Name     `xml:"graphml"`
+	Xmlns   string       `xml:"xmlns,attr,omitempty"`
+	Keys    []graphmlKey `xml:"key"`
+	Graph   graphmlGraph `xml:"graph"`
+}
+
+type graphmlKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr,omitempty"`
+	Type string `xml:"attr.type,attr,omitempty"`
+	// Encoding is "json" for keys of JSON encoded values
+	Encoding string `xml:"lpg.encoding,attr,omitempty"`
+}
+
+type graphmlGraph struct {
+	ID          string        `xml:"id,attr,omitempty"`
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Nodes       []graphmlNode `xml:"node"`
+	Edges       []graphmlEdge `xml:"edge"`
+}
+
+type graphmlNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphmlData `xml:"data"`
+}
+
+type graphmlEdge struct {
+	ID     string        `xml:"id,attr,omitempty"`
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphmlData `xml:"data"`
+}
+
+type graphmlData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+// GraphML attribute types, ordered so that a type can be widened to a
+// type with a larger value
+const (
+	graphmlBoolean = iota
+	graphmlInt
+	graphmlLong
+	graphmlFloat
+	graphmlDouble
+	graphmlString
+	// graphmlJSON values are written as JSON strings under their own
+	// key, and are never widened
+	graphmlJSON
+)
+
+var graphmlTypeNames = []string{"boolean", "int", "long", "float", "double", "string"}
+
+// graphmlValue returns the string representation and the GraphML
+// type of a property value
+func graphmlValue(value interface{}) (string, int) {
+	if n, ok := value.(WithNativeValue); ok {
+		value = n.GetNativeValue()
+	}
+	switch v := value.(type) {
+	case bool:
+		return strconv.FormatBool(v), graphmlBoolean
+	case int8, int16, int32, uint8, uint16:
+		return fmt.Sprint(v), graphmlInt
+	case int, int64, uint, uint32, uint64:
+		return fmt.Sprint(v), graphmlLong
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32), graphmlFloat
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), graphmlDouble
+	case string:
+		return v, graphmlString
+	}
+	if data, err := json.Marshal(value); err == nil {
+		return string(data), graphmlJSON
+	}
+	return fmt.Sprint(value), graphmlString
+}
+
+// widenGraphMLType returns the type that can represent values of
+// both types
+func widenGraphMLType(t1, t2 int) int {
+	if t1 == t2 {
+		return t1
+	}
+	if t1 == graphmlBoolean || t2 == graphmlBoolean {
+		return graphmlString
+	}
+	t := max(t1, t2)
+	if t == graphmlFloat && min(t1, t2) == graphmlLong {
+		return graphmlDouble
+	}
+	return t
+}
+
+type graphmlKeySet struct {
+	prefix     string
+	jsonPrefix string
+	types      map[string]int
+	json       map[string]struct{}
+}
+
+func newGraphMLKeySet(prefix, jsonPrefix string) graphmlKeySet {
+	return graphmlKeySet{
+		prefix:     prefix,
+		jsonPrefix: jsonPrefix,
+		types:      make(map[string]int),
+		json:       make(map[string]struct{}),
+	}
+}
+
+func (k *graphmlKeySet) add(name string, t int) string {
+	if t == graphmlJSON {
+		k.json[name] = struct{}{}
+		return k.jsonPrefix + name
+	}
+	if existing, ok := k.types[name]; ok {
+		t = widenGraphMLType(existing, t)
+	}
+	k.types[name] = t
+	return k.prefix + name
+}
+
+func (k *graphmlKeySet) keys(forElement string) []graphmlKey {
+	names := make([]string, 0, len(k.types))
+	for name := range k.types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	ret := make([]graphmlKey, 0, len(names))
+	for _, name := range names {
+		ret = append(ret, graphmlKey{
+			ID:   k.prefix + name,
+			For:  forElement,
+			Name: name,
+			Type: graphmlTypeNames[k.types[name]],
+		})
+	}
+	names = names[:0]
+	for name := range k.json {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		ret = append(ret, graphmlKey{
+			ID:       k.jsonPrefix + name,
+			For:      forElement,
+			Name:     name,
+			Type:     graphmlTypeNames[graphmlString],
+			Encoding: "json",
+		})
+	}
+	return ret
+}
+
+func (r GraphMLRenderer) propertyData(p properties, keys *graphmlKeySet, render func(map[string]interface{}) (bool, error)) ([]graphmlData, bool, error) {
+	props := make(map[string]interface{}, len(p))
+	for k, v := range p {
+		props[k] = v
+	}
+	if render != nil {
+		include, err := render(props)
+		if err != nil || !include {
+			return nil, false, err
+		}
+	}
+	names := make([]string, 0, len(props))
+	for k := range props {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	ret := make([]graphmlData, 0, len(names))
+	for _, k := range names {
+		value, t := graphmlValue(props[k])
+		ret = append(ret, graphmlData{Key: keys.add(k, t), Value: value})
+	}
+	return ret, true, nil
+}
+
+// Render writes a GraphML document with the given graph ID
+func (r GraphMLRenderer) Render(g *Graph, graphID string, out io.Writer) error {
+	doc := graphmlDoc{
+		Xmlns: "http://graphml.graphdrawing.org/xmlns",
+		Graph: graphmlGraph{
+			ID:          graphID,
+			EdgeDefault: "directed",
+		},
+	}
+	nodeKeys := newGraphMLKeySet("n_", "nj_")
+	edgeKeys := newGraphMLKeySet("e_", "ej_")
+
+	// Give nodes unique IDs for the graph
+	nodeMap := map[*Node]string{}
+	x := 0
+	for itr := g.GetNodes(); itr.Next(); {
+		node := itr.Node()
+		nodeID := fmt.Sprintf("n%d", x)
+		var render func(map[string]interface{}) (bool, error)
+		if r.NodeRenderer != nil {
+			render = func(props map[string]interface{}) (bool, error) {
+				return r.NodeRenderer(nodeID, node, props)
+			}
+		}
+		data, include, err := r.propertyData(node.properties, &nodeKeys, render)
+		if err != nil {
+			return err
+		}
+		if !include {
+			continue
+		}
+		x++
+		nodeMap[node] = nodeID
+		element := graphmlNode{ID: nodeID}
+		if node.labels.Len() > 0 {
+			element.Data = append(element.Data, graphmlData{Key: GraphMLNodeLabelsKey, Value: graphmlList(node.labels)})
+		}
+		if node.contexts.Len() > 0 {
+			element.Data = append(element.Data, graphmlData{Key: GraphMLNodeContextsKey, Value: graphmlList(node.contexts)})
+		}
+		element.Data = append(element.Data, data...)
+		doc.Graph.Nodes = append(doc.Graph.Nodes, element)
+	}
+	for edgeItr := g.GetEdges(); edgeItr.Next(); {
+		edge := edgeItr.Edge()
+		fromID, ok1 := nodeMap[edge.GetFrom()]
+		toID, ok2 := nodeMap[edge.GetTo()]
+		if !ok1 || !ok2 {
+			continue
+		}
+		var render func(map[string]interface{}) (bool, error)
+		if r.EdgeRenderer != nil {
+			render = func(props map[string]interface{}) (bool, error) {
+				return r.EdgeRenderer(fromID, toID, edge, props)
+			}
+		}
+		data, include, err := r.propertyData(edge.properties, &edgeKeys, render)
+		if err != nil {
+			return err
+		}
+		if !include {
+			continue
+		}
+		element := graphmlEdge{
+			ID:     fmt.Sprintf("e%d", len(doc.Graph.Edges)),
+			Source: fromID,
+			Target: toID,
+		}
+		if len(edge.label) > 0 {
+			element.Data = append(element.Data, graphmlData{Key: GraphMLEdgeLabelKey, Value: edge.label})
+		}
+		if edge.contexts.Len() > 0 {
+			element.Data = append(element.Data, graphmlData{Key: GraphMLEdgeContextsKey, Value: graphmlList(edge.contexts)})
+		}
+		element.Data = append(element.Data, data...)
+		doc.Graph.Edges = append(doc.Graph.Edges, element)
+	}
+
+	doc.Keys = []graphmlKey{
+		{ID: GraphMLNodeLabelsKey, For: "node", Name: "labels", Type: "string"},
+		{ID: GraphMLNodeContextsKey, For: "node", Name: "contexts", Type: "string"},
+		{ID: GraphMLEdgeLabelKey, For: "edge", Name: "label", Type: "string"},
+		{ID: GraphMLEdgeContextsKey, For: "edge", Name: "contexts", Type: "string"},
+	}
+	doc.Keys = append(doc.Keys, nodeKeys.keys("node")...)
+	doc.Keys = append(doc.Keys, edgeKeys.keys("edge")...)
+
+	if _, err := io.WriteString(out, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(out)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(out, "\n")
+	return err
+}
+
+// parseGraphMLValue converts the string value to the given GraphML
+// type. Unknown types are kept as strings
+func parseGraphMLValue(value, t string) (interface{}, error) {
+	switch t {
+	case "boolean":
+		return strconv.ParseBool(strings.TrimSpace(value))
+	case "int", "long":
+		return strconv.Atoi(strings.TrimSpace(value))
+	case "float", "double":
+		return strconv.ParseFloat(strings.TrimSpace(value), 64)
+	}
+	return value, nil
+}
+
+var graphmlListEscaper = strings.NewReplacer(`\`, `\\`, ",", `\,`)
+
+// graphmlList returns the comma separated items of the set, with
+// commas and backslashes escaped
+func graphmlList(set *StringSet) string {
+	items := set.Slice()
+	for i := range items {
+		items[i] = graphmlListEscaper.Replace(items[i])
+	}
+	return strings.Join(items, ",")
+}
+
+// splitGraphMLList splits a list written by graphmlList
+func splitGraphMLList(value string) *StringSet {
+	ret := NewStringSet()
+	var item strings.Builder
+	add := func() {
+		if x := strings.TrimSpace(item.String()); len(x) > 0 {
+			ret.Add(x)
+		}
+		item.Reset()
+	}
+	escaped := false
+	for _, c := range value {
+		switch {
+		case escaped:
+			item.WriteRune(c)
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == ',':
+			add()
+		default:
+			item.WriteRune(c)
+		}
+	}
+	add()
+	return ret
+}
+
+// ReadGraphML reads a GraphML document and adds the nodes and edges
+// into the graph. Data elements with the reserved keys are read as
+// labels and contexts. All other data elements are read as
+// properties named after the attr.name of their key, converted to
+// the declared attr.type. Integer values are read as int, and
+// floating point values are read as float64. Values of keys with
+// lpg.encoding="json" are decoded using
+// DefaultJSONPropertyUnmarshaler.
+func ReadGraphML(g *Graph, in io.Reader) error {
+	var doc graphmlDoc
+	if err := xml.NewDecoder(in).Decode(&doc); err != nil {
+		return err
+	}
+	keys := make(map[string]graphmlKey, len(doc.Keys))
+	for _, k := range doc.Keys {
+		if len(k.Name) == 0 {
+			k.Name = k.ID
+		}
+		keys[k.ID] = k
+	}
+	getProperties := func(data []graphmlData) (properties, error) {
+		var ret properties
+		for _, d := range data {
+			k, ok := keys[d.Key]
+			if !ok {
+				k = graphmlKey{ID: d.Key, Name: d.Key}
+			}
+			var value interface{}
+			var err error
+			if k.Encoding == "json" {
+				value, err = DefaultJSONPropertyUnmarshaler(json.RawMessage(d.Value))
+			} else {
+				value, err = parseGraphMLValue(d.Value, k.Type)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("key %s: %w", d.Key, err)
+			}
+			if ret == nil {
+				ret = make(properties)
+			}
+			ret[k.Name] = value
+		}
+		return ret, nil
+	}
+
+	nodeMap := make(map[string]*Node, len(doc.Graph.Nodes))
+	for _, element := range doc.Graph.Nodes {
+		if _, exists := nodeMap[element.ID]; exists {
+			return fmt.Errorf("duplicate node id: %s", element.ID)
+		}
+		labels := NewStringSet()
+		contexts := NewStringSet()
+		data := make([]graphmlData, 0, len(element.Data))
+		for _, d := range element.Data {
+			switch d.Key {
+			case GraphMLNodeLabelsKey:
+				labels = splitGraphMLList(d.Value)
+			case GraphMLNodeContextsKey:
+				contexts = splitGraphMLList(d.Value)
+			default:
+				data = append(data, d)
+			}
+		}
+		props, err := getProperties(data)
+		if err != nil {
+			return fmt.Errorf("node %s: %w", element.ID, err)
+		}
+		node, err := g.TryFastNewNode(labels, props, contexts)
+		if err != nil {
+			return fmt.Errorf("node %s: %w", element.ID, err)
+		}
+		nodeMap[element.ID] = node
+	}
+	for _, element := range doc.Graph.Edges {
+		from, ok := nodeMap[element.Source]
+		if !ok {
+			return fmt.Errorf("edge refers to unknown node: %s", element.Source)
+		}
+		to, ok := nodeMap[element.Target]
+		if !ok {
+			return fmt.Errorf("edge refers to unknown node: %s", element.Target)
+		}
+		var label string
+		contexts := NewStringSet()
+		data := make([]graphmlData, 0, len(element.Data))
+		for _, d := range element.Data {
+			switch d.Key {
+			case GraphMLEdgeLabelKey:
+				label = d.Value
+			case GraphMLEdgeContextsKey:
+				contexts = splitGraphMLList(d.Value)
+			default:
+				data = append(data, d)
+			}
+		}
+		props, err := getProperties(data)
+		if err != nil {
+			return fmt.Errorf("edge %s->%s: %w", element.Source, element.Target, err)
+		}
+		if _, err := g.TryFastNewEdge(from, to, label, props, contexts); err != nil {
+			return fmt.Errorf("edge %s->%s: %w", element.Source, element.Target, err)
+		}
+	}
+	return nil
+}
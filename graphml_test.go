@@ -0,0 +1,135 @@
+// Copyright 2021 Cloud Privacy Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lpg
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestGraphMLRoundTrip(t *testing.T) {
+	g := getJSONTestGraph()
+	for nodes := g.GetNodes(); nodes.Next(); {
+		nodes.Node().SetProperty("flag", true)
+		nodes.Node().SetProperty("ratio", 0.5)
+	}
+	buf := bytes.Buffer{}
+	if err := (GraphMLRenderer{}).Render(g, "g", &buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	assert.Contains(t, out, `<key id="n_key" for="node" attr.name="key" attr.type="long"></key>`)
+	assert.Contains(t, out, `<key id="n_flag" for="node" attr.name="flag" attr.type="boolean"></key>`)
+	assert.Contains(t, out, `<key id="n_ratio" for="node" attr.name="ratio" attr.type="double"></key>`)
+	assert.Contains(t, out, `<key id="n_str" for="node" attr.name="str" attr.type="string"></key>`)
+
+	target := NewGraph()
+	if err := ReadGraphML(target, &buf); err != nil {
+		t.Fatal(err)
+	}
+	checkJSONIsomorphism(t, g, target)
+}
+
+func TestGraphMLRoundTripValues(t *testing.T) {
+	g := NewGraph()
+	labels := NewStringSet("a,b", `c\d`, "e")
+	contexts := NewStringSet(`x,\,y`)
+	node := g.NewNode(labels.Slice(), map[string]interface{}{
+		"list": []interface{}{1, "x"},
+		"map":  map[string]interface{}{"k": 1.5},
+	}, contexts)
+	g.NewEdge(node, node, "self", map[string]interface{}{"list": []interface{}{true}}, contexts)
+	buf := bytes.Buffer{}
+	if err := (GraphMLRenderer{}).Render(g, "g", &buf); err != nil {
+		t.Fatal(err)
+	}
+	target := NewGraph()
+	if err := ReadGraphML(target, &buf); err != nil {
+		t.Fatal(err)
+	}
+	nodes := NodeSlice(target.GetNodes())
+	if assert.Equal(t, 1, len(nodes)) {
+		assert.ElementsMatch(t, labels.Slice(), nodes[0].GetLabels().Slice())
+		assert.Equal(t, contexts.Slice(), nodes[0].GetContexts().Slice())
+		v, _ := nodes[0].GetProperty("list")
+		assert.Equal(t, []interface{}{1, "x"}, v)
+		v, _ = nodes[0].GetProperty("map")
+		assert.Equal(t, map[string]interface{}{"k": 1.5}, v)
+	}
+	edges := EdgeSlice(target.GetEdges())
+	if assert.Equal(t, 1, len(edges)) {
+		assert.Equal(t, contexts.Slice(), edges[0].GetContexts().Slice())
+		v, _ := edges[0].GetProperty("list")
+		assert.Equal(t, []interface{}{true}, v)
+	}
+}
+
+func TestGraphMLRenderers(t *testing.T) {
+	g := getJSONTestGraph()
+	buf := bytes.Buffer{}
+	err := GraphMLRenderer{
+		NodeRenderer: func(ID string, node *Node, props map[string]interface{}) (bool, error) {
+			delete(props, "str")
+			k, _ := node.GetProperty("key")
+			return k != 0, nil
+		},
+		EdgeRenderer: func(fromID, toID string, edge *Edge, props map[string]interface{}) (bool, error) {
+			props["key"] = "x"
+			return edge.GetLabel() == "next", nil
+		},
+	}.Render(g, "g", &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := NewGraph()
+	if err := ReadGraphML(target, &buf); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 4, target.NumNodes())
+	assert.Equal(t, 3, target.NumEdges())
+	for nodes := target.GetNodes(); nodes.Next(); {
+		_, ok := nodes.Node().GetProperty("str")
+		assert.False(t, ok)
+	}
+	for edges := target.GetEdges(); edges.Next(); {
+		v, _ := edges.Edge().GetProperty("key")
+		assert.Equal(t, "x", v)
+	}
+}
+
+func TestReadGraphML(t *testing.T) {
+	input := `<?xml version="1.0" encoding="UTF-8"?>
+<graphml xmlns="http://graphml.graphdrawing.org/xmlns">
+  <key id="d0" for="node" attr.name="color" attr.type="string"/>
+  <key id="d1" for="edge" attr.name="weight" attr.type="double"/>
+  <graph id="G" edgedefault="directed">
+    <node id="a"><data key="d0">green</data></node>
+    <node id="b"/>
+    <edge source="a" target="b"><data key="d1">1.5</data></edge>
+  </graph>
+</graphml>`
+	g := NewGraph()
+	if err := ReadGraphML(g, strings.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, g.NumNodes())
+	edge := EdgeSlice(g.GetEdges())[0]
+	w, _ := edge.GetProperty("weight")
+	assert.Equal(t, 1.5, w)
+	c, _ := edge.GetFrom().GetProperty("color")
+	assert.Equal(t, "green", c)
+}
@@ -51,6 +51,9 @@ func (ix *hashIndex[V, I]) remove(value V, id int) {
 		return
 	}
 	fs.remove(id)
+	if fs.size() == 0 {
+		delete(ix.values, value)
+	}
 	ix.elements.Remove(el.(*list.Element))
 }
 
@@ -73,3 +76,19 @@ func (ix *hashIndex[V, I]) valueItr() Iterator {
 	}
 	return &listIterator{next: ix.elements.Front(), size: ix.elements.Len()}
 }
+
+func (ix *hashIndex[V, I]) indexType() IndexType { return HashIndex }
+
+func (ix *hashIndex[V, I]) scan(valueRange[V], bool) (Iterator, error) {
+	return nil, ErrUnorderedIndex
+}
+
+func (ix *hashIndex[V, I]) forEach(f func(V, I) bool) {
+	for value, fs := range ix.values {
+		for itr := fs.iterator(); itr.Next(); {
+			if !f(value, itr.Value().(*list.Element).Value.(I)) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright 2021 Cloud Privacy Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lpg
+
+import (
+	"github.com/kamstrup/intmap"
+)
+
+// ErrDuplicateExternalID is returned when an external ID is already
+// attached to another node or edge of the graph
+type ErrDuplicateExternalID string
+
+func (e ErrDuplicateExternalID) Error() string {
+	return "Duplicate external ID: " + string(e)
+}
+
+// externalIDs is a two-way mapping between the external IDs and the
+// items. It is allocated when the first external ID is attached.
+type externalIDs[I Item] struct {
+	items map[string]I
+	ids   *intmap.Map[int, string]
+}
+
+func (e *externalIDs[I]) get(id string) (I, bool) {
+	item, ok := e.items[id]
+	return item, ok
+}
+
+func (e *externalIDs[I]) of(itemID int) string {
+	if e.ids == nil {
+		return ""
+	}
+	s, _ := e.ids.Get(itemID)
+	return s
+}
+
+// set attaches the external id to the item. If id is empty, removes
+// the external ID of the item.
+func (e *externalIDs[I]) set(itemID int, item I, id string) error {
+	if len(id) > 0 {
+		if _, exists := e.items[id]; exists && e.of(itemID) != id {
+			return ErrDuplicateExternalID(id)
+		}
+	}
+	e.remove(itemID)
+	if len(id) == 0 {
+		return nil
+	}
+	if e.items == nil {
+		e.items = make(map[string]I)
+		e.ids = intmap.New[int, string](16)
+	}
+	e.items[id] = item
+	e.ids.Put(itemID, id)
+	return nil
+}
+
+func (e *externalIDs[I]) remove(itemID int) {
+	if e.ids == nil {
+		return
+	}
+	if s, ok := e.ids.Get(itemID); ok {
+		delete(e.items, s)
+		e.ids.Del(itemID)
+	}
+}
+
+// GetNode returns the node with the given ID, or nil if the graph does
+// not have a node with that ID
+func (g *Graph) GetNode(id int) *Node {
+	if g.view != nil {
+		return g.view.getNode(id)
+	}
+	node, _ := g.index.nodesByID.Get(id)
+	return node
+}
+
+// GetEdge returns the edge with the given ID, or nil if the graph does
+// not have an edge with that ID
+func (g *Graph) GetEdge(id int) *Edge {
+	if g.view != nil {
+		return g.view.getEdge(id)
+	}
+	edge, _ := g.index.edgesByID.Get(id)
+	return edge
+}
+
+// GetNodeByExternalID returns the node with the given external ID, or
+// nil if there is no such node
+func (g *Graph) GetNodeByExternalID(id string) *Node {
+	if g.view != nil {
+		return g.view.nodeByExternalID(id)
+	}
+	node, _ := g.index.nodeExternalIDs.get(id)
+	return node
+}
+
+// GetEdgeByExternalID returns the edge with the given external ID, or
+// nil if there is no such edge
+func (g *Graph) GetEdgeByExternalID(id string) *Edge {
+	if g.view != nil {
+		return g.view.edgeByExternalID(id)
+	}
+	edge, _ := g.index.edgeExternalIDs.get(id)
+	return edge
+}
+
+// SetExternalID attaches an external ID to the node. External IDs are
+// unique among the nodes of a graph. If the ID is attached to another
+// node, returns ErrDuplicateExternalID. An empty id removes the
+// external ID of the node.
+func (node *Node) SetExternalID(id string) error {
+	g := node.graph
+	g.preserveNode(node, true)
+	old := g.index.nodeExternalIDs.of(node.id)
+	if err := g.index.nodeExternalIDs.set(node.id, node, id); err != nil {
+		return err
+	}
+	if g.recording() {
+		g.record(change{kind: nodeExternalIDChanged, node: node, oldValue: old, newValue: id})
+	}
+	return nil
+}
+
+// GetExternalID returns the external ID of the node, or empty string
+// if the node does not have one
+func (node *Node) GetExternalID() string {
+	if node.graph.view != nil {
+		return node.graph.view.nodeExternalID(node.id)
+	}
+	return node.graph.index.nodeExternalIDs.of(node.id)
+}
+
+// SetExternalID attaches an external ID to the edge. External IDs are
+// unique among the edges of a graph. If the ID is attached to another
+// edge, returns ErrDuplicateExternalID. An empty id removes the
+// external ID of the edge.
+func (edge *Edge) SetExternalID(id string) error {
+	g := edge.from.graph
+	g.preserveEdge(edge, true)
+	old := g.index.edgeExternalIDs.of(edge.id)
+	if err := g.index.edgeExternalIDs.set(edge.id, edge, id); err != nil {
+		return err
+	}
+	if g.recording() {
+		g.record(change{kind: edgeExternalIDChanged, edge: edge, oldValue: old, newValue: id})
+	}
+	return nil
+}
+
+// GetExternalID returns the external ID of the edge, or empty string
+// if the edge does not have one
+func (edge *Edge) GetExternalID() string {
+	if g := edge.from.graph; g.view != nil {
+		return g.view.edgeExternalID(edge.id)
+	}
+	return edge.from.graph.index.edgeExternalIDs.of(edge.id)
+}
@@ -0,0 +1,91 @@
+// Copyright 2021 Cloud Privacy Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lpg
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestGetByID(t *testing.T) {
+	g, nodes := GetLineGraph(5, true)
+	for _, node := range nodes {
+		assert.Equal(t, node, g.GetNode(node.GetID()))
+	}
+	edges := EdgeSlice(g.GetEdges())
+	for _, edge := range edges {
+		assert.Equal(t, edge, g.GetEdge(edge.GetID()))
+		assert.Nil(t, g.GetNode(edge.GetID()))
+	}
+	assert.Nil(t, g.GetNode(1000))
+
+	edges[0].Remove()
+	assert.Nil(t, g.GetEdge(edges[0].GetID()))
+	nodes[2].DetachAndRemove()
+	assert.Nil(t, g.GetNode(nodes[2].GetID()))
+	assert.Nil(t, g.GetEdge(edges[1].GetID()))
+	assert.Equal(t, edges[3], g.GetEdge(edges[3].GetID()))
+
+	target := NewGraph()
+	CopyGraph(g, target, func(_ string, v interface{}) interface{} { return v })
+	for nodes := target.GetNodes(); nodes.Next(); {
+		assert.Equal(t, nodes.Node(), target.GetNode(nodes.Node().GetID()))
+	}
+}
+
+func TestExternalIDs(t *testing.T) {
+	g, nodes := GetLineGraph(3, true)
+	assert.Nil(t, nodes[0].SetExternalID("n0"))
+	assert.Nil(t, nodes[1].SetExternalID("n1"))
+	assert.Nil(t, nodes[1].SetExternalID("n1"))
+	var derr ErrDuplicateExternalID
+	assert.True(t, errors.As(nodes[2].SetExternalID("n0"), &derr))
+	assert.Equal(t, "", nodes[2].GetExternalID())
+	assert.Equal(t, nodes[0], g.GetNodeByExternalID("n0"))
+	assert.Equal(t, "n1", nodes[1].GetExternalID())
+
+	// Rename and remove
+	assert.Nil(t, nodes[0].SetExternalID("first"))
+	assert.Nil(t, g.GetNodeByExternalID("n0"))
+	assert.Equal(t, nodes[0], g.GetNodeByExternalID("first"))
+	assert.Nil(t, nodes[0].SetExternalID(""))
+	assert.Nil(t, g.GetNodeByExternalID("first"))
+
+	// Edges have their own namespace
+	edge := nodes[0].GetEdges(OutgoingEdge)
+	edge.Next()
+	assert.Nil(t, edge.Edge().SetExternalID("n1"))
+	assert.Equal(t, edge.Edge(), g.GetEdgeByExternalID("n1"))
+	assert.Equal(t, nodes[1], g.GetNodeByExternalID("n1"))
+
+	buf := bytes.Buffer{}
+	if err := (BinarySnapshot{}).Encode(g, &buf); err != nil {
+		t.Fatal(err)
+	}
+	target, err := BinarySnapshot{}.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, nodes[1].GetID(), target.GetNodeByExternalID("n1").GetID())
+	assert.Equal(t, edge.Edge().GetID(), target.GetEdgeByExternalID("n1").GetID())
+
+	// Removing the elements removes their external IDs
+	nodes[1].DetachAndRemove()
+	assert.Nil(t, g.GetNodeByExternalID("n1"))
+	assert.Nil(t, g.GetEdgeByExternalID("n1"))
+	assert.Nil(t, nodes[2].SetExternalID("n1"))
+}
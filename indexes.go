@@ -17,7 +17,8 @@ package lpg
 import (
 	"errors"
 	"fmt"
-	"strconv"
+	"github.com/kamstrup/intmap"
+	"strings"
 )
 
 type Item interface {
@@ -29,8 +30,19 @@ type index[V ordered, I Item] interface {
 	remove(value V, id int)
 	find(value V) Iterator
 	valueItr() Iterator
+	indexType() IndexType
+	// scan returns the items with values in the range, ordered by
+	// value. Only B-tree indexes support scans.
+	scan(r valueRange[V], descending bool) (Iterator, error)
+	// forEach calls f for all the value-item pairs of the index until
+	// f returns false
+	forEach(f func(value V, item I) bool)
 }
 
+// ErrUnorderedIndex is returned when a range, prefix, or ordered scan
+// is requested from a hash index
+var ErrUnorderedIndex = errors.New("hash index does not support range, prefix, or ordered scans")
+
 type IndexType int
 
 const (
@@ -39,13 +51,24 @@ const (
 )
 
 type graphIndex struct {
-	nodesByLabel     NodeMap
-	nodesByContext   index[string, *Node]
-	edgesByLabel     index[string, *Edge]
-	edgesFromContext index[string, *Edge]
-	edgesToContext   index[string, *Edge]
-	nodeProperties   map[string]index[string, *Node]
-	edgeProperties   map[string]index[string, *Edge]
+	nodesByLabel   NodeMap
+	nodesByContext index[string, *Node]
+	edgesByLabel   index[string, *Edge]
+	edgesByContext index[string, *Edge]
+	// edgesFromContext and edgesToContext index the edges of the
+	// source and target nodes by edge context
+	edgesFromContext edgeContextIndex
+	edgesToContext   edgeContextIndex
+	nodeProperties   map[string]propertyIndex[*Node]
+	edgeProperties   map[string]propertyIndex[*Edge]
+	nodeComposites   []*compositeIndex[*Node]
+	edgeComposites   []*compositeIndex[*Edge]
+	// uniqueConstraints are the unique constraints on nodes
+	uniqueConstraints []*uniqueConstraint
+	nodesByID         *intmap.Map[int, *Node]
+	edgesByID         *intmap.Map[int, *Edge]
+	nodeExternalIDs   externalIDs[*Node]
+	edgeExternalIDs   externalIDs[*Edge]
 }
 
 func newGraphIndex() graphIndex {
@@ -53,55 +76,166 @@ func newGraphIndex() graphIndex {
 		nodesByLabel:     *NewNodeMap(),
 		edgesByLabel:     &setTree[string, *Edge]{},
 		nodesByContext:   &setTree[string, *Node]{},
-		edgesFromContext: &setTree[string, *Edge]{},
-		edgesToContext:   &setTree[string, *Edge]{},
-		nodeProperties:   make(map[string]index[string, *Node]),
-		edgeProperties:   make(map[string]index[string, *Edge]),
+		edgesByContext:   &setTree[string, *Edge]{},
+		edgesFromContext: newEdgeContextIndex(),
+		edgesToContext:   newEdgeContextIndex(),
+		nodeProperties:   make(map[string]propertyIndex[*Node]),
+		edgeProperties:   make(map[string]propertyIndex[*Edge]),
+		nodesByID:        intmap.New[int, *Node](64),
+		edgesByID:        intmap.New[int, *Edge](64),
+	}
+}
+
+func newPropertyIndex[I Item](it IndexType, kind ValueKind) propertyIndex[I] {
+	if it == BtreeIndex {
+		return propertyIndex[I]{index: &setTree[string, I]{}, kind: kind, other: make(map[int]fallbackItem[I])}
 	}
+	return propertyIndex[I]{index: &hashIndex[string, I]{}, kind: kind, other: make(map[int]fallbackItem[I])}
 }
 
-// NodePropertyIndex sets up an index for the given node property (only support String properties
+// NodePropertyIndex sets up an index for the given node property. The
+// index keeps the string representation of property values.
 func (g *graphIndex) NodePropertyIndex(propertyName string, graph *Graph, it IndexType) {
-	_, exists := g.nodeProperties[propertyName]
-	if exists {
+	if _, exists := g.nodeProperties[propertyName]; exists {
 		return
 	}
-	var ix index[string, *Node]
-	if it == BtreeIndex {
-		ix = &setTree[string, *Node]{}
-	} else {
-		ix = &hashIndex[string, *Node]{}
+	g.TypedNodePropertyIndex(propertyName, graph, it, AnyValue)
+}
+
+// TypedNodePropertyIndex sets up an index for the given node property
+// that accepts only values of the given kind. If a node already has a
+// value of another kind for the property, returns ErrValueKind and
+// the index is not created. If the property is already indexed with
+// a different kind, returns an error.
+func (g *graphIndex) TypedNodePropertyIndex(propertyName string, graph *Graph, it IndexType, kind ValueKind) error {
+	if ix, exists := g.nodeProperties[propertyName]; exists {
+		if ix.kind != kind {
+			return fmt.Errorf("node property %s is already indexed as %s", propertyName, ix.kind)
+		}
+		return nil
 	}
-	g.nodeProperties[propertyName] = ix
-	// Reindex
+	ix := newPropertyIndex[*Node](it, kind)
+	type entry struct {
+		key  string
+		node *Node
+	}
+	entries := make([]entry, 0)
 	for nodes := graph.GetNodes(); nodes.Next(); {
 		node := nodes.Node()
 		value, ok := node.properties[propertyName]
-		val := value.(string)
-		if ok {
-			ix.add(val, node.id, node)
+		if !ok {
+			continue
+		}
+		key, err := ix.key(propertyName, value)
+		if err != nil {
+			return err
 		}
+		entries = append(entries, entry{key: key, node: node})
 	}
+	for _, e := range entries {
+		ix.add(e.key, e.node.id, e.node)
+	}
+	g.nodeProperties[propertyName] = ix
+	return nil
 }
 
-func (g *graphIndex) isNodePropertyIndexed(propertyName string) index[string, *Node] {
-	return g.nodeProperties[propertyName]
+func (g *graphIndex) isNodePropertyIndexed(propertyName string) *propertyIndex[*Node] {
+	ix, ok := g.nodeProperties[propertyName]
+	if !ok {
+		return nil
+	}
+	return &ix
 }
 
-func (g *graphIndex) isEdgePropertyIndexed(propertyName string) index[string, *Edge] {
-	return g.edgeProperties[propertyName]
+func (g *graphIndex) isEdgePropertyIndexed(propertyName string) *propertyIndex[*Edge] {
+	ix, ok := g.edgeProperties[propertyName]
+	if !ok {
+		return nil
+	}
+	return &ix
 }
 
 // GetIteratorForNodeProperty returns an iterator for the given
-// key/value, and the max size of the resultset. If no index found,
-// returns nil,-1
-func (g *graphIndex) GetIteratorForNodeProperty(key string, value string) (NodeIterator, error) {
+// key/value. If no index found, returns nil,err
+func (g *graphIndex) GetIteratorForNodeProperty(key string, value interface{}) (NodeIterator, error) {
 	index, found := g.nodeProperties[key]
 	if !found {
 		return nil, errors.New(fmt.Sprintf("no index found for key %s", key))
 	}
-	itr := index.find(value)
-	return nodeIterator{itr}, nil
+	return nodeIterator{index.findValue(value)}, nil
+}
+
+// rangeSpec describes a scan of a property index using property
+// values. It is converted to index keys based on the kind of the
+// index.
+type rangeSpec struct {
+	// lo and hi are the bounds. nil is unbounded
+	lo, hi    interface{}
+	inclusive bool
+	// If prefix is non-nil, scans the string values starting with
+	// prefix
+	prefix *string
+}
+
+// valueRange returns the index key range for the scan
+func (p propertyIndex[I]) valueRange(key string, spec rangeSpec) (valueRange[string], error) {
+	if spec.prefix != nil {
+		if p.kind != AnyValue && p.kind != StringValue {
+			return valueRange[string]{}, fmt.Errorf("key %s: prefix scan on %s index", key, p.kind)
+		}
+		return prefixRange(*spec.prefix), nil
+	}
+	r := valueRange[string]{loInclusive: spec.inclusive, hiInclusive: spec.inclusive}
+	if spec.lo != nil {
+		v, err := p.key(key, spec.lo)
+		if err != nil {
+			return r, err
+		}
+		r.lo = &v
+	}
+	if spec.hi != nil {
+		v, err := p.key(key, spec.hi)
+		if err != nil {
+			return r, err
+		}
+		r.hi = &v
+	}
+	return r, nil
+}
+
+// prefixRange returns the index value range for values starting with
+// prefix. The upper bound lets descending scans start after the last
+// value with the prefix.
+func prefixRange(prefix string) valueRange[string] {
+	r := valueRange[string]{
+		lo:          &prefix,
+		loInclusive: true,
+		while:       func(s string) bool { return strings.HasPrefix(s, prefix) },
+	}
+	if hi, ok := prefixUpperBound(prefix); ok {
+		r.hi = &hi
+	}
+	return r
+}
+
+// prefixUpperBound returns the smallest string greater than all
+// strings starting with prefix. Returns false if there is no such
+// string, that is, if prefix is empty or all 0xff bytes.
+func prefixUpperBound(prefix string) (string, bool) {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			return prefix[:i] + string([]byte{prefix[i] + 1}), true
+		}
+	}
+	return "", false
+}
+
+func scanIndex[I Item](indexes map[string]propertyIndex[I], key string, spec rangeSpec, descending bool) (Iterator, error) {
+	index, found := indexes[key]
+	if !found {
+		return nil, fmt.Errorf("no index found for key %s", key)
+	}
+	return index.scanValues(key, spec, descending)
 }
 
 // NodesWithProperty returns an iterator that will go through the
@@ -124,7 +258,38 @@ func (g *graphIndex) EdgesWithProperty(key string) EdgeIterator {
 	return edgeIterator{index.valueItr()}
 }
 
+// checkNodeProperties checks if the property values match the kinds
+// of their indexes
+func (g *graphIndex) checkNodeProperties(props map[string]interface{}) error {
+	for k, v := range props {
+		index, found := g.nodeProperties[k]
+		if !found {
+			continue
+		}
+		if _, err := index.key(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkEdgeProperties checks if the property values match the kinds
+// of their indexes
+func (g *graphIndex) checkEdgeProperties(props map[string]interface{}) error {
+	for k, v := range props {
+		index, found := g.edgeProperties[k]
+		if !found {
+			continue
+		}
+		if _, err := index.key(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (g *graphIndex) addNodeToIndex(node *Node) {
+	g.nodesByID.Put(node.id, node)
 	g.nodesByLabel.Add(node)
 	for context := range node.contexts.Range() {
 		g.nodesByContext.add(context, node.id, node)
@@ -135,12 +300,15 @@ func (g *graphIndex) addNodeToIndex(node *Node) {
 		if !found {
 			continue
 		}
-		val := v.(string)
-		index.add(val, node.id, node)
+		index.addValue(v, node.id, node)
 	}
+	addToComposites(g.nodeComposites, node.properties, node.id, node, "")
+	g.addUnique(node, "")
 }
 
 func (g *graphIndex) removeNodeFromIndex(node *Node) {
+	g.nodesByID.Del(node.id)
+	g.nodeExternalIDs.remove(node.id)
 	g.nodesByLabel.Remove(node)
 	for context := range node.contexts.Range() {
 		g.nodesByContext.remove(context, node.id)
@@ -151,39 +319,64 @@ func (g *graphIndex) removeNodeFromIndex(node *Node) {
 		if !found {
 			continue
 		}
-		val := v.(string)
-		index.remove(val, node.id)
+		index.removeValue(v, node.id)
 	}
+	removeFromComposites(g.nodeComposites, node.properties, node.id, "")
+	g.removeUnique(node, "")
 }
 
-// EdgePropertyIndex sets up an index for the given edge property
+// EdgePropertyIndex sets up an index for the given edge property. The
+// index keeps the string representation of property values.
 func (g *graphIndex) EdgePropertyIndex(propertyName string, graph *Graph, it IndexType) {
-	_, exists := g.edgeProperties[propertyName]
-	if exists {
+	if _, exists := g.edgeProperties[propertyName]; exists {
 		return
 	}
-	var ix index[string, *Edge]
-	if it == BtreeIndex {
-		ix = &setTree[string, *Edge]{}
-	} else {
-		ix = &hashIndex[string, *Edge]{}
+	g.TypedEdgePropertyIndex(propertyName, graph, it, AnyValue)
+}
+
+// TypedEdgePropertyIndex sets up an index for the given edge property
+// that accepts only values of the given kind. If an edge already has
+// a value of another kind for the property, returns ErrValueKind and
+// the index is not created. If the property is already indexed with
+// a different kind, returns an error.
+func (g *graphIndex) TypedEdgePropertyIndex(propertyName string, graph *Graph, it IndexType, kind ValueKind) error {
+	if ix, exists := g.edgeProperties[propertyName]; exists {
+		if ix.kind != kind {
+			return fmt.Errorf("edge property %s is already indexed as %s", propertyName, ix.kind)
+		}
+		return nil
 	}
-	g.edgeProperties[propertyName] = ix
-	// Reindex
+	ix := newPropertyIndex[*Edge](it, kind)
+	type entry struct {
+		key  string
+		edge *Edge
+	}
+	entries := make([]entry, 0)
 	for edges := graph.GetEdges(); edges.Next(); {
 		edge := edges.Edge()
 		value, ok := edge.properties[propertyName]
-		val := value.(string)
-		if ok {
-			ix.add(val, edge.id, edge)
+		if !ok {
+			continue
+		}
+		key, err := ix.key(propertyName, value)
+		if err != nil {
+			return err
 		}
+		entries = append(entries, entry{key: key, edge: edge})
 	}
+	for _, e := range entries {
+		ix.add(e.key, e.edge.id, e.edge)
+	}
+	g.edgeProperties[propertyName] = ix
+	return nil
 }
 
 func (g *graphIndex) addEdgeToIndex(edge *Edge) {
+	g.edgesByID.Put(edge.id, edge)
 	for context := range edge.contexts.Range() {
-		g.edgesFromContext.add(strconv.Itoa(edge.from.id)+context, edge.id, edge)
-		g.edgesToContext.add(strconv.Itoa(edge.to.id)+context, edge.id, edge)
+		g.edgesByContext.add(context, edge.id, edge)
+		g.edgesFromContext.add(edge.from.id, context, edge)
+		g.edgesToContext.add(edge.to.id, context, edge)
 	}
 	g.edgesByLabel.add(edge.label, edge.id, edge)
 	for k, v := range edge.properties {
@@ -191,15 +384,18 @@ func (g *graphIndex) addEdgeToIndex(edge *Edge) {
 		if !found {
 			continue
 		}
-		val := v.(string)
-		index.add(val, edge.id, edge)
+		index.addValue(v, edge.id, edge)
 	}
+	addToComposites(g.edgeComposites, edge.properties, edge.id, edge, "")
 }
 
 func (g *graphIndex) removeEdgeFromIndex(edge *Edge) {
+	g.edgesByID.Del(edge.id)
+	g.edgeExternalIDs.remove(edge.id)
 	for context := range edge.contexts.Range() {
-		g.edgesFromContext.remove(strconv.Itoa(edge.from.id)+context, edge.id)
-		g.edgesToContext.remove(strconv.Itoa(edge.to.id)+context, edge.id)
+		g.edgesByContext.remove(context, edge.id)
+		g.edgesFromContext.remove(edge.from.id, context, edge.id)
+		g.edgesToContext.remove(edge.to.id, context, edge.id)
 	}
 
 	g.edgesByLabel.remove(edge.label, edge.id)
@@ -208,21 +404,19 @@ func (g *graphIndex) removeEdgeFromIndex(edge *Edge) {
 		if !found {
 			continue
 		}
-		val := v.(string)
-		index.remove(val, edge.id)
+		index.removeValue(v, edge.id)
 	}
+	removeFromComposites(g.edgeComposites, edge.properties, edge.id, "")
 }
 
 // GetIteratorForEdgeProperty returns an iterator for the given
-// key/value, and the max size of the resultset. If no index found,
-// returns nil,err
-func (g *graphIndex) GetIteratorForEdgeProperty(key string, value string) (EdgeIterator, error) {
+// key/value. If no index found, returns nil,err
+func (g *graphIndex) GetIteratorForEdgeProperty(key string, value interface{}) (EdgeIterator, error) {
 	index, found := g.edgeProperties[key]
 	if !found {
 		return nil, errors.New(fmt.Sprintf("no index found for key %s", key))
 	}
-	itr := index.find(value)
-	return edgeIterator{itr}, nil
+	return edgeIterator{index.findValue(value)}, nil
 }
 
 //
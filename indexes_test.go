@@ -15,8 +15,12 @@
 package lpg
 
 import (
+	"errors"
 	"fmt"
+	"github.com/stretchr/testify/assert"
+	"math"
 	"testing"
+	"time"
 )
 
 func TestBtreeNodeIndex(t *testing.T) {
@@ -69,3 +73,332 @@ func TestHashNodeIndex(t *testing.T) {
 		}
 	}
 }
+
+func nodePropertyValues(t *testing.T, itr NodeIterator, err error, key string) []string {
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret := make([]string, 0)
+	for itr.Next() {
+		v, _ := itr.Node().GetProperty(key)
+		ret = append(ret, v.(string))
+	}
+	return ret
+}
+
+func TestNodeIndexRange(t *testing.T) {
+	g := NewGraph()
+	g.AddNodePropertyIndex("index", BtreeIndex)
+	for _, v := range []string{"b", "a", "ab", "c", "abc", "d", "b"} {
+		g.NewNode(nil, map[string]interface{}{"index": v}, nil)
+	}
+	itr, err := g.GetNodesWithPropertyRange("index", "ab", "c", true)
+	assert.Equal(t, []string{"ab", "abc", "b", "b", "c"}, nodePropertyValues(t, itr, err, "index"))
+	itr, err = g.GetNodesWithPropertyRange("index", "ab", "c", false)
+	assert.Equal(t, 3, itr.MaxSize())
+	assert.Equal(t, []string{"abc", "b", "b"}, nodePropertyValues(t, itr, err, "index"))
+	itr, err = g.GetNodesWithPropertyRange("index", nil, "ab", true)
+	assert.Equal(t, []string{"a", "ab"}, nodePropertyValues(t, itr, err, "index"))
+	itr, err = g.GetNodesWithPropertyRange("index", "c", nil, false)
+	assert.Equal(t, []string{"d"}, nodePropertyValues(t, itr, err, "index"))
+	itr, err = g.GetNodesWithPropertyPrefix("index", "ab")
+	assert.Equal(t, []string{"ab", "abc"}, nodePropertyValues(t, itr, err, "index"))
+	itr, err = g.GetNodesOrderedByProperty("index", true)
+	assert.Equal(t, []string{"d", "c", "b", "b", "abc", "ab", "a"}, nodePropertyValues(t, itr, err, "index"))
+
+	g.AddNodePropertyIndex("hash", HashIndex)
+	_, err = g.GetNodesWithPropertyRange("hash", "a", "b", true)
+	assert.True(t, errors.Is(err, ErrUnorderedIndex))
+	_, err = g.GetNodesWithPropertyPrefix("none", "a")
+	assert.Error(t, err)
+}
+
+func TestDescendingPrefixScan(t *testing.T) {
+	var tree setTree[string, *Node]
+	values := []string{"a", "ab", "abc", "ab\xff", "ab\xff\xff", "b", "\xff", "\xff\xff", "\xff\xffa"}
+	for i, v := range values {
+		tree.add(v, i, &Node{id: i})
+	}
+	scan := func(prefix string, descending bool) []string {
+		itr, err := tree.scan(prefixRange(prefix), descending)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ret := make([]string, 0)
+		for itr.Next() {
+			ret = append(ret, values[itr.Value().(*Node).id])
+		}
+		return ret
+	}
+	assert.Equal(t, []string{"ab", "abc", "ab\xff", "ab\xff\xff"}, scan("ab", false))
+	assert.Equal(t, []string{"ab\xff\xff", "ab\xff", "abc", "ab"}, scan("ab", true))
+	assert.Equal(t, []string{"ab\xff\xff", "ab\xff"}, scan("ab\xff", true))
+	assert.Equal(t, []string{"\xff\xffa", "\xff\xff"}, scan("\xff\xff", true))
+	assert.Equal(t, []string{"b"}, scan("b", true))
+	assert.Empty(t, scan("c", true))
+	assert.Equal(t, len(values), len(scan("", true)))
+}
+
+func TestEdgeIndexRange(t *testing.T) {
+	g := NewGraph()
+	g.AddEdgePropertyIndex("index", BtreeIndex)
+	n := g.NewNode(nil, nil, nil)
+	for _, v := range []string{"x1", "x3", "y", "x2"} {
+		g.NewEdge(n, n, "l", map[string]interface{}{"index": v}, nil)
+	}
+	values := func(itr EdgeIterator, err error) []string {
+		if err != nil {
+			t.Fatal(err)
+		}
+		ret := make([]string, 0)
+		for itr.Next() {
+			v, _ := itr.Edge().GetProperty("index")
+			ret = append(ret, v.(string))
+		}
+		return ret
+	}
+	assert.Equal(t, []string{"x2", "x3"}, values(g.GetEdgesWithPropertyRange("index", "x2", "x3", true)))
+	assert.Equal(t, []string{"x1", "x2", "x3"}, values(g.GetEdgesWithPropertyPrefix("index", "x")))
+	assert.Equal(t, []string{"x1", "x2", "x3", "y"}, values(g.GetEdgesOrderedByProperty("index", false)))
+	g.AddEdgePropertyIndex("hash", HashIndex)
+	_, err := g.GetEdgesOrderedByProperty("hash", false)
+	assert.True(t, errors.Is(err, ErrUnorderedIndex))
+}
+
+func TestTypedNodeIndex(t *testing.T) {
+	g := NewGraph()
+	if err := g.AddTypedNodePropertyIndex("n", BtreeIndex, IntValue); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []interface{}{10, -3, 2, int64(100), uint8(7), 0} {
+		g.NewNode(nil, map[string]interface{}{"n": v}, nil)
+	}
+	ints := func(itr NodeIterator, err error) []int64 {
+		if err != nil {
+			t.Fatal(err)
+		}
+		ret := make([]int64, 0)
+		for itr.Next() {
+			v, _ := itr.Node().GetProperty("n")
+			i, _ := intValue(v)
+			ret = append(ret, i)
+		}
+		return ret
+	}
+	assert.Equal(t, []int64{-3, 0, 2, 7, 10, 100}, ints(g.GetNodesOrderedByProperty("n", false)))
+	assert.Equal(t, []int64{2, 7, 10}, ints(g.GetNodesWithPropertyRange("n", 2, 10, true)))
+	assert.Equal(t, []int64{7}, ints(g.GetNodesWithPropertyRange("n", 2.0, int64(10), false)))
+	assert.Equal(t, []int64{100}, ints(g.FindNodes(nil, map[string]interface{}{"n": 100.0})))
+	_, err := g.GetNodesWithPropertyRange("n", "2", nil, true)
+	assert.True(t, errors.As(err, &ErrValueKind{}))
+	_, err = g.GetNodesWithPropertyPrefix("n", "1")
+	assert.Error(t, err)
+
+	// Values of other kinds are rejected, and the graph is not changed
+	node := g.NewNode(nil, nil, nil)
+	var kerr ErrValueKind
+	assert.True(t, errors.As(node.TrySetProperty("n", "1"), &kerr))
+	assert.Equal(t, "n", kerr.Key)
+	assert.Equal(t, IntValue, kerr.Kind)
+	_, exists := node.GetProperty("n")
+	assert.False(t, exists)
+	assert.Error(t, node.TrySetProperty("n", 1.5))
+	assert.Nil(t, node.TrySetProperty("n", 5))
+	assert.Nil(t, node.TrySetProperty("n", 6))
+	assert.Equal(t, []int64{6}, ints(g.GetNodesWithPropertyRange("n", 5, 6, true)))
+	_, err = g.TryNewNode(nil, map[string]interface{}{"n": true}, nil)
+	assert.True(t, errors.As(err, &kerr))
+	assert.Equal(t, 7, g.NumNodes())
+
+	// Without Try, values of other kinds are stored, and the index
+	// finds them as a scan of the graph would
+	other := g.NewNode(nil, map[string]interface{}{"n": "x"}, nil)
+	v, _ := other.GetProperty("n")
+	assert.Equal(t, "x", v)
+	node.SetProperty("n", 2.5)
+	values := func(itr NodeIterator, err error) []interface{} {
+		if err != nil {
+			t.Fatal(err)
+		}
+		ret := make([]interface{}, 0)
+		for itr.Next() {
+			v, _ := itr.Node().GetProperty("n")
+			ret = append(ret, v)
+		}
+		return ret
+	}
+	assert.Equal(t, []interface{}{-3, 0, 2, 2.5, uint8(7), 10, int64(100), "x"}, values(g.GetNodesOrderedByProperty("n", false)))
+	assert.Equal(t, []interface{}{"x", int64(100), 10, uint8(7), 2.5, 2, 0, -3}, values(g.GetNodesOrderedByProperty("n", true)))
+	assert.Equal(t, []interface{}{2, 2.5, uint8(7)}, values(g.GetNodesWithPropertyRange("n", 2, 7, true)))
+	assert.Equal(t, []interface{}{"x"}, values(g.FindNodes(nil, map[string]interface{}{"n": "x"})))
+	assert.Equal(t, []interface{}{2.5}, values(g.FindNodes(nil, map[string]interface{}{"n": 2.5})))
+	assert.Equal(t, 8, len(NodeSlice(g.GetNodesWithProperty("n"))))
+	assert.Nil(t, g.Verify())
+	snap := g.Snapshot()
+	assert.Equal(t, []interface{}{"x", int64(100), 10, uint8(7), 2.5, 2, 0, -3}, values(snap.GetNodesOrderedByProperty("n", true)))
+	snap.Release()
+	other.RemoveProperty("n")
+	node.SetProperty("n", 6)
+	assert.Empty(t, g.index.nodeProperties["n"].other)
+	assert.Equal(t, []int64{6}, ints(g.GetNodesWithPropertyRange("n", 5, 6, true)))
+	assert.Nil(t, g.Verify())
+
+	// Existing values are checked when the index is added
+	g.NewNode(nil, map[string]interface{}{"f": "x"}, nil)
+	assert.Error(t, g.AddTypedNodePropertyIndex("f", BtreeIndex, FloatValue))
+	assert.Nil(t, g.index.isNodePropertyIndexed("f"))
+	assert.Error(t, g.AddTypedNodePropertyIndex("n", BtreeIndex, FloatValue))
+}
+
+func TestTypedIndexOrder(t *testing.T) {
+	g := NewGraph()
+	g.AddTypedNodePropertyIndex("f", BtreeIndex, FloatValue)
+	g.AddTypedNodePropertyIndex("t", BtreeIndex, TimeValue)
+	g.AddTypedNodePropertyIndex("b", HashIndex, BoolValue)
+	base := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, f := range []float64{2.5, -1, 10, -0.5, 3} {
+		g.NewNode(nil, map[string]interface{}{
+			"f": f,
+			"t": base.Add(time.Duration(f * float64(time.Hour))),
+			"b": i%2 == 0,
+		}, nil)
+	}
+	g.NewNode(nil, map[string]interface{}{"f": 1}, nil)
+	assert.Error(t, g.NewNode(nil, nil, nil).TrySetProperty("f", math.NaN()))
+
+	floats := func(itr NodeIterator, err error) []float64 {
+		if err != nil {
+			t.Fatal(err)
+		}
+		ret := make([]float64, 0)
+		for itr.Next() {
+			v, _ := itr.Node().GetProperty("f")
+			f, _ := floatValue(v)
+			ret = append(ret, f)
+		}
+		return ret
+	}
+	assert.Equal(t, []float64{-1, -0.5, 1, 2.5, 3, 10}, floats(g.GetNodesOrderedByProperty("f", false)))
+	assert.Equal(t, []float64{-0.5, 1, 2.5}, floats(g.GetNodesWithPropertyRange("f", -0.5, 2.5, true)))
+	assert.Equal(t, []float64{10, 3, 2.5, -0.5, -1}, floats(g.GetNodesOrderedByProperty("t", true)))
+	assert.Equal(t, []float64{-0.5, 2.5}, floats(g.GetNodesWithPropertyRange("t", base.Add(-time.Hour), base.Add(3*time.Hour), false)))
+	itr, err := g.FindNodes(nil, map[string]interface{}{"b": true})
+	assert.Equal(t, 3, len(floats(itr, err)))
+
+	// Predicates use the typed index for ranges
+	pat := Pattern{{Where: PropertyCompare{Key: "f", Op: OpGt, Value: 2}}}
+	acc, err := pat.FindPaths(g, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 3, len(acc.Paths))
+	itr2, size := indexCandidates(PropertyCompare{Key: "f", Op: OpGt, Value: 2}, g.index.nodeProperties)
+	assert.NotNil(t, itr2)
+	assert.Equal(t, 3, size)
+}
+
+func TestComparePropertyValueNumeric(t *testing.T) {
+	assert.Equal(t, 0, ComparePropertyValue(1, 1.0))
+	assert.Equal(t, -1, ComparePropertyValue(int64(1), 1.5))
+	assert.Equal(t, 1, ComparePropertyValue(uint8(3), int32(2)))
+	assert.Equal(t, -1, ComparePropertyValue(false, true))
+	assert.Equal(t, 1, ComparePropertyValue(time.Unix(2, 0), time.Unix(1, 0)))
+	assert.Panics(t, func() { ComparePropertyValue(1, "1") })
+}
+
+func TestCompositeIndex(t *testing.T) {
+	g := NewGraph()
+	for i := 0; i < 30; i++ {
+		g.NewNode([]string{"doc"}, map[string]interface{}{
+			"tenant":  fmt.Sprint(i % 3),
+			"id":      fmt.Sprint(i % 10),
+			"version": i,
+		}, nil)
+	}
+	assert.Error(t, g.AddCompositeNodeIndex([]string{"tenant"}, BtreeIndex))
+	assert.Error(t, g.AddCompositeNodeIndex([]string{"tenant", "tenant"}, BtreeIndex))
+	if err := g.AddCompositeNodeIndex([]string{"tenant", "id"}, BtreeIndex); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.AddCompositeNodeIndex([]string{"id", "version"}, HashIndex); err != nil {
+		t.Fatal(err)
+	}
+
+	// Neither property has its own index
+	itr, err := g.FindNodes(nil, map[string]interface{}{"tenant": "1", "id": "4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, itr.MaxSize())
+	assert.Equal(t, 1, len(NodeSlice(itr)))
+
+	// Leftmost prefix of the B-tree index
+	itr, err = g.FindNodes(nil, map[string]interface{}{"tenant": "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 10, itr.MaxSize())
+	assert.Equal(t, 10, len(NodeSlice(itr)))
+
+	// Hash index needs all keys
+	_, err = g.FindNodes(nil, map[string]interface{}{"version": 5})
+	assert.Error(t, err)
+	itr, err = g.FindNodes(nil, map[string]interface{}{"id": "5", "version": 15})
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := NodeSlice(itr)
+	assert.Equal(t, 1, len(found))
+
+	// Index is updated when properties change
+	node := found[0]
+	node.SetProperty("id", "x")
+	itr, _ = g.FindNodes(nil, map[string]interface{}{"id": "x", "version": 15})
+	assert.Equal(t, []*Node{node}, NodeSlice(itr))
+	itr, _ = g.FindNodes(nil, map[string]interface{}{"tenant": "0", "id": "x"})
+	assert.Equal(t, []*Node{node}, NodeSlice(itr))
+	node.RemoveProperty("tenant")
+	itr, _ = g.FindNodes(nil, map[string]interface{}{"tenant": "0", "id": "x"})
+	assert.Equal(t, 0, itr.MaxSize())
+	node.DetachAndRemove()
+	itr, _ = g.FindNodes(nil, map[string]interface{}{"id": "x", "version": 15})
+	assert.Equal(t, 0, itr.MaxSize())
+
+	// Pattern searches use the composite index
+	item := PatternItem{Properties: map[string]interface{}{"tenant": "1", "id": "7"}}
+	_, size := item.estimateNodeSize(g, nil)
+	assert.Equal(t, 1, size)
+}
+
+func TestCompositeEdgeIndex(t *testing.T) {
+	g := NewGraph()
+	n := g.NewNode(nil, nil, nil)
+	for i := 0; i < 10; i++ {
+		g.NewEdge(n, n, "rel", map[string]interface{}{"type": "t\x00" + fmt.Sprint(i%2), "version": i}, nil)
+	}
+	g.NewEdge(n, n, "rel", map[string]interface{}{"type": "t"}, nil)
+	if err := g.AddCompositeEdgeIndex([]string{"type", "version"}, BtreeIndex); err != nil {
+		t.Fatal(err)
+	}
+	itr, err := g.FindEdges("", map[string]interface{}{"type": "t\x001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 5, itr.MaxSize())
+	assert.Equal(t, 5, len(EdgeSlice(itr)))
+	itr, err = g.FindEdges("rel", map[string]interface{}{"type": "t\x001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 5, len(EdgeSlice(itr)))
+	itr, err = g.FindEdges("", map[string]interface{}{"type": "t\x000", "version": 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(EdgeSlice(itr)))
+	itr, err = g.FindEdges("", map[string]interface{}{"type": "t"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 0, itr.MaxSize())
+}
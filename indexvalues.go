@@ -0,0 +1,478 @@
+// Copyright 2021 Cloud Privacy Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lpg
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ValueKind is the kind of property values a property index
+// accepts. The index keeps the values in the native order of their
+// kind, so range scans on numbers and timestamps use numeric ordering.
+type ValueKind int
+
+const (
+	// AnyValue indexes the string representation of values, so it
+	// accepts all values. Range scans compare the string
+	// representations.
+	AnyValue ValueKind = iota
+	// StringValue accepts only strings
+	StringValue
+	// IntValue accepts integers of any size. Floating point values
+	// are accepted if they have no fractional part.
+	IntValue
+	// FloatValue accepts integers and floating point values, and
+	// orders them numerically. NaN is not accepted.
+	FloatValue
+	// BoolValue accepts only booleans. false comes before true.
+	BoolValue
+	// TimeValue accepts only time.Time values
+	TimeValue
+)
+
+func (k ValueKind) String() string {
+	switch k {
+	case AnyValue:
+		return "any"
+	case StringValue:
+		return "string"
+	case IntValue:
+		return "int"
+	case FloatValue:
+		return "float"
+	case BoolValue:
+		return "bool"
+	case TimeValue:
+		return "time"
+	}
+	return fmt.Sprintf("ValueKind(%d)", int(k))
+}
+
+// ErrValueKind is returned when a property value does not match the
+// value kind of the index of the property
+type ErrValueKind struct {
+	Key   string
+	Kind  ValueKind
+	Value interface{}
+}
+
+func (e ErrValueKind) Error() string {
+	return fmt.Sprintf("property %s: value %v (%T) is not of indexed kind %s", e.Key, e.Value, e.Value, e.Kind)
+}
+
+// sortableInt returns an 8-byte key that sorts as the integer
+func sortableInt(v int64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(v)^(1<<63))
+	return buf[:]
+}
+
+// sortableFloat returns an 8-byte key that sorts as the float
+func sortableFloat(f float64) []byte {
+	if f == 0 {
+		// -0 and 0 are equal
+		f = 0
+	}
+	bits := math.Float64bits(f)
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], bits)
+	return buf[:]
+}
+
+// indexKey returns the index key for the value. The keys of a kind
+// sort in the same order as their values. Returns false if the value
+// is not of this kind.
+func (k ValueKind) indexKey(value interface{}) (string, bool) {
+	if k == AnyValue {
+		return fmt.Sprintf("%v", value), true
+	}
+	if n, ok := value.(WithNativeValue); ok {
+		value = n.GetNativeValue()
+	}
+	if value == nil {
+		return "", false
+	}
+	switch k {
+	case StringValue:
+		s, ok := value.(string)
+		return s, ok
+
+	case IntValue:
+		switch v := value.(type) {
+		case int:
+			return string(sortableInt(int64(v))), true
+		case int8:
+			return string(sortableInt(int64(v))), true
+		case int16:
+			return string(sortableInt(int64(v))), true
+		case int32:
+			return string(sortableInt(int64(v))), true
+		case int64:
+			return string(sortableInt(v)), true
+		case uint:
+			if uint64(v) <= math.MaxInt64 {
+				return string(sortableInt(int64(v))), true
+			}
+		case uint8:
+			return string(sortableInt(int64(v))), true
+		case uint16:
+			return string(sortableInt(int64(v))), true
+		case uint32:
+			return string(sortableInt(int64(v))), true
+		case uint64:
+			if v <= math.MaxInt64 {
+				return string(sortableInt(int64(v))), true
+			}
+		case float32:
+			if f := float64(v); f == math.Trunc(f) && math.Abs(f) < 1<<63 {
+				return string(sortableInt(int64(f))), true
+			}
+		case float64:
+			if v == math.Trunc(v) && math.Abs(v) < 1<<63 {
+				return string(sortableInt(int64(v))), true
+			}
+		}
+		return "", false
+
+	case FloatValue:
+		f, ok := floatValue(value)
+		if !ok || math.IsNaN(f) {
+			return "", false
+		}
+		return string(sortableFloat(f)), true
+
+	case BoolValue:
+		b, ok := value.(bool)
+		if !ok {
+			return "", false
+		}
+		if b {
+			return "\x01", true
+		}
+		return "\x00", true
+
+	case TimeValue:
+		t, ok := value.(time.Time)
+		if !ok {
+			return "", false
+		}
+		var nsec [4]byte
+		binary.BigEndian.PutUint32(nsec[:], uint32(t.Nanosecond()))
+		return string(sortableInt(t.Unix())) + string(nsec[:]), true
+	}
+	return "", false
+}
+
+// floatValue returns the numeric value as a float64
+func floatValue(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// intValue returns the value as an int64 if it is an integer type
+// that fits into int64
+func intValue(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint:
+		return int64(v), uint64(v) <= math.MaxInt64
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case uint64:
+		return int64(v), v <= math.MaxInt64
+	}
+	return 0, false
+}
+
+// propertyIndex is a property index, and the kind of values it
+// accepts
+type propertyIndex[I Item] struct {
+	index[string, I]
+	kind ValueKind
+	// other are the items whose values are not of kind by ID. Only
+	// the functions that do not check the kind store such values.
+	// Lookups and scans include them, so the index finds the same
+	// items as a scan of the graph. other is searched linearly.
+	other map[int]fallbackItem[I]
+}
+
+// fallbackItem is an item of a property index whose value is not of
+// the kind of the index
+type fallbackItem[I Item] struct {
+	id    int
+	value interface{}
+	item  I
+}
+
+// addValue adds the item with the property value to the index
+func (p propertyIndex[I]) addValue(value interface{}, id int, item I) {
+	if k, ok := p.kind.indexKey(value); ok {
+		p.add(k, id, item)
+		return
+	}
+	p.other[id] = fallbackItem[I]{id: id, value: value, item: item}
+}
+
+// removeValue removes the item with the property value from the
+// index
+func (p propertyIndex[I]) removeValue(value interface{}, id int) {
+	if k, ok := p.kind.indexKey(value); ok {
+		p.remove(k, id)
+		return
+	}
+	delete(p.other, id)
+}
+
+// fallback returns the items whose values are not of the kind of the
+// index selected by match in ID order. match can be nil to select
+// all.
+func (p propertyIndex[I]) fallback(match func(interface{}) bool) []fallbackItem[I] {
+	ret := make([]fallbackItem[I], 0)
+	for _, x := range p.other {
+		if match == nil || match(x.value) {
+			ret = append(ret, x)
+		}
+	}
+	slices.SortFunc(ret, func(a, b fallbackItem[I]) int { return a.id - b.id })
+	return ret
+}
+
+// withFallback returns an iterator over the items of itr followed by
+// the fallback items
+func withFallback[I Item](itr Iterator, items []fallbackItem[I]) Iterator {
+	if len(items) == 0 {
+		return itr
+	}
+	extra := make([]I, 0, len(items))
+	for _, x := range items {
+		extra = append(extra, x.item)
+	}
+	size := itr.MaxSize()
+	if size != -1 {
+		size += len(extra)
+	}
+	return withSize(MultiIterator(itr, newSliceIterator(extra)), size)
+}
+
+// findValue returns the items whose property value is equal to value
+func (p propertyIndex[I]) findValue(value interface{}) Iterator {
+	var itr Iterator = emptyIterator{}
+	if k, ok := p.kind.indexKey(value); ok {
+		itr = p.find(k)
+	}
+	if len(p.other) == 0 {
+		return itr
+	}
+	return withFallback(itr, p.fallback(func(v interface{}) bool {
+		cmp, ok := comparePredicateValues(v, value)
+		return ok && cmp == 0
+	}))
+}
+
+// valueItr returns all the items of the index
+func (p propertyIndex[I]) valueItr() Iterator {
+	return withFallback(p.index.valueItr(), p.fallback(nil))
+}
+
+// inRange returns true if the property value is in the scan range. r
+// is the index key range of spec. A value not of the kind of the
+// index is in the range if it is comparable to the bounds. All values
+// are in an unbounded range.
+func (p propertyIndex[I]) inRange(r valueRange[string], spec rangeSpec, value interface{}) bool {
+	if k, ok := p.kind.indexKey(value); ok {
+		return r.contains(k)
+	}
+	if spec.prefix != nil {
+		return false
+	}
+	if spec.lo != nil {
+		cmp, ok := comparePredicateValues(value, spec.lo)
+		if !ok || cmp < 0 || (cmp == 0 && !spec.inclusive) {
+			return false
+		}
+	}
+	if spec.hi != nil {
+		cmp, ok := comparePredicateValues(value, spec.hi)
+		if !ok || cmp > 0 || (cmp == 0 && !spec.inclusive) {
+			return false
+		}
+	}
+	return true
+}
+
+// compareValues orders property values for scans. Values of the kind
+// of the index are ordered by their index keys. Numbers not of the
+// kind of a numeric index are placed among them by value, and the
+// other values come after them.
+func (p propertyIndex[I]) compareValues(a, b interface{}) int {
+	ka, aok := p.kind.indexKey(a)
+	kb, bok := p.kind.indexKey(b)
+	if aok && bok {
+		return strings.Compare(ka, kb)
+	}
+	if ao, bo := aok || p.numeric(a), bok || p.numeric(b); ao != bo {
+		if ao {
+			return -1
+		}
+		return 1
+	} else if ao {
+		cmp, _ := comparePredicateValues(a, b)
+		return cmp
+	}
+	return strings.Compare(uniqueValueKey(a), uniqueValueKey(b))
+}
+
+// numeric returns true if the index is numeric, and the value is a
+// number that can be compared with the values of the index
+func (p propertyIndex[I]) numeric(value interface{}) bool {
+	if p.kind != IntValue && p.kind != FloatValue {
+		return false
+	}
+	if n, ok := value.(WithNativeValue); ok {
+		value = n.GetNativeValue()
+	}
+	f, ok := floatValue(value)
+	return ok && !math.IsNaN(f)
+}
+
+// scanValues returns the items whose values of the property are in
+// the scan range, in value order
+func (p propertyIndex[I]) scanValues(property string, spec rangeSpec, descending bool) (Iterator, error) {
+	r, err := p.valueRange(property, spec)
+	if err != nil {
+		return nil, err
+	}
+	itr, err := p.scan(r, descending)
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %w", property, err)
+	}
+	extra := p.fallback(func(v interface{}) bool { return p.inRange(r, spec, v) })
+	if len(extra) == 0 {
+		return itr, nil
+	}
+	compare := func(a, b interface{}) int {
+		if descending {
+			return p.compareValues(b, a)
+		}
+		return p.compareValues(a, b)
+	}
+	slices.SortStableFunc(extra, func(a, b fallbackItem[I]) int { return compare(a.value, b.value) })
+	return &fallbackScan[I]{itr: itr, extra: extra, property: property, compare: compare}, nil
+}
+
+// fallbackScan merges the fallback items into an ordered scan of a
+// property index
+type fallbackScan[I Item] struct {
+	itr      Iterator
+	extra    []fallbackItem[I]
+	property string
+	compare  func(a, b interface{}) int
+	// peeked is true if itr is at an item that is not returned yet
+	peeked  bool
+	hasNext bool
+	current interface{}
+}
+
+func (s *fallbackScan[I]) Next() bool {
+	if !s.peeked {
+		s.hasNext = s.itr.Next()
+		s.peeked = true
+	}
+	if len(s.extra) > 0 {
+		take := !s.hasNext
+		if !take {
+			value, _ := any(s.itr.Value()).(WithProperties).GetProperty(s.property)
+			take = s.compare(s.extra[0].value, value) < 0
+		}
+		if take {
+			s.current = s.extra[0].item
+			s.extra = s.extra[1:]
+			return true
+		}
+	}
+	if !s.hasNext {
+		s.current = nil
+		return false
+	}
+	s.current = s.itr.Value()
+	s.peeked = false
+	return true
+}
+
+func (s *fallbackScan[I]) Value() interface{} { return s.current }
+
+func (s *fallbackScan[I]) MaxSize() int {
+	if size := s.itr.MaxSize(); size != -1 {
+		return size + len(s.extra)
+	}
+	return -1
+}
+
+// key returns the index key for the value of the property
+func (p propertyIndex[I]) key(property string, value interface{}) (string, error) {
+	k, ok := p.kind.indexKey(value)
+	if !ok {
+		return "", ErrValueKind{Key: property, Kind: p.kind, Value: value}
+	}
+	return k, nil
+}
@@ -250,6 +250,27 @@ func (l *listIterator) Value() interface{} {
 
 func (l *listIterator) MaxSize() int { return l.size }
 
+// sliceIterator iterates the elements of a slice
+type sliceIterator[T any] struct {
+	items []T
+	i     int
+}
+
+func newSliceIterator[T any](items []T) *sliceIterator[T] {
+	return &sliceIterator[T]{items: items, i: -1}
+}
+
+func (s *sliceIterator[T]) Next() bool {
+	if s.i < len(s.items) {
+		s.i++
+	}
+	return s.i < len(s.items)
+}
+
+func (s *sliceIterator[T]) Value() interface{} { return s.items[s.i] }
+
+func (s *sliceIterator[T]) MaxSize() int { return len(s.items) }
+
 // nodesFromEdgesIterator iterates the nodes of an edge iterator
 type nodesFromEdgesIterator struct {
 	dir  EdgeDir
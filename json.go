@@ -0,0 +1,331 @@
+// Copyright 2021 Cloud Privacy Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lpg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// JSON marshals/unmarshals a graph to/from JSON. The JSON document
+// has the following layout:
+//
+//	{
+//	  "nodes": [
+//	    {
+//	      "n": 0,
+//	      "labels": [ "l1", ... ],
+//	      "contexts": [ "c1", ... ],
+//	      "properties": {
+//	        "key1": value,
+//	        ...
+//	      },
+//	      "edges": [
+//	        {
+//	          "to": 1,
+//	          "label": "edgeLabel",
+//	          "contexts": [ "c1", ... ],
+//	          "properties": { ... }
+//	        }
+//	      ]
+//	    },
+//	    ...
+//	  ],
+//	  "edges": [
+//	    {
+//	      "from": 0,
+//	      "to": 1,
+//	      "label": "edgeLabel",
+//	      "contexts": [ "c1", ... ],
+//	      "properties": { ... }
+//	    }
+//	  ]
+//	}
+//
+// The "n" field identifies the node, and all node references in edges
+// use these indexes. If EmbedEdges is set, outgoing edges of a node
+// are written under that node and only have a "to" field. Otherwise,
+// edges are written under the top-level "edges" array. Decode accepts
+// both layouts, including a mix of the two.
+type JSON struct {
+	// If true, edges are embedded in their source nodes
+	EmbedEdges bool
+
+	// PropertyMarshaler, if set, is used to encode property
+	// values. The node argument is true for node properties, false
+	// for edge properties. If it returns a nil value, the property is
+	// not written. If PropertyMarshaler is nil, json.Marshal is used.
+	PropertyMarshaler func(node bool, key string, value interface{}) (json.RawMessage, error)
+
+	// PropertyUnmarshaler, if set, is used to decode property
+	// values. The node argument is true for node properties, false
+	// for edge properties. If PropertyUnmarshaler is nil, the value is
+	// decoded using the default decoder, which decodes integral
+	// numbers as int.
+	PropertyUnmarshaler func(node bool, key string, value json.RawMessage) (interface{}, error)
+}
+
+// jsonNode is the JSON representation of a node
+type jsonNode struct {
+	N          int                        `json:"n"`
+	Labels     []string                   `json:"labels,omitempty"`
+	Contexts   []string                   `json:"contexts,omitempty"`
+	Properties map[string]json.RawMessage `json:"properties,omitempty"`
+	Edges      []jsonEdge                 `json:"edges,omitempty"`
+}
+
+// jsonEdge is the JSON representation of an edge. From is omitted
+// for edges embedded in nodes.
+type jsonEdge struct {
+	From       *int                       `json:"from,omitempty"`
+	To         int                        `json:"to"`
+	Label      string                     `json:"label,omitempty"`
+	Contexts   []string                   `json:"contexts,omitempty"`
+	Properties map[string]json.RawMessage `json:"properties,omitempty"`
+}
+
+type jsonGraph struct {
+	Nodes []jsonNode `json:"nodes"`
+	Edges []jsonEdge `json:"edges,omitempty"`
+}
+
+func (j JSON) marshalProperty(node bool, key string, value interface{}) (json.RawMessage, error) {
+	if j.PropertyMarshaler != nil {
+		return j.PropertyMarshaler(node, key, value)
+	}
+	if n, ok := value.(WithNativeValue); ok {
+		value = n.GetNativeValue()
+	}
+	return json.Marshal(value)
+}
+
+func (j JSON) unmarshalProperty(node bool, key string, value json.RawMessage) (interface{}, error) {
+	if j.PropertyUnmarshaler != nil {
+		return j.PropertyUnmarshaler(node, key, value)
+	}
+	return DefaultJSONPropertyUnmarshaler(value)
+}
+
+// DefaultJSONPropertyUnmarshaler decodes a JSON property value. JSON
+// numbers that fit in an int are decoded as int, and other numbers are
+// decoded as float64. Arrays are decoded as []interface{}, and objects
+// as map[string]interface{}.
+func DefaultJSONPropertyUnmarshaler(value json.RawMessage) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(value))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return convertJSONNumbers(v), nil
+}
+
+func convertJSONNumbers(v interface{}) interface{} {
+	switch t := v.(type) {
+	case json.Number:
+		if i, err := t.Int64(); err == nil && int64(int(i)) == i {
+			return int(i)
+		}
+		f, _ := t.Float64()
+		return f
+	case []interface{}:
+		for i := range t {
+			t[i] = convertJSONNumbers(t[i])
+		}
+	case map[string]interface{}:
+		for k, x := range t {
+			t[k] = convertJSONNumbers(x)
+		}
+	}
+	return v
+}
+
+func (j JSON) encodeProperties(node bool, p properties) (map[string]json.RawMessage, error) {
+	if len(p) == 0 {
+		return nil, nil
+	}
+	ret := make(map[string]json.RawMessage, len(p))
+	for k, v := range p {
+		data, err := j.marshalProperty(node, k, v)
+		if err != nil {
+			return nil, err
+		}
+		if data == nil {
+			continue
+		}
+		ret[k] = data
+	}
+	return ret, nil
+}
+
+func (j JSON) decodeProperties(node bool, in map[string]json.RawMessage) (properties, error) {
+	if len(in) == 0 {
+		return nil, nil
+	}
+	ret := make(properties, len(in))
+	for k, v := range in {
+		value, err := j.unmarshalProperty(node, k, v)
+		if err != nil {
+			return nil, fmt.Errorf("property %s: %w", k, err)
+		}
+		ret[k] = value
+	}
+	return ret, nil
+}
+
+// encodeNode returns the JSON representation of a node, without edges
+func (j JSON) encodeNode(node *Node, n int) (jsonNode, error) {
+	ret := jsonNode{
+		N: n,
+	}
+	if node.labels.Len() > 0 {
+		ret.Labels = node.labels.Slice()
+	}
+	if node.contexts.Len() > 0 {
+		ret.Contexts = node.contexts.Slice()
+	}
+	var err error
+	ret.Properties, err = j.encodeProperties(true, node.properties)
+	if err != nil {
+		return ret, err
+	}
+	return ret, nil
+}
+
+// encodeEdge returns the JSON representation of an edge between the
+// given node indexes. If from is nil, the edge is assumed to be
+// embedded in its source node.
+func (j JSON) encodeEdge(edge *Edge, from *int, to int) (jsonEdge, error) {
+	ret := jsonEdge{
+		From:  from,
+		To:    to,
+		Label: edge.label,
+	}
+	if edge.contexts.Len() > 0 {
+		ret.Contexts = edge.contexts.Slice()
+	}
+	var err error
+	ret.Properties, err = j.encodeProperties(false, edge.properties)
+	if err != nil {
+		return ret, err
+	}
+	return ret, nil
+}
+
+// Encode writes the graph as a JSON document
+func (j JSON) Encode(g *Graph, out io.Writer) error {
+	doc := jsonGraph{
+		Nodes: make([]jsonNode, 0, g.NumNodes()),
+	}
+	nodeMap := make(map[*Node]int, g.NumNodes())
+	for nodes := g.GetNodes(); nodes.Next(); {
+		node := nodes.Node()
+		n := len(doc.Nodes)
+		jn, err := j.encodeNode(node, n)
+		if err != nil {
+			return err
+		}
+		nodeMap[node] = n
+		doc.Nodes = append(doc.Nodes, jn)
+	}
+	for edges := g.GetEdges(); edges.Next(); {
+		edge := edges.Edge()
+		from := nodeMap[edge.from]
+		if j.EmbedEdges {
+			je, err := j.encodeEdge(edge, nil, nodeMap[edge.to])
+			if err != nil {
+				return err
+			}
+			doc.Nodes[from].Edges = append(doc.Nodes[from].Edges, je)
+			continue
+		}
+		je, err := j.encodeEdge(edge, &from, nodeMap[edge.to])
+		if err != nil {
+			return err
+		}
+		doc.Edges = append(doc.Edges, je)
+	}
+	return json.NewEncoder(out).Encode(doc)
+}
+
+// Decode reads a JSON document from the input and adds the nodes and
+// edges into the graph. If there is an error, the nodes and edges
+// added by Decode are removed from the graph.
+func (j JSON) Decode(g *Graph, input *json.Decoder) error {
+	var doc jsonGraph
+	if err := input.Decode(&doc); err != nil {
+		return err
+	}
+	start := g.idBase
+	if err := j.decode(g, &doc); err != nil {
+		g.removeAddedSince(start)
+		return err
+	}
+	return nil
+}
+
+func (j JSON) decode(g *Graph, doc *jsonGraph) error {
+	nodeMap := make(map[int]*Node, len(doc.Nodes))
+	for _, jn := range doc.Nodes {
+		if _, exists := nodeMap[jn.N]; exists {
+			return fmt.Errorf("duplicate node index: %d", jn.N)
+		}
+		props, err := j.decodeProperties(true, jn.Properties)
+		if err != nil {
+			return fmt.Errorf("node %d: %w", jn.N, err)
+		}
+		node, err := g.TryFastNewNode(NewStringSet(jn.Labels...), props, NewStringSet(jn.Contexts...))
+		if err != nil {
+			return fmt.Errorf("node %d: %w", jn.N, err)
+		}
+		nodeMap[jn.N] = node
+	}
+	addEdge := func(fromN int, je jsonEdge) error {
+		from, ok := nodeMap[fromN]
+		if !ok {
+			return fmt.Errorf("edge refers to unknown node: %d", fromN)
+		}
+		to, ok := nodeMap[je.To]
+		if !ok {
+			return fmt.Errorf("edge refers to unknown node: %d", je.To)
+		}
+		props, err := j.decodeProperties(false, je.Properties)
+		if err != nil {
+			return fmt.Errorf("edge %d->%d: %w", fromN, je.To, err)
+		}
+		if _, err := g.TryFastNewEdge(from, to, je.Label, props, NewStringSet(je.Contexts...)); err != nil {
+			return fmt.Errorf("edge %d->%d: %w", fromN, je.To, err)
+		}
+		return nil
+	}
+	for _, jn := range doc.Nodes {
+		for _, je := range jn.Edges {
+			if err := addEdge(jn.N, je); err != nil {
+				return err
+			}
+		}
+	}
+	for _, je := range doc.Edges {
+		if je.From == nil {
+			return fmt.Errorf("edge to %d has no source node", je.To)
+		}
+		if err := addEdge(*je.From, je); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+// Copyright 2021 Cloud Privacy Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lpg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func getJSONTestGraph() *Graph {
+	g := NewGraph()
+	nodes := make([]*Node, 0)
+	for i := 0; i < 5; i++ {
+		nodes = append(nodes, g.NewNode([]string{"a", "b"}, map[string]interface{}{"key": i, "str": "value"}, NewStringSet("ctx")))
+	}
+	for i := 0; i < 4; i++ {
+		g.NewEdge(nodes[i], nodes[i+1], "next", map[string]interface{}{"key": i}, NewStringSet("ectx"))
+	}
+	g.NewEdge(nodes[4], nodes[0], "back", nil, nil)
+	return g
+}
+
+func checkJSONIsomorphism(t *testing.T, g1, g2 *Graph) {
+	ok, _ := CheckIsomorphism(context.Background(), g1, g2, func(n1, n2 *Node) bool {
+		return n1.labels.IsEqual(n2.labels) &&
+			n1.contexts.IsEqual(n2.contexts) &&
+			reflect.DeepEqual(n1.properties, n2.properties)
+	}, func(e1, e2 *Edge) bool {
+		return e1.label == e2.label &&
+			e1.contexts.IsEqual(e2.contexts) &&
+			reflect.DeepEqual(e1.properties, e2.properties)
+	})
+	if !ok {
+		t.Errorf("Decoded graph is not isomorphic")
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	for _, embed := range []bool{false, true} {
+		g := getJSONTestGraph()
+		buf := bytes.Buffer{}
+		if err := (JSON{EmbedEdges: embed}).Encode(g, &buf); err != nil {
+			t.Fatal(err)
+		}
+		var doc map[string]interface{}
+		if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+			t.Fatal(err)
+		}
+		_, hasEdges := doc["edges"]
+		assert.Equal(t, !embed, hasEdges)
+
+		target := NewGraph()
+		if err := (JSON{}).Decode(target, json.NewDecoder(&buf)); err != nil {
+			t.Fatal(err)
+		}
+		checkJSONIsomorphism(t, g, target)
+	}
+}
+
+func TestJSONDecodeLayouts(t *testing.T) {
+	input := `{
+ "nodes": [
+   {"n": 10, "labels": ["x"], "edges": [{"to": 20, "label": "e1"}]},
+   {"n": 20, "labels": ["y"], "contexts": ["c"], "properties": {"p": 1.5}}
+ ],
+ "edges": [ {"from": 20, "to": 10, "label": "e2", "properties": {"q": [1, "a"]}} ]
+}`
+	g := NewGraph()
+	if err := (JSON{}).Decode(g, json.NewDecoder(strings.NewReader(input))); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, g.NumNodes())
+	assert.Equal(t, 2, g.NumEdges())
+	y := NodeSlice(g.GetNodesWithAllLabels(NewStringSet("y")))[0]
+	p, _ := y.GetProperty("p")
+	assert.Equal(t, 1.5, p)
+	assert.True(t, y.HasAnyContext("c"))
+	e := EdgeSlice(y.GetEdges(OutgoingEdge))[0]
+	q, _ := e.GetProperty("q")
+	assert.Equal(t, []interface{}{1, "a"}, q)
+
+	err := (JSON{}).Decode(NewGraph(), json.NewDecoder(strings.NewReader(`{"nodes":[{"n":0}],"edges":[{"from":0,"to":1}]}`)))
+	assert.Error(t, err)
+
+	// A failed decode does not leave partial nodes and edges
+	g = getJSONTestGraph()
+	expected := dumpSnapshot(g)
+	err = (JSON{}).Decode(g, json.NewDecoder(strings.NewReader(`{"nodes":[{"n":0,"labels":["a"],"edges":[{"to":1,"label":"x"}]},{"n":1}],"edges":[{"from":0,"to":2}]}`)))
+	assert.Error(t, err)
+	assert.Equal(t, expected, dumpSnapshot(g))
+	assert.Nil(t, g.Verify())
+}
+
+func TestJSONPropertyHooks(t *testing.T) {
+	ts := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	g := NewGraph()
+	g.NewNode([]string{"a"}, map[string]interface{}{"ts": ts}, nil)
+	j := JSON{
+		PropertyMarshaler: func(node bool, key string, value interface{}) (json.RawMessage, error) {
+			if t, ok := value.(time.Time); ok {
+				return json.Marshal(map[string]string{"time": t.Format(time.RFC3339)})
+			}
+			return json.Marshal(value)
+		},
+		PropertyUnmarshaler: func(node bool, key string, value json.RawMessage) (interface{}, error) {
+			var tm map[string]string
+			if err := json.Unmarshal(value, &tm); err == nil {
+				if s, ok := tm["time"]; ok {
+					return time.Parse(time.RFC3339, s)
+				}
+			}
+			return DefaultJSONPropertyUnmarshaler(value)
+		},
+	}
+	buf := bytes.Buffer{}
+	if err := j.Encode(g, &buf); err != nil {
+		t.Fatal(err)
+	}
+	target := NewGraph()
+	if err := j.Decode(target, json.NewDecoder(&buf)); err != nil {
+		t.Fatal(err)
+	}
+	v, _ := NodeSlice(target.GetNodes())[0].GetProperty("ts")
+	assert.Equal(t, ts, v)
+}
@@ -0,0 +1,273 @@
+// Copyright 2021 Cloud Privacy Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lpg
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrJSONLines is returned by JSONLinesReader, and gives the line
+// number of the record that caused the error
+type ErrJSONLines struct {
+	Line int
+	Err  error
+}
+
+func (e ErrJSONLines) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e ErrJSONLines) Unwrap() error { return e.Err }
+
+// jsonLineRecord is a node or an edge record. Node records have "n",
+// edge records have "from" and "to"
+type jsonLineRecord struct {
+	N          *int                       `json:"n"`
+	From       *int                       `json:"from"`
+	To         *int                       `json:"to"`
+	Label      string                     `json:"label"`
+	Labels     []string                   `json:"labels"`
+	Contexts   []string                   `json:"contexts"`
+	Properties map[string]json.RawMessage `json:"properties"`
+}
+
+// JSONLinesWriter writes nodes and edges one record per line. Node
+// records are written using the same format as the nodes of JSON,
+// with the node ID as the "n" field. Edge records are written using
+// the same format as the top-level edges of JSON. The writer does not
+// keep track of the nodes written, so the edges can be written
+// before or after their nodes.
+type JSONLinesWriter struct {
+	json JSON
+	out  *bufio.Writer
+	enc  *json.Encoder
+}
+
+// NewJSONLinesWriter returns a new writer that writes to out. The
+// property marshaler of j is used to write properties.
+func NewJSONLinesWriter(out io.Writer, j JSON) *JSONLinesWriter {
+	w := &JSONLinesWriter{
+		json: j,
+		out:  bufio.NewWriter(out),
+	}
+	w.enc = json.NewEncoder(w.out)
+	return w
+}
+
+// WriteNode writes a single node record
+func (w *JSONLinesWriter) WriteNode(node *Node) error {
+	jn, err := w.json.encodeNode(node, node.id)
+	if err != nil {
+		return err
+	}
+	return w.enc.Encode(jn)
+}
+
+// WriteEdge writes a single edge record
+func (w *JSONLinesWriter) WriteEdge(edge *Edge) error {
+	from := edge.from.id
+	je, err := w.json.encodeEdge(edge, &from, edge.to.id)
+	if err != nil {
+		return err
+	}
+	return w.enc.Encode(je)
+}
+
+// WriteNodes writes all the nodes of the iterator
+func (w *JSONLinesWriter) WriteNodes(nodes NodeIterator) error {
+	for nodes.Next() {
+		if err := w.WriteNode(nodes.Node()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteEdges writes all the edges of the iterator
+func (w *JSONLinesWriter) WriteEdges(edges EdgeIterator) error {
+	for edges.Next() {
+		if err := w.WriteEdge(edges.Edge()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush writes any buffered data to the underlying writer
+func (w *JSONLinesWriter) Flush() error {
+	return w.out.Flush()
+}
+
+// EncodeLines writes all nodes followed by all edges of the graph as
+// JSON Lines
+func (j JSON) EncodeLines(g *Graph, out io.Writer) error {
+	w := NewJSONLinesWriter(out, j)
+	if err := w.WriteNodes(g.GetNodes()); err != nil {
+		return err
+	}
+	if err := w.WriteEdges(g.GetEdges()); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// JSONLinesReader reads node and edge records written by
+// JSONLinesWriter. Edges may refer to nodes that are not read
+// yet. Such nodes are created when the edge is read, and filled in
+// when the node record is read.
+type JSONLinesReader struct {
+	json  JSON
+	in    *bufio.Reader
+	line  int
+	nodes map[int]*Node
+	// Nodes that are referenced by edges but not read yet, and the
+	// line they are first referenced
+	pending map[int]int
+}
+
+// NewJSONLinesReader returns a new reader that reads from in. The
+// property unmarshaler of j is used to read properties.
+func NewJSONLinesReader(in io.Reader, j JSON) *JSONLinesReader {
+	return &JSONLinesReader{
+		json:    j,
+		in:      bufio.NewReader(in),
+		nodes:   make(map[int]*Node),
+		pending: make(map[int]int),
+	}
+}
+
+// Read reads all records until the end of input, and adds them to
+// the graph. It is an error if an edge refers to a node that does not
+// have a node record in the input. If there is an error, the nodes
+// and edges added by Read are removed from the graph, and the reader
+// should not be used again.
+func (r *JSONLinesReader) Read(g *Graph) error {
+	start := g.idBase
+	if err := r.read(g); err != nil {
+		g.removeAddedSince(start)
+		return err
+	}
+	return nil
+}
+
+func (r *JSONLinesReader) read(g *Graph) error {
+	for {
+		line, err := r.in.ReadBytes('\n')
+		if len(line) > 0 {
+			r.line++
+			if err := r.readLine(g, line); err != nil {
+				return ErrJSONLines{Line: r.line, Err: err}
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return ErrJSONLines{Line: r.line, Err: err}
+		}
+	}
+	if len(r.pending) == 0 {
+		return nil
+	}
+	// Report the earliest undefined reference
+	first, firstLine := 0, -1
+	for n, line := range r.pending {
+		if firstLine == -1 || line < firstLine {
+			first, firstLine = n, line
+		}
+	}
+	return ErrJSONLines{Line: firstLine, Err: fmt.Errorf("node %d is referenced, but not defined", first)}
+}
+
+func (r *JSONLinesReader) readLine(g *Graph, line []byte) error {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return nil
+	}
+	var rec jsonLineRecord
+	if err := json.Unmarshal(line, &rec); err != nil {
+		return err
+	}
+	switch {
+	case rec.N != nil:
+		return r.readNode(g, *rec.N, rec)
+	case rec.From != nil && rec.To != nil:
+		return r.readEdge(g, *rec.From, *rec.To, rec)
+	}
+	return errors.New("record is neither a node nor an edge")
+}
+
+func (r *JSONLinesReader) readNode(g *Graph, n int, rec jsonLineRecord) error {
+	props, err := r.json.decodeProperties(true, rec.Properties)
+	if err != nil {
+		return fmt.Errorf("node %d: %w", n, err)
+	}
+	node, exists := r.nodes[n]
+	if !exists {
+		node, err := g.TryFastNewNode(NewStringSet(rec.Labels...), props, NewStringSet(rec.Contexts...))
+		if err != nil {
+			return fmt.Errorf("node %d: %w", n, err)
+		}
+		r.nodes[n] = node
+		return nil
+	}
+	if _, pending := r.pending[n]; !pending {
+		return fmt.Errorf("duplicate node: %d", n)
+	}
+	delete(r.pending, n)
+	if err := g.setNodeLabels(node, NewStringSet(rec.Labels...), true); err != nil {
+		return fmt.Errorf("node %d: %w", n, err)
+	}
+	g.setNodeContexts(node, NewStringSet(rec.Contexts...))
+	for k, v := range props {
+		if err := g.setNodeProperty(node, k, v, true); err != nil {
+			return fmt.Errorf("node %d: %w", n, err)
+		}
+	}
+	return nil
+}
+
+func (r *JSONLinesReader) getNode(g *Graph, n int) *Node {
+	node, exists := r.nodes[n]
+	if exists {
+		return node
+	}
+	node = g.FastNewNode(NewStringSet(), nil, nil)
+	r.nodes[n] = node
+	r.pending[n] = r.line
+	return node
+}
+
+func (r *JSONLinesReader) readEdge(g *Graph, from, to int, rec jsonLineRecord) error {
+	props, err := r.json.decodeProperties(false, rec.Properties)
+	if err != nil {
+		return fmt.Errorf("edge %d->%d: %w", from, to, err)
+	}
+	if _, err := g.TryFastNewEdge(r.getNode(g, from), r.getNode(g, to), rec.Label, props, NewStringSet(rec.Contexts...)); err != nil {
+		return fmt.Errorf("edge %d->%d: %w", from, to, err)
+	}
+	return nil
+}
+
+// DecodeLines reads JSON Lines input and adds the nodes and edges
+// into the graph
+func (j JSON) DecodeLines(g *Graph, in io.Reader) error {
+	return NewJSONLinesReader(in, j).Read(g)
+}
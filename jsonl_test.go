@@ -0,0 +1,103 @@
+// Copyright 2021 Cloud Privacy Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lpg
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestJSONLinesRoundTrip(t *testing.T) {
+	g := getJSONTestGraph()
+	buf := bytes.Buffer{}
+	if err := (JSON{}).EncodeLines(g, &buf); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, g.NumNodes()+g.NumEdges(), strings.Count(buf.String(), "\n"))
+
+	target := NewGraph()
+	if err := (JSON{}).DecodeLines(target, &buf); err != nil {
+		t.Fatal(err)
+	}
+	checkJSONIsomorphism(t, g, target)
+}
+
+func TestJSONLinesForwardReference(t *testing.T) {
+	g := getJSONTestGraph()
+	buf := bytes.Buffer{}
+	w := NewJSONLinesWriter(&buf, JSON{})
+	// Edges first
+	if err := w.WriteEdges(g.GetEdges()); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteNodes(g.GetNodes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	target := NewGraph()
+	if err := (JSON{}).DecodeLines(target, &buf); err != nil {
+		t.Fatal(err)
+	}
+	checkJSONIsomorphism(t, g, target)
+	assert.Equal(t, 5, target.GetNodesWithAllLabels(NewStringSet("a", "b")).MaxSize())
+}
+
+func TestJSONLinesErrors(t *testing.T) {
+	var lerr ErrJSONLines
+	err := (JSON{}).DecodeLines(NewGraph(), strings.NewReader(`{"n":0}
+
+{"n":1}
+{"label":"x"}
+`))
+	if !errors.As(err, &lerr) {
+		t.Fatalf("Expecting line error, got %v", err)
+	}
+	assert.Equal(t, 4, lerr.Line)
+
+	err = (JSON{}).DecodeLines(NewGraph(), strings.NewReader(`{"n":0}
+{"from":0,"to":1}
+{"from":0,"to":2}
+{"n":2}`))
+	if !errors.As(err, &lerr) {
+		t.Fatalf("Expecting line error, got %v", err)
+	}
+	assert.Equal(t, 2, lerr.Line)
+
+	err = (JSON{}).DecodeLines(NewGraph(), strings.NewReader(`{"n":0}
+{"n":0}`))
+	if !errors.As(err, &lerr) {
+		t.Fatalf("Expecting line error, got %v", err)
+	}
+	assert.Equal(t, 2, lerr.Line)
+
+	// A failed read does not leave placeholders or partial nodes
+	g := getJSONTestGraph()
+	numNodes, numEdges := g.NumNodes(), g.NumEdges()
+	err = (JSON{}).DecodeLines(g, strings.NewReader(`{"from":0,"to":1,"label":"x"}
+{"n":0,"labels":["a"]}
+{"n":2}`))
+	if !errors.As(err, &lerr) {
+		t.Fatalf("Expecting line error, got %v", err)
+	}
+	assert.Equal(t, 1, lerr.Line)
+	assert.Equal(t, numNodes, g.NumNodes())
+	assert.Equal(t, numEdges, g.NumEdges())
+	assert.Empty(t, EdgeSlice(g.GetEdgesWithAnyLabel(NewStringSet("x"))))
+}
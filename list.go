@@ -117,8 +117,10 @@ func (list *nodeList) remove(node *Node) {
 	if node.next != nil {
 		node.next.prev = node.prev
 	} else {
-		list.tail = node
+		list.tail = node.prev
 	}
+	node.next = nil
+	node.prev = nil
 	list.n--
 }
 
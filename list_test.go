@@ -0,0 +1,40 @@
+// Copyright 2021 Cloud Privacy Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lpg
+
+import (
+	"testing"
+)
+
+func TestNodeListRemoveTail(t *testing.T) {
+	list := nodeList{}
+	nodes := []*Node{{id: 0}, {id: 1}, {id: 2}}
+	for _, node := range nodes {
+		list.add(node)
+	}
+	list.remove(nodes[2])
+	list.add(&Node{id: 3})
+	list.remove(nodes[0])
+	ids := make([]int, 0)
+	for node := list.head; node != nil; node = node.next {
+		ids = append(ids, node.id)
+	}
+	if len(ids) != 2 || ids[0] != 1 || ids[1] != 3 || list.n != 2 {
+		t.Errorf("Wrong list: %v %d", ids, list.n)
+	}
+	if list.tail.id != 3 || list.tail.next != nil {
+		t.Errorf("Wrong tail: %+v", list.tail)
+	}
+}
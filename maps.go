@@ -40,10 +40,6 @@ func (nm *NodeMap) Replace(node *Node, oldLabels, newLabels *StringSet) {
 		}
 		nm.nolabels.remove(node.id)
 	}
-	if newLabels.Len() == 0 {
-		nm.nolabels.add(node.id, node) // add to list of nodes with no Labels
-		return
-	}
 	var set *fastSet
 	// Process removed labels
 	oldLabels.Iter(func(label string) bool {
@@ -60,6 +56,10 @@ func (nm *NodeMap) Replace(node *Node, oldLabels, newLabels *StringSet) {
 		}
 		return false
 	})
+	if newLabels.Len() == 0 {
+		nm.nolabels.add(node.id, node) // add to list of nodes with no Labels
+		return
+	}
 
 	// Process added labels
 	newLabels.Iter(func(label string) bool {
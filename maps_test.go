@@ -61,3 +61,17 @@ func TestNodeMap(t *testing.T) {
 		}
 	}
 }
+
+func TestNodeMapRemoveAllLabels(t *testing.T) {
+	m := NewNodeMap()
+	node := &Node{labels: NewStringSet("a", "b"), id: 1}
+	m.Add(node)
+	m.Replace(node, node.labels, NewStringSet())
+	node.labels = NewStringSet()
+	if itr := m.IteratorAllLabels(NewStringSet("a")); itr.Next() {
+		t.Errorf("Node still has label a")
+	}
+	if n := len(NodeSlice(m.Iterator())); n != 1 {
+		t.Errorf("Expecting 1 node, got %d", n)
+	}
+}
@@ -29,6 +29,8 @@ type Node struct {
 	incoming edgeMap
 	outgoing edgeMap
 	id       int
+	// epoch is the graph version the node state was written at
+	epoch uint64
 }
 
 // GetProperty returns the property value in the string table
@@ -88,6 +90,9 @@ func (node *Node) GetID() int { return node.id }
 
 // Returns an edge iterator for incoming or outgoing edges
 func (node *Node) GetEdges(dir EdgeDir) EdgeIterator {
+	if node.graph.view != nil {
+		return node.graph.view.edgesOf(node, dir, nil)
+	}
 	switch dir {
 	case IncomingEdge:
 		return node.incoming.iterator(2)
@@ -101,6 +106,9 @@ func (node *Node) GetEdges(dir EdgeDir) EdgeIterator {
 
 // Returns an edge iterator for incoming or outgoing edges with the given label
 func (node *Node) GetEdgesWithLabel(dir EdgeDir, label string) EdgeIterator {
+	if node.graph.view != nil {
+		return node.graph.view.edgesOf(node, dir, NewStringSet(label))
+	}
 	switch dir {
 	case IncomingEdge:
 		return node.incoming.iteratorLabel(label, 2)
@@ -114,6 +122,9 @@ func (node *Node) GetEdgesWithLabel(dir EdgeDir, label string) EdgeIterator {
 
 // Returns an edge iterator for incoming or outgoingn edges that has the given labels
 func (node *Node) GetEdgesWithAnyLabel(dir EdgeDir, labels *StringSet) EdgeIterator {
+	if node.graph.view != nil {
+		return node.graph.view.edgesOf(node, dir, labels)
+	}
 	switch dir {
 	case IncomingEdge:
 		if labels == nil || labels.Len() == 0 {
@@ -131,14 +142,32 @@ func (node *Node) GetEdgesWithAnyLabel(dir EdgeDir, labels *StringSet) EdgeItera
 	return edgeIterator{withSize(MultiIterator(i1, i2), i1.MaxSize()+i2.MaxSize())}
 }
 
-// SetLabels sets the node labels
+// SetLabels sets the node labels. Unique constraints are not
+// checked. Use TrySetLabels to check them.
 func (node *Node) SetLabels(labels *StringSet) {
-	node.graph.setNodeLabels(node, labels)
+	node.graph.setNodeLabels(node, labels, false)
+}
+
+// TrySetLabels sets the node labels. Returns ErrUniqueConstraint if
+// the node would violate a unique constraint for one of the new
+// labels. In that case the node is not changed.
+func (node *Node) TrySetLabels(labels *StringSet) error {
+	return node.graph.setNodeLabels(node, labels, true)
 }
 
-// SetProperty sets a node property
+// SetProperty sets a node property. The index constraints of the
+// graph are not checked: a value that is not of the kind of a typed
+// property index is kept apart from the typed values, but is still
+// found through the index. Use TrySetProperty to check them.
 func (node *Node) SetProperty(key string, value interface{}) {
-	node.graph.setNodeProperty(node, key, value)
+	node.graph.setNodeProperty(node, key, value, false)
+}
+
+// TrySetProperty sets a node property. Returns an error if the value
+// violates the index constraints of the graph. In that case the node
+// is not changed.
+func (node *Node) TrySetProperty(key string, value interface{}) error {
+	return node.graph.setNodeProperty(node, key, value, true)
 }
 
 // RemoveProperty removes a node property
@@ -157,10 +186,16 @@ func (node *Node) Detach() {
 }
 
 func (node *Node) OutgoingEdgeCount() int {
+	if node.graph.view != nil {
+		return node.graph.view.edgeCount(node, OutgoingEdge)
+	}
 	return node.outgoing.n
 }
 
 func (node *Node) IncomingEdgeCount() int {
+	if node.graph.view != nil {
+		return node.graph.view.edgeCount(node, IncomingEdge)
+	}
 	return node.incoming.n
 }
 
@@ -14,20 +14,45 @@
 
 package lpg
 
-import "fmt"
-
 type ErrNodeVariableExpected string
 
 func (e ErrNodeVariableExpected) Error() string {
 	return "Node variable expected: " + string(e)
 }
 
+type ErrUnknownVariable string
+
+func (e ErrUnknownVariable) Error() string {
+	return "Unknown variable: " + string(e)
+}
+
 type ErrEdgeVariableExpected string
 
 func (e ErrEdgeVariableExpected) Error() string {
 	return "Edge variable expected:" + string(e)
 }
 
+// ErrUnboundPathEnd is returned when the node at the end of a
+// shortest path pattern item is not bound to a variable
+type ErrUnboundPathEnd string
+
+func (e ErrUnboundPathEnd) Error() string {
+	return "Shortest path end node is not bound: " + string(e)
+}
+
+// PathMode selects the paths matched by an edge pattern item
+type PathMode int
+
+const (
+	// AllPathsMode matches all the paths of the item
+	AllPathsMode PathMode = iota
+	// ShortestPathMode matches one shortest path between the nodes
+	ShortestPathMode
+	// AllShortestPathsMode matches all the shortest paths between the
+	// nodes
+	AllShortestPathsMode
+)
+
 // Pattern contains pattern items, with even numbered elements
 // corresponding to nodes, and odd numbered elements corresponding to
 // edges
@@ -50,18 +75,30 @@ type PatternItem struct {
 	ToLeft bool
 	// Undirected is true if this is a relationship of the form --
 	Undirected bool
+	// PathMode selects the paths matched by an edge item. With the
+	// shortest path modes, the node item the path leads to must be
+	// bound to a variable, and Max limits the path length. Max=0 is
+	// unbounded for the shortest path modes.
+	PathMode PathMode
 	// Name of the variable associated with this processing node. If the
 	// name is defined, it is used to constrain values. If not, it is
 	// used to store values
 	Name string
+	// Where is an optional predicate the node or the edge must
+	// satisfy in addition to Labels and Properties
+	Where Predicate
+	// Constraints relate the variables of the pattern. A constraint
+	// is checked as soon as all the variables it refers to are bound
+	// during matching, independent of the item it is attached to.
+	Constraints []VariablePredicate
 }
 
 func (p PatternItem) getEdgeFilter() func(*Edge) bool {
-	return GetEdgeFilterFunc(p.Labels, p.Properties)
+	return GetEdgeFilterFunc(p.Labels, p.Properties, p.Where)
 }
 
 func (p PatternItem) getNodeFilter() func(*Node) bool {
-	return GetNodeFilterFunc(p.Labels, p.Properties)
+	return GetNodeFilterFunc(p.Labels, p.Properties, p.Where)
 }
 
 // Returns the set of nodes constraining the pattern item. That is,
@@ -112,7 +149,9 @@ func (p PatternItem) isConstrainedEdges(ctx *MatchContext) (*EdgeSet, error) {
 	return nil, nil
 }
 
-func (p *PatternItem) estimateNodeSize(g *Graph, symbols map[string]*PatternSymbol) (NodeIterator, int) {
+// indexedNodes returns the nodes found using the indexes for the
+// labels and properties of the item, or nil if no index applies
+func (p *PatternItem) indexedNodes(g *Graph) (NodeIterator, int) {
 	max := -1
 	var ret NodeIterator
 	if p.Labels != nil && p.Labels.Len() > 0 {
@@ -124,8 +163,7 @@ func (p *PatternItem) estimateNodeSize(g *Graph, symbols map[string]*PatternSymb
 	}
 	if p.Properties != nil && len(p.Properties) > 0 {
 		for k, v := range p.Properties {
-			prop := fmt.Sprintf("%v", v)
-			itr, _ := g.index.GetIteratorForNodeProperty(k, prop)
+			itr, _ := g.index.GetIteratorForNodeProperty(k, v)
 			if itr == nil {
 				continue
 			}
@@ -139,6 +177,32 @@ func (p *PatternItem) estimateNodeSize(g *Graph, symbols map[string]*PatternSymb
 			}
 		}
 	}
+	if len(p.Properties) > 0 {
+		if itr, _ := compositeCandidates(g.index.nodeComposites, p.Properties); itr != nil {
+			if maxSize := itr.MaxSize(); maxSize != -1 && (max == -1 || maxSize < max) {
+				max = maxSize
+				ret = nodeIterator{itr}
+			}
+		}
+	}
+	if p.Where != nil {
+		itr, maxSize := indexCandidates(p.Where, g.index.nodeProperties)
+		if itr != nil && maxSize != -1 && (max == -1 || maxSize < max) {
+			max = maxSize
+			ret = nodeIterator{itr}
+		}
+	}
+	return ret, max
+}
+
+func (p *PatternItem) estimateNodeSize(g *Graph, symbols map[string]*PatternSymbol) (NodeIterator, int) {
+	var ret NodeIterator
+	max := -1
+	if g.view != nil {
+		ret, max = g.view.nodeCandidates(p)
+	} else {
+		ret, max = p.indexedNodes(g)
+	}
 	if len(p.Name) > 0 {
 		sym, ok := symbols[p.Name]
 		if ok {
@@ -173,10 +237,40 @@ func (p PatternItem) estimateEdgeSize(g *Graph, symbols map[string]*PatternSymbo
 		return ret, max
 	}
 
-	if p.Min > 1 || p.Max > 1 || p.Min == -1 || p.Max == -1 {
+	// A shortest path item must start from a bound node, so it is
+	// never the first step
+	if p.Min > 1 || p.Max > 1 || p.Min == -1 || p.Max == -1 || p.PathMode != AllPathsMode {
 		return g.GetEdges(), -1
 	}
 
+	if g.view != nil {
+		ret, max = g.view.edgeCandidates(p)
+	} else {
+		ret, max = p.indexedEdges(g)
+	}
+	if len(p.Name) > 0 {
+		sym, ok := symbols[p.Name]
+		if ok {
+			if sym.Edges == nil {
+				max = 0
+				ret = &edgeIterator{emptyIterator{}}
+			} else if max == -1 || sym.Edges.Len() < max {
+				max = sym.Edges.Len()
+				ret = sym.Edges.Iterator()
+			}
+		}
+	}
+	if ret == nil {
+		return allEdges()
+	}
+	return ret, max
+}
+
+// indexedEdges returns the edges found using the indexes for the
+// labels and properties of the item, or nil if no index applies
+func (p PatternItem) indexedEdges(g *Graph) (EdgeIterator, int) {
+	max := -1
+	var ret EdgeIterator
 	if p.Labels != nil && p.Labels.Len() > 0 {
 		itr := g.GetEdgesWithAnyLabel(p.Labels)
 		if sz := itr.MaxSize(); sz != -1 {
@@ -186,8 +280,7 @@ func (p PatternItem) estimateEdgeSize(g *Graph, symbols map[string]*PatternSymbo
 	}
 	if p.Properties != nil && len(p.Properties) > 0 {
 		for k, v := range p.Properties {
-			prop := fmt.Sprintf("%v", v)
-			itr, _ := g.index.GetIteratorForEdgeProperty(k, prop)
+			itr, _ := g.index.GetIteratorForEdgeProperty(k, v)
 			if itr == nil {
 				continue
 			}
@@ -201,20 +294,20 @@ func (p PatternItem) estimateEdgeSize(g *Graph, symbols map[string]*PatternSymbo
 			}
 		}
 	}
-	if len(p.Name) > 0 {
-		sym, ok := symbols[p.Name]
-		if ok {
-			if sym.Edges == nil {
-				max = 0
-				ret = &edgeIterator{emptyIterator{}}
-			} else if max == -1 || sym.Edges.Len() < max {
-				max = sym.Edges.Len()
-				ret = sym.Edges.Iterator()
+	if len(p.Properties) > 0 {
+		if itr, _ := compositeCandidates(g.index.edgeComposites, p.Properties); itr != nil {
+			if maxSize := itr.MaxSize(); maxSize != -1 && (max == -1 || maxSize < max) {
+				max = maxSize
+				ret = edgeIterator{itr}
 			}
 		}
 	}
-	if ret == nil {
-		return allEdges()
+	if p.Where != nil {
+		itr, maxSize := indexCandidates(p.Where, g.index.edgeProperties)
+		if itr != nil && maxSize != -1 && (max == -1 || maxSize < max) {
+			max = maxSize
+			ret = edgeIterator{itr}
+		}
 	}
 	return ret, max
 }
@@ -273,6 +366,15 @@ func (p *PatternSymbol) EdgeSlice() *Path {
 type MatchPlan struct {
 	steps    []planProcessor
 	nForward int
+	// constraints[i] are checked after steps[i] binds its result
+	constraints [][]planConstraint
+}
+
+// planConstraint is a constraint, and the steps that bind its
+// variables
+type planConstraint struct {
+	predicate VariablePredicate
+	steps     map[string]planProcessor
 }
 
 type planProcessor interface {
@@ -508,9 +610,60 @@ func (pattern Pattern) GetPlan(graph *Graph, symbols map[string]*PatternSymbol)
 			plan.steps = append(plan.steps, processors[i])
 		}
 	}
+	// The shortest path edges need the node item they lead to
+	for i := 1; i < len(pattern); i += 2 {
+		if edges, ok := processors[i].(*iterateConnectedEdges); ok {
+			if edges.source == processors[i-1] {
+				edges.target = pattern[i+1]
+			} else {
+				edges.target = pattern[i-1]
+			}
+		}
+	}
+	if err := plan.addConstraints(pattern); err != nil {
+		return plan, err
+	}
 	return plan, nil
 }
 
+// addConstraints assigns the constraints of the pattern to the plan
+// step after which all their variables are bound
+func (plan *MatchPlan) addConstraints(pattern Pattern) error {
+	for _, item := range pattern {
+		for _, constraint := range item.Constraints {
+			pc := planConstraint{
+				predicate: constraint,
+				steps:     make(map[string]planProcessor),
+			}
+			last := -1
+			for _, variable := range constraint.Variables() {
+				found := false
+				for i, step := range plan.steps {
+					if step.GetPatternItem().Name == variable {
+						pc.steps[variable] = step
+						if i > last {
+							last = i
+						}
+						found = true
+						break
+					}
+				}
+				if !found {
+					return ErrUnknownVariable(variable)
+				}
+			}
+			if last == -1 {
+				last = 0
+			}
+			if plan.constraints == nil {
+				plan.constraints = make([][]planConstraint, len(plan.steps))
+			}
+			plan.constraints[last] = append(plan.constraints[last], pc)
+		}
+	}
+	return nil
+}
+
 type matchAccumulator interface {
 	Run(*MatchContext) error
 }
@@ -524,6 +677,35 @@ func (n nextAccumulator) Run(ctx *MatchContext) error {
 	return n.run.Run(ctx, n.next)
 }
 
+// constraintAccumulator checks the constraints before running the
+// next step
+type constraintAccumulator struct {
+	constraints []planConstraint
+	next        matchAccumulator
+}
+
+func (c constraintAccumulator) Run(ctx *MatchContext) error {
+	for _, constraint := range c.constraints {
+		values := make(map[string]interface{}, len(constraint.steps))
+		for name, step := range constraint.steps {
+			values[name] = step.GetResult()
+		}
+		if !constraint.predicate.EvalVariables(values) {
+			return nil
+		}
+	}
+	return c.next.Run(ctx)
+}
+
+// checkConstraints returns an accumulator that checks the constraints
+// that can be evaluated after step i before running next
+func (plan MatchPlan) checkConstraints(i int, next matchAccumulator) matchAccumulator {
+	if i >= len(plan.constraints) || len(plan.constraints[i]) == 0 {
+		return next
+	}
+	return constraintAccumulator{constraints: plan.constraints[i], next: next}
+}
+
 type resultAccumulator struct {
 	acc  MatchAccumulator
 	plan MatchPlan
@@ -629,12 +811,13 @@ func (plan MatchPlan) Run(graph *Graph, symbols map[string]*PatternSymbol, resul
 	}
 
 	res := resultAccumulator{acc: result, plan: plan}
-	acc := matchAccumulator(&res)
+	acc := plan.checkConstraints(len(plan.steps)-1, &res)
 	for i := len(plan.steps) - 1; i > 0; i-- {
 		acc = nextAccumulator{
 			run:  plan.steps[i],
 			next: acc,
 		}
+		acc = plan.checkConstraints(i-1, acc)
 	}
 	logf("Plan run: steps: %+v, ctx: %+v\n", plan.steps, ctx)
 	return plan.steps[0].Run(ctx, acc)
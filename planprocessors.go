@@ -122,10 +122,12 @@ func (processor *iterateEdges) IsEdge()                     {}
 type iterateConnectedEdges struct {
 	patternItem PatternItem
 	source      planProcessor
-	dir         EdgeDir
-	result      *Path
-	edgeFilter  func(*Edge) bool
-	edgeItr     EdgeIterator
+	// target is the node item the edges lead to
+	target     PatternItem
+	dir        EdgeDir
+	result     *Path
+	edgeFilter func(*Edge) bool
+	edgeItr    EdgeIterator
 }
 
 func newIterateConnectedEdges(source planProcessor, item PatternItem, dir EdgeDir) *iterateConnectedEdges {
@@ -155,6 +157,9 @@ func (processor *iterateConnectedEdges) Run(ctx *MatchContext, next matchAccumul
 	if processor.edgeItr == nil {
 		return nil
 	}
+	if processor.patternItem.PathMode != AllPathsMode {
+		return processor.runShortest(ctx, node, next)
+	}
 	if processor.patternItem.Min == 1 && processor.patternItem.Max == 1 {
 		for processor.edgeItr.Next() {
 			edge := processor.edgeItr.Edge()
@@ -192,6 +197,50 @@ func (processor *iterateConnectedEdges) Run(ctx *MatchContext, next matchAccumul
 	return err
 }
 
+// runShortest matches the shortest paths from node to the nodes bound
+// to the target item
+func (processor *iterateConnectedEdges) runShortest(ctx *MatchContext, node *Node, next matchAccumulator) error {
+	targets, err := processor.target.isConstrainedNodes(ctx)
+	if err != nil {
+		return err
+	}
+	if targets == nil {
+		return ErrUnboundPathEnd(processor.target.Name)
+	}
+	maxDepth := processor.patternItem.Max
+	if maxDepth == 0 {
+		maxDepth = -1
+	}
+	for itr := targets.Iterator(); itr.Next(); {
+		target := itr.Node()
+		if target == node {
+			continue
+		}
+		var paths []*Path
+		if processor.patternItem.PathMode == ShortestPathMode {
+			if path := ShortestPath(node, target, processor.dir, processor.edgeFilter, maxDepth); path != nil {
+				paths = []*Path{path}
+			}
+		} else {
+			paths = AllShortestPaths(node, target, processor.dir, processor.edgeFilter, maxDepth)
+		}
+		for _, path := range paths {
+			if path.NumEdges() < processor.patternItem.Min {
+				continue
+			}
+			processor.result = path
+			ctx.recordStepResult(processor)
+			ctx.variablePathNode = target
+			if err := next.Run(ctx); err != nil {
+				return err
+			}
+			ctx.variablePathNode = nil
+			ctx.resetStepResult(processor)
+		}
+	}
+	return nil
+}
+
 func (processor *iterateConnectedEdges) GetPatternItem() PatternItem { return processor.patternItem }
 func (processor *iterateConnectedEdges) GetResult() interface{}      { return processor.result }
 func (processor *iterateConnectedEdges) IsEdge()                     {}
@@ -0,0 +1,601 @@
+// Copyright 2021 Cloud Privacy Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lpg
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// PropertyGetter is implemented by nodes and edges
+type PropertyGetter interface {
+	GetProperty(key string) (interface{}, bool)
+}
+
+// A Predicate is a condition on the properties of a node or an
+// edge. Predicates can be combined using AndPredicate, OrPredicate,
+// and NotPredicate.
+//
+// Predicates use three-valued logic as in openCypher. A comparison
+// with a property that does not exist, or that has nil value is
+// unknown, as are comparisons of incomparable values. NOT of unknown
+// is unknown, and Eval returns true only if the result is true.
+type Predicate interface {
+	Eval(PropertyGetter) bool
+}
+
+// ternary is the result of a predicate in three-valued logic
+type ternary int
+
+const (
+	ternaryFalse ternary = iota
+	ternaryTrue
+	ternaryUnknown
+)
+
+func ternaryOf(b bool) ternary {
+	if b {
+		return ternaryTrue
+	}
+	return ternaryFalse
+}
+
+func (t ternary) not() ternary {
+	switch t {
+	case ternaryFalse:
+		return ternaryTrue
+	case ternaryTrue:
+		return ternaryFalse
+	}
+	return ternaryUnknown
+}
+
+// foldTernary returns the conjunction, or the disjunction if or is
+// true, of the n results
+func foldTernary(or bool, n int, eval func(int) ternary) ternary {
+	dominant := ternaryOf(or)
+	ret := dominant.not()
+	for i := 0; i < n; i++ {
+		switch eval(i) {
+		case dominant:
+			return dominant
+		case ternaryUnknown:
+			ret = ternaryUnknown
+		}
+	}
+	return ret
+}
+
+// ternaryPredicate is implemented by the predicates of this package
+// that can be unknown
+type ternaryPredicate interface {
+	evalTernary(PropertyGetter) ternary
+}
+
+// evalPredicate evaluates the predicate in three-valued
+// logic. Predicates that do not implement ternaryPredicate are never
+// unknown.
+func evalPredicate(p Predicate, item PropertyGetter) ternary {
+	if t, ok := p.(ternaryPredicate); ok {
+		return t.evalTernary(item)
+	}
+	return ternaryOf(p.Eval(item))
+}
+
+// CompareOp is a comparison operator
+type CompareOp int
+
+const (
+	OpEq CompareOp = iota
+	OpNe
+	OpLt
+	OpLe
+	OpGt
+	OpGe
+)
+
+func (op CompareOp) String() string {
+	switch op {
+	case OpEq:
+		return "="
+	case OpNe:
+		return "<>"
+	case OpLt:
+		return "<"
+	case OpLe:
+		return "<="
+	case OpGt:
+		return ">"
+	case OpGe:
+		return ">="
+	}
+	return "?"
+}
+
+// test returns the result of the operator given the result of the
+// comparison
+func (op CompareOp) test(cmp int) bool {
+	switch op {
+	case OpEq:
+		return cmp == 0
+	case OpNe:
+		return cmp != 0
+	case OpLt:
+		return cmp < 0
+	case OpLe:
+		return cmp <= 0
+	case OpGt:
+		return cmp > 0
+	case OpGe:
+		return cmp >= 0
+	}
+	return false
+}
+
+// comparePredicateValues compares a and b using
+// ComparePropertyValue. Returns false if they are not comparable
+func comparePredicateValues(a, b interface{}) (cmp int, ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			ok = false
+		}
+	}()
+	return ComparePropertyValue(a, b), true
+}
+
+// getPredicateValue returns the property value, or false if the
+// property does not exist or it is nil
+func getPredicateValue(item PropertyGetter, key string) (interface{}, bool) {
+	v, ok := item.GetProperty(key)
+	if !ok || v == nil {
+		return nil, false
+	}
+	return v, true
+}
+
+// getPredicateString returns the property value if it is a string
+func getPredicateString(item PropertyGetter, key string) (string, bool) {
+	v, ok := getPredicateValue(item, key)
+	if !ok {
+		return "", false
+	}
+	if n, ok := v.(WithNativeValue); ok {
+		v = n.GetNativeValue()
+	}
+	str, ok := v.(string)
+	return str, ok
+}
+
+// PropertyCompare compares the property value with a constant
+type PropertyCompare struct {
+	Key   string
+	Op    CompareOp
+	Value interface{}
+}
+
+func (p PropertyCompare) Eval(item PropertyGetter) bool {
+	return p.evalTernary(item) == ternaryTrue
+}
+
+func (p PropertyCompare) evalTernary(item PropertyGetter) ternary {
+	v, ok := getPredicateValue(item, p.Key)
+	if !ok || p.Value == nil {
+		return ternaryUnknown
+	}
+	cmp, ok := comparePredicateValues(v, p.Value)
+	if !ok {
+		return ternaryUnknown
+	}
+	return ternaryOf(p.Op.test(cmp))
+}
+
+func (p PropertyCompare) String() string {
+	return fmt.Sprintf("%s %s %v", p.Key, p.Op, p.Value)
+}
+
+// PropertyIn is true if the property value is equal to one of the
+// values. If there is no equal value, it is unknown if the property
+// or one of the values is nil.
+type PropertyIn struct {
+	Key    string
+	Values []interface{}
+}
+
+func (p PropertyIn) Eval(item PropertyGetter) bool {
+	return p.evalTernary(item) == ternaryTrue
+}
+
+func (p PropertyIn) evalTernary(item PropertyGetter) ternary {
+	v, ok := getPredicateValue(item, p.Key)
+	if !ok {
+		return ternaryUnknown
+	}
+	ret := ternaryFalse
+	for _, x := range p.Values {
+		if x == nil {
+			ret = ternaryUnknown
+			continue
+		}
+		if cmp, ok := comparePredicateValues(v, x); ok && cmp == 0 {
+			return ternaryTrue
+		}
+	}
+	return ret
+}
+
+func (p PropertyIn) String() string {
+	return fmt.Sprintf("%s IN %v", p.Key, p.Values)
+}
+
+// PropertyStartsWith is true if the property is a string starting
+// with the prefix
+type PropertyStartsWith struct {
+	Key    string
+	Prefix string
+}
+
+func (p PropertyStartsWith) Eval(item PropertyGetter) bool {
+	return p.evalTernary(item) == ternaryTrue
+}
+
+func (p PropertyStartsWith) evalTernary(item PropertyGetter) ternary {
+	str, ok := getPredicateString(item, p.Key)
+	if !ok {
+		return ternaryUnknown
+	}
+	return ternaryOf(strings.HasPrefix(str, p.Prefix))
+}
+
+func (p PropertyStartsWith) String() string {
+	return fmt.Sprintf("%s STARTS WITH %q", p.Key, p.Prefix)
+}
+
+// PropertyMatches is true if the property is a string that matches
+// the regular expression. As in openCypher, the whole string must
+// match.
+type PropertyMatches struct {
+	Key    string
+	Regexp *regexp.Regexp
+}
+
+func (p PropertyMatches) Eval(item PropertyGetter) bool {
+	return p.evalTernary(item) == ternaryTrue
+}
+
+func (p PropertyMatches) evalTernary(item PropertyGetter) ternary {
+	str, ok := getPredicateString(item, p.Key)
+	if !ok {
+		return ternaryUnknown
+	}
+	loc := p.Regexp.FindStringIndex(str)
+	return ternaryOf(loc != nil && loc[0] == 0 && loc[1] == len(str))
+}
+
+func (p PropertyMatches) String() string {
+	return fmt.Sprintf("%s =~ %q", p.Key, p.Regexp.String())
+}
+
+// PropertyIsNull is true if the property does not exist, or if it is
+// nil
+type PropertyIsNull struct {
+	Key string
+}
+
+func (p PropertyIsNull) Eval(item PropertyGetter) bool {
+	_, ok := getPredicateValue(item, p.Key)
+	return !ok
+}
+
+func (p PropertyIsNull) String() string { return p.Key + " IS NULL" }
+
+// PropertyIsNotNull is true if the property exists, and it is not nil
+type PropertyIsNotNull struct {
+	Key string
+}
+
+func (p PropertyIsNotNull) Eval(item PropertyGetter) bool {
+	_, ok := getPredicateValue(item, p.Key)
+	return ok
+}
+
+func (p PropertyIsNotNull) String() string { return p.Key + " IS NOT NULL" }
+
+// AndPredicate is true if all its elements are true, and false if
+// one of its elements is false
+type AndPredicate []Predicate
+
+func (p AndPredicate) Eval(item PropertyGetter) bool {
+	return p.evalTernary(item) == ternaryTrue
+}
+
+func (p AndPredicate) evalTernary(item PropertyGetter) ternary {
+	return foldTernary(false, len(p), func(i int) ternary { return evalPredicate(p[i], item) })
+}
+
+func (p AndPredicate) String() string { return joinPredicates(p, " AND ") }
+
+// OrPredicate is true if at least one of its elements is true, and
+// false if all its elements are false
+type OrPredicate []Predicate
+
+func (p OrPredicate) Eval(item PropertyGetter) bool {
+	return p.evalTernary(item) == ternaryTrue
+}
+
+func (p OrPredicate) evalTernary(item PropertyGetter) ternary {
+	return foldTernary(true, len(p), func(i int) ternary { return evalPredicate(p[i], item) })
+}
+
+func (p OrPredicate) String() string { return joinPredicates(p, " OR ") }
+
+// NotPredicate negates the predicate. The negation of unknown is
+// unknown, so NOT (x = 1) is not true if x does not exist.
+type NotPredicate struct {
+	Predicate Predicate
+}
+
+func (p NotPredicate) Eval(item PropertyGetter) bool {
+	return p.evalTernary(item) == ternaryTrue
+}
+
+func (p NotPredicate) evalTernary(item PropertyGetter) ternary {
+	return evalPredicate(p.Predicate, item).not()
+}
+
+func (p NotPredicate) String() string { return fmt.Sprintf("NOT (%v)", p.Predicate) }
+
+func joinPredicates(p []Predicate, sep string) string {
+	elements := make([]string, 0, len(p))
+	for _, x := range p {
+		elements = append(elements, fmt.Sprintf("(%v)", x))
+	}
+	return strings.Join(elements, sep)
+}
+
+// indexCandidates returns an iterator over the candidate items that
+// may satisfy the predicate, using the property indexes. The iterator
+// may contain items that do not satisfy the predicate, but all items
+// that do are included. Returns nil if the predicate cannot be served
+// by an index.
+func indexCandidates[I Item](pred Predicate, indexes map[string]propertyIndex[I]) (Iterator, int) {
+	switch p := pred.(type) {
+	case PropertyCompare:
+		ix, ok := indexes[p.Key]
+		if !ok || p.Value == nil {
+			return nil, -1
+		}
+		if p.Op == OpEq {
+			itr := ix.findValue(p.Value)
+			return itr, itr.MaxSize()
+		}
+		key, ok := ix.kind.indexKey(p.Value)
+		if !ok {
+			return nil, -1
+		}
+		// Untyped index values are strings, so only string
+		// comparisons follow the index order
+		if _, str := p.Value.(string); ix.kind == AnyValue && !str {
+			return nil, -1
+		}
+		var r valueRange[string]
+		switch p.Op {
+		case OpLt, OpLe:
+			r = valueRange[string]{hi: &key, hiInclusive: p.Op == OpLe}
+		case OpGt, OpGe:
+			r = valueRange[string]{lo: &key, loInclusive: p.Op == OpGe}
+		default:
+			return nil, -1
+		}
+		itr, err := ix.scan(r, false)
+		if err != nil {
+			return nil, -1
+		}
+		// Values not of the kind of the index may be comparable
+		itr = withFallback(itr, ix.fallback(nil))
+		return itr, itr.MaxSize()
+
+	case PropertyStartsWith:
+		ix, ok := indexes[p.Key]
+		if !ok {
+			return nil, -1
+		}
+		r, err := ix.valueRange(p.Key, rangeSpec{prefix: &p.Prefix})
+		if err != nil {
+			return nil, -1
+		}
+		itr, err := ix.scan(r, false)
+		if err != nil {
+			return nil, -1
+		}
+		return itr, itr.MaxSize()
+
+	case PropertyIn:
+		ix, ok := indexes[p.Key]
+		if !ok {
+			return nil, -1
+		}
+		itrs := make([]Iterator, 0, len(p.Values))
+		size := 0
+		for _, v := range p.Values {
+			if v == nil {
+				continue
+			}
+			itr := ix.findValue(v)
+			itrs = append(itrs, itr)
+			size += itr.MaxSize()
+		}
+		return withSize(makeUniqueIterator(MultiIterator(itrs...)), size), size
+
+	case AndPredicate:
+		// Use the smallest of the conjuncts
+		var ret Iterator
+		max := -1
+		for _, x := range p {
+			itr, sz := indexCandidates(x, indexes)
+			if itr != nil && (max == -1 || sz < max) {
+				ret, max = itr, sz
+			}
+		}
+		return ret, max
+
+	case OrPredicate:
+		// All elements must be served by an index
+		itrs := make([]Iterator, 0, len(p))
+		size := 0
+		for _, x := range p {
+			itr, sz := indexCandidates(x, indexes)
+			if itr == nil {
+				return nil, -1
+			}
+			itrs = append(itrs, itr)
+			size += sz
+		}
+		return withSize(makeUniqueIterator(MultiIterator(itrs...)), size), size
+	}
+	return nil, -1
+}
+
+// A VariablePredicate is a condition that relates several variables
+// of a pattern, such as a.owner = b.id. The values of node variables
+// are *Node, and the values of edge variables are *Path.
+type VariablePredicate interface {
+	// Variables returns the names of the pattern variables the
+	// predicate refers to
+	Variables() []string
+	// EvalVariables evaluates the predicate. values contains a value
+	// for all the variables of the predicate
+	EvalVariables(values map[string]interface{}) bool
+}
+
+// ternaryVariablePredicate is implemented by the variable predicates
+// of this package that can be unknown
+type ternaryVariablePredicate interface {
+	evalVariablesTernary(values map[string]interface{}) ternary
+}
+
+// evalVariablePredicate evaluates the predicate in three-valued
+// logic
+func evalVariablePredicate(p VariablePredicate, values map[string]interface{}) ternary {
+	if t, ok := p.(ternaryVariablePredicate); ok {
+		return t.evalVariablesTernary(values)
+	}
+	return ternaryOf(p.EvalVariables(values))
+}
+
+// PropertyRef refers to a property of a pattern variable. If Key is
+// empty, it refers to the node or the edge itself.
+type PropertyRef struct {
+	Variable string
+	Key      string
+}
+
+func (r PropertyRef) String() string {
+	if len(r.Key) == 0 {
+		return r.Variable
+	}
+	return r.Variable + "." + r.Key
+}
+
+// getValue returns the referenced value. If the variable is an edge
+// variable, it must be bound to a single edge.
+func (r PropertyRef) getValue(values map[string]interface{}) (interface{}, bool) {
+	var item interface{}
+	switch v := values[r.Variable].(type) {
+	case *Node:
+		if v == nil {
+			return nil, false
+		}
+		item = v
+	case *Edge:
+		if v == nil {
+			return nil, false
+		}
+		item = v
+	case *Path:
+		if v == nil || v.NumEdges() != 1 {
+			return nil, false
+		}
+		item = v.GetEdge(0)
+	default:
+		return nil, false
+	}
+	if len(r.Key) == 0 {
+		return item, true
+	}
+	return getPredicateValue(item.(PropertyGetter), r.Key)
+}
+
+// VariableCompare compares two variables, or properties of two
+// variables. Variables themselves can only be compared using OpEq and
+// OpNe.
+type VariableCompare struct {
+	Left  PropertyRef
+	Op    CompareOp
+	Right PropertyRef
+}
+
+func (p VariableCompare) Variables() []string {
+	if p.Left.Variable == p.Right.Variable {
+		return []string{p.Left.Variable}
+	}
+	return []string{p.Left.Variable, p.Right.Variable}
+}
+
+func (p VariableCompare) EvalVariables(values map[string]interface{}) bool {
+	return p.evalVariablesTernary(values) == ternaryTrue
+}
+
+func (p VariableCompare) evalVariablesTernary(values map[string]interface{}) ternary {
+	left, ok := p.Left.getValue(values)
+	if !ok {
+		return ternaryUnknown
+	}
+	right, ok := p.Right.getValue(values)
+	if !ok {
+		return ternaryUnknown
+	}
+	if len(p.Left.Key) == 0 || len(p.Right.Key) == 0 {
+		switch p.Op {
+		case OpEq:
+			return ternaryOf(left == right)
+		case OpNe:
+			return ternaryOf(left != right)
+		}
+		return ternaryUnknown
+	}
+	cmp, ok := comparePredicateValues(left, right)
+	if !ok {
+		return ternaryUnknown
+	}
+	return ternaryOf(p.Op.test(cmp))
+}
+
+func (p VariableCompare) String() string {
+	return fmt.Sprintf("%v %s %v", p.Left, p.Op, p.Right)
+}
+
+// VariablePredicateFunc is a VariablePredicate that calls Func to
+// evaluate the predicate
+type VariablePredicateFunc struct {
+	Vars []string
+	Func func(values map[string]interface{}) bool
+}
+
+func (p VariablePredicateFunc) Variables() []string { return p.Vars }
+
+func (p VariablePredicateFunc) EvalVariables(values map[string]interface{}) bool {
+	return p.Func(values)
+}
@@ -0,0 +1,177 @@
+// Copyright 2021 Cloud Privacy Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lpg
+
+import (
+	"github.com/stretchr/testify/assert"
+	"regexp"
+	"testing"
+)
+
+func TestPredicates(t *testing.T) {
+	g := NewGraph()
+	node := g.NewNode(nil, map[string]interface{}{"n": 5, "s": "hello", "null": nil}, nil)
+	for _, tc := range []struct {
+		p        Predicate
+		expected bool
+	}{
+		{PropertyCompare{Key: "n", Op: OpEq, Value: 5}, true},
+		{PropertyCompare{Key: "n", Op: OpNe, Value: 5}, false},
+		{PropertyCompare{Key: "n", Op: OpLt, Value: 6}, true},
+		{PropertyCompare{Key: "n", Op: OpLe, Value: 5}, true},
+		{PropertyCompare{Key: "n", Op: OpGt, Value: 5}, false},
+		{PropertyCompare{Key: "n", Op: OpGe, Value: 4}, true},
+		{PropertyCompare{Key: "n", Op: OpEq, Value: "5"}, false},
+		{PropertyCompare{Key: "n", Op: OpNe, Value: "5"}, false},
+		{PropertyCompare{Key: "x", Op: OpNe, Value: 5}, false},
+		{PropertyCompare{Key: "s", Op: OpGt, Value: "abc"}, true},
+		{PropertyIn{Key: "n", Values: []interface{}{1, "x", 5}}, true},
+		{PropertyIn{Key: "n", Values: []interface{}{1, 2}}, false},
+		{PropertyStartsWith{Key: "s", Prefix: "he"}, true},
+		{PropertyStartsWith{Key: "s", Prefix: "lo"}, false},
+		{PropertyStartsWith{Key: "n", Prefix: "5"}, false},
+		{PropertyMatches{Key: "s", Regexp: regexp.MustCompile("h.*o")}, true},
+		{PropertyMatches{Key: "s", Regexp: regexp.MustCompile("ell")}, false},
+		{PropertyIsNull{Key: "null"}, true},
+		{PropertyIsNull{Key: "x"}, true},
+		{PropertyIsNull{Key: "n"}, false},
+		{PropertyIsNotNull{Key: "n"}, true},
+		{PropertyIsNotNull{Key: "null"}, false},
+		{AndPredicate{PropertyIsNotNull{Key: "n"}, PropertyStartsWith{Key: "s", Prefix: "h"}}, true},
+		{AndPredicate{PropertyIsNotNull{Key: "n"}, PropertyIsNotNull{Key: "x"}}, false},
+		{OrPredicate{PropertyIsNotNull{Key: "x"}, PropertyIsNotNull{Key: "n"}}, true},
+		{OrPredicate{}, false},
+		{NotPredicate{Predicate: PropertyIsNotNull{Key: "x"}}, true},
+		// Comparisons with missing or nil properties are unknown, and
+		// so are their negations
+		{NotPredicate{Predicate: PropertyCompare{Key: "x", Op: OpEq, Value: 5}}, false},
+		{NotPredicate{Predicate: PropertyCompare{Key: "null", Op: OpNe, Value: 5}}, false},
+		{NotPredicate{Predicate: PropertyIn{Key: "x", Values: []interface{}{5}}}, false},
+		{NotPredicate{Predicate: PropertyStartsWith{Key: "x", Prefix: "a"}}, false},
+		{NotPredicate{Predicate: PropertyIn{Key: "n", Values: []interface{}{1, nil}}}, false},
+		{NotPredicate{Predicate: PropertyIn{Key: "n", Values: []interface{}{1, 2}}}, true},
+		{NotPredicate{Predicate: AndPredicate{PropertyIsNull{Key: "n"}, PropertyCompare{Key: "x", Op: OpEq, Value: 5}}}, true},
+		{NotPredicate{Predicate: AndPredicate{PropertyIsNotNull{Key: "n"}, PropertyCompare{Key: "x", Op: OpEq, Value: 5}}}, false},
+		{NotPredicate{Predicate: OrPredicate{PropertyIsNull{Key: "n"}, PropertyCompare{Key: "x", Op: OpEq, Value: 5}}}, false},
+		{NotPredicate{Predicate: NotPredicate{Predicate: PropertyCompare{Key: "x", Op: OpEq, Value: 5}}}, false},
+	} {
+		assert.Equal(t, tc.expected, tc.p.Eval(node), "%v", tc.p)
+	}
+}
+
+func TestPredicatePattern(t *testing.T) {
+	graph, nodes := GetLineGraph(10, true)
+	for i, node := range nodes {
+		node.SetProperty("key", string(rune('a'+i)))
+		node.SetProperty("n", i)
+	}
+	pat := Pattern{
+		{},
+		{Min: 1, Max: 1},
+		{Name: "target", Where: PropertyIn{Key: "key", Values: []interface{}{"c", "d", "c"}}},
+	}
+	if _, i := pat.getFastestElement(graph, map[string]*PatternSymbol{}); i != 2 {
+		t.Errorf("Expecting 2, got %d", i)
+	}
+	acc, err := pat.FindPaths(graph, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, len(acc.Paths))
+
+	pat = Pattern{
+		{Name: "source", Where: AndPredicate{
+			PropertyCompare{Key: "n", Op: OpGe, Value: 3},
+			NotPredicate{Predicate: PropertyIn{Key: "key", Values: []interface{}{"e", "f"}}},
+		}},
+		{Min: 1, Max: 1},
+		{},
+	}
+	acc, err = pat.FindPaths(graph, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 3, 6, 7, 8
+	assert.Equal(t, 4, len(acc.Paths))
+
+	// Range and prefix predicates on strings use the B-tree index
+	pat = Pattern{
+		{},
+		{Min: 1, Max: 1},
+		{Name: "target", Where: PropertyCompare{Key: "key", Op: OpGt, Value: "h"}},
+	}
+	if _, i := pat.getFastestElement(graph, map[string]*PatternSymbol{}); i != 2 {
+		t.Errorf("Expecting 2, got %d", i)
+	}
+	acc, err = pat.FindPaths(graph, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, len(acc.Paths))
+	itr, sz := pat[2].estimateNodeSize(graph, nil)
+	assert.Equal(t, 2, sz)
+	assert.Equal(t, 2, len(NodeSlice(itr)))
+	_, sz = (&PatternItem{Where: PropertyStartsWith{Key: "key", Prefix: "c"}}).estimateNodeSize(graph, nil)
+	assert.Equal(t, 1, sz)
+
+	filter := GetNodeFilterFunc(NewStringSet("a"), nil, PropertyCompare{Key: "n", Op: OpLt, Value: 2})
+	assert.True(t, filter(nodes[1]))
+	assert.False(t, filter(nodes[2]))
+}
+
+func TestVariablePredicates(t *testing.T) {
+	graph, nodes := GetLineGraph(5, false)
+	for i, node := range nodes {
+		node.SetProperty("n", i)
+	}
+	evaluated := 0
+	pat := Pattern{
+		{Name: "a", Constraints: []VariablePredicate{
+			VariableCompare{Left: PropertyRef{Variable: "a", Key: "n"}, Op: OpLt, Right: PropertyRef{Variable: "c", Key: "n"}},
+		}},
+		{Min: 1, Max: 1},
+		{Name: "b", Constraints: []VariablePredicate{
+			VariablePredicateFunc{Vars: []string{"b"}, Func: func(values map[string]interface{}) bool {
+				evaluated++
+				n, _ := values["b"].(*Node).GetProperty("n")
+				return n.(int) != 2
+			}},
+		}},
+		{Min: 1, Max: -1, ToLeft: true},
+		{Name: "c"},
+	}
+	acc, err := pat.FindPaths(graph, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, symbols := range acc.Symbols {
+		a, _ := symbols["a"].(*Node).GetProperty("n")
+		b, _ := symbols["b"].(*Node).GetProperty("n")
+		c, _ := symbols["c"].(*Node).GetProperty("n")
+		assert.Less(t, a.(int), c.(int))
+		assert.NotEqual(t, 2, b)
+	}
+	// a -> b <-* c with a < c: c must come after b, but all edges go
+	// forward, so there are no matches
+	assert.Equal(t, 0, len(acc.Paths))
+	// The constraint on b is checked once for every binding of b, not
+	// for every complete match
+	assert.LessOrEqual(t, evaluated, 4)
+
+	pat = Pattern{{Name: "a"}, {Min: 1, Max: 1}, {Name: "b"}}
+	pat[0].Constraints = []VariablePredicate{VariableCompare{Left: PropertyRef{Variable: "a"}, Op: OpEq, Right: PropertyRef{Variable: "x"}}}
+	_, err = pat.FindPaths(graph, nil)
+	assert.Equal(t, ErrUnknownVariable("x"), err)
+}
@@ -16,7 +16,9 @@ package lpg
 
 import (
 	"fmt"
+	"math"
 	"strings"
+	"time"
 )
 
 // / swiss maps (at least the current lib) is not as efficient as built-in maps for small number of keys (like properties)
@@ -63,6 +65,10 @@ type WithNativeValue interface {
 //	[]string
 //	[]interface
 //
+// Integer and floating point values of any size are compared
+// numerically with each other. time.Time values and bool values
+// (false < true) can be compared with values of the same type.
+//
 // The []interface must have one of the supported types as its elements
 //
 // If one of the values implement GetNativeValue() method, then it is
@@ -206,9 +212,46 @@ func ComparePropertyValue(a, b interface{}) int {
 			return -ComparePropertyValue(v2, v1)
 		}
 	}
+	// Numbers of different types are compared by value
+	if i1, ok := intValue(a); ok {
+		if i2, ok := intValue(b); ok {
+			return compareOrdered(i1, i2)
+		}
+	}
+	if f1, ok := floatValue(a); ok {
+		if f2, ok := floatValue(b); ok && !math.IsNaN(f1) && !math.IsNaN(f2) {
+			return compareOrdered(f1, f2)
+		}
+	}
+	switch v1 := a.(type) {
+	case time.Time:
+		if v2, ok := b.(time.Time); ok {
+			return v1.Compare(v2)
+		}
+	case bool:
+		if v2, ok := b.(bool); ok {
+			if v1 == v2 {
+				return 0
+			}
+			if v2 {
+				return -1
+			}
+			return 1
+		}
+	}
 	panic(fmt.Sprintf("Incomparable values: %v (%T) vs %v (%T)", a, a, b, b))
 }
 
+func compareOrdered[T int64 | float64](a, b T) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
+
 func (p properties) String() string {
 	elements := make([]string, 0, len(p))
 	for k, v := range p {
@@ -0,0 +1,895 @@
+// Copyright 2021 Cloud Privacy Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lpg
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+// SnapshotVersion is the version of the binary snapshot format
+// written by BinarySnapshot
+const SnapshotVersion = 1
+
+var snapshotMagic = []byte("LPGS")
+
+// ErrSnapshotChecksum is returned if the snapshot checksum does not
+// match its contents
+var ErrSnapshotChecksum = errors.New("snapshot checksum mismatch")
+
+// ErrSnapshotFormat is returned if the input is not a snapshot, or
+// has an unsupported version
+type ErrSnapshotFormat string
+
+func (e ErrSnapshotFormat) Error() string {
+	return "Invalid snapshot: " + string(e)
+}
+
+// BinarySnapshot writes and reads a graph using a compact binary
+// format. The snapshot contains the nodes and edges with their
+// labels, contexts, and properties, the node and edge IDs, the
+// external IDs, and the declared property indexes and unique
+// constraints. Labels, contexts, and property keys are stored in a
+// string table. The snapshot ends with a CRC32 checksum of its
+// contents.
+//
+// Property values of the following types are supported: nil, bool,
+// int, int64, float64, string, time.Time, []string, []int,
+// []interface{}, and map[string]interface{}, where the elements of
+// slices and maps must also be one of the supported types. Other
+// values are written using PropertyMarshaler.
+type BinarySnapshot struct {
+	// PropertyMarshaler, if set, is used to encode property values of
+	// types that are not supported natively
+	PropertyMarshaler func(key string, value interface{}) ([]byte, error)
+
+	// PropertyUnmarshaler is used to decode property values written
+	// by PropertyMarshaler
+	PropertyUnmarshaler func(key string, data []byte) (interface{}, error)
+}
+
+// Property value tags
+const (
+	snapNil byte = iota
+	snapFalse
+	snapTrue
+	snapInt
+	snapInt64
+	snapFloat64
+	snapString
+	snapTime
+	snapStringSlice
+	snapIntSlice
+	snapSlice
+	snapMap
+	snapCustom
+)
+
+type snapshotWriter struct {
+	BinarySnapshot
+	strings   map[string]uint64
+	stringTbl []string
+	buf       bytes.Buffer
+	scratch   [binary.MaxVarintLen64]byte
+}
+
+func (w *snapshotWriter) uvarint(v uint64) {
+	n := binary.PutUvarint(w.scratch[:], v)
+	w.buf.Write(w.scratch[:n])
+}
+
+func (w *snapshotWriter) varint(v int64) {
+	n := binary.PutVarint(w.scratch[:], v)
+	w.buf.Write(w.scratch[:n])
+}
+
+func (w *snapshotWriter) bytes(b []byte) {
+	w.uvarint(uint64(len(b)))
+	w.buf.Write(b)
+}
+
+// str writes an interned string reference
+func (w *snapshotWriter) str(s string) {
+	ref, ok := w.strings[s]
+	if !ok {
+		ref = uint64(len(w.stringTbl))
+		w.strings[s] = ref
+		w.stringTbl = append(w.stringTbl, s)
+	}
+	w.uvarint(ref)
+}
+
+func (w *snapshotWriter) stringSet(s *StringSet) {
+	w.uvarint(uint64(s.Len()))
+	s.Iter(func(x string) bool {
+		w.str(x)
+		return false
+	})
+}
+
+func (w *snapshotWriter) value(key string, value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		w.buf.WriteByte(snapNil)
+	case bool:
+		if v {
+			w.buf.WriteByte(snapTrue)
+		} else {
+			w.buf.WriteByte(snapFalse)
+		}
+	case int:
+		w.buf.WriteByte(snapInt)
+		w.varint(int64(v))
+	case int64:
+		w.buf.WriteByte(snapInt64)
+		w.varint(v)
+	case float64:
+		w.buf.WriteByte(snapFloat64)
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(v))
+		w.buf.Write(b[:])
+	case string:
+		w.buf.WriteByte(snapString)
+		w.bytes([]byte(v))
+	case time.Time:
+		data, err := v.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		w.buf.WriteByte(snapTime)
+		w.bytes(data)
+	case []string:
+		w.buf.WriteByte(snapStringSlice)
+		w.uvarint(uint64(len(v)))
+		for _, x := range v {
+			w.bytes([]byte(x))
+		}
+	case []int:
+		w.buf.WriteByte(snapIntSlice)
+		w.uvarint(uint64(len(v)))
+		for _, x := range v {
+			w.varint(int64(x))
+		}
+	case []interface{}:
+		w.buf.WriteByte(snapSlice)
+		w.uvarint(uint64(len(v)))
+		for _, x := range v {
+			if err := w.value(key, x); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		w.buf.WriteByte(snapMap)
+		w.uvarint(uint64(len(v)))
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			w.bytes([]byte(k))
+			if err := w.value(key, v[k]); err != nil {
+				return err
+			}
+		}
+	default:
+		if w.PropertyMarshaler == nil {
+			return fmt.Errorf("cannot write property %s: unsupported type %T", key, value)
+		}
+		data, err := w.PropertyMarshaler(key, value)
+		if err != nil {
+			return err
+		}
+		w.buf.WriteByte(snapCustom)
+		w.bytes(data)
+	}
+	return nil
+}
+
+func (w *snapshotWriter) properties(p properties) error {
+	w.uvarint(uint64(len(p)))
+	for k, v := range p {
+		w.str(k)
+		if err := w.value(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writePropertyIndexes[I Item](w *snapshotWriter, indexes map[string]propertyIndex[I]) {
+	keys := make([]string, 0, len(indexes))
+	for k := range indexes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	w.uvarint(uint64(len(keys)))
+	for _, k := range keys {
+		w.str(k)
+		w.buf.WriteByte(byte(indexes[k].indexType()))
+		w.buf.WriteByte(byte(indexes[k].kind))
+	}
+}
+
+func writeCompositeIndexes[I Item](w *snapshotWriter, indexes []*compositeIndex[I]) {
+	w.uvarint(uint64(len(indexes)))
+	for _, ix := range indexes {
+		w.buf.WriteByte(byte(ix.indexType()))
+		w.uvarint(uint64(len(ix.keys)))
+		for _, k := range ix.keys {
+			w.str(k)
+		}
+	}
+}
+
+func writeUniqueConstraints(w *snapshotWriter, constraints []*uniqueConstraint) {
+	w.uvarint(uint64(len(constraints)))
+	for _, u := range constraints {
+		w.str(u.label)
+		w.uvarint(uint64(len(u.keys)))
+		for _, k := range u.keys {
+			w.str(k)
+		}
+	}
+}
+
+func writeExternalIDs[I Item](w *snapshotWriter, e *externalIDs[I]) {
+	ids := make([]int, 0, len(e.items))
+	e.ids.ForEach(func(id int, _ string) bool {
+		ids = append(ids, id)
+		return true
+	})
+	sort.Ints(ids)
+	w.uvarint(uint64(len(ids)))
+	for _, id := range ids {
+		w.uvarint(uint64(id))
+		w.bytes([]byte(e.of(id)))
+	}
+}
+
+// Encode writes a snapshot of the graph
+func (s BinarySnapshot) Encode(g *Graph, out io.Writer) error {
+	w := &snapshotWriter{
+		BinarySnapshot: s,
+		strings:        make(map[string]uint64),
+	}
+	w.uvarint(uint64(g.idBase))
+	writePropertyIndexes(w, g.index.nodeProperties)
+	writePropertyIndexes(w, g.index.edgeProperties)
+	writeCompositeIndexes(w, g.index.nodeComposites)
+	writeCompositeIndexes(w, g.index.edgeComposites)
+	writeUniqueConstraints(w, g.index.uniqueConstraints)
+
+	w.uvarint(uint64(g.NumNodes()))
+	for nodes := g.GetNodes(); nodes.Next(); {
+		node := nodes.Node()
+		w.uvarint(uint64(node.id))
+		w.stringSet(node.labels)
+		w.stringSet(node.contexts)
+		if err := w.properties(node.properties); err != nil {
+			return err
+		}
+	}
+	w.uvarint(uint64(g.NumEdges()))
+	for edges := g.GetEdges(); edges.Next(); {
+		edge := edges.Edge()
+		w.uvarint(uint64(edge.id))
+		w.uvarint(uint64(edge.from.id))
+		w.uvarint(uint64(edge.to.id))
+		w.str(edge.label)
+		w.stringSet(edge.contexts)
+		if err := w.properties(edge.properties); err != nil {
+			return err
+		}
+	}
+	writeExternalIDs(w, &g.index.nodeExternalIDs)
+	writeExternalIDs(w, &g.index.edgeExternalIDs)
+
+	// Write header, string table, and the body
+	crc := crc32.NewIEEE()
+	bw := bufio.NewWriter(io.MultiWriter(out, crc))
+	header := snapshotWriter{}
+	header.buf.Write(snapshotMagic)
+	header.uvarint(SnapshotVersion)
+	header.uvarint(uint64(len(w.stringTbl)))
+	for _, x := range w.stringTbl {
+		header.bytes([]byte(x))
+	}
+	if _, err := bw.Write(header.buf.Bytes()); err != nil {
+		return err
+	}
+	if _, err := bw.Write(w.buf.Bytes()); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	var sum [4]byte
+	binary.LittleEndian.PutUint32(sum[:], crc.Sum32())
+	_, err := out.Write(sum[:])
+	return err
+}
+
+// snapshotReader reads from the input while computing the checksum
+type snapshotReader struct {
+	BinarySnapshot
+	in        *bufio.Reader
+	crc       hash.Hash32
+	stringTbl []string
+}
+
+func (r *snapshotReader) ReadByte() (byte, error) {
+	b, err := r.in.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	r.crc.Write([]byte{b})
+	return b, nil
+}
+
+func (r *snapshotReader) read(b []byte) error {
+	if _, err := io.ReadFull(r.in, b); err != nil {
+		return err
+	}
+	r.crc.Write(b)
+	return nil
+}
+
+func (r *snapshotReader) uvarint() (uint64, error) {
+	return binary.ReadUvarint(r)
+}
+
+func (r *snapshotReader) int() (int, error) {
+	v, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, err
+	}
+	if v > math.MaxInt {
+		return 0, ErrSnapshotFormat("value out of range")
+	}
+	return int(v), nil
+}
+
+func (r *snapshotReader) bytes() ([]byte, error) {
+	n, err := r.int()
+	if err != nil {
+		return nil, err
+	}
+	// Read in chunks so a corrupt length does not allocate a huge buffer
+	var buf bytes.Buffer
+	chunk := make([]byte, min(n, 4096))
+	for n > 0 {
+		c := chunk[:min(n, len(chunk))]
+		if err := r.read(c); err != nil {
+			return nil, err
+		}
+		buf.Write(c)
+		n -= len(c)
+	}
+	return buf.Bytes(), nil
+}
+
+func (r *snapshotReader) str() (string, error) {
+	ref, err := r.uvarint()
+	if err != nil {
+		return "", err
+	}
+	if ref >= uint64(len(r.stringTbl)) {
+		return "", ErrSnapshotFormat("invalid string reference")
+	}
+	return r.stringTbl[ref], nil
+}
+
+func (r *snapshotReader) stringSet() (*StringSet, error) {
+	n, err := r.int()
+	if err != nil {
+		return nil, err
+	}
+	ret := NewStringSet()
+	for i := 0; i < n; i++ {
+		s, err := r.str()
+		if err != nil {
+			return nil, err
+		}
+		ret.Add(s)
+	}
+	return ret, nil
+}
+
+func (r *snapshotReader) value(key string) (interface{}, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch tag {
+	case snapNil:
+		return nil, nil
+	case snapFalse:
+		return false, nil
+	case snapTrue:
+		return true, nil
+	case snapInt:
+		v, err := binary.ReadVarint(r)
+		return int(v), err
+	case snapInt64:
+		return binary.ReadVarint(r)
+	case snapFloat64:
+		var b [8]byte
+		if err := r.read(b[:]); err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b[:])), nil
+	case snapString:
+		b, err := r.bytes()
+		return string(b), err
+	case snapTime:
+		b, err := r.bytes()
+		if err != nil {
+			return nil, err
+		}
+		var t time.Time
+		err = t.UnmarshalBinary(b)
+		return t, err
+	case snapStringSlice:
+		n, err := r.int()
+		if err != nil {
+			return nil, err
+		}
+		ret := make([]string, 0, min(n, 1024))
+		for i := 0; i < n; i++ {
+			b, err := r.bytes()
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, string(b))
+		}
+		return ret, nil
+	case snapIntSlice:
+		n, err := r.int()
+		if err != nil {
+			return nil, err
+		}
+		ret := make([]int, 0, min(n, 1024))
+		for i := 0; i < n; i++ {
+			v, err := binary.ReadVarint(r)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, int(v))
+		}
+		return ret, nil
+	case snapSlice:
+		n, err := r.int()
+		if err != nil {
+			return nil, err
+		}
+		ret := make([]interface{}, 0, min(n, 1024))
+		for i := 0; i < n; i++ {
+			v, err := r.value(key)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, v)
+		}
+		return ret, nil
+	case snapMap:
+		n, err := r.int()
+		if err != nil {
+			return nil, err
+		}
+		ret := make(map[string]interface{}, min(n, 1024))
+		for i := 0; i < n; i++ {
+			k, err := r.bytes()
+			if err != nil {
+				return nil, err
+			}
+			v, err := r.value(key)
+			if err != nil {
+				return nil, err
+			}
+			ret[string(k)] = v
+		}
+		return ret, nil
+	case snapCustom:
+		b, err := r.bytes()
+		if err != nil {
+			return nil, err
+		}
+		if r.PropertyUnmarshaler == nil {
+			return nil, fmt.Errorf("cannot read property %s: no property unmarshaler", key)
+		}
+		return r.PropertyUnmarshaler(key, b)
+	}
+	return nil, ErrSnapshotFormat(fmt.Sprintf("unknown value tag %d", tag))
+}
+
+func (r *snapshotReader) properties() (properties, error) {
+	n, err := r.int()
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	ret := make(properties, min(n, 1024))
+	for i := 0; i < n; i++ {
+		k, err := r.str()
+		if err != nil {
+			return nil, err
+		}
+		v, err := r.value(k)
+		if err != nil {
+			return nil, err
+		}
+		ret[k] = v
+	}
+	return ret, nil
+}
+
+type snapshotIndex struct {
+	key  string
+	ix   IndexType
+	kind ValueKind
+}
+
+func (r *snapshotReader) propertyIndexes() ([]snapshotIndex, error) {
+	n, err := r.int()
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]snapshotIndex, 0, min(n, 1024))
+	for i := 0; i < n; i++ {
+		k, err := r.str()
+		if err != nil {
+			return nil, err
+		}
+		t, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		kind := ValueKind(b)
+		if kind > TimeValue {
+			return nil, ErrSnapshotFormat(fmt.Sprintf("unknown value kind %d", b))
+		}
+		ret = append(ret, snapshotIndex{key: k, ix: IndexType(t), kind: kind})
+	}
+	return ret, nil
+}
+
+// snapshotComposite is a composite index, or a unique constraint
+// if label is set
+type snapshotComposite struct {
+	label string
+	keys  []string
+	ix    IndexType
+}
+
+func (r *snapshotReader) compositeIndexes() ([]snapshotComposite, error) {
+	n, err := r.int()
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]snapshotComposite, 0, min(n, 1024))
+	for i := 0; i < n; i++ {
+		t, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		nKeys, err := r.int()
+		if err != nil {
+			return nil, err
+		}
+		keys := make([]string, 0, min(nKeys, 1024))
+		for j := 0; j < nKeys; j++ {
+			k, err := r.str()
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, k)
+		}
+		ret = append(ret, snapshotComposite{keys: keys, ix: IndexType(t)})
+	}
+	return ret, nil
+}
+
+func (r *snapshotReader) uniqueConstraints() ([]snapshotComposite, error) {
+	n, err := r.int()
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]snapshotComposite, 0, min(n, 1024))
+	for i := 0; i < n; i++ {
+		label, err := r.str()
+		if err != nil {
+			return nil, err
+		}
+		nKeys, err := r.int()
+		if err != nil {
+			return nil, err
+		}
+		keys := make([]string, 0, min(nKeys, 1024))
+		for j := 0; j < nKeys; j++ {
+			k, err := r.str()
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, k)
+		}
+		ret = append(ret, snapshotComposite{label: label, keys: keys})
+	}
+	return ret, nil
+}
+
+type snapshotExternalID struct {
+	id       int
+	external string
+}
+
+func (r *snapshotReader) externalIDs() ([]snapshotExternalID, error) {
+	n, err := r.int()
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]snapshotExternalID, 0, min(n, 1024))
+	for i := 0; i < n; i++ {
+		id, err := r.int()
+		if err != nil {
+			return nil, err
+		}
+		b, err := r.bytes()
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, snapshotExternalID{id: id, external: string(b)})
+	}
+	return ret, nil
+}
+
+// Decode reads a snapshot and returns a new graph. The node and edge
+// IDs of the new graph are the same as the graph the snapshot is
+// taken from. If the snapshot is corrupt, returns
+// ErrSnapshotChecksum or ErrSnapshotFormat.
+func (s BinarySnapshot) Decode(in io.Reader) (*Graph, error) {
+	r := &snapshotReader{
+		BinarySnapshot: s,
+		in:             bufio.NewReader(in),
+		crc:            crc32.NewIEEE(),
+	}
+	g, err := r.readGraph()
+	if err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, ErrSnapshotFormat("unexpected end of input")
+		}
+		return nil, err
+	}
+	return g, nil
+}
+
+func (r *snapshotReader) readGraph() (*Graph, error) {
+	var magic [4]byte
+	if err := r.read(magic[:]); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(magic[:], snapshotMagic) {
+		return nil, ErrSnapshotFormat("bad magic number")
+	}
+	version, err := r.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	if version != SnapshotVersion {
+		return nil, ErrSnapshotFormat(fmt.Sprintf("unsupported version %d", version))
+	}
+	nStrings, err := r.int()
+	if err != nil {
+		return nil, err
+	}
+	r.stringTbl = make([]string, 0, min(nStrings, 1024))
+	for i := 0; i < nStrings; i++ {
+		b, err := r.bytes()
+		if err != nil {
+			return nil, err
+		}
+		r.stringTbl = append(r.stringTbl, string(b))
+	}
+
+	g := NewGraph()
+	idBase, err := r.int()
+	if err != nil {
+		return nil, err
+	}
+	g.idBase = idBase
+	nodeIndexes, err := r.propertyIndexes()
+	if err != nil {
+		return nil, err
+	}
+	edgeIndexes, err := r.propertyIndexes()
+	if err != nil {
+		return nil, err
+	}
+	nodeComposites, err := r.compositeIndexes()
+	if err != nil {
+		return nil, err
+	}
+	edgeComposites, err := r.compositeIndexes()
+	if err != nil {
+		return nil, err
+	}
+	constraints, err := r.uniqueConstraints()
+	if err != nil {
+		return nil, err
+	}
+	// Declare the indexes while the graph is empty. They are built
+	// after all nodes and edges are read
+	for _, ix := range nodeIndexes {
+		if err := g.index.TypedNodePropertyIndex(ix.key, g, ix.ix, ix.kind); err != nil {
+			return nil, ErrSnapshotFormat(err.Error())
+		}
+	}
+	for _, ix := range edgeIndexes {
+		if err := g.index.TypedEdgePropertyIndex(ix.key, g, ix.ix, ix.kind); err != nil {
+			return nil, ErrSnapshotFormat(err.Error())
+		}
+	}
+	for _, ix := range nodeComposites {
+		if err := g.index.CompositeNodeIndex(ix.keys, g, ix.ix); err != nil {
+			return nil, ErrSnapshotFormat(err.Error())
+		}
+	}
+	for _, ix := range edgeComposites {
+		if err := g.index.CompositeEdgeIndex(ix.keys, g, ix.ix); err != nil {
+			return nil, ErrSnapshotFormat(err.Error())
+		}
+	}
+	for _, u := range constraints {
+		if err := g.index.UniqueConstraint(u.label, u.keys, g); err != nil {
+			return nil, ErrSnapshotFormat(err.Error())
+		}
+	}
+
+	// Nodes and edges share the ID space, and all IDs are below
+	// idBase
+	ids := make(map[int]struct{})
+	checkID := func(kind string, id int) error {
+		if id < 0 || id >= idBase {
+			return ErrSnapshotFormat(fmt.Sprintf("%s id %d out of range", kind, id))
+		}
+		if _, exists := ids[id]; exists {
+			return ErrSnapshotFormat(fmt.Sprintf("duplicate %s id %d", kind, id))
+		}
+		ids[id] = struct{}{}
+		return nil
+	}
+	nNodes, err := r.int()
+	if err != nil {
+		return nil, err
+	}
+	nodeMap := make(map[int]*Node, min(nNodes, 1<<20))
+	for i := 0; i < nNodes; i++ {
+		node := &Node{graph: g}
+		if node.id, err = r.int(); err != nil {
+			return nil, err
+		}
+		if node.labels, err = r.stringSet(); err != nil {
+			return nil, err
+		}
+		if node.contexts, err = r.stringSet(); err != nil {
+			return nil, err
+		}
+		if node.properties, err = r.properties(); err != nil {
+			return nil, err
+		}
+		if err := checkID("node", node.id); err != nil {
+			return nil, err
+		}
+		nodeMap[node.id] = node
+		g.allNodes.add(node)
+	}
+	nEdges, err := r.int()
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < nEdges; i++ {
+		edge := &Edge{}
+		if edge.id, err = r.int(); err != nil {
+			return nil, err
+		}
+		if err := checkID("edge", edge.id); err != nil {
+			return nil, err
+		}
+		from, err := r.int()
+		if err != nil {
+			return nil, err
+		}
+		to, err := r.int()
+		if err != nil {
+			return nil, err
+		}
+		if edge.from = nodeMap[from]; edge.from == nil {
+			return nil, ErrSnapshotFormat(fmt.Sprintf("edge %d refers to unknown node %d", edge.id, from))
+		}
+		if edge.to = nodeMap[to]; edge.to == nil {
+			return nil, ErrSnapshotFormat(fmt.Sprintf("edge %d refers to unknown node %d", edge.id, to))
+		}
+		if edge.label, err = r.str(); err != nil {
+			return nil, err
+		}
+		if edge.contexts, err = r.stringSet(); err != nil {
+			return nil, err
+		}
+		if edge.properties, err = r.properties(); err != nil {
+			return nil, err
+		}
+		g.allEdges.add(edge, 0)
+		g.connect(edge)
+	}
+	nodeExternalIDs, err := r.externalIDs()
+	if err != nil {
+		return nil, err
+	}
+	edgeExternalIDs, err := r.externalIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	expected := r.crc.Sum32()
+	var sum [4]byte
+	if _, err := io.ReadFull(r.in, sum[:]); err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(sum[:]) != expected {
+		return nil, ErrSnapshotChecksum
+	}
+
+	// Build all indexes in one pass
+	for nodes := g.GetNodes(); nodes.Next(); {
+		g.index.addNodeToIndex(nodes.Node())
+	}
+	for edges := g.GetEdges(); edges.Next(); {
+		g.index.addEdgeToIndex(edges.Edge())
+	}
+	for _, x := range nodeExternalIDs {
+		node := g.GetNode(x.id)
+		if node == nil {
+			return nil, ErrSnapshotFormat(fmt.Sprintf("external ID %s refers to unknown node %d", x.external, x.id))
+		}
+		if err := node.SetExternalID(x.external); err != nil {
+			return nil, ErrSnapshotFormat(err.Error())
+		}
+	}
+	for _, x := range edgeExternalIDs {
+		edge := g.GetEdge(x.id)
+		if edge == nil {
+			return nil, ErrSnapshotFormat(fmt.Sprintf("external ID %s refers to unknown edge %d", x.external, x.id))
+		}
+		if err := edge.SetExternalID(x.external); err != nil {
+			return nil, ErrSnapshotFormat(err.Error())
+		}
+	}
+	return g, nil
+}
@@ -0,0 +1,157 @@
+// Copyright 2021 Cloud Privacy Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lpg
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	g := getJSONTestGraph()
+	g.AddNodePropertyIndex("str", HashIndex)
+	g.AddEdgePropertyIndex("name", BtreeIndex)
+	g.AddTypedEdgePropertyIndex("big", BtreeIndex, IntValue)
+	g.AddCompositeNodeIndex([]string{"str", "key"}, HashIndex)
+	nodes := NodeSlice(g.GetNodes())
+	nodes[0].SetProperty("values", []interface{}{1, "a", 1.5, map[string]interface{}{"x": true}})
+	nodes[1].SetProperty("time", time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC))
+	nodes[2].SetProperty("list", []string{"a", "b"})
+	nodes[3].SetProperty("ints", []int{1, 2})
+	nodes[4].SetProperty("nil", nil)
+	edge := g.NewEdge(nodes[0], nodes[2], "named", map[string]interface{}{"name": "x", "big": int64(1 << 40)}, nil)
+	nodes[1].DetachAndRemove()
+
+	buf := bytes.Buffer{}
+	if err := (BinarySnapshot{}).Encode(g, &buf); err != nil {
+		t.Fatal(err)
+	}
+	target, err := BinarySnapshot{}.Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkJSONIsomorphism(t, g, target)
+	assert.Equal(t, g.idBase, target.idBase)
+	assert.Equal(t, HashIndex, target.index.nodeProperties["str"].indexType())
+	assert.Equal(t, BtreeIndex, target.index.edgeProperties["name"].indexType())
+	assert.Equal(t, IntValue, target.index.edgeProperties["big"].kind)
+	assert.Equal(t, 1, len(target.index.nodeComposites))
+	assert.Equal(t, []string{"str", "key"}, target.index.nodeComposites[0].keys)
+	eitr, err := target.GetEdgesWithPropertyRange("big", 1<<39, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(EdgeSlice(eitr)))
+
+	itr, err := target.FindNodes(nil, map[string]interface{}{"str": "value"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 4, len(NodeSlice(itr)))
+	eitr, err = target.FindEdges("", map[string]interface{}{"name": "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	edges := EdgeSlice(eitr)
+	assert.Equal(t, 1, len(edges))
+	assert.Equal(t, edge.GetID(), edges[0].GetID())
+	assert.Equal(t, edge.GetFrom().GetID(), edges[0].GetFrom().GetID())
+}
+
+func TestSnapshotCorrupt(t *testing.T) {
+	g := getJSONTestGraph()
+	buf := bytes.Buffer{}
+	if err := (BinarySnapshot{}).Encode(g, &buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	corrupt := append([]byte{}, data...)
+	corrupt[len(corrupt)/2] ^= 0x01
+	_, err := BinarySnapshot{}.Decode(bytes.NewReader(corrupt))
+	assert.Error(t, err)
+
+	corrupt = append([]byte{}, data...)
+	corrupt[len(corrupt)-1] ^= 0x01
+	_, err = BinarySnapshot{}.Decode(bytes.NewReader(corrupt))
+	assert.True(t, errors.Is(err, ErrSnapshotChecksum))
+
+	_, err = BinarySnapshot{}.Decode(bytes.NewReader(data[:len(data)-10]))
+	assert.Error(t, err)
+
+	_, err = BinarySnapshot{}.Decode(bytes.NewReader([]byte("not a snapshot")))
+	var ferr ErrSnapshotFormat
+	assert.True(t, errors.As(err, &ferr))
+}
+
+func TestSnapshotInvalidIDs(t *testing.T) {
+	decode := func(g *Graph) error {
+		buf := bytes.Buffer{}
+		if err := (BinarySnapshot{}).Encode(g, &buf); err != nil {
+			t.Fatal(err)
+		}
+		_, err := BinarySnapshot{}.Decode(&buf)
+		return err
+	}
+	var ferr ErrSnapshotFormat
+	g := getJSONTestGraph()
+	assert.Nil(t, decode(g))
+	edges := EdgeSlice(g.GetEdges())
+	edges[1].id = edges[0].id
+	assert.True(t, errors.As(decode(g), &ferr))
+	assert.Contains(t, string(ferr), "duplicate edge id")
+
+	// Nodes and edges share the ID space
+	g = getJSONTestGraph()
+	edges = EdgeSlice(g.GetEdges())
+	edges[0].id = NodeSlice(g.GetNodes())[0].id
+	assert.True(t, errors.As(decode(g), &ferr))
+
+	g = getJSONTestGraph()
+	g.idBase--
+	assert.True(t, errors.As(decode(g), &ferr))
+	assert.Contains(t, string(ferr), "out of range")
+}
+
+type snapshotCustomValue struct{ s string }
+
+func TestSnapshotCustomProperty(t *testing.T) {
+	g := NewGraph()
+	g.NewNode([]string{"a"}, map[string]interface{}{"custom": snapshotCustomValue{s: "x"}}, nil)
+	buf := bytes.Buffer{}
+	assert.Error(t, (BinarySnapshot{}).Encode(g, &buf))
+
+	s := BinarySnapshot{
+		PropertyMarshaler: func(key string, value interface{}) ([]byte, error) {
+			return []byte(value.(snapshotCustomValue).s), nil
+		},
+		PropertyUnmarshaler: func(key string, data []byte) (interface{}, error) {
+			return snapshotCustomValue{s: string(data)}, nil
+		},
+	}
+	buf.Reset()
+	if err := s.Encode(g, &buf); err != nil {
+		t.Fatal(err)
+	}
+	target, err := s.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, _ := NodeSlice(target.GetNodes())[0].GetProperty("custom")
+	assert.Equal(t, snapshotCustomValue{s: "x"}, v)
+}
@@ -208,7 +208,7 @@ func (set *StringSet) Replace(other *StringSet, handleRemoved, handleAdded func(
 		if !set.M.has(current.Value.(string)) {
 			handleAdded(current.Value.(string))
 		}
-		newSet.add(current.Value.(string), true)
+		newSet.add(current.Value.(string), current.Value.(string))
 		current = current.Next()
 	}
 	set.M = newSet
@@ -31,6 +31,9 @@ func TestStringSet_Replace(t *testing.T) {
 	assert.False(t, set.Has("b"))
 	assert.Equal(t, 3, added)
 	assert.Equal(t, 2, removed)
+	// The replaced set can be replaced again
+	set.Replace(NewStringSet("a"), func(string) {}, func(string) {})
+	assert.Equal(t, []string{"a"}, set.Slice())
 }
 
 func TestStringSet_Iteractor(t *testing.T) {
@@ -0,0 +1,152 @@
+// Copyright 2021 Cloud Privacy Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lpg
+
+import (
+	"sync"
+)
+
+// SyncGraph is a graph that can be used from multiple
+// goroutines. Many goroutines can read the graph at the same time,
+// and a goroutine modifying the graph has exclusive access to it.
+//
+// The underlying graph must only be accessed through the SyncGraph
+// once it is wrapped. Nodes and edges obtained from the SyncGraph can
+// be kept, but their labels, properties, and edges must only be read
+// inside View or Update.
+type SyncGraph struct {
+	mu    sync.RWMutex
+	graph *Graph
+}
+
+// NewSyncGraph returns a SyncGraph for the graph
+func NewSyncGraph(g *Graph) *SyncGraph {
+	return &SyncGraph{graph: g}
+}
+
+// View calls f with the graph while holding the read lock. Other
+// readers can run at the same time, but no changes are made to the
+// graph until f returns, so all iterators and queries in f see the
+// same state of the graph. f must not modify the graph.
+func (s *SyncGraph) View(f func(*Graph) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return f(s.graph)
+}
+
+// Update calls f with the graph while holding the write lock. f runs
+// in a transaction. If f returns an error or panics, the changes made
+// by f are rolled back.
+func (s *SyncGraph) Update(f func(*Graph) error) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tx := s.graph.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+			panic(r)
+		}
+	}()
+	if err = f(s.graph); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Snapshot returns a read-only graph with the nodes and edges of the
+// graph as they are now. Taking a snapshot does not copy the
+// graph. The snapshot shares the nodes and edges with the graph, and
+// the graph keeps the previous state of a node or edge when it is
+// modified while there are snapshots that see it.
+//
+// The snapshot supports the read operations of a graph, including
+// pattern queries, and keeps returning the same results regardless of
+// later updates. It can be read from any goroutine without View, and
+// its iterators can be used while Update changes the graph. The
+// snapshot must not be read in View or Update. The nodes and edges
+// returned by the snapshot belong to the snapshot. Modifying the
+// snapshot, its nodes, or its edges panics with
+// ErrSnapshotReadOnly. Use CopyGraph to get a modifiable copy of a
+// snapshot.
+//
+// Snapshot takes the write lock, so it must not be called in View or
+// Update. Use Graph.Snapshot in Update for a snapshot that includes
+// the uncommitted changes of the transaction. Call Release when the
+// snapshot is no longer needed.
+func (s *SyncGraph) Snapshot() *Graph {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.graph.snapshot(&s.mu)
+}
+
+// GetNodes returns an iterator over the nodes of the graph at the
+// time of the call. The iterator is not affected by later changes.
+func (s *SyncGraph) GetNodes() NodeIterator {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return nodeIterator{newSliceIterator(NodeSlice(s.graph.GetNodes()))}
+}
+
+// GetEdges returns an iterator over the edges of the graph at the
+// time of the call. The iterator is not affected by later changes.
+func (s *SyncGraph) GetEdges() EdgeIterator {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return edgeIterator{newSliceIterator(EdgeSlice(s.graph.GetEdges()))}
+}
+
+// FindNodes returns an iterator over the nodes found by
+// Graph.FindNodes at the time of the call. The iterator is not
+// affected by later changes.
+func (s *SyncGraph) FindNodes(allLabels *StringSet, properties map[string]interface{}) (NodeIterator, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	itr, err := s.graph.FindNodes(allLabels, properties)
+	if err != nil {
+		return nil, err
+	}
+	return nodeIterator{newSliceIterator(NodeSlice(itr))}, nil
+}
+
+// FindEdges returns an iterator over the edges found by
+// Graph.FindEdges at the time of the call. The iterator is not
+// affected by later changes.
+func (s *SyncGraph) FindEdges(label string, properties map[string]interface{}) (EdgeIterator, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	itr, err := s.graph.FindEdges(label, properties)
+	if err != nil {
+		return nil, err
+	}
+	return edgeIterator{newSliceIterator(EdgeSlice(itr))}, nil
+}
+
+// RunPattern runs the pattern while holding the read lock. The
+// accumulator is called with the read lock held, so it must not
+// modify the graph.
+func (s *SyncGraph) RunPattern(pattern Pattern, symbols map[string]*PatternSymbol, result MatchAccumulator) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return pattern.Run(s.graph, symbols, result)
+}
+
+// FindPaths runs the pattern while holding the read lock, and returns
+// the matching paths
+func (s *SyncGraph) FindPaths(pattern Pattern, symbols map[string]*PatternSymbol) (DefaultMatchAccumulator, error) {
+	acc := DefaultMatchAccumulator{}
+	err := s.RunPattern(pattern, symbols, &acc)
+	return acc, err
+}
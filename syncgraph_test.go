@@ -0,0 +1,106 @@
+// Copyright 2021 Cloud Privacy Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lpg
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+// Run with -race
+func TestSyncGraphConcurrent(t *testing.T) {
+	g := NewGraph()
+	g.AddNodePropertyIndex("key", HashIndex)
+	s := NewSyncGraph(g)
+	const writers, batches, batchSize = 2, 50, 10
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for b := 0; b < batches; b++ {
+				assert.Nil(t, s.Update(func(g *Graph) error {
+					// Each batch adds a chain, so queries see complete chains
+					prev := g.NewNode([]string{"head"}, map[string]interface{}{"key": w}, nil)
+					for i := 1; i < batchSize; i++ {
+						node := g.NewNode([]string{"item"}, map[string]interface{}{"key": w}, nil)
+						g.NewEdge(prev, node, "next", nil, nil)
+						prev = node
+					}
+					return nil
+				}))
+			}
+		}(w)
+	}
+	pattern := Pattern{
+		{Labels: NewStringSet("head")},
+		{Min: 1, Max: -1},
+		{Labels: NewStringSet("item")},
+	}
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				acc, err := s.FindPaths(pattern, map[string]*PatternSymbol{})
+				assert.Nil(t, err)
+				assert.Equal(t, 0, len(acc.Paths)%(batchSize-1))
+				nodes := NodeSlice(s.GetNodes())
+				assert.Equal(t, 0, len(nodes)%batchSize)
+				assert.Nil(t, s.View(func(g *Graph) error {
+					for _, node := range nodes {
+						node.GetProperty("key")
+					}
+					itr, err := g.FindNodes(nil, map[string]interface{}{"key": 0})
+					if err != nil {
+						return err
+					}
+					if len(NodeSlice(itr))%batchSize != 0 {
+						return errors.New("incomplete batch")
+					}
+					return nil
+				}))
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, writers*batches*batchSize, g.NumNodes())
+	assert.Nil(t, g.Verify())
+}
+
+func TestSyncGraphUpdateRollback(t *testing.T) {
+	s := NewSyncGraph(NewGraph())
+	fail := errors.New("fail")
+	assert.Equal(t, fail, s.Update(func(g *Graph) error {
+		g.NewNode(nil, nil, nil)
+		return fail
+	}))
+	assert.Panics(t, func() {
+		s.Update(func(g *Graph) error {
+			g.NewNode(nil, nil, nil)
+			panic("fail")
+		})
+	})
+	assert.Equal(t, 0, len(NodeSlice(s.GetNodes())))
+	// The lock is released after a panic
+	assert.Nil(t, s.Update(func(g *Graph) error {
+		g.NewNode(nil, nil, nil)
+		return nil
+	}))
+	assert.Equal(t, 1, len(NodeSlice(s.GetNodes())))
+}
@@ -0,0 +1,220 @@
+// Copyright 2021 Cloud Privacy Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lpg
+
+// TopologicalSort returns the nodes of the graph ordered so that the
+// source of every edge comes before its target. Only the edges with
+// one of the labels are considered, or all edges if labels is
+// empty. If the graph has a cycle, returns nil and the path of a
+// cycle.
+func TopologicalSort(graph *Graph, labels *StringSet) ([]*Node, *Path) {
+	inDegree := make(map[*Node]int)
+	ret := make([]*Node, 0, graph.NumNodes())
+	for nodes := graph.GetNodes(); nodes.Next(); {
+		node := nodes.Node()
+		n := 0
+		for edges := node.GetEdgesWithAnyLabel(IncomingEdge, labels); edges.Next(); {
+			n++
+		}
+		inDegree[node] = n
+		if n == 0 {
+			ret = append(ret, node)
+		}
+	}
+	// ret is also the queue of the nodes without remaining incoming
+	// edges
+	for i := 0; i < len(ret); i++ {
+		for edges := ret[i].GetEdgesWithAnyLabel(OutgoingEdge, labels); edges.Next(); {
+			to := edges.Edge().GetTo()
+			inDegree[to]--
+			if inDegree[to] == 0 {
+				ret = append(ret, to)
+			}
+		}
+	}
+	if len(ret) == len(inDegree) {
+		return ret, nil
+	}
+	return nil, findCycle(graph, inDegree, labels)
+}
+
+// findCycle returns a cycle among the nodes with remaining incoming
+// edges. Each of them has an incoming edge from another one, so
+// following those edges backwards eventually reaches a node twice.
+func findCycle(graph *Graph, inDegree map[*Node]int, labels *StringSet) *Path {
+	var node *Node
+	for nodes := graph.GetNodes(); nodes.Next(); {
+		if inDegree[nodes.Node()] > 0 {
+			node = nodes.Node()
+			break
+		}
+	}
+	seen := make(map[*Node]int)
+	// edges[i] is an edge to the i'th node from the next one
+	edges := make([]*Edge, 0)
+	for {
+		if i, ok := seen[node]; ok {
+			elements := make([]PathElement, 0, len(edges)-i)
+			for j := len(edges) - 1; j >= i; j-- {
+				elements = append(elements, PathElement{Edge: edges[j]})
+			}
+			return &Path{path: elements}
+		}
+		seen[node] = len(edges)
+		for itr := node.GetEdgesWithAnyLabel(IncomingEdge, labels); itr.Next(); {
+			edge := itr.Edge()
+			if inDegree[edge.GetFrom()] > 0 {
+				edges = append(edges, edge)
+				node = edge.GetFrom()
+				break
+			}
+		}
+	}
+}
+
+// Cycles returns the elementary cycles of the graph using Johnson's
+// algorithm. A cycle is a path that starts and ends at the same node
+// and visits no other node twice. Only the edges with one of the
+// labels are followed, or all edges if labels is empty. If limit is
+// not -1, at most limit cycles are returned.
+func Cycles(graph *Graph, labels *StringSet, limit int) []*Path {
+	ret := make([]*Path, 0)
+	if limit == 0 {
+		return ret
+	}
+	order := make(map[*Node]int)
+	nodes := make([]*Node, 0, graph.NumNodes())
+	for itr := graph.GetNodes(); itr.Next(); {
+		order[itr.Node()] = len(nodes)
+		nodes = append(nodes, itr.Node())
+	}
+	for i, start := range nodes {
+		// The cycles starting at start contain only nodes after it,
+		// in the strongly connected component of start
+		component := reachableFrom(start, OutgoingEdge, labels, func(n *Node) bool { return order[n] >= i })
+		backward := reachableFrom(start, IncomingEdge, labels, func(n *Node) bool { return order[n] >= i })
+		for n := range component {
+			if _, ok := backward[n]; !ok {
+				delete(component, n)
+			}
+		}
+		j := johnson{
+			start:     start,
+			labels:    labels,
+			component: component,
+			blocked:   make(map[*Node]struct{}),
+			blockMap:  make(map[*Node]map[*Node]struct{}),
+			limit:     limit,
+			cycles:    ret,
+		}
+		j.circuit(start)
+		ret = j.cycles
+		if limit != -1 && len(ret) >= limit {
+			break
+		}
+	}
+	return ret
+}
+
+// reachableFrom returns the nodes accepted by include that are
+// reachable from start through nodes accepted by include
+func reachableFrom(start *Node, dir EdgeDir, labels *StringSet, include func(*Node) bool) map[*Node]struct{} {
+	ret := map[*Node]struct{}{start: {}}
+	queue := []*Node{start}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		for edges := node.GetEdgesWithAnyLabel(dir, labels); edges.Next(); {
+			next := stepFrom(node, edges.Edge()).GetTargetNode()
+			if _, ok := ret[next]; ok || !include(next) {
+				continue
+			}
+			ret[next] = struct{}{}
+			queue = append(queue, next)
+		}
+	}
+	return ret
+}
+
+// johnson finds the cycles through start in a strongly connected
+// component
+type johnson struct {
+	start     *Node
+	labels    *StringSet
+	component map[*Node]struct{}
+	blocked   map[*Node]struct{}
+	// blockMap[n] are the nodes to unblock when n is unblocked
+	blockMap map[*Node]map[*Node]struct{}
+	path     []PathElement
+	limit    int
+	cycles   []*Path
+}
+
+func (j *johnson) done() bool {
+	return j.limit != -1 && len(j.cycles) >= j.limit
+}
+
+// circuit returns true if a cycle through node is found
+func (j *johnson) circuit(node *Node) bool {
+	found := false
+	j.blocked[node] = struct{}{}
+	for edges := node.GetEdgesWithAnyLabel(OutgoingEdge, j.labels); edges.Next() && !j.done(); {
+		edge := edges.Edge()
+		next := edge.GetTo()
+		if _, ok := j.component[next]; !ok {
+			continue
+		}
+		if next == j.start {
+			elements := make([]PathElement, len(j.path), len(j.path)+1)
+			copy(elements, j.path)
+			j.cycles = append(j.cycles, &Path{path: append(elements, PathElement{Edge: edge})})
+			found = true
+			continue
+		}
+		if _, ok := j.blocked[next]; ok {
+			continue
+		}
+		j.path = append(j.path, PathElement{Edge: edge})
+		if j.circuit(next) {
+			found = true
+		}
+		j.path = j.path[:len(j.path)-1]
+	}
+	if found {
+		j.unblock(node)
+		return true
+	}
+	for edges := node.GetEdgesWithAnyLabel(OutgoingEdge, j.labels); edges.Next(); {
+		next := edges.Edge().GetTo()
+		if _, ok := j.component[next]; !ok {
+			continue
+		}
+		if j.blockMap[next] == nil {
+			j.blockMap[next] = make(map[*Node]struct{})
+		}
+		j.blockMap[next][node] = struct{}{}
+	}
+	return false
+}
+
+func (j *johnson) unblock(node *Node) {
+	delete(j.blocked, node)
+	for n := range j.blockMap[node] {
+		delete(j.blockMap[node], n)
+		if _, ok := j.blocked[n]; ok {
+			j.unblock(n)
+		}
+	}
+}
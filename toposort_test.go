@@ -0,0 +1,131 @@
+// Copyright 2021 Cloud Privacy Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lpg
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// checkCycle checks that the path is an elementary cycle
+func checkCycle(t *testing.T, p *Path) {
+	checkPath(t, p)
+	assert.Equal(t, p.First(), p.Last())
+	seen := make(map[*Node]struct{})
+	for i := 1; i < p.NumNodes(); i++ {
+		_, ok := seen[p.GetNode(i)]
+		assert.False(t, ok)
+		seen[p.GetNode(i)] = struct{}{}
+	}
+}
+
+func TestTopologicalSort(t *testing.T) {
+	g := NewGraph()
+	nodes := make(map[string]*Node)
+	for _, name := range []string{"link", "test", "compile", "fetch", "lint", "docs"} {
+		nodes[name] = g.NewNode(nil, map[string]interface{}{"name": name}, nil)
+	}
+	for _, e := range [][2]string{{"fetch", "compile"}, {"compile", "link"}, {"compile", "test"}, {"link", "test"}, {"fetch", "lint"}} {
+		g.NewEdge(nodes[e[0]], nodes[e[1]], "before", nil, nil)
+	}
+	// A cycle through edges with another label
+	g.NewEdge(nodes["test"], nodes["fetch"], "triggers", nil, nil)
+
+	order, cycle := TopologicalSort(g, NewStringSet("before"))
+	assert.Nil(t, cycle)
+	assert.Equal(t, g.NumNodes(), len(order))
+	position := make(map[*Node]int)
+	for i, node := range order {
+		position[node] = i
+	}
+	for edges := g.GetEdgesWithAnyLabel(NewStringSet("before")); edges.Next(); {
+		edge := edges.Edge()
+		assert.True(t, position[edge.GetFrom()] < position[edge.GetTo()])
+	}
+
+	order, cycle = TopologicalSort(g, nil)
+	assert.Nil(t, order)
+	if assert.NotNil(t, cycle) {
+		checkCycle(t, cycle)
+		names := make([]string, 0)
+		for i := 0; i < cycle.NumEdges(); i++ {
+			name, _ := cycle.GetNode(i).GetProperty("name")
+			names = append(names, name.(string))
+		}
+		// fetch, compile, test, or fetch, compile, link, test
+		assert.Subset(t, names, []string{"fetch", "compile", "test"})
+	}
+
+	// A self loop is a cycle
+	g = NewGraph()
+	node := g.NewNode(nil, nil, nil)
+	g.NewEdge(g.NewNode(nil, nil, nil), node, "x", nil, nil)
+	loop := g.NewEdge(node, node, "x", nil, nil)
+	order, cycle = TopologicalSort(g, nil)
+	assert.Nil(t, order)
+	if assert.NotNil(t, cycle) {
+		assert.Equal(t, []*Edge{loop}, []*Edge{cycle.GetEdge(0)})
+		assert.Equal(t, 1, cycle.NumEdges())
+	}
+}
+
+func TestCycles(t *testing.T) {
+	// In a complete directed graph with n nodes, there are C(n,k)*(k-1)!
+	// cycles of length k
+	for n, expected := range map[int]int{2: 1, 3: 5, 4: 20, 5: 84} {
+		g := NewGraph()
+		nodes := make([]*Node, 0)
+		for i := 0; i < n; i++ {
+			nodes = append(nodes, g.NewNode(nil, nil, nil))
+		}
+		for _, from := range nodes {
+			for _, to := range nodes {
+				if from != to {
+					g.NewEdge(from, to, "e", nil, nil)
+				}
+			}
+		}
+		cycles := Cycles(g, nil, -1)
+		assert.Equal(t, expected, len(cycles), fmt.Sprint(n))
+		seen := make(map[string]struct{})
+		for _, cycle := range cycles {
+			checkCycle(t, cycle)
+			ids := make([]int, 0)
+			for i := 0; i < cycle.NumEdges(); i++ {
+				ids = append(ids, cycle.GetEdge(i).GetID())
+			}
+			seen[fmt.Sprint(ids)] = struct{}{}
+		}
+		assert.Equal(t, expected, len(seen))
+		if expected > 3 {
+			assert.Equal(t, 3, len(Cycles(g, nil, 3)))
+		}
+	}
+
+	g := NewGraph()
+	a := g.NewNode(nil, nil, nil)
+	b := g.NewNode(nil, nil, nil)
+	c := g.NewNode(nil, nil, nil)
+	g.NewEdge(a, b, "x", nil, nil)
+	g.NewEdge(a, b, "y", nil, nil)
+	g.NewEdge(b, a, "x", nil, nil)
+	g.NewEdge(b, c, "x", nil, nil)
+	g.NewEdge(c, c, "y", nil, nil)
+	// Parallel edges and self loops
+	assert.Equal(t, 3, len(Cycles(g, nil, -1)))
+	assert.Equal(t, 1, len(Cycles(g, NewStringSet("x"), -1)))
+	assert.Empty(t, Cycles(g, nil, 0))
+}
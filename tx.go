@@ -0,0 +1,263 @@
+// Copyright 2021 Cloud Privacy Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lpg
+
+import (
+	"errors"
+)
+
+// ErrTxDone is returned when a transaction that is already committed
+// or rolled back is used
+var ErrTxDone = errors.New("transaction is already committed or rolled back")
+
+// ErrTxNested is returned when a transaction is committed or rolled
+// back while a transaction started after it is still active
+var ErrTxNested = errors.New("a nested transaction is still active")
+
+// changeKind identifies a graph mutation
+type changeKind uint8
+
+const (
+	nodeCreated changeKind = iota + 1
+	nodeRemoved
+	nodeLabelsChanged
+	nodeContextsChanged
+	nodePropertySet
+	nodePropertyRemoved
+	nodeExternalIDChanged
+	edgeCreated
+	edgeRemoved
+	edgeLabelChanged
+	edgeContextsChanged
+	edgePropertySet
+	edgePropertyRemoved
+	edgeExternalIDChanged
+)
+
+// change records a graph mutation with the state it replaced
+type change struct {
+	kind changeKind
+	node *Node
+	edge *Edge
+	// key is the property key
+	key string
+	// oldValue is the replaced value: the old property value, the
+	// old labels or contexts as a *StringSet, the old edge label, or
+	// the old external ID
+	oldValue interface{}
+	// newValue is the value set by the change, of the same type as
+	// oldValue
+	newValue interface{}
+	// existed is true if the property existed before the change
+	existed bool
+}
+
+// recording returns true if the mutations of the graph are recorded
+func (g *Graph) recording() bool {
+	if g.undoing {
+		// Undo operations are only reported to synchronous listeners
+		return g.listeners != nil
+	}
+	return g.tx != nil || g.wal != nil || g.listeners != nil || g.batchListeners != nil
+}
+
+func (g *Graph) record(c change) {
+	if !g.undoing {
+		if g.tx != nil {
+			g.txLog = append(g.txLog, c)
+		}
+		if g.wal != nil {
+			g.wal.append(c)
+		}
+	}
+	if g.listeners != nil || g.batchListeners != nil {
+		g.notify(c)
+	}
+}
+
+// Tx is a graph transaction. While a transaction is active, all
+// changes to the nodes and edges of the graph are recorded, so they
+// can be undone by Rollback. Transactions can be nested. A nested
+// transaction must be committed or rolled back before the enclosing
+// one, and the changes committed by a nested transaction are undone if
+// the enclosing transaction is rolled back.
+//
+// Declaring indexes or constraints is not part of a transaction.
+type Tx struct {
+	graph  *Graph
+	parent *Tx
+	// start is the index of the first undo log entry of the
+	// transaction
+	start  int
+	idBase int
+	// version is the graph version when the transaction started
+	version uint64
+	// walMark is the length of the pending write-ahead log records
+	// when the transaction started
+	walMark int
+	done    bool
+}
+
+// Begin starts a new transaction. If there is an active transaction,
+// the new transaction is nested in it.
+func (g *Graph) Begin() *Tx {
+	g.checkWritable()
+	tx := &Tx{
+		graph:   g,
+		parent:  g.tx,
+		start:   len(g.txLog),
+		idBase:  g.idBase,
+		version: g.version,
+	}
+	if g.wal != nil {
+		tx.walMark = g.wal.pendingLen()
+	}
+	g.tx = tx
+	return tx
+}
+
+func (tx *Tx) check() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	if tx.graph.tx != tx {
+		return ErrTxNested
+	}
+	return nil
+}
+
+// Commit keeps the changes made in the transaction. If this is the
+// outermost transaction, the undo log is discarded, and if the graph
+// has a write-ahead log, the changes are written to it as a single
+// record. Batch listeners receive the events of the transaction.
+// Returns the write-ahead log error if the record cannot be written.
+func (tx *Tx) Commit() error {
+	if err := tx.check(); err != nil {
+		return err
+	}
+	g := tx.graph
+	tx.done = true
+	g.tx = tx.parent
+	if tx.parent != nil {
+		return nil
+	}
+	changes := g.txLog
+	g.txLog = nil
+	var err error
+	if g.wal != nil {
+		err = g.wal.flush()
+	}
+	if g.batchListeners != nil {
+		g.notifyBatch(events(changes))
+	}
+	return err
+}
+
+// Rollback undoes all the changes made in the transaction in reverse
+// order, restoring the nodes, edges, and indexes of the graph. Removed
+// nodes and edges are restored with the same IDs, and the ID counter
+// is reset unless a snapshot was taken in the transaction, so the
+// graph continues as if the transaction never happened. The iteration
+// order of restored nodes and edges may differ.
+//
+// Undo does not check the index constraints. If undoing a change
+// fails, for instance because an external ID is taken, Rollback
+// continues with the remaining changes and returns the first error.
+func (tx *Tx) Rollback() error {
+	if err := tx.check(); err != nil {
+		return err
+	}
+	g := tx.graph
+	tx.done = true
+	// Do not record the undo operations
+	g.undoing = true
+	// The transaction ends even if undo panics
+	defer func() {
+		g.undoing = false
+		g.tx = tx.parent
+		if tx.parent == nil {
+			g.txLog = nil
+		} else {
+			g.txLog = g.txLog[:tx.start]
+		}
+		if g.wal != nil {
+			// The changes of the transaction are not written to the log
+			g.wal.truncatePending(tx.walMark)
+		}
+	}()
+	var firstErr error
+	for i := len(g.txLog) - 1; i >= tx.start; i-- {
+		if err := g.undo(g.txLog[i]); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		g.txLog[i] = change{}
+	}
+	if g.version == tx.version {
+		// The IDs of the rolled back nodes and edges are reused
+		// unless a snapshot taken in the transaction has them
+		g.idBase = tx.idBase
+	}
+	return firstErr
+}
+
+// undo reverts a change. The graph must be in the state right after
+// the change.
+func (g *Graph) undo(c change) error {
+	switch c.kind {
+	case nodeCreated:
+		g.detachRemoveNode(c.node)
+	case nodeRemoved:
+		g.preserveNode(c.node, false)
+		g.addNode(c.node)
+		return g.index.nodeExternalIDs.set(c.node.id, c.node, c.oldValue.(string))
+	case nodeLabelsChanged:
+		return g.setNodeLabels(c.node, c.oldValue.(*StringSet), false)
+	case nodeContextsChanged:
+		g.setNodeContexts(c.node, c.oldValue.(*StringSet))
+	case nodePropertySet:
+		if !c.existed {
+			g.removeNodeProperty(c.node, c.key)
+			return nil
+		}
+		return g.setNodeProperty(c.node, c.key, c.oldValue, false)
+	case nodePropertyRemoved:
+		return g.setNodeProperty(c.node, c.key, c.oldValue, false)
+	case nodeExternalIDChanged:
+		g.preserveNode(c.node, true)
+		return g.index.nodeExternalIDs.set(c.node.id, c.node, c.oldValue.(string))
+	case edgeCreated:
+		g.removeEdge(c.edge)
+	case edgeRemoved:
+		g.preserveEdge(c.edge, false)
+		g.addEdge(c.edge)
+		return g.index.edgeExternalIDs.set(c.edge.id, c.edge, c.oldValue.(string))
+	case edgeLabelChanged:
+		g.setEdgeLabel(c.edge, c.oldValue.(string))
+	case edgeContextsChanged:
+		g.setEdgeContext(c.edge, c.oldValue.(*StringSet))
+	case edgePropertySet:
+		if !c.existed {
+			g.removeEdgeProperty(c.edge, c.key)
+			return nil
+		}
+		return g.setEdgeProperty(c.edge, c.key, c.oldValue, false)
+	case edgePropertyRemoved:
+		return g.setEdgeProperty(c.edge, c.key, c.oldValue, false)
+	case edgeExternalIDChanged:
+		g.preserveEdge(c.edge, true)
+		return g.index.edgeExternalIDs.set(c.edge.id, c.edge, c.oldValue.(string))
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+// Copyright 2021 Cloud Privacy Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lpg
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// dumpGraph returns a description of the graph that does not depend
+// on the iteration order
+func dumpGraph(g *Graph) string {
+	lines := make([]string, 0)
+	for nodes := g.GetNodes(); nodes.Next(); {
+		node := nodes.Node()
+		lines = append(lines, fmt.Sprintf("node %d %v %v %v %q", node.id, node.labels.SortedSlice(), node.contexts.SortedSlice(), map[string]interface{}(node.properties), node.GetExternalID()))
+	}
+	for edges := g.GetEdges(); edges.Next(); {
+		edge := edges.Edge()
+		lines = append(lines, fmt.Sprintf("edge %d %d->%d %s %v %v %q", edge.id, edge.from.id, edge.to.id, edge.label, edge.contexts.SortedSlice(), map[string]interface{}(edge.properties), edge.GetExternalID()))
+	}
+	sort.Strings(lines)
+	return fmt.Sprintf("idBase %d\n", g.idBase) + strings.Join(lines, "\n")
+}
+
+func getTxTestGraph(t *testing.T) (*Graph, []*Node) {
+	g := NewGraph()
+	g.AddNodePropertyIndex("name", BtreeIndex)
+	assert.Nil(t, g.AddTypedNodePropertyIndex("age", BtreeIndex, IntValue))
+	g.AddEdgePropertyIndex("weight", HashIndex)
+	assert.Nil(t, g.AddCompositeNodeIndex([]string{"name", "age"}, HashIndex))
+	assert.Nil(t, g.AddUniqueConstraint("Person", "name"))
+	nodes := make([]*Node, 0)
+	for i := 0; i < 5; i++ {
+		nodes = append(nodes, g.NewNode([]string{"Person"}, map[string]interface{}{"name": fmt.Sprint("p", i), "age": i}, NewStringSet("c1")))
+	}
+	for i := 0; i < 4; i++ {
+		g.NewEdge(nodes[i], nodes[i+1], "knows", map[string]interface{}{"weight": i}, NewStringSet("c1"))
+	}
+	assert.Nil(t, nodes[0].SetExternalID("first"))
+	return g, nodes
+}
+
+func TestTxRollback(t *testing.T) {
+	g, nodes := getTxTestGraph(t)
+	before := dumpGraph(g)
+
+	tx := g.Begin()
+	created := make([]*Node, 0)
+	for i := 0; i < 50; i++ {
+		created = append(created, g.NewNode([]string{"Person"}, map[string]interface{}{"name": fmt.Sprint("new", i), "age": i}, nil))
+	}
+	g.NewEdge(created[0], nodes[1], "knows", map[string]interface{}{"weight": 10}, nil)
+	nodes[1].SetProperty("name", "renamed")
+	nodes[1].SetProperty("extra", 1)
+	nodes[2].RemoveProperty("age")
+	nodes[3].SetLabels(NewStringSet("Robot"))
+	nodes[3].SetContexts(NewStringSet("c2"))
+	nodes[0].DetachAndRemove()
+	edge := EdgeSlice(nodes[3].GetEdges(OutgoingEdge))[0]
+	edge.SetLabel("likes")
+	edge.SetContexts(NewStringSet("c3"))
+	edge.SetProperty("weight", 100)
+	assert.Nil(t, edge.SetExternalID("e"))
+	EdgeSlice(nodes[1].GetEdges(OutgoingEdge))[0].Remove()
+	assert.Nil(t, nodes[4].SetExternalID("last"))
+	assert.Nil(t, g.Verify())
+
+	assert.Nil(t, tx.Rollback())
+	assert.Nil(t, g.Verify())
+	assert.Equal(t, before, dumpGraph(g))
+	assert.Equal(t, nodes[0], g.GetNodeByExternalID("first"))
+	assert.Nil(t, g.GetEdgeByExternalID("e"))
+	node, err := g.GetNodeByKey("Person", "name", "p1")
+	assert.Nil(t, err)
+	assert.Equal(t, nodes[1], node)
+	itr, err := g.FindNodes(nil, map[string]interface{}{"name": "p2", "age": 2})
+	assert.Nil(t, err)
+	assert.Equal(t, []*Node{nodes[2]}, NodeSlice(itr))
+	assert.Equal(t, 4, len(EdgeSlice(g.GetEdgesWithAnyLabel(NewStringSet("knows")))))
+	assert.False(t, g.recording())
+	assert.Nil(t, g.txLog)
+}
+
+func TestTxCommit(t *testing.T) {
+	g, nodes := getTxTestGraph(t)
+	tx := g.Begin()
+	n := g.NewNode(nil, nil, nil)
+	nodes[0].DetachAndRemove()
+	assert.Nil(t, tx.Commit())
+	assert.Nil(t, g.txLog)
+	assert.False(t, g.recording())
+	assert.Equal(t, n, g.GetNode(n.GetID()))
+	assert.Nil(t, g.GetNode(nodes[0].GetID()))
+	assert.Equal(t, ErrTxDone, tx.Commit())
+	assert.Equal(t, ErrTxDone, tx.Rollback())
+	assert.Nil(t, g.Verify())
+}
+
+func TestTxNested(t *testing.T) {
+	g, nodes := getTxTestGraph(t)
+	before := dumpGraph(g)
+	outer := g.Begin()
+	nodes[0].SetProperty("age", 100)
+	afterOuter := dumpGraph(g)
+
+	inner := g.Begin()
+	assert.Equal(t, ErrTxNested, outer.Commit())
+	nodes[1].DetachAndRemove()
+	assert.Nil(t, inner.Rollback())
+	assert.Equal(t, afterOuter, dumpGraph(g))
+
+	inner = g.Begin()
+	g.NewNode(nil, nil, nil)
+	assert.Nil(t, inner.Commit())
+	assert.Nil(t, outer.Rollback())
+	assert.Equal(t, before, dumpGraph(g))
+	assert.Nil(t, g.Verify())
+}
+
+func TestTxRollbackPanic(t *testing.T) {
+	g := NewGraph()
+	tx := g.Begin()
+	g.NewNode(nil, nil, nil)
+	// A change that cannot be undone
+	g.txLog = append(g.txLog, change{kind: nodeLabelsChanged})
+	assert.Panics(t, func() { tx.Rollback() })
+	assert.Nil(t, g.tx)
+	assert.False(t, g.undoing)
+	assert.Nil(t, g.txLog)
+
+	tx = g.Begin()
+	node := g.NewNode(nil, nil, nil)
+	assert.Nil(t, tx.Rollback())
+	assert.Nil(t, g.GetNode(node.id))
+}
@@ -0,0 +1,298 @@
+// Copyright 2021 Cloud Privacy Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lpg
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ErrUniqueConstraint is returned when a node would have the same
+// key as another node with the same label
+type ErrUniqueConstraint struct {
+	Label  string
+	Keys   []string
+	Values []interface{}
+	// Existing is the node that already has the key
+	Existing *Node
+}
+
+func (e ErrUniqueConstraint) Error() string {
+	return fmt.Sprintf("unique constraint violation: :%s%v = %v exists", e.Label, e.Keys, e.Values)
+}
+
+// uniqueConstraint maps the key values of the nodes with a label to
+// the nodes with that key. The first node is the one found by key.
+// There is more than one node only if a function that does not check
+// the constraint added a duplicate, and the duplicates take over the
+// key in order when the first node loses it. Nodes that do not have
+// all the key properties are not constrained. Values are compared
+// using uniqueValueKey.
+type uniqueConstraint struct {
+	label string
+	keys  []string
+	nodes map[string][]*Node
+}
+
+// key returns the constraint key for a node with the labels, and
+// properties returned by get. Returns false if the node is not
+// constrained.
+func (u *uniqueConstraint) key(labels *StringSet, get func(string) (interface{}, bool)) (string, bool) {
+	if labels == nil || !labels.Has(u.label) {
+		return "", false
+	}
+	var buf strings.Builder
+	for _, k := range u.keys {
+		v, ok := get(k)
+		if !ok || v == nil {
+			return "", false
+		}
+		appendKeyPart(&buf, uniqueValueKey(v))
+	}
+	return buf.String(), true
+}
+
+// lookupKey returns the constraint key for the key values. Returns
+// false if a value is nil.
+func (u *uniqueConstraint) lookupKey(values []interface{}) (string, bool) {
+	var buf strings.Builder
+	for _, v := range values {
+		if v == nil {
+			return "", false
+		}
+		appendKeyPart(&buf, uniqueValueKey(v))
+	}
+	return buf.String(), true
+}
+
+// uniqueValueKey returns the value kind followed by the index key of
+// the value for that kind, so values of different kinds are
+// distinct. Integers, and floating point values without a fractional
+// part are equal if they are numerically equal. Values that are not
+// of any kind are compared using their type and string
+// representation.
+func uniqueValueKey(value interface{}) string {
+	if n, ok := value.(WithNativeValue); ok {
+		value = n.GetNativeValue()
+	}
+	for _, kind := range []ValueKind{StringValue, IntValue, FloatValue, BoolValue, TimeValue} {
+		if key, ok := kind.indexKey(value); ok {
+			return string(rune('0'+kind)) + key
+		}
+	}
+	return fmt.Sprintf("%d%T:%v", AnyValue, value, value)
+}
+
+func (u *uniqueConstraint) has(property string) bool {
+	for _, k := range u.keys {
+		if k == property {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *uniqueConstraint) violation(existing *Node, get func(string) (interface{}, bool)) ErrUniqueConstraint {
+	values := make([]interface{}, 0, len(u.keys))
+	for _, k := range u.keys {
+		v, _ := get(k)
+		values = append(values, v)
+	}
+	return ErrUniqueConstraint{
+		Label:    u.label,
+		Keys:     append([]string{}, u.keys...),
+		Values:   values,
+		Existing: existing,
+	}
+}
+
+func (p properties) getter() func(string) (interface{}, bool) {
+	return func(k string) (interface{}, bool) {
+		v, ok := p[k]
+		return v, ok
+	}
+}
+
+// checkUnique checks if the node with the given labels and properties
+// violates a unique constraint. node is nil for new nodes.
+func (g *graphIndex) checkUnique(node *Node, labels *StringSet, get func(string) (interface{}, bool)) error {
+	for _, u := range g.uniqueConstraints {
+		key, ok := u.key(labels, get)
+		if !ok {
+			continue
+		}
+		if existing := u.nodes[key]; len(existing) > 0 && existing[0] != node {
+			return u.violation(existing[0], get)
+		}
+	}
+	return nil
+}
+
+// checkNewNode checks if a new node with the labels and properties
+// can be added to the graph
+func (g *graphIndex) checkNewNode(labels *StringSet, props properties) error {
+	if err := g.checkNodeProperties(props); err != nil {
+		return err
+	}
+	return g.checkUnique(nil, labels, props.getter())
+}
+
+// addUnique registers the node with the constraints that include the
+// property. If property is empty, registers with all constraints.
+func (g *graphIndex) addUnique(node *Node, property string) {
+	for _, u := range g.uniqueConstraints {
+		if len(property) > 0 && !u.has(property) {
+			continue
+		}
+		if key, ok := u.key(node.labels, node.properties.getter()); ok && !slices.Contains(u.nodes[key], node) {
+			u.nodes[key] = append(u.nodes[key], node)
+		}
+	}
+}
+
+// removeUnique removes the node from the constraints that include the
+// property. If property is empty, removes from all constraints.
+func (g *graphIndex) removeUnique(node *Node, property string) {
+	for _, u := range g.uniqueConstraints {
+		if len(property) > 0 && !u.has(property) {
+			continue
+		}
+		key, ok := u.key(node.labels, node.properties.getter())
+		if !ok {
+			continue
+		}
+		if nodes := slices.DeleteFunc(u.nodes[key], func(n *Node) bool { return n == node }); len(nodes) > 0 {
+			u.nodes[key] = nodes
+		} else {
+			delete(u.nodes, key)
+		}
+	}
+}
+
+func (g *graphIndex) findUnique(label string, keys []string) *uniqueConstraint {
+	for _, u := range g.uniqueConstraints {
+		if u.label != label || len(u.keys) != len(keys) {
+			continue
+		}
+		found := true
+		for i := range keys {
+			if u.keys[i] != keys[i] {
+				found = false
+				break
+			}
+		}
+		if found {
+			return u
+		}
+	}
+	return nil
+}
+
+// UniqueConstraint adds a unique constraint for the nodes with the
+// label and the key properties. Returns ErrUniqueConstraint if
+// existing nodes violate the constraint, and the constraint is not
+// added.
+func (g *graphIndex) UniqueConstraint(label string, keys []string, graph *Graph) error {
+	if len(keys) == 0 {
+		return errors.New("unique constraint needs at least one key")
+	}
+	seen := make(map[string]struct{}, len(keys))
+	for _, k := range keys {
+		if _, ok := seen[k]; ok {
+			return fmt.Errorf("duplicate key in unique constraint: %s", k)
+		}
+		seen[k] = struct{}{}
+	}
+	if g.findUnique(label, keys) != nil {
+		return nil
+	}
+	u := &uniqueConstraint{
+		label: label,
+		keys:  append([]string{}, keys...),
+		nodes: make(map[string][]*Node),
+	}
+	for nodes := graph.GetNodesWithAllLabels(NewStringSet(label)); nodes.Next(); {
+		node := nodes.Node()
+		get := node.properties.getter()
+		key, ok := u.key(node.labels, get)
+		if !ok {
+			continue
+		}
+		if existing := u.nodes[key]; len(existing) > 0 {
+			return u.violation(existing[0], get)
+		}
+		u.nodes[key] = []*Node{node}
+	}
+	g.uniqueConstraints = append(g.uniqueConstraints, u)
+	return nil
+}
+
+// AddUniqueConstraint requires that the nodes with the label have
+// distinct values for the key properties. With more than one key, the
+// tuple of values must be distinct. Nodes that do not have all the key
+// properties are not constrained. Values of different kinds are
+// distinct, so the string "1" and the integer 1 are different keys,
+// but numbers are compared by value.
+//
+// Once the constraint is added, TryNewNode, TrySetProperty, and
+// TrySetLabels fail with ErrUniqueConstraint if the node would
+// violate the constraint. The other functions do not check the
+// constraint. A node they give an existing key does not replace the
+// node found by that key, and it is found by the key only after the
+// existing node is removed or its key changes. Returns
+// ErrUniqueConstraint if existing nodes violate the constraint.
+func (g *Graph) AddUniqueConstraint(label string, keys ...string) error {
+	g.checkWritable()
+	if err := g.index.UniqueConstraint(label, keys, g); err != nil {
+		return err
+	}
+	g.wal.declareUniqueConstraint(label, keys)
+	return nil
+}
+
+// GetNodeByKey returns the node with the label whose key property has
+// the given value using the unique constraint for label and
+// key. Returns nil if there is no such node. Returns an error if there
+// is no unique constraint for the label and key.
+func (g *Graph) GetNodeByKey(label, key string, value interface{}) (*Node, error) {
+	return g.GetNodeByKeys(label, []string{key}, value)
+}
+
+// GetNodeByKeys returns the node with the label whose key properties
+// have the given values using the unique constraint for label and
+// keys. Returns nil if there is no such node. Returns an error if
+// there is no unique constraint for the label and keys.
+func (g *Graph) GetNodeByKeys(label string, keys []string, values ...interface{}) (*Node, error) {
+	if g.view != nil {
+		return g.view.nodeByKeys(label, keys, values)
+	}
+	u := g.index.findUnique(label, keys)
+	if u == nil {
+		return nil, fmt.Errorf("no unique constraint for :%s%v", label, keys)
+	}
+	if len(values) != len(keys) {
+		return nil, fmt.Errorf("expecting %d values for :%s%v", len(keys), label, keys)
+	}
+	key, ok := u.lookupKey(values)
+	if !ok {
+		return nil, nil
+	}
+	if nodes := u.nodes[key]; len(nodes) > 0 {
+		return nodes[0], nil
+	}
+	return nil, nil
+}
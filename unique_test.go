@@ -0,0 +1,169 @@
+// Copyright 2021 Cloud Privacy Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lpg
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestUniqueConstraint(t *testing.T) {
+	g := NewGraph()
+	p1 := g.NewNode([]string{"Person"}, map[string]interface{}{"id": "1"}, nil)
+	g.NewNode([]string{"Person"}, map[string]interface{}{"id": "2"}, nil)
+	other := g.NewNode([]string{"Other"}, map[string]interface{}{"id": "1"}, nil)
+	if err := g.AddUniqueConstraint("Person", "id"); err != nil {
+		t.Fatal(err)
+	}
+
+	node, err := g.GetNodeByKey("Person", "id", "1")
+	assert.Nil(t, err)
+	assert.Equal(t, p1, node)
+	node, err = g.GetNodeByKey("Person", "id", "3")
+	assert.Nil(t, err)
+	assert.Nil(t, node)
+	_, err = g.GetNodeByKey("Person", "name", "1")
+	assert.Error(t, err)
+
+	// New nodes
+	_, err = g.TryNewNode([]string{"Person", "X"}, map[string]interface{}{"id": "1"}, nil)
+	var uerr ErrUniqueConstraint
+	assert.True(t, errors.As(err, &uerr))
+	assert.Equal(t, "Person", uerr.Label)
+	assert.Equal(t, []interface{}{"1"}, uerr.Values)
+	assert.Equal(t, p1, uerr.Existing)
+	_, err = g.TryFastNewNode(NewStringSet("Person"), map[string]interface{}{"id": "2"}, nil)
+	assert.Error(t, err)
+	assert.Equal(t, 3, g.NumNodes())
+	p3 := g.NewNode([]string{"Person"}, map[string]interface{}{"id": "3"}, nil)
+	g.NewNode([]string{"Person"}, nil, nil)
+	g.NewNode([]string{"Person"}, nil, nil)
+
+	// Properties
+	assert.Error(t, p3.TrySetProperty("id", "2"))
+	v, _ := p3.GetProperty("id")
+	assert.Equal(t, "3", v)
+	assert.Nil(t, p3.TrySetProperty("id", "4"))
+	node, _ = g.GetNodeByKey("Person", "id", "4")
+	assert.Equal(t, p3, node)
+	node, _ = g.GetNodeByKey("Person", "id", "3")
+	assert.Nil(t, node)
+	p3.RemoveProperty("id")
+	node, _ = g.GetNodeByKey("Person", "id", "4")
+	assert.Nil(t, node)
+	assert.Nil(t, p3.TrySetProperty("id", "3"))
+
+	// Labels
+	assert.True(t, errors.As(other.TrySetLabels(NewStringSet("Person")), &uerr))
+	assert.False(t, other.HasLabel("Person"))
+	p1.SetLabels(NewStringSet("Former"))
+	assert.Nil(t, other.TrySetLabels(NewStringSet("Person")))
+	node, _ = g.GetNodeByKey("Person", "id", "1")
+	assert.Equal(t, other, node)
+
+	// Removing a node frees its key
+	other.DetachAndRemove()
+	assert.Nil(t, p1.TrySetLabels(NewStringSet("Person")))
+
+	// The functions without Try do not check the constraint, but a
+	// duplicate does not take the key of the existing node
+	dup := g.NewNode([]string{"Person"}, map[string]interface{}{"id": "1"}, nil)
+	node, _ = g.GetNodeByKey("Person", "id", "1")
+	assert.Equal(t, p1, node)
+	p3.SetProperty("id", "1")
+	v, _ = p3.GetProperty("id")
+	assert.Equal(t, "1", v)
+	node, _ = g.GetNodeByKey("Person", "id", "1")
+	assert.Equal(t, p1, node)
+
+	// Removing a duplicate keeps the existing node
+	dup.DetachAndRemove()
+	node, _ = g.GetNodeByKey("Person", "id", "1")
+	assert.Equal(t, p1, node)
+
+	// Removing the existing node hands the key to the duplicate
+	p1.DetachAndRemove()
+	node, _ = g.GetNodeByKey("Person", "id", "1")
+	assert.Equal(t, p3, node)
+	p3.RemoveProperty("id")
+	node, _ = g.GetNodeByKey("Person", "id", "1")
+	assert.Nil(t, node)
+}
+
+func TestUniqueConstraintTuple(t *testing.T) {
+	g := NewGraph()
+	g.NewNode([]string{"Doc"}, map[string]interface{}{"tenant": "a", "id": "1"}, nil)
+	g.NewNode([]string{"Doc"}, map[string]interface{}{"tenant": "b", "id": "1"}, nil)
+	dup := g.NewNode([]string{"Doc"}, map[string]interface{}{"tenant": "b", "id": "1"}, nil)
+	assert.True(t, errors.As(g.AddUniqueConstraint("Doc", "tenant", "id"), &ErrUniqueConstraint{}))
+	assert.Nil(t, g.index.findUnique("Doc", []string{"tenant", "id"}))
+	dup.SetProperty("id", "2")
+	if err := g.AddUniqueConstraint("Doc", "tenant", "id"); err != nil {
+		t.Fatal(err)
+	}
+	_, err := g.TryNewNode([]string{"Doc"}, map[string]interface{}{"tenant": "a", "id": "2"}, nil)
+	assert.Nil(t, err)
+	_, err = g.TryNewNode([]string{"Doc"}, map[string]interface{}{"tenant": "a", "id": "2"}, nil)
+	assert.Error(t, err)
+	node, err := g.GetNodeByKeys("Doc", []string{"tenant", "id"}, "b", "2")
+	assert.Nil(t, err)
+	assert.Equal(t, dup, node)
+
+	// Constraints are kept in snapshots
+	buf := bytes.Buffer{}
+	if err := (BinarySnapshot{}).Encode(g, &buf); err != nil {
+		t.Fatal(err)
+	}
+	target, err := BinarySnapshot{}.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, err = target.GetNodeByKeys("Doc", []string{"tenant", "id"}, "b", "2")
+	assert.Nil(t, err)
+	assert.Equal(t, dup.id, node.id)
+	_, err = target.TryNewNode([]string{"Doc"}, map[string]interface{}{"tenant": "b", "id": "2"}, nil)
+	assert.Error(t, err)
+}
+
+func TestUniqueConstraintValueKinds(t *testing.T) {
+	g := NewGraph()
+	if err := g.AddUniqueConstraint("Item", "key"); err != nil {
+		t.Fatal(err)
+	}
+	str, err := g.TryNewNode([]string{"Item"}, map[string]interface{}{"key": "1"}, nil)
+	assert.Nil(t, err)
+	node, err := g.GetNodeByKey("Item", "key", 1)
+	assert.Nil(t, err)
+	assert.Nil(t, node)
+
+	// Values of different kinds do not collide, but numbers are
+	// compared by value
+	num, err := g.TryNewNode([]string{"Item"}, map[string]interface{}{"key": 1}, nil)
+	assert.Nil(t, err)
+	_, err = g.TryNewNode([]string{"Item"}, map[string]interface{}{"key": 1.0}, nil)
+	assert.Error(t, err)
+	_, err = g.TryNewNode([]string{"Item"}, map[string]interface{}{"key": true}, nil)
+	assert.Nil(t, err)
+	_, err = g.TryNewNode([]string{"Item"}, map[string]interface{}{"key": "true"}, nil)
+	assert.Nil(t, err)
+	node, _ = g.GetNodeByKey("Item", "key", "1")
+	assert.Equal(t, str, node)
+	node, _ = g.GetNodeByKey("Item", "key", int64(1))
+	assert.Equal(t, num, node)
+	node, _ = g.GetNodeByKey("Item", "key", 1.5)
+	assert.Nil(t, node)
+}
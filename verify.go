@@ -0,0 +1,532 @@
+// Copyright 2021 Cloud Privacy Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lpg
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ErrInconsistentGraph is returned by Verify. It lists all the
+// inconsistencies found in the graph.
+type ErrInconsistentGraph []string
+
+func (e ErrInconsistentGraph) Error() string {
+	const maxShown = 10
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "inconsistent graph: %d problems", len(e))
+	for i, s := range e {
+		if i == maxShown {
+			fmt.Fprintf(&buf, "; and %d more", len(e)-maxShown)
+			break
+		}
+		buf.WriteString("; ")
+		buf.WriteString(s)
+	}
+	return buf.String()
+}
+
+type verifier struct {
+	problems ErrInconsistentGraph
+}
+
+func (v *verifier) errorf(format string, args ...interface{}) {
+	v.problems = append(v.problems, fmt.Sprintf(format, args...))
+}
+
+// indexEntry is a value-id pair of an index
+type indexEntry struct {
+	key string
+	id  int
+}
+
+func nodeID(node *Node) int { return node.id }
+func edgeID(edge *Edge) int { return edge.id }
+
+// verifyIndex checks that the index has exactly the expected entries
+func verifyIndex[I Item](v *verifier, name string, ix index[string, I], expected map[indexEntry]I, id func(I) int) {
+	found := make(map[indexEntry]struct{}, len(expected))
+	ix.forEach(func(key string, item I) bool {
+		e := indexEntry{key: key, id: id(item)}
+		if _, seen := found[e]; seen {
+			v.errorf("%s: duplicate entry %q for %d", name, key, e.id)
+			return true
+		}
+		found[e] = struct{}{}
+		exp, ok := expected[e]
+		if !ok {
+			v.errorf("%s: stale entry %q for %d", name, key, e.id)
+			return true
+		}
+		if any(exp) != any(item) {
+			v.errorf("%s: entry %q for %d is not the graph item", name, key, e.id)
+		}
+		return true
+	})
+	for e := range expected {
+		if _, ok := found[e]; !ok {
+			v.errorf("%s: missing entry %q for %d", name, e.key, e.id)
+		}
+	}
+}
+
+// verifyEdgeMap checks the structure of an edge map, and returns the
+// edges in it
+func (v *verifier) verifyEdgeMap(name string, em *edgeMap, listIndex int) []*Edge {
+	switch em.n {
+	case 0:
+		if em.only != nil {
+			v.errorf("%s: empty map has an edge", name)
+		}
+		return nil
+	case 1:
+		if em.only == nil {
+			v.errorf("%s: single edge is missing", name)
+			return nil
+		}
+		return []*Edge{em.only}
+	}
+	ret := make([]*Edge, 0, em.n)
+	if em.edgeLabelLists == nil {
+		v.errorf("%s: %d edges but no label lists", name, em.n)
+		return ret
+	}
+	if em.edgeLabelLists.Len() != len(em.labelMap) {
+		v.errorf("%s: %d label lists, but %d labels", name, em.edgeLabelLists.Len(), len(em.labelMap))
+	}
+	for el := em.edgeLabelLists.Front(); el != nil; el = el.Next() {
+		ell := el.Value.(*edgeLabelList)
+		var label string
+		if ell.edges.head != nil {
+			label = ell.edges.head.label
+		}
+		if em.labelMap[label] != el {
+			v.errorf("%s: label list for %q is not in the label map", name, label)
+		}
+		n := 0
+		var prev *Edge
+		for edge := ell.edges.head; edge != nil; edge = edge.listElements[listIndex].next {
+			if n > em.n {
+				v.errorf("%s: cycle in the list for label %q", name, label)
+				break
+			}
+			if edge.listElements[listIndex].prev != prev {
+				v.errorf("%s: broken link at edge %d", name, edge.id)
+			}
+			if edge.label != label {
+				v.errorf("%s: edge %d with label %q is in the list for label %q", name, edge.id, edge.label, label)
+			}
+			ret = append(ret, edge)
+			prev = edge
+			n++
+		}
+		if ell.edges.tail != prev {
+			v.errorf("%s: wrong tail in the list for label %q", name, label)
+		}
+		if ell.edges.n != n {
+			v.errorf("%s: list for label %q has %d edges, expected %d", name, label, n, ell.edges.n)
+		}
+		if n == 0 {
+			v.errorf("%s: empty label list", name)
+		}
+	}
+	if len(ret) != em.n {
+		v.errorf("%s: has %d edges, expected %d", name, len(ret), em.n)
+	}
+	return ret
+}
+
+// Verify cross-checks the node and edge lists, the adjacency lists of
+// the nodes, and the graph indexes. It returns nil if they are
+// consistent, otherwise an ErrInconsistentGraph listing every problem
+// found. If only the indexes are inconsistent, RebuildIndexes repairs
+// them.
+//
+// Verify visits all nodes and edges, so it is meant for tests and
+// diagnostics.
+func (g *Graph) Verify() error {
+	v := &verifier{}
+	nodes := g.verifyNodes(v)
+	edges := g.verifyEdges(v, nodes)
+	g.verifyNodeIndexes(v, nodes)
+	g.verifyEdgeIndexes(v, edges)
+	if len(v.problems) == 0 {
+		return nil
+	}
+	return v.problems
+}
+
+// verifyNodes checks the node list, and returns the nodes by ID
+func (g *Graph) verifyNodes(v *verifier) map[int]*Node {
+	nodes := make(map[int]*Node)
+	var prev *Node
+	for node := g.allNodes.head; node != nil; node = node.next {
+		if existing, ok := nodes[node.id]; ok {
+			if existing == node {
+				v.errorf("node list: cycle at node %d", node.id)
+				break
+			}
+			v.errorf("node list: duplicate node id %d", node.id)
+		}
+		nodes[node.id] = node
+		if node.prev != prev {
+			v.errorf("node list: broken link at node %d", node.id)
+		}
+		if node.graph != g {
+			v.errorf("node %d: belongs to another graph", node.id)
+		}
+		if node.id >= g.idBase {
+			v.errorf("node %d: id is not below the id base %d", node.id, g.idBase)
+		}
+		prev = node
+	}
+	if g.allNodes.tail != prev {
+		v.errorf("node list: wrong tail")
+	}
+	if g.allNodes.n != len(nodes) {
+		v.errorf("node list: has %d nodes, expected %d", len(nodes), g.allNodes.n)
+	}
+	return nodes
+}
+
+// verifyEdges checks the edge list and the adjacency lists, and
+// returns the edges by ID
+func (g *Graph) verifyEdges(v *verifier, nodes map[int]*Node) map[int]*Edge {
+	edges := make(map[int]*Edge)
+	for _, edge := range v.verifyEdgeMap("edge list", &g.allEdges, 0) {
+		if _, ok := edges[edge.id]; ok {
+			v.errorf("edge list: duplicate edge id %d", edge.id)
+			continue
+		}
+		edges[edge.id] = edge
+		if _, ok := nodes[edge.id]; ok {
+			v.errorf("edge %d: id is used by a node", edge.id)
+		}
+		if edge.id >= g.idBase {
+			v.errorf("edge %d: id is not below the id base %d", edge.id, g.idBase)
+		}
+		if edge.from == nil || nodes[edge.from.id] != edge.from {
+			v.errorf("edge %d: source node is not in the graph", edge.id)
+		}
+		if edge.to == nil || nodes[edge.to.id] != edge.to {
+			v.errorf("edge %d: target node is not in the graph", edge.id)
+		}
+	}
+
+	outgoing := make(map[*Edge]int, len(edges))
+	incoming := make(map[*Edge]int, len(edges))
+	for _, node := range nodes {
+		for _, edge := range v.verifyEdgeMap(fmt.Sprintf("node %d outgoing", node.id), &node.outgoing, 1) {
+			if edge.from != node {
+				v.errorf("node %d: outgoing edge %d is from another node", node.id, edge.id)
+			}
+			if edges[edge.id] != edge {
+				v.errorf("node %d: outgoing edge %d is not in the graph", node.id, edge.id)
+			}
+			outgoing[edge]++
+		}
+		for _, edge := range v.verifyEdgeMap(fmt.Sprintf("node %d incoming", node.id), &node.incoming, 2) {
+			if edge.to != node {
+				v.errorf("node %d: incoming edge %d is to another node", node.id, edge.id)
+			}
+			if edges[edge.id] != edge {
+				v.errorf("node %d: incoming edge %d is not in the graph", node.id, edge.id)
+			}
+			incoming[edge]++
+		}
+	}
+	for _, edge := range edges {
+		if outgoing[edge] != 1 {
+			v.errorf("edge %d: in the outgoing edges of the source node %d times", edge.id, outgoing[edge])
+		}
+		if incoming[edge] != 1 {
+			v.errorf("edge %d: in the incoming edges of the target node %d times", edge.id, incoming[edge])
+		}
+	}
+	return edges
+}
+
+func (g *Graph) verifyNodeIndexes(v *verifier, nodes map[int]*Node) {
+	for id, node := range nodes {
+		if x, _ := g.index.nodesByID.Get(id); x != node {
+			v.errorf("node ids: missing node %d", id)
+		}
+	}
+	g.index.nodesByID.ForEach(func(id int, node *Node) bool {
+		if nodes[id] != node {
+			v.errorf("node ids: stale node %d", id)
+		}
+		return true
+	})
+
+	byLabel := make(map[indexEntry]*Node)
+	byContext := make(map[indexEntry]*Node)
+	for _, node := range nodes {
+		if node.labels.Len() == 0 {
+			byLabel[indexEntry{id: node.id}] = node
+		}
+		for label := range node.labels.Range() {
+			byLabel[indexEntry{key: ":" + label, id: node.id}] = node
+		}
+		for context := range node.contexts.Range() {
+			byContext[indexEntry{key: context, id: node.id}] = node
+		}
+	}
+	labels := &setTree[string, *Node]{}
+	for itr := g.index.nodesByLabel.m.Iterator(); itr.Next(); {
+		for items := itr.Value().(*fastSet).iterator(); items.Next(); {
+			node := items.Value().(*Node)
+			labels.add(":"+itr.Key().(string), node.id, node)
+		}
+	}
+	for items := g.index.nodesByLabel.nolabels.iterator(); items.Next(); {
+		node := items.Value().(*Node)
+		labels.add("", node.id, node)
+	}
+	verifyIndex[*Node](v, "node labels", labels, byLabel, nodeID)
+	verifyIndex(v, "node contexts", g.index.nodesByContext, byContext, nodeID)
+
+	for key, ix := range g.index.nodeProperties {
+		expected := make(map[indexEntry]*Node)
+		for _, node := range nodes {
+			if value, ok := node.properties[key]; ok {
+				if k, ok := ix.kind.indexKey(value); ok {
+					expected[indexEntry{key: k, id: node.id}] = node
+				}
+			}
+		}
+		verifyIndex(v, "node property "+key, ix.index, expected, nodeID)
+		verifyFallback(v, "node property "+key, key, ix, nodes)
+	}
+	for _, ix := range g.index.nodeComposites {
+		expected := make(map[indexEntry]*Node)
+		for _, node := range nodes {
+			if k, ok := ix.itemKey(node.properties); ok {
+				expected[indexEntry{key: k, id: node.id}] = node
+			}
+		}
+		verifyIndex(v, fmt.Sprintf("node composite %v", ix.keys), ix.index, expected, nodeID)
+	}
+
+	for _, u := range g.index.uniqueConstraints {
+		expected := make(map[string][]*Node)
+		for _, node := range nodes {
+			key, ok := u.key(node.labels, node.properties.getter())
+			if !ok {
+				continue
+			}
+			if existing := expected[key]; len(existing) > 0 {
+				v.errorf("unique :%s%v: nodes %d and %d have the same key", u.label, u.keys, existing[0].id, node.id)
+			}
+			expected[key] = append(expected[key], node)
+		}
+		for key, entries := range u.nodes {
+			for _, node := range entries {
+				if !slices.Contains(expected[key], node) {
+					v.errorf("unique :%s%v: stale entry for %d", u.label, u.keys, node.id)
+				}
+			}
+		}
+		for key, entries := range expected {
+			for _, node := range entries {
+				if !slices.Contains(u.nodes[key], node) {
+					v.errorf("unique :%s%v: missing entry for %d", u.label, u.keys, node.id)
+				}
+			}
+		}
+	}
+	verifyExternalIDs(v, "node", &g.index.nodeExternalIDs, nodes, nodeID)
+}
+
+func (g *Graph) verifyEdgeIndexes(v *verifier, edges map[int]*Edge) {
+	for id, edge := range edges {
+		if x, _ := g.index.edgesByID.Get(id); x != edge {
+			v.errorf("edge ids: missing edge %d", id)
+		}
+	}
+	g.index.edgesByID.ForEach(func(id int, edge *Edge) bool {
+		if edges[id] != edge {
+			v.errorf("edge ids: stale edge %d", id)
+		}
+		return true
+	})
+
+	byLabel := make(map[indexEntry]*Edge)
+	byContext := make(map[indexEntry]*Edge)
+	fromContext := make(map[indexEntry]*Edge)
+	toContext := make(map[indexEntry]*Edge)
+	for _, edge := range edges {
+		byLabel[indexEntry{key: edge.label, id: edge.id}] = edge
+		if edge.from == nil || edge.to == nil {
+			continue
+		}
+		for context := range edge.contexts.Range() {
+			byContext[indexEntry{key: context, id: edge.id}] = edge
+			fromContext[indexEntry{key: contextKey(edge.from.id, context), id: edge.id}] = edge
+			toContext[indexEntry{key: contextKey(edge.to.id, context), id: edge.id}] = edge
+		}
+	}
+	verifyIndex(v, "edge labels", g.index.edgesByLabel, byLabel, edgeID)
+	verifyIndex(v, "edge contexts", g.index.edgesByContext, byContext, edgeID)
+	verifyIndex(v, "edge source contexts", contextEntries(&g.index.edgesFromContext), fromContext, edgeID)
+	verifyIndex(v, "edge target contexts", contextEntries(&g.index.edgesToContext), toContext, edgeID)
+
+	for key, ix := range g.index.edgeProperties {
+		expected := make(map[indexEntry]*Edge)
+		for _, edge := range edges {
+			if value, ok := edge.properties[key]; ok {
+				if k, ok := ix.kind.indexKey(value); ok {
+					expected[indexEntry{key: k, id: edge.id}] = edge
+				}
+			}
+		}
+		verifyIndex(v, "edge property "+key, ix.index, expected, edgeID)
+		verifyFallback(v, "edge property "+key, key, ix, edges)
+	}
+	for _, ix := range g.index.edgeComposites {
+		expected := make(map[indexEntry]*Edge)
+		for _, edge := range edges {
+			if k, ok := ix.itemKey(edge.properties); ok {
+				expected[indexEntry{key: k, id: edge.id}] = edge
+			}
+		}
+		verifyIndex(v, fmt.Sprintf("edge composite %v", ix.keys), ix.index, expected, edgeID)
+	}
+	verifyExternalIDs(v, "edge", &g.index.edgeExternalIDs, edges, edgeID)
+}
+
+// verifyFallback checks that the items whose values are not of the
+// kind of the property index are the fallback items of the index
+func verifyFallback[I interface {
+	comparable
+	WithProperties
+}](v *verifier, name, key string, ix propertyIndex[I], items map[int]I) {
+	for id, item := range items {
+		value, ok := item.GetProperty(key)
+		if !ok {
+			continue
+		}
+		if _, ok := ix.kind.indexKey(value); !ok && ix.other[id].item != item {
+			v.errorf("%s: missing fallback entry for %d", name, id)
+		}
+	}
+	for id, x := range ix.other {
+		item, ok := items[id]
+		if !ok || item != x.item {
+			v.errorf("%s: stale fallback entry for %d", name, id)
+			continue
+		}
+		value, _ := item.GetProperty(key)
+		if _, ok := ix.kind.indexKey(value); ok {
+			v.errorf("%s: stale fallback entry for %d", name, id)
+		}
+	}
+}
+
+func contextKey(nodeID int, context string) string {
+	return strconv.Itoa(nodeID) + ":" + context
+}
+
+// contextEntries copies the edge context index to an index keyed by
+// contextKey
+func contextEntries(ix *edgeContextIndex) index[string, *Edge] {
+	ret := &setTree[string, *Edge]{}
+	ix.forEach(func(nodeID int, context string, edge *Edge) bool {
+		ret.add(contextKey(nodeID, context), edge.id, edge)
+		return true
+	})
+	return ret
+}
+
+func verifyExternalIDs[I comparable](v *verifier, name string, ids *externalIDs[I], items map[int]I, id func(I) int) {
+	for s, item := range ids.items {
+		itemID := id(item)
+		if existing, ok := items[itemID]; !ok || existing != item {
+			v.errorf("%s external id %q: %d is not in the graph", name, s, itemID)
+		}
+		if ids.of(itemID) != s {
+			v.errorf("%s external id %q: %d has external id %q", name, s, itemID, ids.of(itemID))
+		}
+	}
+	if ids.ids != nil && ids.ids.Len() != len(ids.items) {
+		v.errorf("%s external ids: %d ids, but %d items", name, ids.ids.Len(), len(ids.items))
+	}
+}
+
+// RebuildIndexes discards the graph indexes and rebuilds them from the
+// nodes and edges of the graph. Property indexes, composite indexes,
+// and unique constraints are declared again with the same types and
+// kinds. External IDs of the nodes and edges of the graph are kept.
+//
+// If nodes violate a unique constraint, the first of those nodes in
+// the graph is found by the key, and RebuildIndexes returns an
+// ErrInconsistentGraph listing the duplicate keys. The indexes are
+// rebuilt in either case.
+func (g *Graph) RebuildIndexes() error {
+	g.checkWritable()
+	v := &verifier{}
+	old := g.index
+	g.index = newGraphIndex()
+	for key, ix := range old.nodeProperties {
+		g.index.nodeProperties[key] = newPropertyIndex[*Node](ix.indexType(), ix.kind)
+	}
+	for key, ix := range old.edgeProperties {
+		g.index.edgeProperties[key] = newPropertyIndex[*Edge](ix.indexType(), ix.kind)
+	}
+	for _, ix := range old.nodeComposites {
+		g.index.nodeComposites = append(g.index.nodeComposites, newCompositeIndex[*Node](ix.keys, ix.indexType()))
+	}
+	for _, ix := range old.edgeComposites {
+		g.index.edgeComposites = append(g.index.edgeComposites, newCompositeIndex[*Edge](ix.keys, ix.indexType()))
+	}
+	for _, u := range old.uniqueConstraints {
+		g.index.uniqueConstraints = append(g.index.uniqueConstraints, &uniqueConstraint{
+			label: u.label,
+			keys:  u.keys,
+			nodes: make(map[string][]*Node),
+		})
+	}
+	for node := g.allNodes.head; node != nil; node = node.next {
+		for _, u := range g.index.uniqueConstraints {
+			key, ok := u.key(node.labels, node.properties.getter())
+			if !ok {
+				continue
+			}
+			if existing := u.nodes[key]; len(existing) > 0 {
+				v.errorf("unique :%s%v: nodes %d and %d have the same key", u.label, u.keys, existing[0].id, node.id)
+			}
+		}
+		g.index.addNodeToIndex(node)
+	}
+	for edges := g.allEdges.iterator(0); edges.Next(); {
+		g.index.addEdgeToIndex(edges.Edge())
+	}
+	for s, node := range old.nodeExternalIDs.items {
+		if n, _ := g.index.nodesByID.Get(node.id); n == node {
+			g.index.nodeExternalIDs.set(node.id, node, s)
+		}
+	}
+	for s, edge := range old.edgeExternalIDs.items {
+		if e, _ := g.index.edgesByID.Get(edge.id); e == edge {
+			g.index.edgeExternalIDs.set(edge.id, edge, s)
+		}
+	}
+	if len(v.problems) == 0 {
+		return nil
+	}
+	return v.problems
+}
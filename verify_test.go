@@ -0,0 +1,117 @@
+// Copyright 2021 Cloud Privacy Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lpg
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestVerifyEdgeMutations(t *testing.T) {
+	g := NewGraph()
+	g.AddEdgePropertyIndex("weight", HashIndex)
+	g.AddNodePropertyIndex("key", BtreeIndex)
+	nodes := make([]*Node, 0)
+	for i := 0; i < 5; i++ {
+		nodes = append(nodes, g.NewNode([]string{"a"}, map[string]interface{}{"key": i}, NewStringSet("c")))
+	}
+	edges := make([]*Edge, 0)
+	for i := 0; i < 4; i++ {
+		edges = append(edges, g.NewEdge(nodes[i], nodes[i+1], "next", map[string]interface{}{"weight": i}, NewStringSet("c")))
+	}
+	g.NewEdge(nodes[0], nodes[0], "self", nil, nil)
+	assert.Nil(t, g.Verify())
+
+	edges[1].Remove()
+	assert.Nil(t, g.Verify())
+	itr, err := g.FindEdges("", map[string]interface{}{"weight": 1})
+	assert.Nil(t, err)
+	assert.Empty(t, EdgeSlice(itr))
+
+	edges[2].SetLabel("other")
+	assert.Nil(t, g.Verify())
+	assert.Equal(t, []*Edge{edges[2]}, EdgeSlice(g.GetEdgesWithAnyLabel(NewStringSet("other"))))
+	assert.Equal(t, 2, len(EdgeSlice(g.GetEdgesWithAnyLabel(NewStringSet("next")))))
+	assert.Equal(t, 4, len(EdgeSlice(g.GetEdges())))
+
+	nodes[1].SetLabels(NewStringSet())
+	assert.Nil(t, g.Verify())
+	assert.Equal(t, 4, len(NodeSlice(g.GetNodesWithAllLabels(NewStringSet("a")))))
+
+	// Removing the last node must keep the nodes added after it
+	nodes[4].DetachAndRemove()
+	n := g.NewNode(nil, nil, nil)
+	assert.Nil(t, g.Verify())
+	assert.Contains(t, NodeSlice(g.GetNodes()), n)
+	assert.Equal(t, 5, len(NodeSlice(g.GetNodes())))
+}
+
+func TestVerifyRebuild(t *testing.T) {
+	g, nodes := GetLineGraph(5, true)
+	assert.Nil(t, g.AddCompositeNodeIndex([]string{"key", "x"}, HashIndex))
+	assert.Nil(t, g.AddUniqueConstraint("a", "key"))
+	for i, node := range nodes {
+		node.SetProperty("key", i)
+		node.SetProperty("x", "y")
+	}
+	assert.Nil(t, nodes[2].SetExternalID("two"))
+	assert.Nil(t, g.Verify())
+
+	// Corrupt the indexes
+	g.index.nodeProperties["key"].remove("1", nodes[1].id)
+	g.index.nodesByContext.add("stale", nodes[3].id, nodes[3])
+	g.index.edgesByID.Del(nodes[0].outgoing.only.id)
+	g.index.uniqueConstraints[0].nodes["9"] = []*Node{nodes[4]}
+
+	err := g.Verify()
+	var inconsistent ErrInconsistentGraph
+	assert.True(t, errors.As(err, &inconsistent))
+	assert.Equal(t, 4, len(inconsistent), "%v", inconsistent)
+
+	assert.Nil(t, g.RebuildIndexes())
+	assert.Nil(t, g.Verify())
+	assert.Equal(t, nodes[2], g.GetNodeByExternalID("two"))
+	node, err := g.GetNodeByKey("a", "key", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, nodes[1], node)
+	itr, err := g.FindNodes(nil, map[string]interface{}{"key": 1, "x": "y"})
+	assert.Nil(t, err)
+	assert.Equal(t, []*Node{nodes[1]}, NodeSlice(itr))
+
+	// Duplicate keys are reported
+	nodes[3].SetProperty("key", 1)
+	assert.True(t, errors.As(g.Verify(), &inconsistent))
+	err = g.RebuildIndexes()
+	if assert.True(t, errors.As(err, &inconsistent)) && assert.Equal(t, 1, len(inconsistent)) {
+		assert.Contains(t, inconsistent[0], "have the same key")
+	}
+	node, _ = g.GetNodeByKey("a", "key", 1)
+	assert.Equal(t, nodes[1], node)
+
+	// Snapshots cannot rebuild the indexes
+	snapshot := g.Snapshot()
+	defer snapshot.Release()
+	assert.PanicsWithValue(t, ErrSnapshotReadOnly, func() { snapshot.RebuildIndexes() })
+}
+
+func TestVerifyAdjacency(t *testing.T) {
+	g, nodes := GetLineGraph(3, false)
+	edge := nodes[0].outgoing.only
+	nodes[0].outgoing.remove(edge, 1)
+	err := g.Verify()
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, len(err.(ErrInconsistentGraph)), "%v", err)
+}
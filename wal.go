@@ -0,0 +1,912 @@
+// Copyright 2021 Cloud Privacy Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lpg
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// SyncPolicy determines when the write-ahead log is flushed to stable
+// storage
+type SyncPolicy int
+
+const (
+	// SyncAlways syncs the log after every record. A change is
+	// durable when the call making it returns.
+	SyncAlways SyncPolicy = iota
+	// SyncInterval syncs the log when a record is written and the
+	// last sync is older than WALOptions.SyncInterval. There is no
+	// background sync: the records written since the last sync stay
+	// unsynced until another record is written, or until Sync,
+	// Checkpoint, or Close is called, and may be lost in a crash.
+	SyncInterval
+	// SyncNever leaves flushing to the operating system. The log is
+	// synced only by Sync, Checkpoint, and Close.
+	SyncNever
+)
+
+// WALOptions configures a write-ahead log
+type WALOptions struct {
+	// Snapshot writes and reads the snapshots. Its property
+	// marshaler and unmarshaler are also used for the property values
+	// in the log.
+	Snapshot BinarySnapshot
+
+	Sync SyncPolicy
+	// SyncInterval is used with the SyncInterval policy
+	SyncInterval time.Duration
+
+	// CheckpointEvery is the number of log records after which a
+	// snapshot is taken and a new log is started. If 0, snapshots are
+	// only taken by Checkpoint.
+	CheckpointEvery int
+}
+
+// ErrWALFormat is returned if the log file is not a write-ahead log,
+// or a log record is invalid
+type ErrWALFormat string
+
+func (e ErrWALFormat) Error() string {
+	return "Invalid write-ahead log: " + string(e)
+}
+
+var walMagic = []byte("LPGW")
+
+const walVersion = 2
+
+// Log record tags for declarations. Graph changes are tagged with
+// their change kind.
+const (
+	walNodeIndex byte = 64 + iota
+	walEdgeIndex
+	walNodeComposite
+	walEdgeComposite
+	walUniqueConstraint
+)
+
+// WAL is a write-ahead log of the changes of a graph. The log lives in
+// a directory that contains the last snapshot of the graph, and a log
+// file of the changes made after the snapshot. Every record of the log
+// file is length-prefixed, and both the length and the record are
+// checksummed. The changes made in a
+// transaction are written as a single record when the outermost
+// transaction commits, so after a crash either all or none of them
+// are recovered. Index and constraint declarations are written when
+// they are made.
+//
+// Changes are written as they are made, and mutations of the graph
+// cannot return errors. If a record cannot be written, for instance
+// because a property value cannot be encoded, the log stops
+// recording changes, and Err, Sync, and Close return the error.
+type WAL struct {
+	options WALOptions
+	dir     string
+	gen     uint64
+	file    *os.File
+	graph   *Graph
+	// enc holds the encoded records that are not written yet
+	enc       snapshotWriter
+	records   int
+	lastSync  time.Time
+	err       error
+	discarded int64
+}
+
+func walSnapshotName(gen uint64) string { return fmt.Sprintf("snapshot-%016d.lpg", gen) }
+func walLogName(gen uint64) string      { return fmt.Sprintf("wal-%016d.log", gen) }
+
+// OpenWAL opens the write-ahead log in dir, creating the directory if
+// necessary. The graph is rebuilt from the last snapshot and the
+// changes in the log. An incomplete record at the end of the log,
+// left by a crash while it was written, is discarded. A corrupt
+// record elsewhere in the log returns ErrWALFormat, and the log is
+// not changed. The returned graph records all its changes to the log
+// until the log is closed.
+func OpenWAL(dir string, options WALOptions) (*Graph, *WAL, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	var gen uint64
+	hasSnapshot := false
+	for _, entry := range entries {
+		var g uint64
+		if _, err := fmt.Sscanf(entry.Name(), "snapshot-%d.lpg", &g); err == nil && entry.Name() == walSnapshotName(g) {
+			if !hasSnapshot || g > gen {
+				gen = g
+				hasSnapshot = true
+			}
+		}
+	}
+	w := &WAL{
+		options: options,
+		dir:     dir,
+		gen:     gen,
+	}
+	w.enc.BinarySnapshot = options.Snapshot
+	g := NewGraph()
+	if hasSnapshot {
+		f, err := os.Open(filepath.Join(dir, walSnapshotName(gen)))
+		if err != nil {
+			return nil, nil, err
+		}
+		g, err = options.Snapshot.Decode(bufio.NewReader(f))
+		f.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if w.file, err = w.openLog(g); err != nil {
+		return nil, nil, err
+	}
+	// Remove the files of other generations
+	for _, entry := range entries {
+		name := entry.Name()
+		if name == walSnapshotName(gen) || name == walLogName(gen) {
+			continue
+		}
+		if strings.HasPrefix(name, "snapshot-") || strings.HasPrefix(name, "wal-") {
+			os.Remove(filepath.Join(dir, name))
+		}
+	}
+	w.lastSync = time.Now()
+	w.graph = g
+	g.wal = w
+	return g, w, nil
+}
+
+// openLog replays the log of the current generation into the graph
+// and opens it for writing. Creates the log if it does not exist.
+func (w *WAL) openLog(g *Graph) (*os.File, error) {
+	f, err := os.OpenFile(filepath.Join(w.dir, walLogName(w.gen)), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	end, err := w.replay(g, f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	w.discarded = info.Size() - end
+	if end == 0 {
+		// New log, or a crash before the header was written
+		var header bytes.Buffer
+		header.Write(walMagic)
+		header.WriteByte(walVersion)
+		if _, err := f.WriteAt(header.Bytes(), 0); err != nil {
+			f.Close()
+			return nil, err
+		}
+		end = int64(header.Len())
+		w.discarded = 0
+	}
+	if err := f.Truncate(end); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(end, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := syncDir(w.dir); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// walReader counts the bytes read
+type walReader struct {
+	in *bufio.Reader
+	n  int64
+}
+
+func (r *walReader) ReadByte() (byte, error) {
+	b, err := r.in.ReadByte()
+	if err == nil {
+		r.n++
+	}
+	return b, err
+}
+
+func (r *walReader) Read(b []byte) (int, error) {
+	n, err := r.in.Read(b)
+	r.n += int64(n)
+	return n, err
+}
+
+// walZeroTail returns true if the bytes of f from offset start to
+// end are all zero
+func walZeroTail(f *os.File, start, end int64) (bool, error) {
+	in := bufio.NewReader(io.NewSectionReader(f, start, end-start))
+	for {
+		b, err := in.ReadByte()
+		if errors.Is(err, io.EOF) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if b != 0 {
+			return false, nil
+		}
+	}
+}
+
+// replay applies the records of the log to the graph, and returns the
+// offset of the end of the last complete record. Returns 0 if the log
+// does not have a complete header.
+func (w *WAL) replay(g *Graph, f *os.File) (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	in := &walReader{in: bufio.NewReader(f)}
+	var header [5]byte
+	if _, err := io.ReadFull(in, header[:]); err != nil {
+		return 0, nil
+	}
+	if !bytes.Equal(header[:4], walMagic) {
+		return 0, ErrWALFormat("bad magic number")
+	}
+	if header[4] != walVersion {
+		return 0, ErrWALFormat(fmt.Sprintf("unsupported version %d", header[4]))
+	}
+	r := &snapshotReader{
+		BinarySnapshot: w.options.Snapshot,
+		in:             bufio.NewReader(nil),
+		crc:            crc32.NewIEEE(),
+	}
+	for {
+		// A torn write can only leave an incomplete or corrupt record
+		// at the end of the log. A bad record followed by more data is
+		// an error.
+		end := in.n
+		size, err := binary.ReadUvarint(in)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return end, nil
+		}
+		if err != nil {
+			return 0, ErrWALFormat(fmt.Sprintf("bad record size at offset %d", end))
+		}
+		var sizeSum [4]byte
+		if _, err := io.ReadFull(in, sizeSum[:]); err != nil {
+			return end, nil
+		}
+		if crc32.ChecksumIEEE(binary.AppendUvarint(nil, size)) != binary.LittleEndian.Uint32(sizeSum[:]) {
+			// The file may be extended with zeros before the
+			// record is written
+			zero, err := walZeroTail(f, end, info.Size())
+			if err != nil {
+				return 0, err
+			}
+			if zero {
+				return end, nil
+			}
+			return 0, ErrWALFormat(fmt.Sprintf("bad record size at offset %d", end))
+		}
+		// The size is valid, so the log ends within this record
+		if size+4 > uint64(info.Size()-in.n) {
+			return end, nil
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(in, payload); err != nil {
+			return 0, err
+		}
+		var sum [4]byte
+		if _, err := io.ReadFull(in, sum[:]); err != nil {
+			return 0, err
+		}
+		if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(sum[:]) {
+			if in.n == info.Size() {
+				return end, nil
+			}
+			return 0, ErrWALFormat(fmt.Sprintf("checksum mismatch at offset %d", end))
+		}
+		r.in.Reset(bytes.NewReader(payload))
+		if err := w.applyRecord(g, r); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return 0, ErrWALFormat("truncated change")
+			}
+			return 0, err
+		}
+	}
+}
+
+func (w *WAL) str(s string) {
+	w.enc.bytes([]byte(s))
+}
+
+func (w *WAL) stringSet(s *StringSet) {
+	w.enc.uvarint(uint64(s.Len()))
+	s.Iter(func(x string) bool {
+		w.str(x)
+		return false
+	})
+}
+
+func (w *WAL) strings(s []string) {
+	w.enc.uvarint(uint64(len(s)))
+	for _, x := range s {
+		w.str(x)
+	}
+}
+
+func (w *WAL) properties(p properties) error {
+	w.enc.uvarint(uint64(len(p)))
+	for k, v := range p {
+		w.str(k)
+		if err := w.enc.value(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readWALString(r *snapshotReader) (string, error) {
+	b, err := r.bytes()
+	return string(b), err
+}
+
+func readWALStringSet(r *snapshotReader) (*StringSet, error) {
+	n, err := r.int()
+	if err != nil {
+		return nil, err
+	}
+	ret := NewStringSet()
+	for i := 0; i < n; i++ {
+		s, err := readWALString(r)
+		if err != nil {
+			return nil, err
+		}
+		ret.Add(s)
+	}
+	return ret, nil
+}
+
+func readWALStrings(r *snapshotReader) ([]string, error) {
+	n, err := r.int()
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]string, 0, min(n, 1024))
+	for i := 0; i < n; i++ {
+		s, err := readWALString(r)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, s)
+	}
+	return ret, nil
+}
+
+func readWALProperties(r *snapshotReader) (properties, error) {
+	n, err := r.int()
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	ret := make(properties, min(n, 1024))
+	for i := 0; i < n; i++ {
+		k, err := readWALString(r)
+		if err != nil {
+			return nil, err
+		}
+		v, err := r.value(k)
+		if err != nil {
+			return nil, err
+		}
+		ret[k] = v
+	}
+	return ret, nil
+}
+
+// encode appends the change to the pending records
+func (w *WAL) encode(c change) error {
+	w.enc.buf.WriteByte(byte(c.kind))
+	switch c.kind {
+	case nodeCreated:
+		w.enc.uvarint(uint64(c.node.id))
+		w.stringSet(c.node.labels)
+		w.stringSet(c.node.contexts)
+		return w.properties(c.node.properties)
+	case edgeCreated:
+		w.enc.uvarint(uint64(c.edge.id))
+		w.enc.uvarint(uint64(c.edge.from.id))
+		w.enc.uvarint(uint64(c.edge.to.id))
+		w.str(c.edge.label)
+		w.stringSet(c.edge.contexts)
+		return w.properties(c.edge.properties)
+	}
+	if c.node != nil {
+		w.enc.uvarint(uint64(c.node.id))
+	} else {
+		w.enc.uvarint(uint64(c.edge.id))
+	}
+	switch c.kind {
+	case nodeLabelsChanged, nodeContextsChanged, edgeContextsChanged:
+		w.stringSet(c.newValue.(*StringSet))
+	case nodePropertySet, edgePropertySet:
+		w.str(c.key)
+		return w.enc.value(c.key, c.newValue)
+	case nodePropertyRemoved, edgePropertyRemoved:
+		w.str(c.key)
+	case nodeExternalIDChanged, edgeExternalIDChanged, edgeLabelChanged:
+		w.str(c.newValue.(string))
+	}
+	return nil
+}
+
+// applyRecord applies the changes and declarations of a record to the
+// graph
+func (w *WAL) applyRecord(g *Graph, r *snapshotReader) error {
+	for {
+		tag, err := r.ReadByte()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := applyWALEntry(g, r, tag); err != nil {
+			return err
+		}
+	}
+}
+
+func applyWALEntry(g *Graph, r *snapshotReader, tag byte) error {
+	switch tag {
+	case walNodeIndex, walEdgeIndex:
+		key, err := readWALString(r)
+		if err != nil {
+			return err
+		}
+		it, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		kind, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if IndexType(it) != BtreeIndex && IndexType(it) != HashIndex {
+			return ErrWALFormat(fmt.Sprintf("unknown index type %d", it))
+		}
+		if ValueKind(kind) > TimeValue {
+			return ErrWALFormat(fmt.Sprintf("unknown value kind %d", kind))
+		}
+		switch {
+		case tag == walNodeIndex && ValueKind(kind) == AnyValue:
+			g.index.NodePropertyIndex(key, g, IndexType(it))
+		case tag == walNodeIndex:
+			return g.index.TypedNodePropertyIndex(key, g, IndexType(it), ValueKind(kind))
+		case ValueKind(kind) == AnyValue:
+			g.index.EdgePropertyIndex(key, g, IndexType(it))
+		default:
+			return g.index.TypedEdgePropertyIndex(key, g, IndexType(it), ValueKind(kind))
+		}
+		return nil
+	case walNodeComposite, walEdgeComposite:
+		keys, err := readWALStrings(r)
+		if err != nil {
+			return err
+		}
+		it, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if tag == walNodeComposite {
+			return g.index.CompositeNodeIndex(keys, g, IndexType(it))
+		}
+		return g.index.CompositeEdgeIndex(keys, g, IndexType(it))
+	case walUniqueConstraint:
+		label, err := readWALString(r)
+		if err != nil {
+			return err
+		}
+		keys, err := readWALStrings(r)
+		if err != nil {
+			return err
+		}
+		return g.index.UniqueConstraint(label, keys, g)
+	}
+
+	kind := changeKind(tag)
+	id, err := r.int()
+	if err != nil {
+		return err
+	}
+	switch kind {
+	case nodeCreated:
+		labels, err := readWALStringSet(r)
+		if err != nil {
+			return err
+		}
+		contexts, err := readWALStringSet(r)
+		if err != nil {
+			return err
+		}
+		props, err := readWALProperties(r)
+		if err != nil {
+			return err
+		}
+		return g.newNodeWithID(id, labels, contexts, props)
+	case edgeCreated:
+		from, err := r.int()
+		if err != nil {
+			return err
+		}
+		to, err := r.int()
+		if err != nil {
+			return err
+		}
+		label, err := readWALString(r)
+		if err != nil {
+			return err
+		}
+		contexts, err := readWALStringSet(r)
+		if err != nil {
+			return err
+		}
+		props, err := readWALProperties(r)
+		if err != nil {
+			return err
+		}
+		return g.newEdgeWithID(id, from, to, label, contexts, props)
+	}
+
+	var node *Node
+	var edge *Edge
+	switch kind {
+	case nodeRemoved, nodeLabelsChanged, nodeContextsChanged, nodePropertySet, nodePropertyRemoved, nodeExternalIDChanged:
+		if node = g.GetNode(id); node == nil {
+			return ErrWALFormat(fmt.Sprintf("unknown node %d", id))
+		}
+	case edgeRemoved, edgeLabelChanged, edgeContextsChanged, edgePropertySet, edgePropertyRemoved, edgeExternalIDChanged:
+		if edge = g.GetEdge(id); edge == nil {
+			return ErrWALFormat(fmt.Sprintf("unknown edge %d", id))
+		}
+	default:
+		return ErrWALFormat(fmt.Sprintf("unknown record tag %d", tag))
+	}
+	switch kind {
+	case nodeRemoved:
+		g.detachRemoveNode(node)
+	case edgeRemoved:
+		g.removeEdge(edge)
+	case nodeLabelsChanged, nodeContextsChanged, edgeContextsChanged:
+		set, err := readWALStringSet(r)
+		if err != nil {
+			return err
+		}
+		switch kind {
+		case nodeLabelsChanged:
+			return g.setNodeLabels(node, set, false)
+		case nodeContextsChanged:
+			g.setNodeContexts(node, set)
+		default:
+			g.setEdgeContext(edge, set)
+		}
+	case nodePropertySet, edgePropertySet:
+		key, err := readWALString(r)
+		if err != nil {
+			return err
+		}
+		value, err := r.value(key)
+		if err != nil {
+			return err
+		}
+		if node != nil {
+			return g.setNodeProperty(node, key, value, false)
+		}
+		return g.setEdgeProperty(edge, key, value, false)
+	case nodePropertyRemoved, edgePropertyRemoved:
+		key, err := readWALString(r)
+		if err != nil {
+			return err
+		}
+		if node != nil {
+			g.removeNodeProperty(node, key)
+		} else {
+			g.removeEdgeProperty(edge, key)
+		}
+	case nodeExternalIDChanged, edgeExternalIDChanged, edgeLabelChanged:
+		s, err := readWALString(r)
+		if err != nil {
+			return err
+		}
+		switch kind {
+		case nodeExternalIDChanged:
+			return node.SetExternalID(s)
+		case edgeExternalIDChanged:
+			return edge.SetExternalID(s)
+		default:
+			g.setEdgeLabel(edge, s)
+		}
+	}
+	return nil
+}
+
+// newNodeWithID adds a node with the given ID to the graph
+func (g *Graph) newNodeWithID(id int, labels, contexts *StringSet, props properties) error {
+	if g.GetNode(id) != nil || g.GetEdge(id) != nil {
+		return ErrWALFormat(fmt.Sprintf("duplicate id %d", id))
+	}
+	node := &Node{
+		labels:     labels,
+		contexts:   contexts,
+		properties: props,
+		graph:      g,
+		id:         id,
+	}
+	if id >= g.idBase {
+		g.idBase = id + 1
+	}
+	g.addNode(node)
+	return nil
+}
+
+// newEdgeWithID adds an edge with the given ID to the graph
+func (g *Graph) newEdgeWithID(id, from, to int, label string, contexts *StringSet, props properties) error {
+	if g.GetNode(id) != nil || g.GetEdge(id) != nil {
+		return ErrWALFormat(fmt.Sprintf("duplicate id %d", id))
+	}
+	fromNode, toNode := g.GetNode(from), g.GetNode(to)
+	if fromNode == nil || toNode == nil {
+		return ErrWALFormat(fmt.Sprintf("edge %d: unknown node", id))
+	}
+	edge := &Edge{
+		from:       fromNode,
+		to:         toNode,
+		label:      label,
+		contexts:   contexts,
+		properties: props,
+		id:         id,
+	}
+	if id >= g.idBase {
+		g.idBase = id + 1
+	}
+	g.addEdge(edge)
+	return nil
+}
+
+func (w *WAL) pendingLen() int { return w.enc.buf.Len() }
+
+func (w *WAL) truncatePending(n int) { w.enc.buf.Truncate(n) }
+
+// append adds a change to the pending records. The records are written
+// right away unless there is an active transaction.
+func (w *WAL) append(c change) {
+	if w.err != nil {
+		return
+	}
+	mark := w.enc.buf.Len()
+	if err := w.encode(c); err != nil {
+		w.enc.buf.Truncate(mark)
+		w.err = err
+		return
+	}
+	if w.graph.tx == nil {
+		w.flush()
+	}
+}
+
+// declare writes a declaration record. Declarations are written right
+// away even if there is an active transaction.
+func (w *WAL) declare(encode func(w *WAL)) {
+	if w == nil || w.err != nil {
+		return
+	}
+	pending := w.enc.buf
+	w.enc.buf = bytes.Buffer{}
+	encode(w)
+	w.flush()
+	w.enc.buf = pending
+}
+
+func (w *WAL) declarePropertyIndex(tag byte, key string, it IndexType, kind ValueKind) {
+	w.declare(func(w *WAL) {
+		w.enc.buf.WriteByte(tag)
+		w.str(key)
+		w.enc.buf.WriteByte(byte(it))
+		w.enc.buf.WriteByte(byte(kind))
+	})
+}
+
+func (w *WAL) declareComposite(tag byte, keys []string, it IndexType) {
+	w.declare(func(w *WAL) {
+		w.enc.buf.WriteByte(tag)
+		w.strings(keys)
+		w.enc.buf.WriteByte(byte(it))
+	})
+}
+
+func (w *WAL) declareUniqueConstraint(label string, keys []string) {
+	w.declare(func(w *WAL) {
+		w.enc.buf.WriteByte(walUniqueConstraint)
+		w.str(label)
+		w.strings(keys)
+	})
+}
+
+// flush writes the pending records to the log as a single record
+func (w *WAL) flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.enc.buf.Len() == 0 {
+		return nil
+	}
+	payload := w.enc.buf.Bytes()
+	frame := make([]byte, 0, binary.MaxVarintLen64+len(payload)+8)
+	frame = binary.AppendUvarint(frame, uint64(len(payload)))
+	frame = binary.LittleEndian.AppendUint32(frame, crc32.ChecksumIEEE(frame))
+	frame = append(frame, payload...)
+	frame = binary.LittleEndian.AppendUint32(frame, crc32.ChecksumIEEE(payload))
+	w.enc.buf.Reset()
+	if _, err := w.file.Write(frame); err != nil {
+		w.err = err
+		return err
+	}
+	w.records++
+	if w.options.Sync == SyncAlways || (w.options.Sync == SyncInterval && time.Since(w.lastSync) >= w.options.SyncInterval) {
+		if err := w.sync(); err != nil {
+			w.err = err
+			return err
+		}
+	}
+	if w.options.CheckpointEvery > 0 && w.records >= w.options.CheckpointEvery && w.graph.tx == nil {
+		if err := w.Checkpoint(); err != nil {
+			w.err = err
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *WAL) sync() error {
+	w.lastSync = time.Now()
+	return w.file.Sync()
+}
+
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// Err returns the error that stopped the log, or nil
+func (w *WAL) Err() error { return w.err }
+
+// Discarded returns the number of bytes discarded from the end of the
+// log when it was opened. A non-zero value means the last record was
+// not completely written, or was corrupt.
+func (w *WAL) Discarded() int64 { return w.discarded }
+
+// Sync flushes the log to stable storage. The changes of an active
+// transaction are not written.
+func (w *WAL) Sync() error {
+	if w.err != nil {
+		return w.err
+	}
+	if err := w.sync(); err != nil {
+		w.err = err
+	}
+	return w.err
+}
+
+// Checkpoint writes a snapshot of the graph and starts a new, empty
+// log. Checkpoint cannot be called while there is an active
+// transaction.
+func (w *WAL) Checkpoint() error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.graph.tx != nil {
+		return errors.New("cannot checkpoint during a transaction")
+	}
+	gen := w.gen + 1
+	name := filepath.Join(w.dir, walSnapshotName(gen))
+	f, err := os.Create(name + ".tmp")
+	if err != nil {
+		return err
+	}
+	out := bufio.NewWriter(f)
+	err = w.options.Snapshot.Encode(w.graph, out)
+	if err == nil {
+		err = out.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(name+".tmp", name)
+	}
+	if err != nil {
+		os.Remove(name + ".tmp")
+		return err
+	}
+	// The new snapshot is in place. If there is a crash before the
+	// new log is created, the snapshot is loaded without the old log.
+	oldGen, oldFile, discarded := w.gen, w.file, w.discarded
+	w.gen = gen
+	file, err := w.openLog(w.graph)
+	w.discarded = discarded
+	if err != nil {
+		w.err = err
+		return err
+	}
+	w.file = file
+	w.records = 0
+	w.lastSync = time.Now()
+	oldFile.Close()
+	os.Remove(filepath.Join(w.dir, walLogName(oldGen)))
+	os.Remove(filepath.Join(w.dir, walSnapshotName(oldGen)))
+	return nil
+}
+
+// Close syncs and closes the log, and detaches it from the graph. The
+// changes of an active transaction are not written.
+func (w *WAL) Close() error {
+	if w.file == nil {
+		return w.err
+	}
+	err := w.err
+	if serr := w.file.Sync(); err == nil {
+		err = serr
+	}
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	w.file = nil
+	if w.graph.wal == w {
+		w.graph.wal = nil
+	}
+	return err
+}
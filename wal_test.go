@@ -0,0 +1,253 @@
+// Copyright 2021 Cloud Privacy Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lpg
+
+import (
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func reopenWAL(t *testing.T, dir string, w *WAL) (*Graph, *WAL) {
+	assert.Nil(t, w.Close())
+	g, w, err := OpenWAL(dir, WALOptions{})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	assert.Nil(t, g.Verify())
+	return g, w
+}
+
+func TestWALReplay(t *testing.T) {
+	dir := t.TempDir()
+	g, w, err := OpenWAL(dir, WALOptions{Sync: SyncNever})
+	assert.Nil(t, err)
+	assert.Nil(t, g.AddTypedNodePropertyIndex("age", BtreeIndex, IntValue))
+	assert.Nil(t, g.AddCompositeNodeIndex([]string{"name", "age"}, HashIndex))
+	assert.Nil(t, g.AddUniqueConstraint("Person", "name"))
+	g.AddEdgePropertyIndex("weight", HashIndex)
+	nodes := make([]*Node, 0)
+	for i := 0; i < 5; i++ {
+		nodes = append(nodes, g.NewNode([]string{"Person"}, map[string]interface{}{"name": string(rune('a' + i)), "age": i}, NewStringSet("c1")))
+	}
+	for i := 0; i < 4; i++ {
+		g.NewEdge(nodes[i], nodes[i+1], "knows", map[string]interface{}{"weight": float64(i)}, nil)
+	}
+	assert.Nil(t, nodes[0].SetExternalID("first"))
+	nodes[1].SetLabels(NewStringSet("Robot"))
+	nodes[1].SetContexts(NewStringSet("c2"))
+	nodes[2].RemoveProperty("age")
+	edge := EdgeSlice(nodes[3].GetEdges(OutgoingEdge))[0]
+	edge.SetLabel("likes")
+	edge.SetProperty("tags", []string{"x", "y"})
+	nodes[4].DetachAndRemove()
+	expected := dumpGraph(g)
+
+	g, w = reopenWAL(t, dir, w)
+	assert.Equal(t, expected, dumpGraph(g))
+	assert.Equal(t, int64(0), w.Discarded())
+	node, err := g.GetNodeByKey("Person", "name", "a")
+	assert.Nil(t, err)
+	assert.Equal(t, g.GetNodeByExternalID("first"), node)
+	assert.Nil(t, g.AddUniqueConstraint("Person", "age"))
+
+	// The replayed graph continues recording
+	g, w = reopenWAL(t, dir, w)
+	_, err = g.TryNewNode([]string{"Person"}, map[string]interface{}{"name": "x", "age": 0}, nil)
+	assert.NotNil(t, err)
+	_, err = g.TryNewNode([]string{"Person"}, map[string]interface{}{"name": "x", "age": "0"}, nil)
+	assert.NotNil(t, err)
+	g.NewNode(nil, nil, nil)
+	g, w = reopenWAL(t, dir, w)
+	assert.Equal(t, 5, len(NodeSlice(g.GetNodes())))
+	assert.Nil(t, w.Close())
+}
+
+func TestWALTx(t *testing.T) {
+	dir := t.TempDir()
+	g, w, err := OpenWAL(dir, WALOptions{})
+	assert.Nil(t, err)
+	g.NewNode([]string{"a"}, nil, nil)
+
+	tx := g.Begin()
+	g.NewNode([]string{"b"}, nil, nil)
+	inner := g.Begin()
+	g.NewNode([]string{"c"}, nil, nil)
+	assert.Nil(t, inner.Rollback())
+	assert.Nil(t, tx.Commit())
+
+	tx = g.Begin()
+	g.NewNode([]string{"d"}, nil, nil)
+	assert.Nil(t, tx.Rollback())
+	expected := dumpGraph(g)
+
+	// Uncommitted changes are not written
+	g.Begin()
+	g.NewNode([]string{"e"}, nil, nil)
+
+	g, w = reopenWAL(t, dir, w)
+	assert.Equal(t, expected, dumpGraph(g))
+	assert.Nil(t, w.Close())
+}
+
+func TestWALCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	g, w, err := OpenWAL(dir, WALOptions{CheckpointEvery: 10})
+	assert.Nil(t, err)
+	g.AddNodePropertyIndex("key", HashIndex)
+	var prev *Node
+	for i := 0; i < 25; i++ {
+		node := g.NewNode([]string{"a"}, map[string]interface{}{"key": i}, nil)
+		if prev != nil {
+			g.NewEdge(prev, node, "next", nil, nil)
+		}
+		prev = node
+	}
+	assert.Equal(t, uint64(5), w.gen)
+	tx := g.Begin()
+	assert.NotNil(t, w.Checkpoint())
+	assert.Nil(t, tx.Commit())
+	assert.Nil(t, w.Checkpoint())
+	g.NewNode(nil, nil, nil)
+	expected := dumpGraph(g)
+
+	g, w = reopenWAL(t, dir, w)
+	assert.Equal(t, expected, dumpGraph(g))
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(entries))
+	itr, err := g.FindNodes(nil, map[string]interface{}{"key": 3})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(NodeSlice(itr)))
+	assert.Nil(t, w.Close())
+}
+
+func TestWALSyncInterval(t *testing.T) {
+	dir := t.TempDir()
+	g, w, err := OpenWAL(dir, WALOptions{Sync: SyncInterval, SyncInterval: time.Millisecond})
+	assert.Nil(t, err)
+	last := w.lastSync
+	time.Sleep(5 * time.Millisecond)
+	// The interval has passed, but nothing was written
+	assert.Equal(t, last, w.lastSync)
+	g.NewNode([]string{"a"}, nil, nil)
+	assert.True(t, w.lastSync.After(last))
+
+	// A record written within the interval is not synced
+	w.options.SyncInterval = time.Hour
+	last = w.lastSync
+	g.NewNode([]string{"b"}, nil, nil)
+	assert.Equal(t, last, w.lastSync)
+	assert.Nil(t, w.Sync())
+	assert.True(t, w.lastSync.After(last))
+	assert.Nil(t, w.Close())
+}
+
+func TestWALTruncatedTail(t *testing.T) {
+	dir := t.TempDir()
+	g, w, err := OpenWAL(dir, WALOptions{})
+	assert.Nil(t, err)
+	for i := 0; i < 3; i++ {
+		g.NewNode([]string{"a"}, map[string]interface{}{"key": i}, nil)
+	}
+	expected := dumpGraph(g)
+	g.NewNode([]string{"b"}, map[string]interface{}{"key": 3}, nil)
+	assert.Nil(t, w.Close())
+
+	// Cut the last record short
+	name := filepath.Join(dir, walLogName(0))
+	info, err := os.Stat(name)
+	assert.Nil(t, err)
+	assert.Nil(t, os.Truncate(name, info.Size()-3))
+
+	g, w, err = OpenWAL(dir, WALOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, expected, dumpGraph(g))
+	assert.True(t, w.Discarded() > 0)
+	g.NewNode([]string{"c"}, nil, nil)
+	expected = dumpGraph(g)
+	assert.Nil(t, w.Close())
+
+	// Garbage at the end
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
+	assert.Nil(t, err)
+	garbage := binary.AppendUvarint(nil, 5)
+	garbage = binary.LittleEndian.AppendUint32(garbage, crc32.ChecksumIEEE(garbage))
+	garbage = append(garbage, 1, 2, 3, 4, 5, 0, 0, 0, 0)
+	f.Write(garbage)
+	f.Close()
+	g, w, err = OpenWAL(dir, WALOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, expected, dumpGraph(g))
+	assert.Equal(t, int64(len(garbage)), w.Discarded())
+	assert.Nil(t, w.Close())
+
+	// Zeros at the end
+	f, err = os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
+	assert.Nil(t, err)
+	f.Write(make([]byte, 20))
+	f.Close()
+	g, w, err = OpenWAL(dir, WALOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, expected, dumpGraph(g))
+	assert.Equal(t, int64(20), w.Discarded())
+	assert.Nil(t, w.Close())
+
+	// A corrupt record followed by a valid one
+	data, err := os.ReadFile(name)
+	assert.Nil(t, err)
+	g, w, err = OpenWAL(dir, WALOptions{})
+	assert.Nil(t, err)
+	g.NewNode([]string{"d"}, nil, nil)
+	assert.Nil(t, w.Close())
+	corrupt, err := os.ReadFile(name)
+	assert.Nil(t, err)
+	corrupt[len(data)-5]++
+	assert.Nil(t, os.WriteFile(name, corrupt, 0o644))
+	_, _, err = OpenWAL(dir, WALOptions{})
+	_, ok := err.(ErrWALFormat)
+	assert.True(t, ok)
+
+	// The same record at the end of the log is a torn write
+	assert.Nil(t, os.WriteFile(name, corrupt[:len(data)], 0o644))
+	g, w, err = OpenWAL(dir, WALOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(NodeSlice(g.GetNodes())))
+	assert.Nil(t, w.Close())
+
+	// A corrupt record size in the middle of the log. It must not be
+	// taken for a torn write, and the log is not truncated.
+	data, err = os.ReadFile(name)
+	assert.Nil(t, err)
+	corrupt = append([]byte{}, data...)
+	corrupt[len(walMagic)+1] = 0x7f
+	assert.Nil(t, os.WriteFile(name, corrupt, 0o644))
+	_, _, err = OpenWAL(dir, WALOptions{})
+	_, ok = err.(ErrWALFormat)
+	assert.True(t, ok)
+	after, err := os.ReadFile(name)
+	assert.Nil(t, err)
+	assert.Equal(t, corrupt, after)
+
+	// A file that is not a log
+	assert.Nil(t, os.WriteFile(name, []byte("not a log"), 0o644))
+	_, _, err = OpenWAL(dir, WALOptions{})
+	_, ok = err.(ErrWALFormat)
+	assert.True(t, ok)
+}
@@ -0,0 +1,268 @@
+// Copyright 2021 Cloud Privacy Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lpg
+
+// WalkOrder is the order a Walker visits the nodes
+type WalkOrder int
+
+const (
+	// BreadthFirst visits the nodes closer to the start nodes first
+	BreadthFirst WalkOrder = iota
+	// DepthFirst follows each path as deep as possible before
+	// backtracking
+	DepthFirst
+)
+
+// Uniqueness selects what a Walker visits at most once
+type Uniqueness int
+
+const (
+	// NodeGlobal visits every node at most once
+	NodeGlobal Uniqueness = iota
+	// EdgeGlobal follows every edge at most once. A node is visited
+	// once for every path reaching it.
+	EdgeGlobal
+	// NodePath visits a node at most once in a path. A node is
+	// visited once for every path without cycles reaching it, so the
+	// number of visits can grow exponentially with depth.
+	NodePath
+)
+
+// WalkAction is returned by a walk visitor. Actions can be combined,
+// e.g. WalkEmit|WalkPrune.
+type WalkAction int
+
+const (
+	// WalkEmit returns the path to the visited node from the walk
+	WalkEmit WalkAction = 1 << iota
+	// WalkPrune does not follow the edges of the visited node
+	WalkPrune
+	// WalkStop ends the walk
+	WalkStop
+)
+
+// WalkContinue follows the edges of the visited node without emitting
+// the path
+const WalkContinue WalkAction = 0
+
+// Walker describes a traversal of the graph from a set of start
+// nodes. The zero value is a breadth-first walk that follows edges
+// in both directions and visits every reachable node once.
+type Walker struct {
+	Order WalkOrder
+	// Dir is the direction edges are followed. With AnyEdge, edges are
+	// followed in both directions.
+	Dir        EdgeDir
+	Uniqueness Uniqueness
+	// MaxDepth is the maximum number of edges of a path. If 0, the
+	// depth is not limited.
+	MaxDepth int
+	// If Labels is not empty, only edges with one of these labels are
+	// followed
+	Labels *StringSet
+	// If Contexts is not empty, only edges with one of these contexts
+	// are followed
+	Contexts *StringSet
+	// EdgeFilter, if not nil, selects the edges to follow
+	EdgeFilter func(*Edge) bool
+	// NodeFilter, if not nil, selects the nodes to visit. The start
+	// nodes are always visited.
+	NodeFilter func(*Node) bool
+	// Visit is called with the path to every visited node, starting
+	// with the paths containing only a start node. If nil, every path
+	// is emitted.
+	Visit func(*Path) WalkAction
+}
+
+// Walk is a traversal in progress. It is an iterator of the emitted
+// paths. The graph must not be modified during the walk.
+type Walk struct {
+	walker  *Walker
+	pending []*Path
+	nodes   map[*Node]struct{}
+	edges   map[*Edge]struct{}
+	path    *Path
+	stopped bool
+}
+
+// Walk starts a walk from the given nodes
+func (w *Walker) Walk(start ...*Node) *Walk {
+	walk := &Walk{
+		walker:  w,
+		pending: make([]*Path, 0, len(start)),
+	}
+	switch w.Uniqueness {
+	case NodeGlobal:
+		walk.nodes = make(map[*Node]struct{})
+	case EdgeGlobal:
+		walk.edges = make(map[*Edge]struct{})
+	}
+	if w.Order == DepthFirst {
+		// pending is a stack, so push in reverse
+		for i := len(start) - 1; i >= 0; i-- {
+			walk.pending = append(walk.pending, PathFromNode(start[i]))
+		}
+	} else {
+		for _, node := range start {
+			walk.pending = append(walk.pending, PathFromNode(node))
+		}
+	}
+	return walk
+}
+
+// Next visits nodes until a path is emitted, and returns false when
+// the walk ends
+func (w *Walk) Next() bool {
+	w.path = nil
+	for !w.stopped && len(w.pending) > 0 {
+		var path *Path
+		if w.walker.Order == DepthFirst {
+			path = w.pending[len(w.pending)-1]
+			w.pending[len(w.pending)-1] = nil
+			w.pending = w.pending[:len(w.pending)-1]
+		} else {
+			path = w.pending[0]
+			w.pending[0] = nil
+			w.pending = w.pending[1:]
+		}
+		node := path.Last()
+		if w.nodes != nil {
+			if _, seen := w.nodes[node]; seen {
+				continue
+			}
+			w.nodes[node] = struct{}{}
+		}
+		action := WalkEmit
+		if w.walker.Visit != nil {
+			action = w.walker.Visit(path)
+		}
+		if action&WalkStop != 0 {
+			w.stopped = true
+			w.pending = nil
+		} else if action&WalkPrune == 0 {
+			w.extend(path)
+		}
+		if action&WalkEmit != 0 {
+			w.path = path
+			return true
+		}
+	}
+	return false
+}
+
+// extend adds the paths one edge longer than path to the pending paths
+func (w *Walk) extend(path *Path) {
+	walker := w.walker
+	if walker.MaxDepth > 0 && path.NumEdges() >= walker.MaxDepth {
+		return
+	}
+	node := path.Last()
+	var edges EdgeIterator
+	if walker.Labels != nil && walker.Labels.Len() > 0 {
+		edges = node.GetEdgesWithAnyLabel(walker.Dir, walker.Labels)
+	} else {
+		edges = node.GetEdges(walker.Dir)
+	}
+	next := make([]*Path, 0)
+	for edges.Next() {
+		edge := edges.Edge()
+		if walker.Contexts != nil && walker.Contexts.Len() > 0 && !edge.HasAnyContextsSet(walker.Contexts) {
+			continue
+		}
+		if walker.EdgeFilter != nil && !walker.EdgeFilter(edge) {
+			continue
+		}
+		pe := stepFrom(node, edge)
+		target := pe.GetTargetNode()
+		switch walker.Uniqueness {
+		case NodeGlobal:
+			if _, seen := w.nodes[target]; seen {
+				continue
+			}
+		case EdgeGlobal:
+			if _, seen := w.edges[edge]; seen {
+				continue
+			}
+		case NodePath:
+			if pathHasNode(path, target) {
+				continue
+			}
+		}
+		if walker.NodeFilter != nil && !walker.NodeFilter(target) {
+			continue
+		}
+		if w.edges != nil {
+			w.edges[edge] = struct{}{}
+		}
+		elements := make([]PathElement, len(path.path), len(path.path)+1)
+		copy(elements, path.path)
+		next = append(next, &Path{path: append(elements, pe)})
+	}
+	if walker.Order == DepthFirst {
+		// Visit the first edge first
+		for i := len(next) - 1; i >= 0; i-- {
+			w.pending = append(w.pending, next[i])
+		}
+	} else {
+		w.pending = append(w.pending, next...)
+	}
+}
+
+func pathHasNode(path *Path, node *Node) bool {
+	for i := 0; i < path.NumNodes(); i++ {
+		if path.GetNode(i) == node {
+			return true
+		}
+	}
+	return false
+}
+
+// Path returns the emitted path. It ends at the visited node.
+func (w *Walk) Path() *Path { return w.path }
+
+// Value returns the emitted path
+func (w *Walk) Value() interface{} { return w.path }
+
+// MaxSize returns -1, the number of paths is not known
+func (w *Walk) MaxSize() int { return -1 }
+
+// Nodes returns an iterator over the last nodes of the emitted
+// paths. A node is returned more than once if it is the end of
+// multiple emitted paths.
+func (w *Walk) Nodes() NodeIterator {
+	return nodeIterator{&procIterator{
+		itr: w,
+		proc: func(item interface{}) interface{} {
+			return item.(*Path).Last()
+		},
+	}}
+}
+
+// Edges returns an iterator over the last edges of the emitted
+// paths. Paths without edges are skipped.
+func (w *Walk) Edges() EdgeIterator {
+	return edgeIterator{&procIterator{
+		itr: &filterIterator{
+			itr: w,
+			filter: func(item interface{}) bool {
+				return item.(*Path).NumEdges() > 0
+			},
+		},
+		proc: func(item interface{}) interface{} {
+			path := item.(*Path)
+			return path.GetEdge(path.NumEdges() - 1)
+		},
+	}}
+}
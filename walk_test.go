@@ -0,0 +1,199 @@
+// Copyright 2021 Cloud Privacy Labs, LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lpg
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// getWalkTestGraph returns the graph
+//
+//	s -> x -> t -> u
+//	s -> y -> t
+//	u -> s
+//
+// where y -> t is labeled "slow" and has context "c", and all other
+// edges are labeled "fast"
+func getWalkTestGraph() (*Graph, map[string]*Node) {
+	g := NewGraph()
+	nodes := make(map[string]*Node)
+	for _, name := range []string{"s", "x", "y", "t", "u"} {
+		nodes[name] = g.NewNode(nil, map[string]interface{}{"name": name}, nil)
+	}
+	for _, e := range [][3]string{
+		{"s", "x", "fast"},
+		{"s", "y", "fast"},
+		{"x", "t", "fast"},
+		{"y", "t", "slow"},
+		{"t", "u", "fast"},
+		{"u", "s", "fast"},
+	} {
+		var contexts *StringSet
+		if e[2] == "slow" {
+			contexts = NewStringSet("c")
+		}
+		g.NewEdge(nodes[e[0]], nodes[e[1]], e[2], nil, contexts)
+	}
+	return g, nodes
+}
+
+// walkNames returns the names of the last nodes of the emitted paths
+func walkNames(walk *Walk) []string {
+	names := make([]string, 0)
+	for walk.Next() {
+		name, _ := walk.Path().Last().GetProperty("name")
+		names = append(names, name.(string))
+	}
+	return names
+}
+
+func TestWalkOrder(t *testing.T) {
+	_, nodes := getWalkTestGraph()
+	walker := Walker{Dir: OutgoingEdge}
+	assert.Equal(t, []string{"s", "x", "y", "t", "u"}, walkNames(walker.Walk(nodes["s"])))
+	walker.MaxDepth = 1
+	assert.Equal(t, []string{"s", "x", "y"}, walkNames(walker.Walk(nodes["s"])))
+
+	// Depth first goes down x before visiting y
+	walker = Walker{Dir: OutgoingEdge, Order: DepthFirst}
+	names := walkNames(walker.Walk(nodes["s"]))
+	assert.Equal(t, 5, len(names))
+	assert.Equal(t, "s", names[0])
+	idx := make(map[string]int)
+	for i, name := range names {
+		idx[name] = i
+	}
+	if idx["x"] < idx["y"] {
+		assert.Equal(t, []string{"s", "x", "t", "u", "y"}, names)
+	} else {
+		assert.Equal(t, []string{"s", "y", "t", "u", "x"}, names)
+	}
+
+	// Breadth first paths do not get shorter
+	walker = Walker{Dir: OutgoingEdge, Uniqueness: NodePath}
+	depth := 0
+	for walk := walker.Walk(nodes["s"]); walk.Next(); {
+		assert.True(t, walk.Path().NumEdges() >= depth)
+		depth = walk.Path().NumEdges()
+	}
+}
+
+func TestWalkUniqueness(t *testing.T) {
+	_, nodes := getWalkTestGraph()
+	sorted := func(walk *Walk) string {
+		names := walkNames(walk)
+		sort.Strings(names)
+		return strings.Join(names, "")
+	}
+	for _, order := range []WalkOrder{BreadthFirst, DepthFirst} {
+		walker := Walker{Dir: OutgoingEdge, Order: order, Uniqueness: NodeGlobal}
+		assert.Equal(t, "stuxy", sorted(walker.Walk(nodes["s"])))
+		// t is reached through two edges, and u -> s leads back to s
+		walker.Uniqueness = EdgeGlobal
+		assert.Equal(t, "ssttuxy", sorted(walker.Walk(nodes["s"])))
+		// t and u are on two paths
+		walker.Uniqueness = NodePath
+		assert.Equal(t, "sttuuxy", sorted(walker.Walk(nodes["s"])))
+	}
+	walker := Walker{Dir: AnyEdge, Uniqueness: NodePath}
+	for walk := walker.Walk(nodes["s"]); walk.Next(); {
+		path := walk.Path()
+		assert.Equal(t, path.NumEdges()+1, len(pathNames(path)))
+		seen := make(map[*Node]struct{})
+		for i := 0; i < path.NumNodes(); i++ {
+			_, ok := seen[path.GetNode(i)]
+			assert.False(t, ok)
+			seen[path.GetNode(i)] = struct{}{}
+		}
+	}
+}
+
+func TestWalkFilters(t *testing.T) {
+	_, nodes := getWalkTestGraph()
+	walker := Walker{Dir: OutgoingEdge, Labels: NewStringSet("fast")}
+	assert.Equal(t, []string{"s", "x", "y", "t", "u"}, walkNames(walker.Walk(nodes["s"])))
+	walker = Walker{Dir: OutgoingEdge, Labels: NewStringSet("fast"), Uniqueness: NodePath}
+	assert.Equal(t, []string{"s", "x", "y", "t", "u"}, walkNames(walker.Walk(nodes["s"])))
+	walker = Walker{Dir: OutgoingEdge, Contexts: NewStringSet("c")}
+	assert.Equal(t, []string{"y", "t"}, walkNames(walker.Walk(nodes["y"])))
+	walker = Walker{Dir: OutgoingEdge, NodeFilter: func(node *Node) bool { return node != nodes["x"] }}
+	assert.Equal(t, []string{"s", "y", "t", "u"}, walkNames(walker.Walk(nodes["s"])))
+	walker = Walker{Dir: OutgoingEdge, EdgeFilter: func(edge *Edge) bool { return edge.GetTo() != nodes["t"] }}
+	assert.Equal(t, []string{"s", "x", "y"}, walkNames(walker.Walk(nodes["s"])))
+
+	// Walk backwards
+	walker = Walker{Dir: IncomingEdge, Labels: NewStringSet("fast")}
+	walk := walker.Walk(nodes["u"])
+	assert.Equal(t, []string{"u", "t", "x", "s"}, walkNames(walk))
+	walk = walker.Walk(nodes["u"])
+	for walk.Next() {
+		for i := 0; i < walk.Path().NumEdges(); i++ {
+			assert.True(t, walk.Path().path[i].Reverse)
+		}
+		checkPath(t, walk.Path())
+	}
+}
+
+func TestWalkVisitor(t *testing.T) {
+	_, nodes := getWalkTestGraph()
+	// Emit the paths to t, and do not go beyond t
+	walker := Walker{
+		Dir:        OutgoingEdge,
+		Uniqueness: NodePath,
+		Visit: func(path *Path) WalkAction {
+			if path.Last() == nodes["t"] {
+				return WalkEmit | WalkPrune
+			}
+			return WalkContinue
+		},
+	}
+	paths := make([]*Path, 0)
+	for walk := walker.Walk(nodes["s"]); walk.Next(); {
+		paths = append(paths, walk.Path())
+	}
+	assert.Equal(t, []string{"sxt", "syt"}, sortedPathNames(paths))
+
+	visited := 0
+	walker = Walker{
+		Dir: OutgoingEdge,
+		Visit: func(path *Path) WalkAction {
+			visited++
+			if visited == 3 {
+				return WalkEmit | WalkStop
+			}
+			return WalkEmit
+		},
+	}
+	assert.Equal(t, []string{"s", "x", "y"}, walkNames(walker.Walk(nodes["s"])))
+	assert.Equal(t, 3, visited)
+
+	// Multiple start nodes
+	walker = Walker{Dir: OutgoingEdge, MaxDepth: 1}
+	assert.Equal(t, []string{"x", "y", "t"}, walkNames(walker.Walk(nodes["x"], nodes["y"])))
+}
+
+func TestWalkIterators(t *testing.T) {
+	_, nodes := getWalkTestGraph()
+	walker := Walker{Dir: OutgoingEdge}
+	assert.Equal(t, []*Node{nodes["s"], nodes["x"], nodes["y"], nodes["t"], nodes["u"]}, NodeSlice(walker.Walk(nodes["s"]).Nodes()))
+	edges := EdgeSlice(walker.Walk(nodes["s"]).Edges())
+	assert.Equal(t, 4, len(edges))
+	assert.ElementsMatch(t, []*Node{nodes["x"], nodes["y"], nodes["t"], nodes["u"]}, TargetNodes(edgeIterator{newSliceIterator(edges)}))
+	walker.Uniqueness = EdgeGlobal
+	assert.Equal(t, 6, len(EdgeSlice(walker.Walk(nodes["s"]).Edges())))
+}